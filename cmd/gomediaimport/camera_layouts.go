@@ -0,0 +1,203 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// cameraFamily names the camera maker or recording format whose folder layout
+// a file was found in. It is empty for files outside a recognized layout.
+type cameraFamily string
+
+const (
+	cameraFamilyDCF        cameraFamily = "DCF"
+	cameraFamilyCanon      cameraFamily = "Canon"
+	cameraFamilyNikon      cameraFamily = "Nikon"
+	cameraFamilySony       cameraFamily = "Sony"
+	cameraFamilyFujifilm   cameraFamily = "Fujifilm"
+	cameraFamilyPanasonic  cameraFamily = "Panasonic"
+	cameraFamilyOlympus    cameraFamily = "Olympus"
+	cameraFamilyApple      cameraFamily = "Apple"
+	cameraFamilyGoPro      cameraFamily = "GoPro"
+	cameraFamilyDJI        cameraFamily = "DJI"
+	cameraFamilyInsta360   cameraFamily = "Insta360"
+	cameraFamilyAVCHD      cameraFamily = "AVCHD"
+	cameraFamilyBlackmagic cameraFamily = "Blackmagic"
+	cameraFamilyCanonXF    cameraFamily = "Canon XF"
+)
+
+// dcfFolderFamilies maps the five free characters of DCF folder names such as
+// 100CANON to the family that writes them
+var dcfFolderFamilies = map[string]cameraFamily{
+	"CANON": cameraFamilyCanon,
+	"EOS5D": cameraFamilyCanon,
+	"NIKON": cameraFamilyNikon,
+	"NCD50": cameraFamilyNikon,
+	"MSDCF": cameraFamilySony,
+	"_FUJI": cameraFamilyFujifilm,
+	"_PANA": cameraFamilyPanasonic,
+	"OLYMP": cameraFamilyOlympus,
+	"OMSYS": cameraFamilyOlympus,
+	"APPLE": cameraFamilyApple,
+	"GOPRO": cameraFamilyGoPro,
+	"MEDIA": cameraFamilyDJI,
+}
+
+// cameraLayoutMatch is what the camera layout says about one source path
+type cameraLayoutMatch struct {
+	Family cameraFamily
+	// Artifact is set for camera-written files and directories that are not
+	// imported, such as proxies, thumbnails, and metadata companions
+	Artifact sourceArtifactKind
+}
+
+// matchCameraLayout recognizes the folder layouts cameras write: DCF
+// (DCIM/100CANON), Sony XAVC (PRIVATE/M4ROOT), AVCHD (BDMV/STREAM), GoPro,
+// DJI, Insta360, Blackmagic, and Canon XF. relPath is relative to the source
+// root.
+func matchCameraLayout(relPath string, isDir bool) cameraLayoutMatch {
+	components := relativePathComponents(relPath)
+	if len(components) == 0 {
+		return cameraLayoutMatch{}
+	}
+	name := components[len(components)-1]
+	ext := strings.ToUpper(filepath.Ext(name))
+
+	if i := indexFoldComponent(components, "M4ROOT"); i >= 0 && (i == 0 || (i == 1 && strings.EqualFold(components[0], "PRIVATE"))) {
+		return matchSonyXAVC(relPath, components[i+1:], isDir)
+	}
+	for _, root := range [][]string{{"BDMV"}, {"AVCHD", "BDMV"}, {"PRIVATE", "AVCHD", "BDMV"}} {
+		if len(components) >= len(root) && equalFoldComponents(components[:len(root)], root...) {
+			return matchAVCHD(components[len(root):], isDir)
+		}
+	}
+	if len(components) >= 2 && strings.EqualFold(components[0], "CONTENTS") && hasFoldPrefix(components[1], "CLIPS") {
+		return matchCanonXF(ext, isDir)
+	}
+	if len(components) >= 2 && strings.EqualFold(components[0], "DCIM") {
+		return matchDCIM(components[1], name, ext, isDir)
+	}
+	if ext == ".BRAW" {
+		return cameraLayoutMatch{Family: cameraFamilyBlackmagic}
+	}
+	if ext == ".SIDECAR" && !isDir {
+		return cameraLayoutMatch{Family: cameraFamilyBlackmagic, Artifact: sourceArtifactCameraMetadata}
+	}
+	return cameraLayoutMatch{}
+}
+
+// matchSonyXAVC handles PRIVATE/M4ROOT. rest is the path below M4ROOT.
+func matchSonyXAVC(relPath string, rest []string, isDir bool) cameraLayoutMatch {
+	match := cameraLayoutMatch{Family: cameraFamilySony}
+	switch {
+	case isDir && isSonyThumbnailDir(relPath):
+		match.Artifact = sourceArtifactSonyThumbnail
+	case isDir && equalFoldComponents(rest, "SUB"):
+		// Low-resolution sub-clips recorded alongside the main clips
+		match.Artifact = sourceArtifactProxy
+	case !isDir && len(rest) == 2 && strings.EqualFold(rest[0], "SUB"):
+		match.Artifact = sourceArtifactProxy
+	case !isDir && isSonyClipXML(relPath):
+		match.Artifact = sourceArtifactSonyXML
+	case !isDir && len(rest) == 1:
+		// MEDIAPRO.XML, STATUS.BIN and the like
+		match.Artifact = sourceArtifactCameraMetadata
+	}
+	return match
+}
+
+// matchAVCHD handles BDMV. rest is the path below BDMV; only the clips in
+// STREAM are media.
+func matchAVCHD(rest []string, isDir bool) cameraLayoutMatch {
+	match := cameraLayoutMatch{Family: cameraFamilyAVCHD}
+	if len(rest) == 0 || strings.EqualFold(rest[0], "STREAM") {
+		return match
+	}
+	if !isDir {
+		// index.bdm, MovieObject.bdm, and the playback data in CLIPINF,
+		// PLAYLIST and BACKUP
+		match.Artifact = sourceArtifactCameraMetadata
+	}
+	return match
+}
+
+// matchCanonXF handles CONTENTS/CLIPSnnn, where everything next to the MXF
+// clips is clip metadata
+func matchCanonXF(ext string, isDir bool) cameraLayoutMatch {
+	match := cameraLayoutMatch{Family: cameraFamilyCanonXF}
+	if !isDir && ext != ".MXF" && ext != ".MP4" {
+		match.Artifact = sourceArtifactCameraMetadata
+	}
+	return match
+}
+
+// matchDCIM handles files below DCIM/<folder>
+func matchDCIM(folder, name, ext string, isDir bool) cameraLayoutMatch {
+	family := dcimFolderFamily(folder)
+	if family == "" {
+		return cameraLayoutMatch{}
+	}
+	if isDir {
+		return cameraLayoutMatch{Family: family}
+	}
+	if hasFoldPrefix(name, "DJI_") {
+		family = cameraFamilyDJI
+	}
+
+	match := cameraLayoutMatch{Family: family}
+	switch family {
+	case cameraFamilyGoPro:
+		switch ext {
+		case ".LRV":
+			match.Artifact = sourceArtifactProxy
+		case ".THM":
+			match.Artifact = sourceArtifactThumbnail
+		}
+	case cameraFamilyDJI:
+		if ext == ".LRF" {
+			match.Artifact = sourceArtifactProxy
+		}
+	case cameraFamilyInsta360:
+		if ext == ".LRV" || hasFoldPrefix(name, "LRV_") {
+			match.Artifact = sourceArtifactProxy
+		}
+	}
+	return match
+}
+
+// dcimFolderFamily returns the family for a folder directly below DCIM:
+// DCF folders (three digits and five characters), DJI_nnn, and Insta360's
+// Camera01.
+func dcimFolderFamily(folder string) cameraFamily {
+	upper := strings.ToUpper(folder)
+	switch {
+	case len(upper) == 8 && isDCFFolderNumber(upper[:3]):
+		if family, ok := dcfFolderFamilies[upper[3:]]; ok {
+			return family
+		}
+		return cameraFamilyDCF
+	case strings.HasPrefix(upper, "DJI_"):
+		return cameraFamilyDJI
+	case len(upper) == 8 && strings.HasPrefix(upper, "CAMERA") && isASCIIDigit(upper[6]) && isASCIIDigit(upper[7]):
+		return cameraFamilyInsta360
+	}
+	return ""
+}
+
+// isDCFFolderNumber checks for a DCF directory number, 100 to 999
+func isDCFFolderNumber(s string) bool {
+	return isASCIIDigit(s[0]) && isASCIIDigit(s[1]) && isASCIIDigit(s[2]) && s[0] != '0'
+}
+
+func indexFoldComponent(components []string, name string) int {
+	for i, component := range components {
+		if strings.EqualFold(component, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+func hasFoldPrefix(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMatchCameraLayout(t *testing.T) {
+	tests := []struct {
+		path     string
+		isDir    bool
+		family   cameraFamily
+		artifact sourceArtifactKind
+	}{
+		{"DCIM/100CANON/IMG_0001.CR3", false, cameraFamilyCanon, ""},
+		{"DCIM/101MSDCF/DSC00001.JPG", false, cameraFamilySony, ""},
+		{"DCIM/100_FUJI/DSCF0001.RAF", false, cameraFamilyFujifilm, ""},
+		{"dcim/100nikon/dsc_0001.nef", false, cameraFamilyNikon, ""},
+		{"DCIM/123ABCDE/IMG_0001.JPG", false, cameraFamilyDCF, ""},
+		{"DCIM/Camera/IMG_0001.JPG", false, "", ""},
+		{"DCIM/099CANON/IMG_0001.JPG", false, "", ""},
+		{"DCIM/100GOPRO/GX010042.MP4", false, cameraFamilyGoPro, ""},
+		{"DCIM/100GOPRO/GL010042.LRV", false, cameraFamilyGoPro, sourceArtifactProxy},
+		{"DCIM/100GOPRO/GX010042.THM", false, cameraFamilyGoPro, sourceArtifactThumbnail},
+		{"DCIM/100MEDIA/DJI_0001.MP4", false, cameraFamilyDJI, ""},
+		{"DCIM/100MEDIA/DJI_0001.LRF", false, cameraFamilyDJI, sourceArtifactProxy},
+		{"DCIM/100MEDIA/DJI_0001.SRT", false, cameraFamilyDJI, ""},
+		{"DCIM/DJI_001/DJI_20240615103000_0001_D.MP4", false, cameraFamilyDJI, ""},
+		{"DCIM/Camera01/VID_20240615_103000_00_001.insv", false, cameraFamilyInsta360, ""},
+		{"DCIM/Camera01/LRV_20240615_103000_01_001.insv", false, cameraFamilyInsta360, sourceArtifactProxy},
+		{"PRIVATE/M4ROOT/CLIP/C0001.MP4", false, cameraFamilySony, ""},
+		{"PRIVATE/M4ROOT/CLIP/C0001M01.XML", false, cameraFamilySony, sourceArtifactSonyXML},
+		{"PRIVATE/M4ROOT/THMBNL", true, cameraFamilySony, sourceArtifactSonyThumbnail},
+		{"PRIVATE/M4ROOT/SUB", true, cameraFamilySony, sourceArtifactProxy},
+		{"PRIVATE/M4ROOT/MEDIAPRO.XML", false, cameraFamilySony, sourceArtifactCameraMetadata},
+		{"M4ROOT/CLIP/C0002.MP4", false, cameraFamilySony, ""},
+		{"PRIVATE/AVCHD/BDMV/STREAM/00000.MTS", false, cameraFamilyAVCHD, ""},
+		{"PRIVATE/AVCHD/BDMV/CLIPINF", true, cameraFamilyAVCHD, ""},
+		{"PRIVATE/AVCHD/BDMV/CLIPINF/00000.CPI", false, cameraFamilyAVCHD, sourceArtifactCameraMetadata},
+		{"PRIVATE/AVCHD/BDMV/INDEX.BDM", false, cameraFamilyAVCHD, sourceArtifactCameraMetadata},
+		{"AVCHD/BDMV/STREAM/00001.MTS", false, cameraFamilyAVCHD, ""},
+		{"CONTENTS/CLIPS001/AA0001/AA000101.MXF", false, cameraFamilyCanonXF, ""},
+		{"CONTENTS/CLIPS001/AA0001/AA0001.XML", false, cameraFamilyCanonXF, sourceArtifactCameraMetadata},
+		{"A001_06151030_C001.braw", false, cameraFamilyBlackmagic, ""},
+		{"A001_06151030_C001.sidecar", false, cameraFamilyBlackmagic, sourceArtifactCameraMetadata},
+		{"Holiday/IMG_0001.JPG", false, "", ""},
+		{"Holiday/BDMV/Projects/STREAM/clip.mts", false, "", ""},
+	}
+	for _, tt := range tests {
+		got := matchCameraLayout(filepath.FromSlash(tt.path), tt.isDir)
+		if got.Family != tt.family || got.Artifact != tt.artifact {
+			t.Errorf("matchCameraLayout(%q, %v) = %+v, want family %q artifact %q", tt.path, tt.isDir, got, tt.family, tt.artifact)
+		}
+	}
+}
+
+func TestEnumerateFilesCameraLayouts(t *testing.T) {
+	sourceDir := t.TempDir()
+	for _, rel := range []string{
+		"DCIM/100GOPRO/GX010042.MP4",
+		"DCIM/100GOPRO/GL010042.LRV",
+		"DCIM/100GOPRO/GX010042.THM",
+		"PRIVATE/AVCHD/BDMV/STREAM/00000.MTS",
+		"PRIVATE/AVCHD/BDMV/CLIPINF/00000.CPI",
+		"PRIVATE/AVCHD/BDMV/PLAYLIST/00000.MPL",
+		"PRIVATE/AVCHD/BDMV/INDEX.BDM",
+		"PRIVATE/M4ROOT/CLIP/C0001.MP4",
+		"PRIVATE/M4ROOT/SUB/C0001S03.MP4",
+		"Holiday/clip.thm",
+		"Holiday/clip.mp4",
+	} {
+		writeTestArtifact(t, sourceDir, rel)
+	}
+
+	result, err := enumerateFiles(sourceDir, config{SidecarDefault: SidecarDelete})
+	if err != nil {
+		t.Fatalf("enumerateFiles failed: %v", err)
+	}
+
+	type enumerated struct {
+		family   cameraFamily
+		category MediaCategory
+	}
+	files := make(map[string]enumerated)
+	for _, file := range result.Files {
+		rel, err := filepath.Rel(sourceDir, sourcePath(file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[filepath.ToSlash(rel)] = enumerated{file.CameraFamily, file.MediaCategory}
+	}
+	// Proxies follow proxy_action and thumbnails and playback data with a
+	// sidecar extension the sidecar settings; INDEX.BDM is left alone
+	want := map[string]enumerated{
+		"DCIM/100GOPRO/GX010042.MP4":            {cameraFamilyGoPro, Video},
+		"DCIM/100GOPRO/GL010042.LRV":            {cameraFamilyGoPro, Proxy},
+		"DCIM/100GOPRO/GX010042.THM":            {cameraFamilyGoPro, Sidecar},
+		"PRIVATE/AVCHD/BDMV/STREAM/00000.MTS":   {cameraFamilyAVCHD, Video},
+		"PRIVATE/AVCHD/BDMV/CLIPINF/00000.CPI":  {cameraFamilyAVCHD, Sidecar},
+		"PRIVATE/AVCHD/BDMV/PLAYLIST/00000.MPL": {cameraFamilyAVCHD, Sidecar},
+		"PRIVATE/M4ROOT/CLIP/C0001.MP4":         {cameraFamilySony, Video},
+		"PRIVATE/M4ROOT/SUB/C0001S03.MP4":       {cameraFamilySony, Proxy},
+		"Holiday/clip.thm":                      {"", Sidecar},
+		"Holiday/clip.mp4":                      {"", Video},
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("enumerated %v, want %v", files, want)
+	}
+	if len(result.CleanupTargets) != 0 {
+		t.Errorf("cleanup targets = %v, want none", result.CleanupTargets)
+	}
+}
+
+func TestImportMediaCameraLayoutDeletesOnlyHandledFiles(t *testing.T) {
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+	for _, rel := range []string{
+		"DCIM/100GOPRO/GX010042.MP4",
+		"DCIM/100GOPRO/GX010042.THM",
+		"DCIM/100GOPRO/GL010042.LRV",
+		"PRIVATE/M4ROOT/MEDIAPRO.XML",
+		"PRIVATE/M4ROOT/CLIP/C0001.MP4",
+		"PRIVATE/M4ROOT/SUB/C0001S03.MP4",
+	} {
+		writeTestArtifact(t, sourceDir, rel)
+	}
+
+	cfg := config{
+		SourceDir:       sourceDir,
+		DestDir:         destDir,
+		DeleteOriginals: true,
+		SidecarDefault:  SidecarDelete,
+		Sidecars:        map[string]SidecarAction{"thm": SidecarCopy},
+		ProxyAction:     ProxyIgnore,
+		Workers:         1,
+		Quiet:           true,
+	}
+	if err := importMedia(cfg); err != nil {
+		t.Fatalf("importMedia failed: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(destDir, "GX010042.THM")); err != nil {
+		t.Errorf("THM was not copied under sidecars thm: copy: %v", err)
+	}
+	for rel, kept := range map[string]bool{
+		"DCIM/100GOPRO/GX010042.MP4":      false,
+		"DCIM/100GOPRO/GX010042.THM":      false,
+		"PRIVATE/M4ROOT/CLIP/C0001.MP4":   false,
+		"DCIM/100GOPRO/GL010042.LRV":      true,
+		"PRIVATE/M4ROOT/SUB/C0001S03.MP4": true,
+		"PRIVATE/M4ROOT/MEDIAPRO.XML":     true,
+	} {
+		_, err := os.Stat(filepath.Join(sourceDir, filepath.FromSlash(rel)))
+		if kept && err != nil {
+			t.Errorf("%s was deleted although it was not copied: %v", rel, err)
+		}
+		if !kept && !os.IsNotExist(err) {
+			t.Errorf("%s was not deleted with the originals: %v", rel, err)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Action cameras split long recordings into chapter files. GoPro puts the
+// chapter number before the recording number (GX010042.MP4, GX020042.MP4),
+// so chapters of different recordings interleave when sorted by name.
+
+// ChapterInfo describes a file's place in a recording that the camera split
+// into several files
+type ChapterInfo struct {
+	Recording string    // Name of the first chapter without extension
+	Start     time.Time // Capture time of the first chapter
+	Part      int       // 1-based position in recording order
+	Count     int
+}
+
+// DestBaseName returns the base name shared by all chapters, with the part
+// suffix. base is the name planned for the recording as a whole.
+func (c ChapterInfo) DestBaseName(base string) string {
+	return fmt.Sprintf("%s_part%02d", base, c.Part)
+}
+
+var (
+	// goProChapterName matches GoPro HERO5 and later (GH/GX chapter number
+	// first) and earlier models (GOPR for the first chapter, GP for the rest)
+	goProChapterName = regexp.MustCompile(`^(?i)(GH|GX|GP)(\d{2})(\d{4})\.(mp4|mov)$`)
+	goProFirstName   = regexp.MustCompile(`^(?i)GOPR(\d{4})\.(mp4|mov)$`)
+	// insta360ChapterName matches VID_<start date>_<start time>_<lens>_<counter>;
+	// chapters share the start time and lens
+	insta360ChapterName = regexp.MustCompile(`^(?i)((?:PRO_)?VID_\d{8}_\d{6}_\d{2})_(\d{3})\.(insv|mp4)$`)
+	// djiSequenceName matches DJI_0001.MP4; DJI numbers chapters as separate
+	// files, so continuity is checked against the previous chapter's duration
+	djiSequenceName = regexp.MustCompile(`^(?i)(DJI_)(\d{4})\.(mp4|mov)$`)
+)
+
+// djiChapterMaxGap is the largest difference between the end of one DJI
+// chapter and the capture time of the next
+const djiChapterMaxGap = 3 * time.Second
+
+// chapterKey returns the recording a file belongs to and its order within
+// the recording for GoPro and Insta360 names. ok is false for other names.
+func chapterKey(name string) (recording string, order int, ok bool) {
+	if match := goProChapterName.FindStringSubmatch(name); match != nil {
+		chapter, _ := strconv.Atoi(match[2])
+		prefix := strings.ToUpper(match[1])
+		if prefix == "GP" {
+			// GOPR0042 is chapter 1, GP010042 chapter 2
+			return "GoPro GP" + match[3], chapter + 1, true
+		}
+		return "GoPro " + prefix + match[3], chapter, true
+	}
+	if match := goProFirstName.FindStringSubmatch(name); match != nil {
+		return "GoPro GP" + match[1], 1, true
+	}
+	if match := insta360ChapterName.FindStringSubmatch(name); match != nil {
+		counter, _ := strconv.Atoi(match[2])
+		return "Insta360 " + strings.ToUpper(match[1]), counter, true
+	}
+	return "", 0, false
+}
+
+// djiSequence returns the file number of a DJI_nnnn video
+func djiSequence(name string) (int, bool) {
+	match := djiSequenceName.FindStringSubmatch(name)
+	if match == nil {
+		return 0, false
+	}
+	n, _ := strconv.Atoi(match[2])
+	return n, true
+}
+
+// continuesDJIRecording returns true if next was recorded straight after
+// prev, which is how DJI cameras split long recordings.
+func continuesDJIRecording(prev, next FileInfo) bool {
+	prevNumber, _ := djiSequence(prev.SourceName)
+	nextNumber, _ := djiSequence(next.SourceName)
+	if nextNumber != prevNumber+1 || prev.VideoMetadata == nil || prev.VideoMetadata.Duration <= 0 {
+		return false
+	}
+	gap := next.CreationDateTime.Sub(prev.CreationDateTime.Add(prev.VideoMetadata.Duration))
+	return gap >= -djiChapterMaxGap && gap <= djiChapterMaxGap
+}
+
+// detectChapters finds chaptered recordings among videos and sets Chapter on
+// each chapter. It returns the number of recordings found.
+func detectChapters(files []FileInfo) int {
+	recordings := make(map[string][]int)
+	var keys []string
+	var djiFiles []int
+	for i := range files {
+		files[i].Chapter = nil
+		if files[i].MediaCategory != Video {
+			continue
+		}
+		if recording, _, ok := chapterKey(files[i].SourceName); ok {
+			key := files[i].SourceDir + "\x00" + recording
+			if _, exists := recordings[key]; !exists {
+				keys = append(keys, key)
+			}
+			recordings[key] = append(recordings[key], i)
+			continue
+		}
+		if _, ok := djiSequence(files[i].SourceName); ok {
+			djiFiles = append(djiFiles, i)
+		}
+	}
+
+	var groups [][]int
+	for _, key := range keys {
+		members := recordings[key]
+		sort.SliceStable(members, func(a, b int) bool {
+			_, orderA, _ := chapterKey(files[members[a]].SourceName)
+			_, orderB, _ := chapterKey(files[members[b]].SourceName)
+			return orderA < orderB
+		})
+		groups = append(groups, members)
+	}
+
+	sort.SliceStable(djiFiles, func(a, b int) bool {
+		fa, fb := files[djiFiles[a]], files[djiFiles[b]]
+		if fa.SourceDir != fb.SourceDir {
+			return fa.SourceDir < fb.SourceDir
+		}
+		na, _ := djiSequence(fa.SourceName)
+		nb, _ := djiSequence(fb.SourceName)
+		return na < nb
+	})
+	var current []int
+	for _, i := range djiFiles {
+		if len(current) > 0 {
+			prev := files[current[len(current)-1]]
+			if prev.SourceDir != files[i].SourceDir || !continuesDJIRecording(prev, files[i]) {
+				groups = append(groups, current)
+				current = nil
+			}
+		}
+		current = append(current, i)
+	}
+	groups = append(groups, current)
+
+	count := 0
+	for _, members := range groups {
+		if len(members) < 2 {
+			continue
+		}
+		count++
+		first := files[members[0]]
+		recording := strings.TrimSuffix(first.SourceName, filepath.Ext(first.SourceName))
+		for part, i := range members {
+			files[i].Chapter = &ChapterInfo{
+				Recording: recording,
+				Start:     first.CreationDateTime,
+				Part:      part + 1,
+				Count:     len(members),
+			}
+		}
+	}
+	return count
+}
+
+// chapterKeyOf identifies the recording of a chapter among all files
+func chapterKeyOf(file FileInfo) string {
+	return file.SourceDir + "\x00" + file.Chapter.Recording
+}
+
+// chapterDestName returns the destination name of a chapter with the
+// collision suffix of its recording. Every chapter shares the base name of
+// the recording, the first chapter's name or with rename_by_date_time its
+// capture time, and gets a part number.
+func chapterDestName(file FileInfo, suffix string, cfg config) string {
+	ext := filepath.Ext(file.SourceName)
+	if !cfg.RenameByDateTime {
+		return file.Chapter.DestBaseName(file.Chapter.Recording+suffix) + ext
+	}
+	return file.Chapter.DestBaseName(dateTimeBaseName(file.Chapter.Start, cfg)+suffix) + renamedExtension(file, ext, cfg)
+}
+
+// chapterGroupSuffix returns the collision suffix for the recording of
+// files[current], "" if none is needed. A recording takes one suffix for all
+// its chapters, the first under which every chapter's name is free or already
+// holds that chapter. All chapters land in the directory of files[current].
+func chapterGroupSuffix(files []FileInfo, current int, cfg config) (string, error) {
+	key := chapterKeyOf(files[current])
+	var members []int
+	for i := range files {
+		if files[i].Chapter != nil && chapterKeyOf(files[i]) == key && files[i].Status != StatusPairSkipped && files[i].LibraryPath == "" {
+			members = append(members, i)
+		}
+	}
+
+	dir := files[current].DestDir
+	for n := 0; n <= 999999; n++ {
+		suffix := ""
+		if n > 0 {
+			suffix = fmt.Sprintf("_%03d", n)
+		}
+		available := true
+		for _, i := range members {
+			name := chapterDestName(files[i], suffix, cfg)
+			if isDestinationPlanned(files, i, dir, name) {
+				available = false
+				break
+			}
+			path := filepath.Join(dir, name)
+			taken, err := exists(destinationFor(cfg), path)
+			if err != nil {
+				return "", fmt.Errorf("error checking file %s: %w", path, err)
+			}
+			if !taken {
+				continue
+			}
+			dup, err := isDuplicate(sourceFor(cfg), destinationFor(cfg), &files[i], path, cfg.ChecksumDuplicates)
+			if err != nil {
+				return "", err
+			}
+			if !dup {
+				available = false
+				break
+			}
+		}
+		if available {
+			return suffix, nil
+		}
+	}
+	return "", fmt.Errorf("couldn't find a unique filename after 999,999 attempts")
+}
+
+// printChapteredRecordings lists each chaptered recording as one clip with
+// the destinations of its chapters
+func printChapteredRecordings(files []FileInfo) {
+	chapters := make(map[string][]FileInfo)
+	var recordings []string
+	for _, file := range files {
+		if file.Chapter == nil {
+			continue
+		}
+		key := chapterKeyOf(file)
+		if _, exists := chapters[key]; !exists {
+			recordings = append(recordings, key)
+		}
+		chapters[key] = append(chapters[key], file)
+	}
+	if len(recordings) == 0 {
+		return
+	}
+
+	fmt.Printf("\nChaptered recordings: %d\n", len(recordings))
+	for _, key := range recordings {
+		members := chapters[key]
+		sort.Slice(members, func(a, b int) bool { return members[a].Chapter.Part < members[b].Chapter.Part })
+		var size int64
+		for _, file := range members {
+			size += file.Size
+		}
+		first := members[0]
+		fmt.Printf("  %s: %d chapters, %s, recorded %s\n", filepath.Join(first.SourceDir, first.Chapter.Recording), len(members), humanReadableSize(size), first.Chapter.Start.Format("2006-01-02 15:04:05"))
+		for _, file := range members {
+			fmt.Printf("    part %d: %s -> %s (%s)\n", file.Chapter.Part, file.SourceName, filepath.Join(file.DestDir, file.DestName), file.Status)
+		}
+	}
+}
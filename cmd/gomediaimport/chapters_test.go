@@ -0,0 +1,229 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func chapterTestVideo(name string, capturedAt time.Time, duration time.Duration) FileInfo {
+	file := FileInfo{
+		SourceName:       name,
+		SourceDir:        "/src",
+		CreationDateTime: capturedAt,
+		Size:             int64(1000 + len(name)),
+		MediaCategory:    Video,
+		FileType:         MP4,
+		ParentIndex:      -1,
+		PairIndex:        -1,
+	}
+	if duration > 0 {
+		file.VideoMetadata = &VideoMetadata{Duration: duration}
+	}
+	return file
+}
+
+func TestChapterKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		recording string
+		order     int
+		ok        bool
+	}{
+		{"GX010042.MP4", "GoPro GX0042", 1, true},
+		{"GX020042.MP4", "GoPro GX0042", 2, true},
+		{"gh030042.mp4", "GoPro GH0042", 3, true},
+		{"GOPR0042.MP4", "GoPro GP0042", 1, true},
+		{"GP010042.MP4", "GoPro GP0042", 2, true},
+		{"VID_20240615_103000_00_001.insv", "Insta360 VID_20240615_103000_00", 1, true},
+		{"VID_20240615_103000_10_002.insv", "Insta360 VID_20240615_103000_10", 2, true},
+		{"PRO_VID_20240615_103000_00_003.mp4", "Insta360 PRO_VID_20240615_103000_00", 3, true},
+		{"GX010042.JPG", "", 0, false},
+		{"DJI_0001.MP4", "", 0, false},
+		{"IMG_0001.MP4", "", 0, false},
+	}
+	for _, tt := range tests {
+		recording, order, ok := chapterKey(tt.name)
+		if recording != tt.recording || order != tt.order || ok != tt.ok {
+			t.Errorf("chapterKey(%q) = %q, %d, %v; want %q, %d, %v", tt.name, recording, order, ok, tt.recording, tt.order, tt.ok)
+		}
+	}
+}
+
+func TestDetectChapters(t *testing.T) {
+	base := time.Date(2024, 6, 15, 10, 30, 0, 0, time.Local)
+	files := []FileInfo{
+		// Two GoPro recordings whose chapters interleave by name
+		chapterTestVideo("GX010042.MP4", base, 0),
+		chapterTestVideo("GX010043.MP4", base.Add(time.Hour), 0),
+		chapterTestVideo("GX020042.MP4", base.Add(17*time.Minute), 0),
+		chapterTestVideo("GX030042.MP4", base.Add(34*time.Minute), 0),
+		// A single-chapter recording is not chaptered
+		chapterTestVideo("GX010044.MP4", base.Add(2*time.Hour), 0),
+		// Older GoPro naming
+		chapterTestVideo("GP010050.MP4", base.Add(3*time.Hour+26*time.Minute), 0),
+		chapterTestVideo("GOPR0050.MP4", base.Add(3*time.Hour), 0),
+		// DJI chapters follow each other without a gap
+		chapterTestVideo("DJI_0001.MP4", base.Add(4*time.Hour), 10*time.Minute),
+		chapterTestVideo("DJI_0002.MP4", base.Add(4*time.Hour+10*time.Minute+time.Second), 5*time.Minute),
+		// A later DJI clip is a new recording
+		chapterTestVideo("DJI_0003.MP4", base.Add(5*time.Hour), 5*time.Minute),
+		// Insta360 lens files of the same recording are separate recordings
+		chapterTestVideo("VID_20240615_160000_00_002.insv", base.Add(6*time.Hour+20*time.Minute), 0),
+		chapterTestVideo("VID_20240615_160000_00_001.insv", base.Add(6*time.Hour), 0),
+		chapterTestVideo("VID_20240615_160000_10_001.insv", base.Add(6*time.Hour), 0),
+	}
+
+	if recordings := detectChapters(files); recordings != 4 {
+		t.Fatalf("got %d recordings, want 4", recordings)
+	}
+
+	type chapter struct {
+		recording string
+		part      int
+		count     int
+	}
+	want := map[string]chapter{
+		"GX010042.MP4":                    {"GX010042", 1, 3},
+		"GX020042.MP4":                    {"GX010042", 2, 3},
+		"GX030042.MP4":                    {"GX010042", 3, 3},
+		"GOPR0050.MP4":                    {"GOPR0050", 1, 2},
+		"GP010050.MP4":                    {"GOPR0050", 2, 2},
+		"DJI_0001.MP4":                    {"DJI_0001", 1, 2},
+		"DJI_0002.MP4":                    {"DJI_0001", 2, 2},
+		"VID_20240615_160000_00_001.insv": {"VID_20240615_160000_00_001", 1, 2},
+		"VID_20240615_160000_00_002.insv": {"VID_20240615_160000_00_001", 2, 2},
+	}
+	for _, file := range files {
+		expected, chaptered := want[file.SourceName]
+		if !chaptered {
+			if file.Chapter != nil {
+				t.Errorf("%s: expected no chapter, got %+v", file.SourceName, *file.Chapter)
+			}
+			continue
+		}
+		if file.Chapter == nil {
+			t.Errorf("%s: expected chapter %+v, got none", file.SourceName, expected)
+			continue
+		}
+		got := chapter{file.Chapter.Recording, file.Chapter.Part, file.Chapter.Count}
+		if got != expected {
+			t.Errorf("%s: got %+v, want %+v", file.SourceName, got, expected)
+		}
+	}
+}
+
+func TestPlanDestinationsChapters(t *testing.T) {
+	base := time.Date(2024, 6, 30, 23, 50, 0, 0, time.Local)
+	newFiles := func() []FileInfo {
+		return []FileInfo{
+			chapterTestVideo("GX020042.MP4", base.Add(17*time.Minute), 0),
+			chapterTestVideo("GX010042.MP4", base, 0),
+			chapterTestVideo("GX010043.MP4", base.Add(time.Hour), 0),
+		}
+	}
+
+	t.Run("rename by date", func(t *testing.T) {
+		destDir := t.TempDir()
+		files := newFiles()
+		cfg := config{
+			DestDir:          destDir,
+			OrganizeByDate:   true,
+			RenameByDateTime: true,
+			SidecarDefault:   SidecarDelete,
+			Sidecars:         make(map[string]SidecarAction),
+		}
+		if err := planDestinations(files, cfg); err != nil {
+			t.Fatal(err)
+		}
+		// The second chapter starts in July but stays with its recording
+		june := filepath.Join(destDir, "2024", "06")
+		want := map[string]string{
+			"GX010042.MP4": filepath.Join(june, "20240630_235000_part01.mp4"),
+			"GX020042.MP4": filepath.Join(june, "20240630_235000_part02.mp4"),
+			"GX010043.MP4": filepath.Join(destDir, "2024", "07", "20240701_005000.mp4"),
+		}
+		got := destNamesBySource(files)
+		for sourceName, destPath := range want {
+			if got[sourceName] != destPath {
+				t.Errorf("%s: got destination %s, want %s", sourceName, got[sourceName], destPath)
+			}
+		}
+	})
+
+	t.Run("original names", func(t *testing.T) {
+		destDir := t.TempDir()
+		files := newFiles()
+		cfg := config{
+			DestDir:        destDir,
+			SidecarDefault: SidecarDelete,
+			Sidecars:       make(map[string]SidecarAction),
+		}
+		if err := planDestinations(files, cfg); err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]string{
+			"GX010042.MP4": filepath.Join(destDir, "GX010042_part01.MP4"),
+			"GX020042.MP4": filepath.Join(destDir, "GX010042_part02.MP4"),
+			"GX010043.MP4": filepath.Join(destDir, "GX010043.MP4"),
+		}
+		got := destNamesBySource(files)
+		for sourceName, destPath := range want {
+			if got[sourceName] != destPath {
+				t.Errorf("%s: got destination %s, want %s", sourceName, got[sourceName], destPath)
+			}
+		}
+	})
+
+	t.Run("collision suffix with original names", func(t *testing.T) {
+		destDir := t.TempDir()
+		writeLibraryTestFile(t, destDir, "GX010042_part01.MP4", "another clip")
+		files := newFiles()
+		cfg := config{
+			DestDir:        destDir,
+			SidecarDefault: SidecarDelete,
+			Sidecars:       make(map[string]SidecarAction),
+		}
+		if err := planDestinations(files, cfg); err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]string{
+			"GX010042.MP4": filepath.Join(destDir, "GX010042_001_part01.MP4"),
+			"GX020042.MP4": filepath.Join(destDir, "GX010042_001_part02.MP4"),
+		}
+		got := destNamesBySource(files)
+		for sourceName, destPath := range want {
+			if got[sourceName] != destPath {
+				t.Errorf("%s: got destination %s, want %s", sourceName, got[sourceName], destPath)
+			}
+		}
+	})
+
+	t.Run("collision suffix per recording", func(t *testing.T) {
+		destDir := t.TempDir()
+		june := filepath.Join(destDir, "2024", "06")
+		// Only the second chapter's name is taken, by a different file
+		writeLibraryTestFile(t, june, "20240630_235000_part02.mp4", "another clip")
+		files := newFiles()
+		cfg := config{
+			DestDir:          destDir,
+			OrganizeByDate:   true,
+			RenameByDateTime: true,
+			SidecarDefault:   SidecarDelete,
+			Sidecars:         make(map[string]SidecarAction),
+		}
+		if err := planDestinations(files, cfg); err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]string{
+			"GX010042.MP4": filepath.Join(june, "20240630_235000_001_part01.mp4"),
+			"GX020042.MP4": filepath.Join(june, "20240630_235000_001_part02.mp4"),
+		}
+		got := destNamesBySource(files)
+		for sourceName, destPath := range want {
+			if got[sourceName] != destPath {
+				t.Errorf("%s: got destination %s, want %s", sourceName, got[sourceName], destPath)
+			}
+		}
+	})
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// Checksum manifests list "<hex digest>  <file name>" lines for the files in
+// their directory, the format read by xxhsum -c and sha256sum -c.
+const (
+	xxhashManifestName = "checksums.xxhash"
+	sha256ManifestName = "SHA256SUMS"
+)
+
+type verifyCmd struct{}
+
+func isChecksumManifestName(name string) bool {
+	return name == xxhashManifestName || name == sha256ManifestName
+}
+
+// fileChecksums returns the xxh64 digest of a file and, if withSHA256 is set,
+// its SHA-256 digest, reading the file once.
+func fileChecksums(path string, withSHA256 bool) (string, string, error) {
+	if !withSHA256 {
+		checksum, err := calculateXXHash(path)
+		return checksum, "", err
+	}
+	xxh := xxhash.New()
+	sha := sha256.New()
+	if err := hashFile(path, xxh, sha); err != nil {
+		return "", "", err
+	}
+	return fmt.Sprintf("%016x", xxh.Sum64()), hex.EncodeToString(sha.Sum(nil)), nil
+}
+
+// readChecksumManifest returns the entries of a manifest keyed by file name.
+// A missing manifest yields no entries.
+func readChecksumManifest(path string) (map[string]string, error) {
+	entries := make(map[string]string)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return entries, nil
+		}
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// "  " marks text mode and " *" binary mode; both name the same file
+		digest, name, ok := strings.Cut(line, " ")
+		if !ok || (!strings.HasPrefix(name, " ") && !strings.HasPrefix(name, "*")) || len(name) < 2 {
+			return nil, fmt.Errorf("%s:%d: malformed checksum line", path, lineNumber)
+		}
+		entries[name[1:]] = strings.ToLower(digest)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// writeChecksumManifest replaces a manifest atomically, sorted by file name.
+func writeChecksumManifest(path string, entries map[string]string) error {
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s  %s\n", entries[name], name)
+	}
+
+	tmpPath := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err := os.WriteFile(tmpPath, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// writeChecksumManifests hashes every copied file and written XMP sidecar at
+// its destination and adds it to the manifests of its directory, keeping
+// entries for files imported earlier.
+func writeChecksumManifests(files []FileInfo, withSHA256 bool) error {
+	byDir := make(map[string][]string)
+	for _, file := range files {
+		if file.Status == StatusCopied {
+			byDir[file.DestDir] = append(byDir[file.DestDir], file.DestName)
+		}
+		if file.XMPSidecarName != "" {
+			byDir[file.DestDir] = append(byDir[file.DestDir], file.XMPSidecarName)
+		}
+	}
+
+	dirs := make([]string, 0, len(byDir))
+	for dir := range byDir {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	var manifestErrors []error
+	for _, dir := range dirs {
+		if err := updateChecksumManifests(dir, byDir[dir], withSHA256); err != nil {
+			manifestErrors = append(manifestErrors, fmt.Errorf("failed to update checksum manifest in %s: %w", dir, err))
+		}
+	}
+	return errors.Join(manifestErrors...)
+}
+
+func updateChecksumManifests(dir string, names []string, withSHA256 bool) error {
+	xxhashPath := filepath.Join(dir, xxhashManifestName)
+	xxhashEntries, err := readChecksumManifest(xxhashPath)
+	if err != nil {
+		return err
+	}
+	sha256Path := filepath.Join(dir, sha256ManifestName)
+	var sha256Entries map[string]string
+	if withSHA256 {
+		if sha256Entries, err = readChecksumManifest(sha256Path); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range names {
+		xxh, sha, err := fileChecksums(filepath.Join(dir, name), withSHA256)
+		if err != nil {
+			return fmt.Errorf("failed to calculate checksum for %s: %w", name, err)
+		}
+		xxhashEntries[name] = xxh
+		if withSHA256 {
+			sha256Entries[name] = sha
+		}
+	}
+
+	if err := writeChecksumManifest(xxhashPath, xxhashEntries); err != nil {
+		return err
+	}
+	if withSHA256 {
+		return writeChecksumManifest(sha256Path, sha256Entries)
+	}
+	return nil
+}
+
+// applyManifestChanges carries the manifest entries of changed library files
+// along: entries of removed files are dropped and entries of moved files move
+// to the manifests of their new directory. Content is unchanged, so nothing
+// is hashed, and no manifest is created where there was none.
+func applyManifestChanges(changes []libraryChange) error {
+	manifests := make(map[string]map[string]string)
+	load := func(path string) (map[string]string, error) {
+		if entries, ok := manifests[path]; ok {
+			return entries, nil
+		}
+		var entries map[string]string
+		if _, err := os.Stat(path); err == nil {
+			if entries, err = readChecksumManifest(path); err != nil {
+				return nil, err
+			}
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+		manifests[path] = entries
+		return entries, nil
+	}
+
+	changed := make(map[string]bool)
+	for _, change := range changes {
+		if change.To == change.From {
+			continue
+		}
+		for _, manifestName := range []string{xxhashManifestName, sha256ManifestName} {
+			fromPath := filepath.Join(filepath.Dir(change.From), manifestName)
+			from, err := load(fromPath)
+			if err != nil {
+				return err
+			}
+			digest, ok := from[filepath.Base(change.From)]
+			if !ok {
+				continue
+			}
+			delete(from, filepath.Base(change.From))
+			changed[fromPath] = true
+			if change.To == "" {
+				continue
+			}
+			toPath := filepath.Join(filepath.Dir(change.To), manifestName)
+			to, err := load(toPath)
+			if err != nil {
+				return err
+			}
+			if to != nil {
+				to[filepath.Base(change.To)] = digest
+				changed[toPath] = true
+			}
+		}
+	}
+
+	paths := make([]string, 0, len(changed))
+	for path := range changed {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var manifestErrors []error
+	for _, path := range paths {
+		if err := writeChecksumManifest(path, manifests[path]); err != nil {
+			manifestErrors = append(manifestErrors, fmt.Errorf("failed to update checksum manifest %s: %w", path, err))
+		}
+	}
+	return errors.Join(manifestErrors...)
+}
+
+// verifyResult lists library problems as paths relative to the library root
+type verifyResult struct {
+	Verified   int
+	Missing    []string
+	Changed    []string
+	Unexpected []string
+	Unverified []string // Directories with files but no manifest
+}
+
+// verifyLibrary re-hashes every file listed in the checksum manifests under
+// root. Files listed but absent are missing, files whose digest differs are
+// changed, and files not listed in their directory's manifests are
+// unexpected. A directory without manifests, such as one imported before
+// checksum_manifests was enabled, is reported once as unverified instead.
+// Hidden files and directories are skipped.
+func verifyLibrary(root string) (verifyResult, error) {
+	var result verifyResult
+	if _, err := os.Stat(root); err != nil {
+		return result, fmt.Errorf("error accessing library %s: %w", root, err)
+	}
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsPermission(err) {
+				fmt.Fprintf(os.Stderr, "Warning: Permission denied accessing %s, skipping\n", path)
+				if d != nil && d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			return fmt.Errorf("error accessing path %q: %w", path, err)
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != root && isLibraryMetadataName(d.Name()) {
+			return filepath.SkipDir
+		}
+		return verifyDirectory(root, path, &result)
+	})
+	if err != nil {
+		return result, fmt.Errorf("error walking the library %s: %w", root, err)
+	}
+
+	sort.Strings(result.Missing)
+	sort.Strings(result.Changed)
+	sort.Strings(result.Unexpected)
+	sort.Strings(result.Unverified)
+	return result, nil
+}
+
+func verifyDirectory(root, dir string, result *verifyResult) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	hasManifest, hasFiles := false, false
+	for _, entry := range entries {
+		if isChecksumManifestName(entry.Name()) {
+			hasManifest = true
+		} else if entry.Type().IsRegular() && !isLibraryMetadataName(entry.Name()) {
+			hasFiles = true
+		}
+	}
+	if !hasManifest {
+		if hasFiles {
+			result.Unverified = append(result.Unverified, libraryRelPath(root, dir))
+		}
+		return nil
+	}
+
+	xxhashEntries, err := readChecksumManifest(filepath.Join(dir, xxhashManifestName))
+	if err != nil {
+		return err
+	}
+	sha256Entries, err := readChecksumManifest(filepath.Join(dir, sha256ManifestName))
+	if err != nil {
+		return err
+	}
+
+	listed := make(map[string]bool)
+	for name := range xxhashEntries {
+		listed[name] = true
+	}
+	for name := range sha256Entries {
+		listed[name] = true
+	}
+
+	for name := range listed {
+		path := filepath.Join(dir, name)
+		relPath := libraryRelPath(root, path)
+		wantXXHash, hasXXHash := xxhashEntries[name]
+		wantSHA256, hasSHA256 := sha256Entries[name]
+
+		xxh, sha, err := fileChecksums(path, hasSHA256)
+		if err != nil {
+			if os.IsNotExist(err) {
+				result.Missing = append(result.Missing, relPath)
+				continue
+			}
+			return fmt.Errorf("failed to calculate checksum for %s: %w", path, err)
+		}
+		if (hasXXHash && xxh != wantXXHash) || (hasSHA256 && sha != wantSHA256) {
+			result.Changed = append(result.Changed, relPath)
+			continue
+		}
+		result.Verified++
+	}
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || isLibraryMetadataName(entry.Name()) || listed[entry.Name()] {
+			continue
+		}
+		result.Unexpected = append(result.Unexpected, libraryRelPath(root, filepath.Join(dir, entry.Name())))
+	}
+	return nil
+}
+
+func runVerifyCommand(cfg config) error {
+	if cfg.DestDir == "" {
+		return fmt.Errorf("destination directory is not specified")
+	}
+	if isRemoteDestination(cfg.DestDir) {
+		return fmt.Errorf("the verify command requires a local destination directory")
+	}
+
+	result, err := verifyLibrary(cfg.DestDir)
+	if err != nil {
+		return err
+	}
+
+	if !cfg.Quiet {
+		for _, path := range result.Missing {
+			fmt.Printf("Missing: %s\n", path)
+		}
+		for _, path := range result.Changed {
+			fmt.Printf("Changed: %s\n", path)
+		}
+		for _, path := range result.Unexpected {
+			fmt.Printf("Unexpected: %s\n", path)
+		}
+		for _, path := range result.Unverified {
+			fmt.Printf("Unverified: %s (no checksum manifest)\n", path)
+		}
+		fmt.Printf("Verified %d files: %d missing, %d changed, %d unexpected, %d directories unverified\n",
+			result.Verified, len(result.Missing), len(result.Changed), len(result.Unexpected), len(result.Unverified))
+	}
+
+	if len(result.Missing) > 0 || len(result.Changed) > 0 {
+		return fmt.Errorf("library verification failed: %d missing, %d changed", len(result.Missing), len(result.Changed))
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadChecksumManifest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), xxhashManifestName)
+	content := "# comment\n0123456789ABCDEF  IMG 0001.JPG\r\nfedcba9876543210 *IMG_0002.JPG\n\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := readChecksumManifest(path)
+	if err != nil {
+		t.Fatalf("readChecksumManifest failed: %v", err)
+	}
+	want := map[string]string{
+		"IMG 0001.JPG": "0123456789abcdef",
+		"IMG_0002.JPG": "fedcba9876543210",
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("got %v, want %v", entries, want)
+	}
+
+	if err := os.WriteFile(path, []byte("not a checksum line\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readChecksumManifest(path); err == nil {
+		t.Error("expected error for malformed manifest")
+	}
+}
+
+func TestImportMediaWritesChecksumManifests(t *testing.T) {
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+	writeLibraryTestFile(t, sourceDir, "IMG_0001.JPG", "photo one")
+	writeLibraryTestFile(t, sourceDir, "IMG_0002.JPG", "photo two")
+	// An earlier import's entry is kept when the manifest is updated
+	writeLibraryTestFile(t, destDir, "IMG_0000.JPG", "photo zero")
+	writeLibraryTestFile(t, destDir, xxhashManifestName, "1111111111111111  IMG_0000.JPG\n")
+
+	cfg := config{
+		SourceDir:          sourceDir,
+		DestDir:            destDir,
+		ChecksumDuplicates: true,
+		ChecksumManifests:  true,
+		ChecksumSHA256:     true,
+		SidecarDefault:     SidecarDelete,
+	}
+	if err := importMedia(cfg); err != nil {
+		t.Fatalf("importMedia failed: %v", err)
+	}
+
+	xxhashEntries, err := readChecksumManifest(filepath.Join(destDir, xxhashManifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantXXHash, err := calculateXXHash(filepath.Join(destDir, "IMG_0001.JPG"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(xxhashEntries) != 3 || xxhashEntries["IMG_0001.JPG"] != wantXXHash || xxhashEntries["IMG_0000.JPG"] != "1111111111111111" {
+		t.Errorf("unexpected xxhash manifest: %v", xxhashEntries)
+	}
+
+	data, err := os.ReadFile(filepath.Join(destDir, sha256ManifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sha256sum of "photo two"
+	wantLine := "c98e20d6905ee127cdc6a37d86a0c5fd2d8bfa8900b439498f01dc91242cb455  IMG_0002.JPG"
+	if !strings.Contains(string(data), wantLine+"\n") {
+		t.Errorf("expected SHA256SUMS to contain %q, got:\n%s", wantLine, data)
+	}
+}
+
+func TestVerifyLibrary(t *testing.T) {
+	root := t.TempDir()
+	ok := writeLibraryTestFile(t, root, "2024/03/IMG_0001.JPG", "photo one")
+	changed := writeLibraryTestFile(t, root, "2024/03/IMG_0002.JPG", "photo two")
+	missing := writeLibraryTestFile(t, root, "2024/03/IMG_0003.JPG", "photo three")
+	writeLibraryTestFile(t, root, "2024/03/IMG_0006.JPG", "not recorded")
+	// A directory without manifests is reported once, not file by file
+	writeLibraryTestFile(t, root, "2024/04/IMG_0004.JPG", "never recorded")
+	writeLibraryTestFile(t, root, "2024/04/IMG_0007.JPG", "never recorded either")
+	writeLibraryTestFile(t, root, ".gomediaimport-quarantine/IMG_0005.JPG", "hidden")
+
+	dir := filepath.Dir(ok)
+	if err := updateChecksumManifests(dir, []string{"IMG_0001.JPG", "IMG_0002.JPG", "IMG_0003.JPG"}, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(changed, []byte("photo 2!!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(missing); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := verifyLibrary(root)
+	if err != nil {
+		t.Fatalf("verifyLibrary failed: %v", err)
+	}
+	want := verifyResult{
+		Verified:   1,
+		Missing:    []string{"2024/03/IMG_0003.JPG"},
+		Changed:    []string{"2024/03/IMG_0002.JPG"},
+		Unexpected: []string{"2024/03/IMG_0006.JPG"},
+		Unverified: []string{"2024/04"},
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("got %+v, want %+v", result, want)
+	}
+}
+
+func TestRunVerifyCommand(t *testing.T) {
+	root := t.TempDir()
+	path := writeLibraryTestFile(t, root, "IMG_0001.JPG", "photo")
+	if err := updateChecksumManifests(root, []string{"IMG_0001.JPG"}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := captureStdout(t, func() error {
+		return run([]string{"cmd", "--config", emptyConfigFile(t), "--dest", root, "verify"})
+	})
+	if err != nil {
+		t.Fatalf("verify of an intact library failed: %v", err)
+	}
+	if !strings.Contains(output, "Verified 1 files: 0 missing, 0 changed, 0 unexpected, 0 directories unverified") {
+		t.Errorf("unexpected verify output:\n%s", output)
+	}
+
+	if err := os.WriteFile(path, []byte("bitrot"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output, err = captureStdout(t, func() error {
+		return run([]string{"cmd", "--config", emptyConfigFile(t), "--dest", root, "verify"})
+	})
+	if err == nil {
+		t.Fatal("expected verify to fail for a changed file")
+	}
+	if !strings.Contains(output, "Changed: IMG_0001.JPG") {
+		t.Errorf("expected changed file to be reported, got:\n%s", output)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// contactSheetsSubfolder is the directory below the thumbnail directory that
+// holds the contact sheet of each import session
+const contactSheetsSubfolder = "contact-sheets"
+
+// contactSheetEntry is one file on a contact sheet
+type contactSheetEntry struct {
+	Thumbnail   string // Thumbnail path relative to the sheet, "" if none
+	Source      string
+	Destination string
+	Status      string
+	Time        string
+	Camera      string
+	Category    string
+	Poster      string // Where a video's poster came from
+}
+
+// contactSheet is the data of a session's contact sheet
+type contactSheet struct {
+	Session     string
+	Created     string
+	Source      string
+	Destination string
+	Counts      []string
+	Entries     []contactSheetEntry
+}
+
+var contactSheetTemplate = template.Must(template.New("contact sheet").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Import {{.Created}}</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 1.5em; background: #f4f4f4; color: #222; }
+header p { margin: 0.2em 0; color: #555; }
+.sheet { display: grid; grid-template-columns: repeat(auto-fill, minmax(220px, 1fr)); gap: 1em; margin-top: 1.5em; }
+figure { margin: 0; background: #fff; border-radius: 6px; padding: 0.6em; box-shadow: 0 1px 3px rgba(0,0,0,0.15); }
+figure .frame { height: 160px; display: flex; align-items: center; justify-content: center; background: #e8e8e8; border-radius: 4px; overflow: hidden; }
+figure img { max-width: 100%; max-height: 160px; }
+figure .placeholder { color: #888; text-transform: uppercase; font-size: 0.8em; }
+figcaption { font-size: 0.8em; margin-top: 0.5em; word-break: break-all; }
+figcaption .status { font-weight: bold; }
+figcaption .status.failed, figcaption .status.unnamable { color: #b00020; }
+figcaption div { margin-top: 0.15em; }
+</style>
+</head>
+<body>
+<header>
+<h1>Import {{.Created}}</h1>
+<p>Session {{.Session}}</p>
+<p>{{.Source}} &rarr; {{.Destination}}</p>
+<p>{{range $i, $c := .Counts}}{{if $i}} &middot; {{end}}{{$c}}{{end}}</p>
+</header>
+<main class="sheet">
+{{- range .Entries}}
+<figure>
+<div class="frame">{{if .Thumbnail}}<img src="{{.Thumbnail}}" alt="{{.Source}}" loading="lazy">{{else}}<span class="placeholder">{{.Category}}</span>{{end}}</div>
+<figcaption>
+<div class="status {{.Status}}">{{.Status}}</div>
+<div>{{.Time}}{{if .Camera}} &middot; {{.Camera}}{{end}}</div>
+{{- if .Poster}}
+<div title="Poster">{{.Category}}, poster from {{.Poster}}</div>
+{{- end}}
+<div title="Source">{{.Source}}</div>
+{{- if .Destination}}
+<div title="Destination">&rarr; {{.Destination}}</div>
+{{- end}}
+</figcaption>
+</figure>
+{{- end}}
+</main>
+</body>
+</html>
+`))
+
+// fileCamera names the camera a file was recorded with, from its metadata
+// or else the camera folder layout it was found in
+func fileCamera(file FileInfo) string {
+	var maker, model string
+	switch {
+	case file.ImageMetadata != nil:
+		maker, model = file.ImageMetadata.Make, file.ImageMetadata.Model
+	case file.VideoMetadata != nil:
+		maker, model = file.VideoMetadata.Make, file.VideoMetadata.Model
+	}
+	switch {
+	case model != "" && (maker == "" || strings.HasPrefix(strings.ToLower(model), strings.ToLower(maker))):
+		return model
+	case model != "" || maker != "":
+		return strings.TrimSpace(maker + " " + model)
+	}
+	return string(file.CameraFamily)
+}
+
+// buildContactSheet collects the sheet data of an import. thumbnails maps
+// file indexes to their thumbnails in the thumbnail directory.
+func buildContactSheet(files []FileInfo, cfg config, session string, created time.Time, thumbnails map[int]thumbnail) contactSheet {
+	sheet := contactSheet{
+		Session:     session,
+		Created:     created.Format("2006-01-02 15:04:05"),
+		Source:      cfg.SourceDir,
+		Destination: redactDestination(cfg.DestDir),
+	}
+	order := make([]int, len(files))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		fa, fb := files[order[a]], files[order[b]]
+		if !fa.CreationDateTime.Equal(fb.CreationDateTime) {
+			return fa.CreationDateTime.Before(fb.CreationDateTime)
+		}
+		return naturalCompare(sourcePath(fa), sourcePath(fb)) < 0
+	})
+	counts := make(map[string]int)
+	var statuses []string
+	for _, i := range order {
+		file := files[i]
+		status := string(file.Status)
+		if status == "" {
+			status = "planned"
+		}
+		if counts[status] == 0 {
+			statuses = append(statuses, status)
+		}
+		counts[status]++
+		entry := contactSheetEntry{
+			Source:   sourcePath(file),
+			Status:   status,
+			Time:     file.CreationDateTime.Format("2006-01-02 15:04:05"),
+			Camera:   fileCamera(file),
+			Category: strings.ReplaceAll(string(file.MediaCategory), "_", " "),
+		}
+		if file.DestName != "" {
+			entry.Destination = filepath.Join(file.DestDir, file.DestName)
+		}
+		if thumb, ok := thumbnails[i]; ok {
+			entry.Thumbnail = "../" + thumb.Name
+			entry.Poster = string(thumb.Poster)
+		}
+		sheet.Entries = append(sheet.Entries, entry)
+	}
+	for _, status := range statuses {
+		sheet.Counts = append(sheet.Counts, fmt.Sprintf("%d %s", counts[status], status))
+	}
+	return sheet
+}
+
+// writeContactSheet makes the thumbnails of an import and its contact sheet
+// and returns the path of the sheet
+func writeContactSheet(files []FileInfo, cfg config, session string) (string, error) {
+	dir := cfg.ThumbnailDirectory
+	if dir == "" {
+		var err error
+		if dir, err = defaultThumbnailDirectory(); err != nil {
+			return "", err
+		}
+	}
+	thumbnails, err := writeThumbnails(files, cfg, dir)
+	if err != nil {
+		return "", err
+	}
+	if cfg.Verbose && cfg.VideoPosters {
+		printVideoPosters(files, thumbnails)
+	}
+	sheetsDir := filepath.Join(dir, contactSheetsSubfolder)
+	if err := os.MkdirAll(sheetsDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create contact sheet directory: %w", err)
+	}
+	created := time.Now()
+	path := filepath.Join(sheetsDir, fmt.Sprintf("%s_%s.html", created.Format("20060102_150405"), strings.SplitN(session, "-", 2)[0]))
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if err := contactSheetTemplate.Execute(f, buildContactSheet(files, cfg, session, created, thumbnails)); err != nil {
+		_ = f.Close()
+		return "", err
+	}
+	return path, f.Close()
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/bep/imagemeta"
+)
+
+// mediaTypesConfig is the media_types section of the config file. It extends
+// the compiled-in file type and sidecar tables so that new camera formats can
+// be imported without waiting for a release.
+type mediaTypesConfig struct {
+	// Extensions adds extensions to existing file types
+	Extensions map[FileType][]string `yaml:"extensions,omitempty"`
+	// Types defines new file types
+	Types []customFileType `yaml:"types,omitempty"`
+	// Sidecars declares new sidecar extensions with their default actions
+	Sidecars map[string]SidecarAction `yaml:"sidecars,omitempty"`
+}
+
+// customFileType is a file type defined in the config file
+type customFileType struct {
+	Name       FileType       `yaml:"name"`
+	Category   MediaCategory  `yaml:"category"`
+	Extensions []string       `yaml:"extensions"`
+	Metadata   metadataParser `yaml:"metadata,omitempty"`
+}
+
+// metadataParser names the reader used for the capture time of a custom file
+// type
+type metadataParser string
+
+const (
+	// metadataParserNone dates files by their modification time
+	metadataParserNone metadataParser = "none"
+	// metadataParserISOBMFF reads the QuickTime creation time of MP4-like
+	// containers
+	metadataParserISOBMFF metadataParser = "isobmff"
+	// metadataParserBWF reads the origination time of Broadcast Wave files
+	metadataParserBWF metadataParser = "bwf"
+)
+
+// imageMetadataParsers are the EXIF readers custom picture types can use
+var imageMetadataParsers = map[metadataParser]imagemeta.ImageFormat{
+	"jpeg": imagemeta.JPEG,
+	"tiff": imagemeta.TIFF,
+	"png":  imagemeta.PNG,
+	"webp": imagemeta.WebP,
+	"heif": imagemeta.HEIF,
+	"avif": imagemeta.AVIF,
+	"dng":  imagemeta.DNG,
+	"cr2":  imagemeta.CR2,
+	"nef":  imagemeta.NEF,
+	"arw":  imagemeta.ARW,
+	"pef":  imagemeta.PEF,
+}
+
+var customFileTypeName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// normalizeExtension lowercases an extension and removes its leading dot
+func normalizeExtension(ext string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+}
+
+// effectiveMetadataParser returns the parser to use, defaulting to none
+func effectiveMetadataParser(parser metadataParser) metadataParser {
+	if parser == "" {
+		return metadataParserNone
+	}
+	return parser
+}
+
+// isValidMetadataParser returns true if the parser can read files of the
+// category
+func isValidMetadataParser(parser metadataParser, category MediaCategory) bool {
+	switch parser {
+	case "", metadataParserNone:
+		return true
+	case metadataParserISOBMFF:
+		return category == Video || category == Audio
+	case metadataParserBWF:
+		return category == Audio
+	}
+	_, ok := imageMetadataParsers[parser]
+	return ok && (category == ProcessedPicture || category == RawPicture)
+}
+
+// isValidCustomCategory returns true if new file types may use the category.
+// Sidecars and proxies are declared through their own settings.
+func isValidCustomCategory(category MediaCategory) bool {
+	switch category {
+	case ProcessedPicture, RawPicture, Video, RawVideo, Audio:
+		return true
+	default:
+		return false
+	}
+}
+
+// validateExtension checks an extension from the media_types section
+func validateExtension(ext string) error {
+	normalized := normalizeExtension(ext)
+	if normalized == "" || strings.ContainsAny(normalized, `./\ `) {
+		return fmt.Errorf("invalid extension: %q", ext)
+	}
+	return nil
+}
+
+// validateMediaTypes checks the media_types section against the compiled-in
+// tables: every extension may belong to only one file type, sidecar or proxy.
+func validateMediaTypes(mediaTypes mediaTypesConfig) error {
+	owners := make(map[string]string)
+	for _, ft := range builtinFileTypes {
+		for _, ext := range ft.Extensions {
+			owners[ext] = fmt.Sprintf("file type %q", ft.FileType)
+		}
+	}
+	for ext := range builtinSidecarDefaults {
+		owners[ext] = "the sidecars"
+	}
+	for ext := range proxyExtensions {
+		owners[ext] = "the proxies"
+	}
+	claim := func(ext, owner string) error {
+		if err := validateExtension(ext); err != nil {
+			return err
+		}
+		ext = normalizeExtension(ext)
+		if existing, ok := owners[ext]; ok && existing != owner {
+			return fmt.Errorf("extension %q for %s already belongs to %s", ext, owner, existing)
+		}
+		owners[ext] = owner
+		return nil
+	}
+
+	known := make(map[FileType]bool)
+	for _, ft := range builtinFileTypes {
+		known[ft.FileType] = true
+	}
+	for _, custom := range mediaTypes.Types {
+		if !customFileTypeName.MatchString(string(custom.Name)) {
+			return fmt.Errorf("invalid media type name: %q (must be lowercase letters, digits, or underscores)", custom.Name)
+		}
+		if known[custom.Name] {
+			return fmt.Errorf("media type %q is already defined", custom.Name)
+		}
+		known[custom.Name] = true
+		if !isValidCustomCategory(custom.Category) {
+			return fmt.Errorf("invalid category for media type %q: %q (must be processed_picture, raw_picture, video, raw_video, or audio)", custom.Name, custom.Category)
+		}
+		if !isValidMetadataParser(custom.Metadata, custom.Category) {
+			return fmt.Errorf("invalid metadata parser for media type %q: %q (must be %s)", custom.Name, custom.Metadata, metadataParserChoices(custom.Category))
+		}
+		if len(custom.Extensions) == 0 {
+			return fmt.Errorf("media type %q has no extensions", custom.Name)
+		}
+		for _, ext := range custom.Extensions {
+			if err := claim(ext, fmt.Sprintf("file type %q", custom.Name)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, fileType := range slices.Sorted(maps.Keys(mediaTypes.Extensions)) {
+		if !known[fileType] {
+			return fmt.Errorf("unknown media type for extensions: %q", fileType)
+		}
+		for _, ext := range mediaTypes.Extensions[fileType] {
+			if err := claim(ext, fmt.Sprintf("file type %q", fileType)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, ext := range slices.Sorted(maps.Keys(mediaTypes.Sidecars)) {
+		if _, ok := builtinSidecarDefaults[normalizeExtension(ext)]; ok {
+			return fmt.Errorf("extension %q is already a sidecar; set its action under sidecars", ext)
+		}
+		if err := claim(ext, "the sidecars"); err != nil {
+			return err
+		}
+		if action := mediaTypes.Sidecars[ext]; !isValidSidecarAction(action) {
+			return fmt.Errorf("invalid sidecar action for extension %q: %q (must be ignore, copy, or delete)", ext, action)
+		}
+	}
+	return nil
+}
+
+// metadataParserChoices lists the parsers a category accepts
+func metadataParserChoices(category MediaCategory) string {
+	choices := []string{string(metadataParserNone)}
+	switch category {
+	case Video:
+		choices = append(choices, string(metadataParserISOBMFF))
+	case Audio:
+		choices = append(choices, string(metadataParserISOBMFF), string(metadataParserBWF))
+	case ProcessedPicture, RawPicture:
+		for _, name := range slices.Sorted(maps.Keys(imageMetadataParsers)) {
+			choices = append(choices, string(name))
+		}
+	}
+	if len(choices) == 1 {
+		return choices[0]
+	}
+	return strings.Join(choices[:len(choices)-1], ", ") + ", or " + choices[len(choices)-1]
+}
+
+// newMediaTypeTables builds the tables files are classified by from the
+// compiled-in ones and a validated media_types section
+func newMediaTypeTables(mediaTypes mediaTypesConfig) *mediaTypeTables {
+	types := make([]FileTypeInfo, 0, len(builtinFileTypes)+len(mediaTypes.Types))
+	for _, ft := range builtinFileTypes {
+		ft.Extensions = slices.Clone(ft.Extensions)
+		types = append(types, ft)
+	}
+	extensions := maps.Clone(mediaTypes.Extensions)
+	if extensions == nil {
+		extensions = make(map[FileType][]string)
+	}
+	parsers := make(map[FileType]metadataParser, len(mediaTypes.Types))
+	for _, custom := range mediaTypes.Types {
+		types = append(types, FileTypeInfo{FileType: custom.Name, MediaCategory: custom.Category})
+		parsers[custom.Name] = effectiveMetadataParser(custom.Metadata)
+		extensions[custom.Name] = append(slices.Clone(custom.Extensions), extensions[custom.Name]...)
+	}
+	for i := range types {
+		for _, ext := range extensions[types[i].FileType] {
+			if ext = normalizeExtension(ext); !slices.Contains(types[i].Extensions, ext) {
+				types[i].Extensions = append(types[i].Extensions, ext)
+			}
+		}
+	}
+
+	sidecars := maps.Clone(builtinSidecarDefaults)
+	for ext, action := range mediaTypes.Sidecars {
+		sidecars[normalizeExtension(ext)] = action
+	}
+	return &mediaTypeTables{fileTypes: types, sidecarDefaults: sidecars, metadataParsers: parsers}
+}
+
+// mediaTables returns the tables files are classified by, which are the
+// compiled-in ones unless run built them from media_types
+func (cfg config) mediaTables() *mediaTypeTables {
+	if cfg.MediaTables == nil {
+		return builtinMediaTypes
+	}
+	return cfg.MediaTables
+}
+
+// extractCustomMetadata reads the capture time of a file of a type defined in
+// the config file with the parser it names
+func extractCustomMetadata(src mediaSource, fileInfo FileInfo, parser metadataParser) (mediaMetadata, error) {
+	filePath := filepath.Join(fileInfo.SourceDir, fileInfo.SourceName)
+	switch parser {
+	case metadataParserISOBMFF:
+		if fileInfo.MediaCategory == Audio {
+			return extractAudioMetadata(src, filePath, M4A, fileInfo.CreationDateTime)
+		}
+		return extractVideoMetadata(src, filePath, MP4, fileInfo.CreationDateTime)
+	case metadataParserBWF:
+		return extractAudioMetadata(src, filePath, WAV, fileInfo.CreationDateTime)
+	}
+	if format, ok := imageMetadataParsers[parser]; ok {
+		return extractImageMetadata(src, filePath, format)
+	}
+	return mediaMetadata{}, fmt.Errorf("no metadata parser for %s files", fileInfo.FileType)
+}
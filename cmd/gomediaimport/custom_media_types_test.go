@@ -0,0 +1,202 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateMediaTypes(t *testing.T) {
+	tests := []struct {
+		name       string
+		mediaTypes mediaTypesConfig
+		wantErr    string
+	}{
+		{"empty", mediaTypesConfig{}, ""},
+		{"valid", mediaTypesConfig{
+			Extensions: map[FileType][]string{RAW: {".NRW", "3fr", "iiq"}, HEIF: {"avif"}},
+			Types: []customFileType{
+				{Name: "braw_proxy", Category: RawVideo, Extensions: []string{"brp"}},
+				{Name: "nikon_coolpix", Category: RawPicture, Extensions: []string{"ncr"}, Metadata: "nef"},
+				{Name: "field_audio", Category: Audio, Extensions: []string{"w64"}, Metadata: metadataParserBWF},
+			},
+			Sidecars: map[string]SidecarAction{"dop": SidecarCopy, "pp3": SidecarCopy},
+		}, ""},
+		// Repeating a type's own extension is harmless
+		{"existing extension of the same type", mediaTypesConfig{Extensions: map[FileType][]string{RAW: {"nef"}}}, ""},
+		{"unknown type", mediaTypesConfig{Extensions: map[FileType][]string{"hologram": {"hlg"}}}, "unknown media type"},
+		{"extension of another type", mediaTypesConfig{Extensions: map[FileType][]string{JPEG: {"png"}}}, `already belongs to file type "png"`},
+		{"extension of a proxy", mediaTypesConfig{Extensions: map[FileType][]string{MP4: {"lrv"}}}, "already belongs to the proxies"},
+		{"extension with a path", mediaTypesConfig{Extensions: map[FileType][]string{RAW: {"a/b"}}}, "invalid extension"},
+		{"empty extension", mediaTypesConfig{Extensions: map[FileType][]string{RAW: {"."}}}, "invalid extension"},
+		{"redefined type", mediaTypesConfig{Types: []customFileType{{Name: RAW, Category: RawPicture, Extensions: []string{"iiq"}}}}, "already defined"},
+		{"type name", mediaTypesConfig{Types: []customFileType{{Name: "Phase One", Category: RawPicture, Extensions: []string{"iiq"}}}}, "invalid media type name"},
+		{"sidecar category", mediaTypesConfig{Types: []customFileType{{Name: "dop", Category: Sidecar, Extensions: []string{"dop"}}}}, "invalid category"},
+		{"parser for another category", mediaTypesConfig{Types: []customFileType{{Name: "clip", Category: Video, Extensions: []string{"clp"}, Metadata: "nef"}}}, "must be none, or isobmff"},
+		{"unknown parser", mediaTypesConfig{Types: []customFileType{{Name: "scan", Category: ProcessedPicture, Extensions: []string{"scn"}, Metadata: "ocr"}}}, "invalid metadata parser"},
+		{"type without extensions", mediaTypesConfig{Types: []customFileType{{Name: "scan", Category: ProcessedPicture}}}, "has no extensions"},
+		{"built-in sidecar", mediaTypesConfig{Sidecars: map[string]SidecarAction{"xmp": SidecarCopy}}, "set its action under sidecars"},
+		{"sidecar of a media type", mediaTypesConfig{Sidecars: map[string]SidecarAction{"jpg": SidecarCopy}}, `already belongs to file type "jpeg"`},
+		{"sidecar action", mediaTypesConfig{Sidecars: map[string]SidecarAction{"dop": "archive"}}, "invalid sidecar action"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateMediaTypes(tt.mediaTypes)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateMediaTypes failed: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateMediaTypes = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMediaTypesConfigParsing(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	content := `
+media_types:
+  extensions:
+    raw: [nrw, iiq]
+  types:
+    - name: hasselblad
+      category: raw_picture
+      extensions: [3fr, fff]
+      metadata: tiff
+  sidecars:
+    dop: copy
+`
+	if err := os.WriteFile(configFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config{ConfigFile: configFile}
+	if err := parseConfigFile(cfg); err != nil {
+		t.Fatalf("parseConfigFile failed: %v", err)
+	}
+	want := mediaTypesConfig{
+		Extensions: map[FileType][]string{RAW: {"nrw", "iiq"}},
+		Types:      []customFileType{{Name: "hasselblad", Category: RawPicture, Extensions: []string{"3fr", "fff"}, Metadata: "tiff"}},
+		Sidecars:   map[string]SidecarAction{"dop": SidecarCopy},
+	}
+	if !reflect.DeepEqual(cfg.MediaTypes, want) {
+		t.Errorf("media_types = %+v, want %+v", cfg.MediaTypes, want)
+	}
+
+	// Unknown keys are rejected like everywhere else in the config
+	if err := os.WriteFile(configFile, []byte("media_types:\n  formats: [iiq]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := parseConfigFile(&config{ConfigFile: configFile}); err == nil {
+		t.Error("parseConfigFile should reject unknown media_types keys")
+	}
+}
+
+func TestRunValidatesMediaTypes(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configFile, []byte("media_types:\n  extensions:\n    raw: [jpg]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Every command validates media_types, including those that do not
+	// validate the rest of the configuration
+	for _, command := range []string{"verify", "index"} {
+		err := run([]string{"cmd", "--config", configFile, "--dest", t.TempDir(), command})
+		if err == nil || !strings.Contains(err.Error(), "invalid media_types") {
+			t.Errorf("%s with an extension claimed by two types = %v, want an invalid media_types error", command, err)
+		}
+	}
+}
+
+func TestNewMediaTypeTables(t *testing.T) {
+	types := newMediaTypeTables(mediaTypesConfig{
+		Extensions: map[FileType][]string{RAW: {".IIQ"}},
+		Types:      []customFileType{{Name: "hasselblad", Category: RawPicture, Extensions: []string{"3fr", "fff"}, Metadata: "tiff"}},
+		Sidecars:   map[string]SidecarAction{"DOP": SidecarCopy},
+	})
+
+	for name, want := range map[string]FileType{"P0001.IIQ": RAW, "B0001.3FR": "hasselblad", "B0002.fff": "hasselblad", "IMG_0001.CR2": RAW} {
+		category, fileType := types.getMediaTypeInfo(FileInfo{SourceName: name})
+		if category != RawPicture || fileType != want {
+			t.Errorf("getMediaTypeInfo(%s) = %s/%s, want %s/%s", name, category, fileType, RawPicture, want)
+		}
+	}
+	if !types.isSidecarExtension("dop") || types.getSidecarAction("dop", nil, SidecarDelete) != SidecarCopy {
+		t.Error("declared sidecar dop should be copied by default")
+	}
+	if types.metadataParsers["hasselblad"] != "tiff" || types.metadataParsers[RAW] != "" {
+		t.Error("only types defined in the config should have a metadata parser")
+	}
+	if got := types.getFirstExtensionForFileType("hasselblad"); got != "3fr" {
+		t.Errorf("first extension = %q, want 3fr", got)
+	}
+
+	// The compiled-in tables are left as they are
+	if category, _ := builtinMediaTypes.getMediaTypeInfo(FileInfo{SourceName: "B0001.3FR"}); category != "" {
+		t.Errorf("3FR is recognized as %s without media_types", category)
+	}
+	if category, _ := builtinMediaTypes.getMediaTypeInfo(FileInfo{SourceName: "P0001.IIQ"}); category != "" {
+		t.Errorf("IIQ is recognized as %s without media_types", category)
+	}
+	if builtinMediaTypes.isSidecarExtension("dop") {
+		t.Error("dop is a sidecar without media_types")
+	}
+	if slices.Contains(builtinFileTypes[slices.IndexFunc(builtinFileTypes, func(ft FileTypeInfo) bool { return ft.FileType == RAW })].Extensions, "iiq") {
+		t.Error("building the tables changed the compiled-in RAW extensions")
+	}
+}
+
+func TestImportMediaCustomMediaTypes(t *testing.T) {
+	mediaTypes := mediaTypesConfig{
+		Extensions: map[FileType][]string{RAW: {"iiq"}},
+		Types:      []customFileType{{Name: "hasselblad", Category: RawPicture, Extensions: []string{"3fr"}}},
+		Sidecars:   map[string]SidecarAction{"dop": SidecarCopy},
+	}
+
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+	base := time.Date(2024, 3, 1, 9, 0, 0, 0, time.Local)
+	for i, file := range []struct {
+		name    string
+		content string
+	}{
+		// A TIFF header does not override a type the config defines
+		{"B0001.3FR", "MM\x00*\x00\x00\x00\x08 hasselblad"},
+		{"P0002.IIQ", "phase one"},
+		{"P0002.dop", "dxo settings"},
+	} {
+		path := filepath.Join(sourceDir, file.name)
+		if err := os.WriteFile(path, []byte(file.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := base.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := config{
+		SourceDir:        sourceDir,
+		DestDir:          destDir,
+		RenameByDateTime: true,
+		SidecarDefault:   SidecarDelete,
+		MediaTypes:       mediaTypes,
+		MediaTables:      newMediaTypeTables(mediaTypes),
+	}
+	if err := importMedia(cfg); err != nil {
+		t.Fatalf("importMedia failed: %v", err)
+	}
+	want := []string{
+		"20240301_090000.3fr",
+		"20240301_090100.dop",
+		"20240301_090100.iiq",
+	}
+	if got := listTree(t, destDir); !reflect.DeepEqual(got, want) {
+		t.Errorf("destination = %v, want %v", got, want)
+	}
+}
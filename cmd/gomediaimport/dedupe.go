@@ -0,0 +1,583 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// DedupeAction is what the dedupe command does with redundant copies
+type DedupeAction string
+
+const (
+	DedupeDryRun     DedupeAction = "dry_run"
+	DedupeHardlink   DedupeAction = "hardlink"
+	DedupeQuarantine DedupeAction = "quarantine"
+	DedupeDelete     DedupeAction = "delete"
+)
+
+// defaultQuarantineDirName is created in the library root. It is hidden so
+// it is never scanned or indexed as library content.
+const defaultQuarantineDirName = ".gomediaimport-quarantine"
+
+type dedupeCmd struct {
+	Action        string `arg:"--action" help:"What to do with redundant copies (dry_run/hardlink/quarantine/delete)" default:"dry_run"`
+	QuarantineDir string `arg:"--quarantine-dir" help:"Directory for quarantined copies (default: .gomediaimport-quarantine in the library)"`
+	Report        string `arg:"--report" help:"Write a JSON report to this file"`
+}
+
+func isValidDedupeAction(action DedupeAction) bool {
+	switch action {
+	case DedupeDryRun, DedupeHardlink, DedupeQuarantine, DedupeDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// dedupeReport is the JSON report of a dedupe run. Paths are relative to Root.
+type dedupeReport struct {
+	Root            string        `json:"root"`
+	Action          DedupeAction  `json:"action"`
+	Applied         bool          `json:"applied"`
+	Groups          []dedupeGroup `json:"groups"`
+	RedundantFiles  int           `json:"redundant_files"`
+	RedundantBytes  int64         `json:"redundant_bytes"`
+	SidecarsMoved   int           `json:"sidecars_moved"`
+	FailedFileCount int           `json:"failed_files"`
+}
+
+type dedupeGroup struct {
+	Size       int64             `json:"size"`
+	XXHash     string            `json:"xxh64"`
+	Keep       string            `json:"keep"`
+	Duplicates []dedupeDuplicate `json:"duplicates"`
+}
+
+type dedupeDuplicate struct {
+	Path           string          `json:"path"`
+	Action         DedupeAction    `json:"action"`
+	QuarantinePath string          `json:"quarantine_path,omitempty"`
+	Error          string          `json:"error,omitempty"`
+	Sidecars       []dedupeSidecar `json:"sidecars,omitempty"`
+}
+
+// dedupeSidecar records what happened to a sidecar of a redundant copy.
+// Action is "move" when it is renamed next to the surviving copy, "keep" when
+// the survivor already has a different sidecar of that type, and "duplicate"
+// when the survivor already has an identical one, in which case the sidecar
+// is handled like the redundant copy itself.
+type dedupeSidecar struct {
+	Path   string `json:"path"`
+	Action string `json:"action"`
+	Target string `json:"target,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// libraryFile is a regular file found while scanning a library for duplicates
+type libraryFile struct {
+	Path string
+	Info os.FileInfo
+}
+
+// duplicateGroup holds byte-identical files. Files[0] is the copy to keep.
+type duplicateGroup struct {
+	XXHash string
+	Files  []libraryFile
+}
+
+func runDedupeCommand(cmd *dedupeCmd, cfg config) error {
+	if cfg.DestDir == "" {
+		return fmt.Errorf("destination directory is not specified")
+	}
+	if isRemoteDestination(cfg.DestDir) {
+		return fmt.Errorf("the dedupe command requires a local destination directory")
+	}
+	action := DedupeAction(cmd.Action)
+	if !isValidDedupeAction(action) {
+		return fmt.Errorf("invalid dedupe action %q: must be one of dry_run, hardlink, quarantine, delete", cmd.Action)
+	}
+	quarantineDir := cmd.QuarantineDir
+	if quarantineDir == "" {
+		quarantineDir = filepath.Join(cfg.DestDir, defaultQuarantineDirName)
+	}
+
+	report, err := dedupeLibrary(cfg.DestDir, action, quarantineDir, cfg)
+	if report != nil && cmd.Report != "" {
+		if writeErr := writeDedupeReport(cmd.Report, report); writeErr != nil {
+			err = errors.Join(err, writeErr)
+		}
+	}
+	if report != nil && !cfg.Quiet {
+		printDedupeSummary(report, cmd.Report)
+	}
+	return err
+}
+
+// dedupeLibrary finds byte-identical media files under root, keeps one copy of
+// each and applies action to the others. Like isDuplicate, sizes are compared
+// first and only files with a size match are hashed. Per-file failures are
+// recorded in the report and returned together.
+func dedupeLibrary(root string, action DedupeAction, quarantineDir string, cfg config) (*dedupeReport, error) {
+	media, sidecars, err := scanLibraryForDedupe(root, quarantineDir, cfg.mediaTables())
+	if err != nil {
+		return nil, err
+	}
+
+	apply := action != DedupeDryRun && !cfg.DryRun
+	report := &dedupeReport{Root: root, Action: action, Applied: apply, Groups: []dedupeGroup{}}
+	groups, hashErrors := findDuplicateGroups(media)
+	actionErrors := hashErrors
+
+	// removed tracks media paths that will no longer exist under their own
+	// name, so sidecars only move once no media file is left for them.
+	removed := make(map[string]bool)
+	for _, group := range groups {
+		for _, file := range group.Files[1:] {
+			removed[file.Path] = true
+		}
+	}
+	claimedTargets := make(map[string]bool)
+	var changes []libraryChange
+
+	for _, group := range groups {
+		survivor := group.Files[0]
+		reportGroup := dedupeGroup{
+			Size:   survivor.Info.Size(),
+			XXHash: group.XXHash,
+			Keep:   libraryRelPath(root, survivor.Path),
+		}
+
+		for _, file := range group.Files[1:] {
+			if cfg.Verbose {
+				fmt.Printf("Duplicate: %s (same as %s)\n", file.Path, survivor.Path)
+			}
+			duplicate := dedupeDuplicate{Path: libraryRelPath(root, file.Path), Action: action}
+			report.RedundantFiles++
+			report.RedundantBytes += file.Info.Size()
+
+			// A hardlinked copy keeps its name, so its sidecars stay valid
+			if action != DedupeHardlink {
+				duplicate.Sidecars = planDedupeSidecars(root, file.Path, survivor.Path, sidecars, removed, claimedTargets, cfg.mediaTables())
+			}
+
+			if apply {
+				quarantinePath, err := applyDedupeAction(root, file.Path, survivor.Path, action, quarantineDir)
+				duplicate.QuarantinePath = quarantinePath
+				if err != nil {
+					duplicate.Error = err.Error()
+					report.FailedFileCount++
+					actionErrors = append(actionErrors, fmt.Errorf("failed to %s %s: %w", action, file.Path, err))
+					// The copy is still in place, so its sidecars stay with it
+					duplicate.Sidecars = nil
+				} else {
+					changes = append(changes, dedupeChange(file.Path, action))
+					for i := range duplicate.Sidecars {
+						sidecar := &duplicate.Sidecars[i]
+						if err := applyDedupeSidecar(root, sidecar, action, quarantineDir); err != nil {
+							report.FailedFileCount++
+							actionErrors = append(actionErrors, err)
+							continue
+						}
+						path := filepath.Join(root, filepath.FromSlash(sidecar.Path))
+						if sidecar.Action == "move" {
+							changes = append(changes, libraryChange{From: path, To: filepath.Join(root, filepath.FromSlash(sidecar.Target))})
+						} else if sidecar.Action == "duplicate" {
+							changes = append(changes, dedupeChange(path, action))
+						}
+					}
+				}
+			}
+			for _, sidecar := range duplicate.Sidecars {
+				if sidecar.Action == "move" && sidecar.Error == "" {
+					report.SidecarsMoved++
+				}
+			}
+			reportGroup.Duplicates = append(reportGroup.Duplicates, duplicate)
+		}
+		report.Groups = append(report.Groups, reportGroup)
+	}
+
+	if err := recordLibraryChanges(root, changes); err != nil {
+		actionErrors = append(actionErrors, err)
+	}
+	return report, errors.Join(actionErrors...)
+}
+
+// recordLibraryChanges updates the checksum manifests and, if the library
+// has one, the content index after dedupe changed files, so that verify and
+// later imports see the library as it now is.
+func recordLibraryChanges(root string, changes []libraryChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	var recordErrors []error
+	if err := applyManifestChanges(changes); err != nil {
+		recordErrors = append(recordErrors, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, libraryIndexFileName)); err == nil {
+		index, err := loadLibraryIndex(root)
+		if err == nil {
+			err = index.applyChanges(changes)
+		}
+		if err == nil {
+			err = index.save()
+		}
+		if err != nil {
+			recordErrors = append(recordErrors, fmt.Errorf("failed to update library index: %w", err))
+		}
+	}
+	return errors.Join(recordErrors...)
+}
+
+// dedupeChange describes what action did to the library file at path. A
+// hardlinked copy keeps its name but is a new inode with the survivor's
+// mtime; quarantined and deleted copies leave the library.
+func dedupeChange(path string, action DedupeAction) libraryChange {
+	if action == DedupeHardlink {
+		return libraryChange{From: path, To: path}
+	}
+	return libraryChange{From: path}
+}
+
+// scanLibraryForDedupe returns media files and sidecars under root. Hidden
+// files and directories and the quarantine directory are skipped.
+func scanLibraryForDedupe(root, quarantineDir string, types *mediaTypeTables) ([]libraryFile, map[string][]libraryFile, error) {
+	if _, err := os.Stat(root); err != nil {
+		return nil, nil, fmt.Errorf("error accessing library %s: %w", root, err)
+	}
+	absQuarantine, _ := filepath.Abs(quarantineDir)
+
+	var media []libraryFile
+	sidecars := make(map[string][]libraryFile)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsPermission(err) {
+				fmt.Fprintf(os.Stderr, "Warning: Permission denied accessing %s, skipping\n", path)
+				if d != nil && d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			return fmt.Errorf("error accessing path %q: %w", path, err)
+		}
+		if path != root && isLibraryMetadataName(d.Name()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if absPath, _ := filepath.Abs(path); path != root && absPath == absQuarantine {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("error getting info for %q: %w", path, err)
+		}
+		file := libraryFile{Path: path, Info: info}
+		if category, _ := types.getMediaTypeInfo(FileInfo{SourceName: d.Name()}); category != "" {
+			media = append(media, file)
+			return nil
+		}
+		ext := strings.ToLower(filepath.Ext(d.Name()))
+		if ext != "" && types.isSidecarExtension(ext[1:]) {
+			key := sidecarKey(path)
+			sidecars[key] = append(sidecars[key], file)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error walking the library %s: %w", root, err)
+	}
+	return media, sidecars, nil
+}
+
+// sidecarKey identifies the media base name a file belongs to, matching the
+// (directory, lowercase base name) association used when importing.
+func sidecarKey(path string) string {
+	name := filepath.Base(path)
+	return filepath.Join(filepath.Dir(path), strings.ToLower(strings.TrimSuffix(name, filepath.Ext(name))))
+}
+
+// findDuplicateGroups groups files with identical content. Files that are
+// already hard links to the kept copy are left out.
+func findDuplicateGroups(files []libraryFile) ([]duplicateGroup, []error) {
+	bySize := make(map[int64][]libraryFile)
+	for _, file := range files {
+		bySize[file.Info.Size()] = append(bySize[file.Info.Size()], file)
+	}
+
+	var groups []duplicateGroup
+	var hashErrors []error
+	for _, candidates := range bySize {
+		if len(candidates) < 2 {
+			continue
+		}
+		byHash := make(map[string][]libraryFile)
+		for _, file := range candidates {
+			checksum, err := calculateXXHash(file.Path)
+			if err != nil {
+				hashErrors = append(hashErrors, fmt.Errorf("failed to calculate checksum for %s: %w", file.Path, err))
+				continue
+			}
+			byHash[checksum] = append(byHash[checksum], file)
+		}
+		for checksum, group := range byHash {
+			if len(group) < 2 {
+				continue
+			}
+			sortDedupeCandidates(group)
+			kept := group[:1]
+			for _, file := range group[1:] {
+				if !os.SameFile(group[0].Info, file.Info) {
+					kept = append(kept, file)
+				}
+			}
+			if len(kept) > 1 {
+				groups = append(groups, duplicateGroup{XXHash: checksum, Files: kept})
+			}
+		}
+	}
+
+	sort.Slice(groups, func(a, b int) bool {
+		return naturalCompare(groups[a].Files[0].Path, groups[b].Files[0].Path) < 0
+	})
+	return groups, hashErrors
+}
+
+// sortDedupeCandidates orders identical copies so the one to keep comes
+// first: the shortest base name, which prefers IMG_0001.JPG over the
+// IMG_0001_001.JPG collision copy, then natural path order.
+func sortDedupeCandidates(files []libraryFile) {
+	sort.SliceStable(files, func(a, b int) bool {
+		nameA, nameB := filepath.Base(files[a].Path), filepath.Base(files[b].Path)
+		baseA := strings.TrimSuffix(nameA, filepath.Ext(nameA))
+		baseB := strings.TrimSuffix(nameB, filepath.Ext(nameB))
+		if len(baseA) != len(baseB) {
+			return len(baseA) < len(baseB)
+		}
+		return naturalCompare(files[a].Path, files[b].Path) < 0
+	})
+}
+
+// planDedupeSidecars decides what happens to the sidecars of a redundant
+// copy. Sidecars still shared with another media file that stays in place,
+// such as the other half of a RAW+JPEG pair, are left alone.
+func planDedupeSidecars(root, duplicatePath, survivorPath string, sidecars map[string][]libraryFile, removed map[string]bool, claimedTargets map[string]bool, types *mediaTypeTables) []dedupeSidecar {
+	key := sidecarKey(duplicatePath)
+	candidates := sidecars[key]
+	if len(candidates) == 0 || hasRemainingMedia(duplicatePath, key, removed, types) {
+		return nil
+	}
+
+	survivorName := filepath.Base(survivorPath)
+	survivorBase := strings.TrimSuffix(survivorName, filepath.Ext(survivorName))
+
+	var planned []dedupeSidecar
+	for _, sidecar := range candidates {
+		target := filepath.Join(filepath.Dir(survivorPath), survivorBase+filepath.Ext(sidecar.Path))
+		entry := dedupeSidecar{Path: libraryRelPath(root, sidecar.Path), Target: libraryRelPath(root, target)}
+
+		_, statErr := os.Stat(target)
+		switch {
+		case statErr == nil:
+			if same, err := sameContent(sidecar.Path, target); err != nil {
+				entry.Action = "keep"
+				entry.Error = err.Error()
+			} else if same {
+				entry.Action = "duplicate"
+			} else {
+				entry.Action = "keep"
+			}
+		case !os.IsNotExist(statErr):
+			entry.Action = "keep"
+			entry.Error = statErr.Error()
+		case claimedTargets[target]:
+			entry.Action = "keep"
+		default:
+			entry.Action = "move"
+			claimedTargets[target] = true
+		}
+		planned = append(planned, entry)
+	}
+	return planned
+}
+
+// hasRemainingMedia returns true if a media file other than duplicatePath
+// with the same base name stays in duplicatePath's directory.
+func hasRemainingMedia(duplicatePath, key string, removed map[string]bool, types *mediaTypeTables) bool {
+	entries, err := os.ReadDir(filepath.Dir(duplicatePath))
+	if err != nil {
+		return true
+	}
+	for _, entry := range entries {
+		path := filepath.Join(filepath.Dir(duplicatePath), entry.Name())
+		if path == duplicatePath || removed[path] || entry.IsDir() {
+			continue
+		}
+		if category, _ := types.getMediaTypeInfo(FileInfo{SourceName: entry.Name()}); category != "" && sidecarKey(path) == key {
+			return true
+		}
+	}
+	return false
+}
+
+func sameContent(a, b string) (bool, error) {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	if infoA.Size() != infoB.Size() {
+		return false, nil
+	}
+	checksumA, err := calculateXXHash(a)
+	if err != nil {
+		return false, err
+	}
+	checksumB, err := calculateXXHash(b)
+	if err != nil {
+		return false, err
+	}
+	return checksumA == checksumB, nil
+}
+
+// applyDedupeAction replaces, quarantines or deletes a redundant copy. It
+// returns the quarantine path, if any.
+func applyDedupeAction(root, path, survivorPath string, action DedupeAction, quarantineDir string) (string, error) {
+	switch action {
+	case DedupeHardlink:
+		// Link under a temporary name first so the copy is never missing
+		tmpPath := filepath.Join(filepath.Dir(path), ".gomediaimport-link-"+filepath.Base(path))
+		if err := os.Link(survivorPath, tmpPath); err != nil {
+			return "", err
+		}
+		if err := os.Rename(tmpPath, path); err != nil {
+			_ = os.Remove(tmpPath)
+			return "", err
+		}
+		return "", nil
+	case DedupeQuarantine:
+		return quarantineFile(root, path, quarantineDir)
+	case DedupeDelete:
+		return "", os.Remove(path)
+	default:
+		return "", nil
+	}
+}
+
+// quarantineFile moves path into quarantineDir, keeping its path relative to
+// the library root.
+func quarantineFile(root, path, quarantineDir string) (string, error) {
+	target := filepath.Join(quarantineDir, filepath.FromSlash(libraryRelPath(root, path)))
+	if _, err := os.Lstat(target); err == nil {
+		return "", fmt.Errorf("quarantine target %s already exists", target)
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return "", err
+	}
+	if err := moveFile(path, target); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// moveFile renames path to target. A quarantine directory may be on another
+// filesystem, where rename fails with EXDEV; the file is then copied, with its
+// modification time, and the original removed.
+func moveFile(path, target string) error {
+	err := os.Rename(path, target)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	return copyAndRemove(path, target)
+}
+
+func copyAndRemove(path, target string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := copyFile(localSource{}, localDestination{}, path, target); err != nil {
+		return err
+	}
+	if err := setFileTimes(target, info.ModTime()); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func applyDedupeSidecar(root string, sidecar *dedupeSidecar, action DedupeAction, quarantineDir string) error {
+	path := filepath.Join(root, filepath.FromSlash(sidecar.Path))
+	var err error
+	switch sidecar.Action {
+	case "move":
+		target := filepath.Join(root, filepath.FromSlash(sidecar.Target))
+		if _, statErr := os.Lstat(target); statErr == nil {
+			err = fmt.Errorf("%s already exists", target)
+		} else {
+			err = os.Rename(path, target)
+		}
+	case "duplicate":
+		_, err = applyDedupeAction(root, path, "", action, quarantineDir)
+	}
+	if err != nil {
+		sidecar.Error = err.Error()
+		return fmt.Errorf("failed to handle sidecar %s: %w", path, err)
+	}
+	return nil
+}
+
+func libraryRelPath(root, path string) string {
+	relPath, err := filepath.Rel(root, path)
+	if err != nil {
+		return path
+	}
+	return filepath.ToSlash(relPath)
+}
+
+func writeDedupeReport(path string, report *dedupeReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode dedupe report: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write dedupe report: %w", err)
+	}
+	return nil
+}
+
+func printDedupeSummary(report *dedupeReport, reportPath string) {
+	fmt.Printf("Duplicate groups: %d\n", len(report.Groups))
+	fmt.Printf("Redundant copies: %d (%s)\n", report.RedundantFiles, humanReadableSize(report.RedundantBytes))
+	if report.SidecarsMoved > 0 {
+		fmt.Printf("Sidecars moved to surviving copies: %d\n", report.SidecarsMoved)
+	}
+	if report.FailedFileCount > 0 {
+		fmt.Printf("Failed: %d\n", report.FailedFileCount)
+	}
+	if !report.Applied && report.RedundantFiles > 0 {
+		fmt.Println("No changes made (dry run)")
+	}
+	if reportPath != "" {
+		fmt.Printf("Report written to %s\n", reportPath)
+	}
+}
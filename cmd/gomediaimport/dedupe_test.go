@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// dedupeTestLibrary creates a library with one original, a collision copy
+// with its own XMP, an unrelated file and an identical copy of it in another
+// month.
+func dedupeTestLibrary(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	writeLibraryTestFile(t, root, "2024/03/IMG_0001.JPG", "photo one")
+	writeLibraryTestFile(t, root, "2024/03/IMG_0001_001.JPG", "photo one")
+	writeLibraryTestFile(t, root, "2024/03/IMG_0001_001.xmp", "<rating>5</rating>")
+	writeLibraryTestFile(t, root, "2024/03/IMG_0002.JPG", "photo two")
+	writeLibraryTestFile(t, root, "2024/04/IMG_0002.JPG", "photo two")
+	writeLibraryTestFile(t, root, "2024/04/IMG_0003.JPG", "other size")
+	return root
+}
+
+func TestDedupeLibraryDryRun(t *testing.T) {
+	root := dedupeTestLibrary(t)
+
+	report, err := dedupeLibrary(root, DedupeDryRun, filepath.Join(root, defaultQuarantineDirName), config{})
+	if err != nil {
+		t.Fatalf("dedupeLibrary failed: %v", err)
+	}
+
+	if len(report.Groups) != 2 {
+		t.Fatalf("got %d groups, want 2", len(report.Groups))
+	}
+	first := report.Groups[0]
+	if first.Keep != "2024/03/IMG_0001.JPG" || len(first.Duplicates) != 1 || first.Duplicates[0].Path != "2024/03/IMG_0001_001.JPG" {
+		t.Errorf("unexpected first group: %+v", first)
+	}
+	wantSidecars := []dedupeSidecar{{Path: "2024/03/IMG_0001_001.xmp", Action: "move", Target: "2024/03/IMG_0001.xmp"}}
+	if !reflect.DeepEqual(first.Duplicates[0].Sidecars, wantSidecars) {
+		t.Errorf("got sidecars %+v, want %+v", first.Duplicates[0].Sidecars, wantSidecars)
+	}
+	if second := report.Groups[1]; second.Keep != "2024/03/IMG_0002.JPG" || second.Duplicates[0].Path != "2024/04/IMG_0002.JPG" {
+		t.Errorf("unexpected second group: %+v", second)
+	}
+	if report.Applied || report.RedundantFiles != 2 || report.RedundantBytes != int64(2*len("photo one")) {
+		t.Errorf("unexpected report totals: %+v", report)
+	}
+
+	for _, path := range []string{"2024/03/IMG_0001_001.JPG", "2024/03/IMG_0001_001.xmp", "2024/04/IMG_0002.JPG"} {
+		if _, err := os.Stat(filepath.Join(root, path)); err != nil {
+			t.Errorf("dry run must not change %s: %v", path, err)
+		}
+	}
+}
+
+func TestDedupeLibraryQuarantineMovesSidecar(t *testing.T) {
+	root := dedupeTestLibrary(t)
+	quarantineDir := filepath.Join(root, defaultQuarantineDirName)
+
+	if _, err := dedupeLibrary(root, DedupeQuarantine, quarantineDir, config{}); err != nil {
+		t.Fatalf("dedupeLibrary failed: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(quarantineDir, "2024/03/IMG_0001_001.JPG")); err != nil {
+		t.Errorf("expected copy in quarantine: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "2024/03/IMG_0001_001.JPG")); !os.IsNotExist(err) {
+		t.Errorf("expected copy to leave the library, got err=%v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(root, "2024/03/IMG_0001.xmp"))
+	if err != nil || string(data) != "<rating>5</rating>" {
+		t.Errorf("expected XMP to follow the surviving copy, got %q, err=%v", data, err)
+	}
+
+	// A second run finds nothing; the quarantine is not scanned
+	report, err := dedupeLibrary(root, DedupeQuarantine, quarantineDir, config{})
+	if err != nil {
+		t.Fatalf("second dedupeLibrary failed: %v", err)
+	}
+	if len(report.Groups) != 0 {
+		t.Errorf("expected no duplicates after quarantine, got %+v", report.Groups)
+	}
+}
+
+func TestDedupeLibraryDeleteKeepsSharedAndConflictingSidecars(t *testing.T) {
+	root := t.TempDir()
+	writeLibraryTestFile(t, root, "a/DSC0001.JPG", "jpeg")
+	writeLibraryTestFile(t, root, "a/DSC0001.xmp", "<survivor/>")
+	writeLibraryTestFile(t, root, "b/DSC0001.JPG", "jpeg")
+	writeLibraryTestFile(t, root, "b/DSC0001.xmp", "<copy/>")
+	// The copy's RAW sibling is unique, so the shared sidecar stays with it
+	writeLibraryTestFile(t, root, "c/DSC0001.JPG", "jpeg")
+	writeLibraryTestFile(t, root, "c/DSC0001.ARW", "raw")
+	writeLibraryTestFile(t, root, "c/DSC0001.xmp", "<pair/>")
+
+	report, err := dedupeLibrary(root, DedupeDelete, filepath.Join(root, defaultQuarantineDirName), config{})
+	if err != nil {
+		t.Fatalf("dedupeLibrary failed: %v", err)
+	}
+	if len(report.Groups) != 1 || len(report.Groups[0].Duplicates) != 2 {
+		t.Fatalf("unexpected groups: %+v", report.Groups)
+	}
+	if got := report.Groups[0].Duplicates[0].Sidecars; len(got) != 1 || got[0].Action != "keep" {
+		t.Errorf("expected conflicting sidecar to be kept, got %+v", got)
+	}
+	if got := report.Groups[0].Duplicates[1].Sidecars; len(got) != 0 {
+		t.Errorf("expected shared sidecar to be left alone, got %+v", got)
+	}
+
+	for _, path := range []string{"b/DSC0001.JPG", "c/DSC0001.JPG"} {
+		if _, err := os.Stat(filepath.Join(root, path)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be deleted, got err=%v", path, err)
+		}
+	}
+	for _, path := range []string{"a/DSC0001.xmp", "b/DSC0001.xmp", "c/DSC0001.ARW", "c/DSC0001.xmp"} {
+		if _, err := os.Stat(filepath.Join(root, path)); err != nil {
+			t.Errorf("expected %s to be kept: %v", path, err)
+		}
+	}
+}
+
+func TestDedupeLibraryHardlink(t *testing.T) {
+	root := dedupeTestLibrary(t)
+
+	if _, err := dedupeLibrary(root, DedupeHardlink, filepath.Join(root, defaultQuarantineDirName), config{}); err != nil {
+		t.Fatalf("dedupeLibrary failed: %v", err)
+	}
+
+	survivor, err := os.Stat(filepath.Join(root, "2024/03/IMG_0001.JPG"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	linked, err := os.Stat(filepath.Join(root, "2024/03/IMG_0001_001.JPG"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(survivor, linked) {
+		t.Error("expected copy to be replaced by a hard link")
+	}
+	if _, err := os.Stat(filepath.Join(root, "2024/03/IMG_0001_001.xmp")); err != nil {
+		t.Errorf("expected sidecar of a hardlinked copy to stay: %v", err)
+	}
+
+	// Hard links to the kept copy are not reported again
+	report, err := dedupeLibrary(root, DedupeHardlink, filepath.Join(root, defaultQuarantineDirName), config{})
+	if err != nil {
+		t.Fatalf("second dedupeLibrary failed: %v", err)
+	}
+	if len(report.Groups) != 0 {
+		t.Errorf("expected no duplicates after hardlinking, got %+v", report.Groups)
+	}
+}
+
+func TestDedupeLibraryUpdatesManifestsAndIndex(t *testing.T) {
+	root := dedupeTestLibrary(t)
+	for _, dir := range []string{"2024/03", "2024/04"} {
+		entries, err := os.ReadDir(filepath.Join(root, dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		if err := updateChecksumManifests(filepath.Join(root, dir), names, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	index, _, err := refreshLibraryIndex(root, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := index.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := dedupeLibrary(root, DedupeQuarantine, filepath.Join(root, defaultQuarantineDirName), config{}); err != nil {
+		t.Fatalf("dedupeLibrary failed: %v", err)
+	}
+
+	result, err := verifyLibrary(root)
+	if err != nil {
+		t.Fatalf("verifyLibrary failed: %v", err)
+	}
+	if len(result.Missing) != 0 || len(result.Changed) != 0 || len(result.Unexpected) != 0 {
+		t.Errorf("expected a clean library after dedupe, got %+v", result)
+	}
+	if result.Verified != 4 {
+		t.Errorf("got %d verified files, want 4", result.Verified)
+	}
+
+	index, err = loadLibraryIndex(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for key := range index.Files {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	want := []string{"2024/03/IMG_0001.JPG", "2024/03/IMG_0001.xmp", "2024/03/IMG_0002.JPG", "2024/04/IMG_0003.JPG"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("got index keys %v, want %v", keys, want)
+	}
+	if _, stats, err := refreshLibraryIndex(root, false); err != nil || stats != (libraryIndexStats{Unchanged: 4}) {
+		t.Errorf("expected an up to date index, got %+v, err=%v", stats, err)
+	}
+}
+
+func TestCopyAndRemoveKeepsModTime(t *testing.T) {
+	root := t.TempDir()
+	src := filepath.Join(root, "a.JPG")
+	writeLibraryTestFile(t, root, "a.JPG", "photo")
+	modTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(src, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(root, "quarantine", "a.JPG")
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := copyAndRemove(src, target); err != nil {
+		t.Fatalf("copyAndRemove failed: %v", err)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("expected source to be removed, got err=%v", err)
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(modTime) || info.Size() != int64(len("photo")) {
+		t.Errorf("unexpected target: mtime %v, size %d", info.ModTime(), info.Size())
+	}
+}
+
+func TestRunDedupeCommandWritesReport(t *testing.T) {
+	root := dedupeTestLibrary(t)
+	reportPath := filepath.Join(t.TempDir(), "dedupe.json")
+
+	_, err := captureStdout(t, func() error {
+		return run([]string{"cmd", "--config", emptyConfigFile(t), "--dest", root, "--dry-run", "dedupe", "--action", "delete", "--report", reportPath})
+	})
+	if err != nil {
+		t.Fatalf("run dedupe failed: %v", err)
+	}
+
+	data, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report dedupeReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("invalid report JSON: %v", err)
+	}
+	if report.Action != DedupeDelete || report.Applied || report.RedundantFiles != 2 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if _, err := os.Stat(filepath.Join(root, "2024/04/IMG_0002.JPG")); err != nil {
+		t.Errorf("--dry-run must not delete copies: %v", err)
+	}
+}
+
+func TestRunDedupeCommandRejectsInvalidAction(t *testing.T) {
+	err := run([]string{"cmd", "--config", emptyConfigFile(t), "--dest", t.TempDir(), "dedupe", "--action", "shred"})
+	if err == nil {
+		t.Fatal("expected error for invalid dedupe action")
+	}
+}
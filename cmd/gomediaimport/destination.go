@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// destination is the storage an import writes to. Paths are in the
+// destination's own namespace: ordinary paths for local directories and the
+// path component of the destination URL for remote backends. Missing files are
+// reported with errors matching fs.ErrNotExist.
+type destination interface {
+	Stat(path string) (fs.FileInfo, error)
+	// Create opens path for writing, replacing any existing file. The data is
+	// stored when Close returns nil.
+	Create(path string) (io.WriteCloser, error)
+	Rename(oldPath, newPath string) error
+	MkdirAll(path string) error
+	Remove(path string) error
+	// FreeSpace returns the bytes available for new files at path, or
+	// errFreeSpaceUnknown if the backend cannot tell.
+	FreeSpace(path string) (uint64, error)
+	// Hash returns the xxHash64 checksum of a file in the same format as
+	// calculateXXHash.
+	Hash(path string) (string, error)
+	// Chtimes sets the modification time where the backend supports it and
+	// is a no-op elsewhere.
+	Chtimes(path string, modTime time.Time) error
+	Close() error
+}
+
+var errFreeSpaceUnknown = errors.New("free space is not reported by this destination")
+
+// destinationSchemes lists the URL schemes accepted in destination_directory
+var destinationSchemes = []string{"sftp", "webdav", "webdavs", "s3"}
+
+// isRemoteDestination returns true if dir is a destination URL rather than a
+// local path.
+func isRemoteDestination(dir string) bool {
+	return strings.Contains(dir, "://")
+}
+
+// validateDestinationURL checks that a remote destination uses a supported
+// scheme and names a host.
+func validateDestinationURL(dir string) error {
+	if !isRemoteDestination(dir) {
+		return nil
+	}
+	u, err := url.Parse(dir)
+	if err != nil {
+		return fmt.Errorf("invalid destination URL: %w", err)
+	}
+	scheme := strings.ToLower(u.Scheme)
+	supported := false
+	for _, s := range destinationSchemes {
+		if scheme == s {
+			supported = true
+		}
+	}
+	if !supported {
+		return fmt.Errorf("unsupported destination scheme %q: must be one of %s", u.Scheme, strings.Join(destinationSchemes, ", "))
+	}
+	if u.Host == "" {
+		return fmt.Errorf("destination URL %s has no host", u.Redacted())
+	}
+	return nil
+}
+
+// redactDestination returns dir with any password in a destination URL
+// masked, for display.
+func redactDestination(dir string) string {
+	if !isRemoteDestination(dir) {
+		return dir
+	}
+	u, err := url.Parse(dir)
+	if err != nil {
+		return dir
+	}
+	return u.Redacted()
+}
+
+// openDestination connects to the destination named by dir and returns it
+// with the root path imports are planned under.
+func openDestination(dir string) (destination, string, error) {
+	if !isRemoteDestination(dir) {
+		return localDestination{}, dir, nil
+	}
+	if err := validateDestinationURL(dir); err != nil {
+		return nil, "", err
+	}
+	u, _ := url.Parse(dir)
+	root := path.Clean("/" + u.Path)
+
+	var dest destination
+	var err error
+	switch strings.ToLower(u.Scheme) {
+	case "sftp":
+		dest, err = openSFTPDestination(u)
+	case "webdav", "webdavs":
+		dest, err = openWebDAVDestination(u)
+	case "s3":
+		dest, root, err = openS3Destination(u)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open destination %s: %w", u.Redacted(), err)
+	}
+	return dest, root, nil
+}
+
+// destinationFor returns the destination an import writes to. Imports that
+// did not open one write to the local filesystem.
+func destinationFor(cfg config) destination {
+	if cfg.Destination != nil {
+		return cfg.Destination
+	}
+	return localDestination{}
+}
+
+// remotePath converts a planned destination path to a slash-separated path.
+func remotePath(p string) string {
+	return path.Clean("/" + filepath.ToSlash(p))
+}
+
+// localDestination writes to the local filesystem
+type localDestination struct{}
+
+func (localDestination) Stat(path string) (fs.FileInfo, error) {
+	return os.Stat(path)
+}
+
+func (localDestination) Create(path string) (io.WriteCloser, error) {
+	return os.Create(path)
+}
+
+func (localDestination) Rename(oldPath, newPath string) error {
+	return os.Rename(oldPath, newPath)
+}
+
+func (localDestination) MkdirAll(path string) error {
+	return os.MkdirAll(path, 0755)
+}
+
+func (localDestination) Remove(path string) error {
+	return os.Remove(path)
+}
+
+func (localDestination) FreeSpace(path string) (uint64, error) {
+	return availableDiskSpace(path)
+}
+
+func (localDestination) Hash(path string) (string, error) {
+	return calculateXXHash(path)
+}
+
+func (localDestination) Chtimes(path string, modTime time.Time) error {
+	return setFileTimes(path, modTime)
+}
+
+func (localDestination) Close() error {
+	return nil
+}
+
+// remoteFileInfo describes a file on a remote destination
+type remoteFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+func (fi remoteFileInfo) Name() string       { return fi.name }
+func (fi remoteFileInfo) Size() int64        { return fi.size }
+func (fi remoteFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi remoteFileInfo) IsDir() bool        { return fi.isDir }
+func (fi remoteFileInfo) Sys() any           { return nil }
+
+func (fi remoteFileInfo) Mode() fs.FileMode {
+	if fi.isDir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+
+// hashReader returns the xxHash64 checksum of everything read from r.
+func hashReader(r io.Reader) (string, error) {
+	digest := xxhash.New()
+	if _, err := io.Copy(digest, r); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%016x", digest.Sum64()), nil
+}
+
+// atomicCreator is implemented by destinations whose Create already stores a
+// file all at once, so copies do not need a temporary name.
+type atomicCreator interface {
+	createsAtomically() bool
+}
+
+// aborter is implemented by writers that can discard a partial upload.
+type aborter interface {
+	Abort() error
+}
@@ -0,0 +1,522 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// emptyPayloadSHA256 is the SHA-256 of an empty request body
+	emptyPayloadSHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	// s3MaxCopySize is the largest object a single CopyObject request copies
+	s3MaxCopySize = 5 << 30
+)
+
+// Uploads larger than s3PartSize are sent in parts of that size, each held
+// in memory while it is sent. S3 allows up to 10,000 parts per object.
+var s3PartSize int64 = 16 << 20
+
+type s3Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// s3Destination writes to an S3-compatible bucket using path-style requests
+// signed with AWS Signature Version 4. Destination URLs have the form
+// s3://bucket/prefix?endpoint=https://minio.example:9000&region=us-east-1.
+type s3Destination struct {
+	client      *http.Client
+	endpoint    url.URL
+	bucket      string
+	region      string
+	credentials s3Credentials
+	now         func() time.Time
+}
+
+func openS3Destination(u *url.URL) (*s3Destination, string, error) {
+	credentials := s3Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if credentials.AccessKeyID == "" || credentials.SecretAccessKey == "" {
+		return nil, "", fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set for s3 destinations")
+	}
+
+	query := u.Query()
+	region := firstNonEmpty(query.Get("region"), os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1")
+	endpoint := firstNonEmpty(query.Get("endpoint"), os.Getenv("AWS_ENDPOINT_URL_S3"), os.Getenv("AWS_ENDPOINT_URL"), "https://s3."+region+".amazonaws.com")
+
+	dest, err := newS3Destination(endpoint, u.Host, region, credentials, &http.Client{})
+	if err != nil {
+		return nil, "", err
+	}
+	return dest, path.Clean("/" + u.Path), nil
+}
+
+func newS3Destination(endpoint, bucket, region string, credentials s3Credentials, client *http.Client) (*s3Destination, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", endpoint)
+	}
+	return &s3Destination{
+		client:      client,
+		endpoint:    url.URL{Scheme: u.Scheme, Host: u.Host},
+		bucket:      bucket,
+		region:      region,
+		credentials: credentials,
+		now:         time.Now,
+	}, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// objectKey maps a destination path to an object key
+func objectKey(p string) string {
+	return strings.TrimPrefix(remotePath(p), "/")
+}
+
+func (d *s3Destination) newRequest(method, key string, query url.Values, body io.Reader) (*http.Request, error) {
+	u := d.endpoint
+	u.Path = "/" + d.bucket + "/" + key
+	u.RawPath = "/" + s3Escape(d.bucket, false) + "/" + s3Escape(key, false)
+	u.RawQuery = s3CanonicalQuery(query)
+	return http.NewRequest(method, u.String(), body)
+}
+
+// do signs and sends a request. payloadHash is the hex SHA-256 of the body.
+func (d *s3Destination) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	signS3Request(req, payloadHash, d.credentials, d.region, d.now())
+	return d.client.Do(req)
+}
+
+// s3Error is the error document returned by S3
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// s3ResponseError reads an unexpected response into an error, mapping
+// missing objects to fs.ErrNotExist.
+func s3ResponseError(op, key string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode == http.StatusNotFound {
+		return &fs.PathError{Op: op, Path: key, Err: fs.ErrNotExist}
+	}
+	var e s3Error
+	if xml.Unmarshal(body, &e) == nil && e.Code != "" {
+		return &fs.PathError{Op: op, Path: key, Err: fmt.Errorf("%s: %s", e.Code, e.Message)}
+	}
+	return &fs.PathError{Op: op, Path: key, Err: fmt.Errorf("unexpected response %s", resp.Status)}
+}
+
+// checkS3Response closes resp and returns an error unless it succeeded. S3
+// can report copy and completion failures in the body of a 200 response.
+func checkS3Response(op, key string, resp *http.Response) ([]byte, error) {
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode/100 != 2 {
+		return nil, s3ResponseError(op, key, resp)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Contains(body, []byte("<Error>")) {
+		var e s3Error
+		_ = xml.Unmarshal(body, &e)
+		return nil, &fs.PathError{Op: op, Path: key, Err: fmt.Errorf("%s: %s", e.Code, e.Message)}
+	}
+	return body, nil
+}
+
+func (d *s3Destination) Stat(p string) (fs.FileInfo, error) {
+	key := objectKey(p)
+	req, err := d.newRequest(http.MethodHead, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.do(req, emptyPayloadSHA256)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := checkS3Response("stat", key, resp); err != nil {
+		return nil, err
+	}
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return remoteFileInfo{name: path.Base(key), size: resp.ContentLength, modTime: modTime}, nil
+}
+
+// Create streams the file to S3 as it is written, so the object only
+// appears once it is complete. See s3Upload.
+func (d *s3Destination) Create(p string) (io.WriteCloser, error) {
+	return &s3Upload{dest: d, key: objectKey(p)}, nil
+}
+
+func (d *s3Destination) createsAtomically() bool {
+	return true
+}
+
+func (d *s3Destination) putObject(key string, body io.Reader, size int64, sha256Hex string) error {
+	if size == 0 {
+		// A non-nil body with zero length would be sent chunked
+		body = http.NoBody
+	}
+	req, err := d.newRequest(http.MethodPut, key, nil, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	resp, err := d.do(req, sha256Hex)
+	if err != nil {
+		return err
+	}
+	_, err = checkS3Response("create", key, resp)
+	return err
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type s3CompleteMultipartUpload struct {
+	XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+	Parts   []s3CompletedPart `xml:"Part"`
+}
+
+// s3Upload is an object being written. Data is collected one part at a time,
+// since every request is signed with the SHA-256 of its body: an object that
+// fits in one part is sent with a single PUT on Close, and a larger one as a
+// multipart upload that starts once a second part is written.
+type s3Upload struct {
+	dest     *s3Destination
+	key      string
+	part     []byte
+	uploadID string
+	parts    []s3CompletedPart
+	err      error
+}
+
+func (u *s3Upload) Write(p []byte) (int, error) {
+	if u.err != nil {
+		return 0, u.err
+	}
+	var n int
+	for n < len(p) {
+		if int64(len(u.part)) == s3PartSize {
+			if err := u.sendPart(); err != nil {
+				u.err = err
+				u.abort()
+				return n, err
+			}
+		}
+		chunk := min(int64(len(p)-n), s3PartSize-int64(len(u.part)))
+		u.part = append(u.part, p[n:n+int(chunk)]...)
+		n += int(chunk)
+	}
+	return n, nil
+}
+
+func (u *s3Upload) Close() error {
+	if u.err != nil {
+		return u.err
+	}
+	if u.uploadID == "" {
+		sum := sha256.Sum256(u.part)
+		return u.dest.putObject(u.key, bytes.NewReader(u.part), int64(len(u.part)), hex.EncodeToString(sum[:]))
+	}
+	if err := u.sendPart(); err != nil {
+		u.abort()
+		return err
+	}
+	if err := u.complete(); err != nil {
+		u.abort()
+		return err
+	}
+	return nil
+}
+
+// Abort discards a partial upload
+func (u *s3Upload) Abort() error {
+	if u.err == nil {
+		u.err = errUploadAborted
+		u.abort()
+	}
+	return nil
+}
+
+func (u *s3Upload) abort() {
+	if u.uploadID != "" {
+		u.dest.abortMultipartUpload(u.key, u.uploadID)
+	}
+	u.part = nil
+}
+
+// sendPart uploads the collected part, starting the multipart upload first
+// if needed
+func (u *s3Upload) sendPart() error {
+	if u.uploadID == "" {
+		if err := u.start(); err != nil {
+			return err
+		}
+	}
+	number := len(u.parts) + 1
+	sum := sha256.Sum256(u.part)
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {u.uploadID}}
+	req, err := u.dest.newRequest(http.MethodPut, u.key, query, bytes.NewReader(u.part))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(u.part))
+	resp, err := u.dest.do(req, hex.EncodeToString(sum[:]))
+	if err != nil {
+		return err
+	}
+	if _, err := checkS3Response("create", u.key, resp); err != nil {
+		return err
+	}
+	u.parts = append(u.parts, s3CompletedPart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+	u.part = u.part[:0]
+	return nil
+}
+
+func (u *s3Upload) start() error {
+	req, err := u.dest.newRequest(http.MethodPost, u.key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := u.dest.do(req, emptyPayloadSHA256)
+	if err != nil {
+		return err
+	}
+	data, err := checkS3Response("create", u.key, resp)
+	if err != nil {
+		return err
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(data, &initiated); err != nil || initiated.UploadID == "" {
+		return fmt.Errorf("failed to start multipart upload of %s", u.key)
+	}
+	u.uploadID = initiated.UploadID
+	return nil
+}
+
+func (u *s3Upload) complete() error {
+	payload, err := xml.Marshal(s3CompleteMultipartUpload{Parts: u.parts})
+	if err != nil {
+		return err
+	}
+	payloadHash := sha256.Sum256(payload)
+	req, err := u.dest.newRequest(http.MethodPost, u.key, url.Values{"uploadId": {u.uploadID}}, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	resp, err := u.dest.do(req, hex.EncodeToString(payloadHash[:]))
+	if err != nil {
+		return err
+	}
+	_, err = checkS3Response("create", u.key, resp)
+	return err
+}
+
+func (d *s3Destination) abortMultipartUpload(key, uploadID string) {
+	req, err := d.newRequest(http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil)
+	if err != nil {
+		return
+	}
+	if resp, err := d.do(req, emptyPayloadSHA256); err == nil {
+		_ = resp.Body.Close()
+	}
+}
+
+// Rename copies the object on the server and deletes the original.
+func (d *s3Destination) Rename(oldPath, newPath string) error {
+	oldKey, newKey := objectKey(oldPath), objectKey(newPath)
+	info, err := d.Stat(oldPath)
+	if err != nil {
+		return err
+	}
+	if info.Size() > s3MaxCopySize {
+		return fmt.Errorf("cannot rename %s: objects over 5 GiB cannot be copied in one request", oldKey)
+	}
+
+	req, err := d.newRequest(http.MethodPut, newKey, nil, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Amz-Copy-Source", "/"+s3Escape(d.bucket, false)+"/"+s3Escape(oldKey, false))
+	resp, err := d.do(req, emptyPayloadSHA256)
+	if err != nil {
+		return err
+	}
+	if _, err := checkS3Response("rename", oldKey, resp); err != nil {
+		return err
+	}
+	return d.Remove(oldPath)
+}
+
+// MkdirAll is a no-op: object stores have no directories.
+func (d *s3Destination) MkdirAll(string) error {
+	return nil
+}
+
+func (d *s3Destination) Remove(p string) error {
+	key := objectKey(p)
+	req, err := d.newRequest(http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := d.do(req, emptyPayloadSHA256)
+	if err != nil {
+		return err
+	}
+	_, err = checkS3Response("remove", key, resp)
+	return err
+}
+
+func (d *s3Destination) FreeSpace(string) (uint64, error) {
+	return 0, errFreeSpaceUnknown
+}
+
+func (d *s3Destination) Hash(p string) (string, error) {
+	key := objectKey(p)
+	req, err := d.newRequest(http.MethodGet, key, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := d.do(req, emptyPayloadSHA256)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return "", s3ResponseError("hash", key, resp)
+	}
+	return hashReader(resp.Body)
+}
+
+// Chtimes is a no-op: object modification times are set by the server.
+func (d *s3Destination) Chtimes(string, time.Time) error {
+	return nil
+}
+
+func (d *s3Destination) Close() error {
+	d.client.CloseIdleConnections()
+	return nil
+}
+
+// s3Escape URI-encodes s as Signature Version 4 requires: every byte except
+// unreserved characters, and "/" unless encodeSlash is set.
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3CanonicalQuery encodes query parameters sorted by name, which is both
+// the canonical form for signing and a valid query string.
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, s3Escape(key, true)+"="+s3Escape(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// signS3Request adds Signature Version 4 headers to req. Every header already
+// set on the request is signed along with the host.
+func signS3Request(req *http.Request, payloadHash string, credentials s3Credentials, region string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	shortDate := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if credentials.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", credentials.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := shortDate + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+credentials.SecretAccessKey), shortDate)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s,SignedHeaders=%s,Signature=%s",
+		credentials.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sftpDestination writes to an SFTP server. The host key must be listed in
+// ~/.ssh/known_hosts. Authentication tries the URL password, the SSH agent
+// and unencrypted default private keys, in that order.
+type sftpDestination struct {
+	client *sftp.Client
+	conn   io.Closer
+	agent  io.Closer // connection to the SSH agent, if one was used
+}
+
+func openSFTPDestination(u *url.URL) (*sftpDestination, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	hostKeyCallback, err := knownhosts.New(filepath.Join(homeDir, ".ssh", "known_hosts"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known hosts: %w", err)
+	}
+
+	username := u.User.Username()
+	if username == "" {
+		current, err := user.Current()
+		if err != nil {
+			return nil, fmt.Errorf("no user in destination URL: %w", err)
+		}
+		username = current.Username
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "22")
+	}
+
+	auth, agentConn := sftpAuthMethods(u, homeDir)
+	closeAgent := func() {
+		if agentConn != nil {
+			_ = agentConn.Close()
+		}
+	}
+	sshConfig := &ssh.ClientConfig{
+		User:            username,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         30 * time.Second,
+	}
+	conn, err := ssh.Dial("tcp", host, sshConfig)
+	if err != nil {
+		closeAgent()
+		return nil, err
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		_ = conn.Close()
+		closeAgent()
+		return nil, err
+	}
+	return &sftpDestination{client: client, conn: conn, agent: agentConn}, nil
+}
+
+// sftpAuthMethods returns the authentication methods to try and the
+// connection to the SSH agent, if there is one, which the caller closes
+func sftpAuthMethods(u *url.URL, homeDir string) ([]ssh.AuthMethod, io.Closer) {
+	var methods []ssh.AuthMethod
+	var agentConn io.Closer
+	if password, ok := u.User.Password(); ok {
+		methods = append(methods, ssh.Password(password))
+	}
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			agentConn = conn
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+	var signers []ssh.Signer
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		key, err := os.ReadFile(filepath.Join(homeDir, ".ssh", name))
+		if err != nil {
+			continue
+		}
+		if signer, err := ssh.ParsePrivateKey(key); err == nil {
+			signers = append(signers, signer)
+		}
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+	return methods, agentConn
+}
+
+func (d *sftpDestination) Stat(p string) (fs.FileInfo, error) {
+	return d.client.Stat(remotePath(p))
+}
+
+func (d *sftpDestination) Create(p string) (io.WriteCloser, error) {
+	return d.client.Create(remotePath(p))
+}
+
+// Rename replaces newPath if it exists. Plain SFTP renames fail on an
+// existing target, so servers without the posix-rename@openssh.com extension
+// have it removed first.
+func (d *sftpDestination) Rename(oldPath, newPath string) error {
+	oldPath, newPath = remotePath(oldPath), remotePath(newPath)
+	if _, ok := d.client.HasExtension("posix-rename@openssh.com"); ok {
+		return d.client.PosixRename(oldPath, newPath)
+	}
+	if err := d.client.Remove(newPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return d.client.Rename(oldPath, newPath)
+}
+
+func (d *sftpDestination) MkdirAll(p string) error {
+	return d.client.MkdirAll(remotePath(p))
+}
+
+func (d *sftpDestination) Remove(p string) error {
+	return d.client.Remove(remotePath(p))
+}
+
+// FreeSpace uses the statvfs@openssh.com extension on the nearest existing
+// directory.
+func (d *sftpDestination) FreeSpace(p string) (uint64, error) {
+	current := remotePath(p)
+	for {
+		vfs, err := d.client.StatVFS(current)
+		if err == nil {
+			return vfs.Bavail * vfs.Frsize, nil
+		}
+		// Servers without the extension say so; other failures are real
+		var statusErr *sftp.StatusError
+		if errors.As(err, &statusErr) && statusErr.FxCode() == sftp.ErrSSHFxOpUnsupported {
+			return 0, errFreeSpaceUnknown
+		}
+		if !errors.Is(err, fs.ErrNotExist) || current == "/" {
+			return 0, err
+		}
+		current = path.Dir(current)
+	}
+}
+
+func (d *sftpDestination) Hash(p string) (string, error) {
+	file, err := d.client.Open(remotePath(p))
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = file.Close() }()
+	return hashReader(file)
+}
+
+func (d *sftpDestination) Chtimes(p string, modTime time.Time) error {
+	return d.client.Chtimes(remotePath(p), modTime, modTime)
+}
+
+func (d *sftpDestination) Close() error {
+	err := d.client.Close()
+	if d.conn != nil {
+		err = errors.Join(err, d.conn.Close())
+	}
+	if d.agent != nil {
+		err = errors.Join(err, d.agent.Close())
+	}
+	return err
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultLocationFallback is the folder for files without a known place
+// when destination_template uses location tokens
+const defaultLocationFallback = "Unknown Location"
+
+// destinationTemplateTokens are the tokens destination_template may use
+var destinationTemplateTokens = map[string]bool{
+	"year": true, "month": true, "day": true,
+	"country": true, "city": true, "place": true,
+}
+
+// locationTokens are the tokens resolved from a file's position
+var locationTokens = map[string]bool{"country": true, "city": true, "place": true}
+
+// templatePart is literal text or, if Token is set, a token of a template
+// segment
+type templatePart struct {
+	Text  string
+	Token string
+}
+
+// parseDestinationTemplate splits a template into its path segments
+func parseDestinationTemplate(template string) ([][]templatePart, error) {
+	if strings.HasPrefix(template, "/") || filepath.IsAbs(template) {
+		return nil, fmt.Errorf("template must be relative to the destination directory")
+	}
+	var segments [][]templatePart
+	for _, segment := range strings.Split(template, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return nil, fmt.Errorf("invalid path segment %q", segment)
+		}
+		var parts []templatePart
+		for segment != "" {
+			open := strings.IndexAny(segment, "{}")
+			if open < 0 {
+				parts = append(parts, templatePart{Text: segment})
+				break
+			}
+			if segment[open] == '}' {
+				return nil, fmt.Errorf("unexpected } in %q", template)
+			}
+			if open > 0 {
+				parts = append(parts, templatePart{Text: segment[:open]})
+			}
+			end := strings.IndexByte(segment[open:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed { in %q", template)
+			}
+			token := segment[open+1 : open+end]
+			if !destinationTemplateTokens[token] {
+				return nil, fmt.Errorf("unknown token {%s} (must be year, month, day, country, city, or place)", token)
+			}
+			parts = append(parts, templatePart{Token: token})
+			segment = segment[open+end+1:]
+		}
+		segments = append(segments, parts)
+	}
+	return segments, nil
+}
+
+// templateUsesLocation returns true if a template has a location token
+func templateUsesLocation(template string) bool {
+	segments, err := parseDestinationTemplate(template)
+	if err != nil {
+		return false
+	}
+	for _, segment := range segments {
+		if segmentUsesLocation(segment) {
+			return true
+		}
+	}
+	return false
+}
+
+func segmentUsesLocation(segment []templatePart) bool {
+	for _, part := range segment {
+		if locationTokens[part.Token] {
+			return true
+		}
+	}
+	return false
+}
+
+// expandDestinationTemplate returns the directory, relative to the
+// destination, of a file captured at t. Without a place, the segments that
+// use location tokens are replaced by one fallback folder, so a template
+// such as {year}/{country}/{city} files them under 2024/Unknown Location.
+// The template must be valid.
+func expandDestinationTemplate(template string, t time.Time, place *placeInfo, fallback string) string {
+	segments, _ := parseDestinationTemplate(template)
+	if fallback == "" {
+		fallback = defaultLocationFallback
+	}
+	var dirs []string
+	fellBack := false
+	for _, segment := range segments {
+		if place == nil && segmentUsesLocation(segment) {
+			if !fellBack {
+				dirs = append(dirs, fallback)
+				fellBack = true
+			}
+			continue
+		}
+		var b strings.Builder
+		for _, part := range segment {
+			switch part.Token {
+			case "":
+				b.WriteString(part.Text)
+			case "year":
+				b.WriteString(t.Format("2006"))
+			case "month":
+				b.WriteString(t.Format("01"))
+			case "day":
+				b.WriteString(t.Format("02"))
+			case "country":
+				b.WriteString(sanitizePathSegment(place.Country))
+			case "city":
+				b.WriteString(sanitizePathSegment(place.City))
+			case "place":
+				b.WriteString(sanitizePathSegment(place.Place))
+			}
+		}
+		dirs = append(dirs, b.String())
+	}
+	return filepath.Join(dirs...)
+}
+
+// sanitizePathSegment makes a place name usable as a folder name on every
+// platform
+func sanitizePathSegment(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r < 0x20, strings.ContainsRune(`/\:*?"<>|`, r):
+			return '-'
+		}
+		return r
+	}, name)
+	name = strings.Trim(name, " .")
+	if name == "" {
+		return "-"
+	}
+	return name
+}
+
+// destinationDir returns the directory a file dated t is filed in: the
+// folder chosen for it in interactive review, its event folder, the expanded
+// destination_template, the YYYY/MM folder of organize_by_date, or the
+// destination itself.
+func destinationDir(cfg config, t time.Time, file FileInfo) string {
+	switch {
+	case file.ReviewDir != "":
+		return filepath.Join(cfg.DestDir, file.ReviewDir)
+	case file.Event != nil:
+		return eventDir(cfg.DestDir, file.Event)
+	case cfg.DestinationTemplate != "":
+		return filepath.Join(cfg.DestDir, expandDestinationTemplate(cfg.DestinationTemplate, t, file.Place, cfg.LocationFallback))
+	case cfg.OrganizeByDate || cfg.OrganizeBy == OrganizeModeDate:
+		return filepath.Join(cfg.DestDir, t.Format("2006/01"))
+	}
+	return cfg.DestDir
+}
+
+// validateLocationConfig checks destination_template and the settings its
+// location tokens use
+func validateLocationConfig(cfg *config) error {
+	if cfg.DestinationTemplate == "" {
+		return nil
+	}
+	if _, err := parseDestinationTemplate(cfg.DestinationTemplate); err != nil {
+		return fmt.Errorf("invalid destination_template: %w", err)
+	}
+	if cfg.OrganizeByDate || cfg.OrganizeBy != "" {
+		return fmt.Errorf("destination_template cannot be combined with organize_by_date or organize_by")
+	}
+	if !templateUsesLocation(cfg.DestinationTemplate) {
+		return nil
+	}
+	if cfg.GeoNamesCities == "" {
+		return fmt.Errorf("destination_template location tokens require geonames_cities")
+	}
+	if fallback := cfg.LocationFallback; fallback != "" && (sanitizePathSegment(fallback) != fallback || fallback == "-") {
+		return fmt.Errorf("invalid location_fallback: %q (must be a single folder name)", fallback)
+	}
+	return nil
+}
+
+// unifyGroupPlaces gives every chapter of a recording and every frame of a
+// burst or bracket the place of its first file, so a location folder never
+// splits a group.
+func unifyGroupPlaces(files []FileInfo) {
+	first := make(map[string]*placeInfo)
+	key := func(file FileInfo) (string, bool) {
+		switch {
+		case file.Chapter != nil:
+			return "chapter\x00" + file.SourceDir + "\x00" + file.Chapter.Recording, file.Chapter.Part == 1
+		case file.Sequence != nil:
+			return "sequence\x00" + file.SourceDir + "\x00" + file.Sequence.Folder(), file.Sequence.Position == 1
+		}
+		return "", false
+	}
+	for _, file := range files {
+		if k, isFirst := key(file); k != "" && isFirst {
+			first[k] = file.Place
+		}
+	}
+	for i := range files {
+		if k, _ := key(files[i]); k != "" {
+			if place, ok := first[k]; ok {
+				files[i].Place = place
+			}
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDestinationTemplate(t *testing.T) {
+	segments, err := parseDestinationTemplate("{year}/{year}-{month} {city}")
+	if err != nil {
+		t.Fatalf("parseDestinationTemplate failed: %v", err)
+	}
+	want := [][]templatePart{
+		{{Token: "year"}},
+		{{Token: "year"}, {Text: "-"}, {Token: "month"}, {Text: " "}, {Token: "city"}},
+	}
+	if !reflect.DeepEqual(segments, want) {
+		t.Errorf("segments = %+v, want %+v", segments, want)
+	}
+
+	for template, wantErr := range map[string]string{
+		"/{year}":         "relative",
+		"{year}//{month}": "invalid path segment",
+		"{year}/../x":     "invalid path segment",
+		"{year":           "unclosed {",
+		"year}":           "unexpected }",
+		"{year}/{camera}": "unknown token {camera}",
+	} {
+		if _, err := parseDestinationTemplate(template); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("parseDestinationTemplate(%q) = %v, want an error containing %q", template, err, wantErr)
+		}
+	}
+}
+
+func TestExpandDestinationTemplate(t *testing.T) {
+	at := time.Date(2024, 6, 5, 10, 0, 0, 0, time.UTC)
+	helsinki := &placeInfo{Country: "Finland", City: "Helsinki", Place: "Kallio"}
+	tests := []struct {
+		name     string
+		template string
+		place    *placeInfo
+		fallback string
+		want     string
+	}{
+		{"date", "{year}/{month}/{day}", nil, "", "2024/06/05"},
+		{"location", "{year}/{country}/{city}", helsinki, "", "2024/Finland/Helsinki"},
+		{"mixed segment", "{year}-{month} {place}", helsinki, "", "2024-06 Kallio"},
+		{"fallback", "{year}/{country}/{city}", nil, "", "2024/Unknown Location"},
+		{"custom fallback", "{country}/{year}/{city}", nil, "Elsewhere", "Elsewhere/2024"},
+		{"unsafe name", "{city}", &placeInfo{City: "Ciudad: A/B"}, "", "Ciudad- A-B"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := expandDestinationTemplate(tt.template, at, tt.place, tt.fallback)
+			if got != filepath.FromSlash(tt.want) {
+				t.Errorf("expandDestinationTemplate = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateConfigDestinationTemplate(t *testing.T) {
+	tmpDir := t.TempDir()
+	base := config{SourceDir: tmpDir, DestDir: filepath.Join(tmpDir, "dest"), SidecarDefault: SidecarDelete}
+	tests := []struct {
+		name    string
+		modify  func(*config)
+		wantErr string
+	}{
+		{"date tokens", func(c *config) { c.DestinationTemplate = "{year}/{month}" }, ""},
+		{"invalid template", func(c *config) { c.DestinationTemplate = "{year}/{camera}" }, "invalid destination_template"},
+		{"with organize_by_date", func(c *config) { c.DestinationTemplate = "{year}"; c.OrganizeByDate = true }, "organize_by_date"},
+		{"location without dataset", func(c *config) { c.DestinationTemplate = "{year}/{city}" }, "geonames_cities"},
+		{"location with dataset", func(c *config) { c.DestinationTemplate = "{year}/{city}"; c.GeoNamesCities = "cities15000.zip" }, ""},
+		{"nested fallback", func(c *config) {
+			c.DestinationTemplate = "{year}/{city}"
+			c.GeoNamesCities = "cities15000.zip"
+			c.LocationFallback = "No/Place"
+		}, "location_fallback"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := base
+			tt.modify(&cfg)
+			err := validateCommonConfig(&cfg)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateCommonConfig returned error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateCommonConfig = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUnifyGroupPlaces(t *testing.T) {
+	start := time.Date(2024, 6, 15, 10, 0, 0, 0, time.UTC)
+	first := &placeInfo{City: "Helsinki"}
+	files := []FileInfo{
+		{SourceDir: "DCIM", Chapter: &ChapterInfo{Recording: "GH010001", Start: start, Part: 2, Count: 2}, Place: &placeInfo{City: "Espoo"}},
+		{SourceDir: "DCIM", Chapter: &ChapterInfo{Recording: "GH010001", Start: start, Part: 1, Count: 2}, Place: first},
+		{SourceDir: "DCIM", Sequence: &SequenceInfo{Kind: SequenceBurst, Start: start, Position: 1, Count: 2}},
+		{SourceDir: "DCIM", Sequence: &SequenceInfo{Kind: SequenceBurst, Start: start, Position: 2, Count: 2}, Place: first},
+		{SourceDir: "DCIM", Place: &placeInfo{City: "Espoo"}},
+	}
+	unifyGroupPlaces(files)
+	for i, want := range []*placeInfo{first, first, nil, nil, {City: "Espoo"}} {
+		if !reflect.DeepEqual(files[i].Place, want) {
+			t.Errorf("files[%d].Place = %+v, want %+v", i, files[i].Place, want)
+		}
+	}
+}
+
+func TestImportMediaDestinationTemplate(t *testing.T) {
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+	dataDir := t.TempDir()
+
+	shot := time.Date(2024, 6, 15, 10, 1, 30, 0, time.Local)
+	track := strings.NewReplacer(
+		"2024-06-15T10:00:00Z", shot.Add(-90*time.Second).UTC().Format(time.RFC3339),
+		"2024-06-15T10:01:00Z", shot.Add(-30*time.Second).UTC().Format(time.RFC3339),
+		"2024-06-15T10:02:00Z", shot.Add(30*time.Second).UTC().Format(time.RFC3339),
+	).Replace(testGPX)
+	gpx := filepath.Join(dataDir, "day1.gpx")
+	if err := os.WriteFile(gpx, []byte(track), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cities := filepath.Join(dataDir, "cities15000.txt")
+	if err := os.WriteFile(cities, []byte(testGeoNames), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeTestJPEGWithEXIF(t, filepath.Join(sourceDir, "IMG_0001.JPG"), testEXIFASCII(exifTagDateTimeOriginal, shot.Format("2006:01:02 15:04:05")))
+	writeTestJPEGWithEXIF(t, filepath.Join(sourceDir, "IMG_0002.JPG"), testEXIFASCII(exifTagDateTimeOriginal, "2023:01:01 12:00:00"))
+
+	cfg := config{
+		SourceDir:           sourceDir,
+		DestDir:             destDir,
+		SidecarDefault:      SidecarDelete,
+		GPX:                 []string{gpx},
+		DestinationTemplate: "{year}/{country}/{city}",
+		GeoNamesCities:      cities,
+	}
+	if err := importMedia(cfg); err != nil {
+		t.Fatalf("importMedia failed: %v", err)
+	}
+	want := []string{
+		"2023/Unknown Location/IMG_0002.JPG",
+		"2024/Finland/Helsinki/IMG_0001.JPG",
+	}
+	if got := listTree(t, destDir); !reflect.DeepEqual(got, want) {
+		t.Errorf("destination = %v, want %v", got, want)
+	}
+}
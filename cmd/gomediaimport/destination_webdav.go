@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// webdavDestination writes to a WebDAV server. webdav:// URLs use HTTP and
+// webdavs:// URLs use HTTPS; user info in the URL is sent as basic auth.
+type webdavDestination struct {
+	client   *http.Client
+	base     url.URL
+	username string
+	password string
+
+	mu      sync.Mutex
+	created map[string]bool
+}
+
+var errUploadAborted = errors.New("upload aborted")
+
+func openWebDAVDestination(u *url.URL) (*webdavDestination, error) {
+	scheme := "http"
+	if strings.EqualFold(u.Scheme, "webdavs") {
+		scheme = "https"
+	}
+	return newWebDAVDestination(scheme+"://"+u.Host, u.User, &http.Client{}), nil
+}
+
+func newWebDAVDestination(baseURL string, user *url.Userinfo, client *http.Client) *webdavDestination {
+	base, _ := url.Parse(baseURL)
+	d := &webdavDestination{client: client, base: *base, created: make(map[string]bool)}
+	if user != nil {
+		d.username = user.Username()
+		d.password, _ = user.Password()
+	}
+	return d
+}
+
+func (d *webdavDestination) url(p string) string {
+	u := d.base
+	u.Path = remotePath(p)
+	return u.String()
+}
+
+func (d *webdavDestination) do(method, p string, body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, d.url(p), body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if d.username != "" {
+		req.SetBasicAuth(d.username, d.password)
+	}
+	return d.client.Do(req)
+}
+
+// webdavStatusError converts an unexpected response into an error, mapping
+// 404 to fs.ErrNotExist.
+func webdavStatusError(method, p string, resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return &fs.PathError{Op: method, Path: p, Err: fs.ErrNotExist}
+	}
+	return &fs.PathError{Op: method, Path: p, Err: fmt.Errorf("unexpected response %s", resp.Status)}
+}
+
+// webdavMultistatus is the subset of a PROPFIND response that is used
+type webdavMultistatus struct {
+	Responses []struct {
+		Propstats []struct {
+			Status string `xml:"status"`
+			Prop   struct {
+				ContentLength string `xml:"getcontentlength"`
+				LastModified  string `xml:"getlastmodified"`
+				ResourceType  struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+				QuotaAvailable string `xml:"quota-available-bytes"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+const webdavPropfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:"><D:prop>
+<D:getcontentlength/><D:getlastmodified/><D:resourcetype/><D:quota-available-bytes/>
+</D:prop></D:propfind>`
+
+// propfind returns the properties of a single resource that the server
+// reported with a 200 status.
+func (d *webdavDestination) propfind(p string) (remoteFileInfo, string, error) {
+	header := http.Header{"Depth": {"0"}, "Content-Type": {"application/xml; charset=utf-8"}}
+	resp, err := d.do("PROPFIND", p, strings.NewReader(webdavPropfindBody), header)
+	if err != nil {
+		return remoteFileInfo{}, "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusMultiStatus {
+		return remoteFileInfo{}, "", webdavStatusError("stat", p, resp)
+	}
+
+	var ms webdavMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return remoteFileInfo{}, "", fmt.Errorf("failed to parse PROPFIND response for %s: %w", p, err)
+	}
+	info := remoteFileInfo{name: path.Base(remotePath(p))}
+	var quota string
+	for _, r := range ms.Responses {
+		for _, ps := range r.Propstats {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			if ps.Prop.ContentLength != "" {
+				info.size, _ = strconv.ParseInt(ps.Prop.ContentLength, 10, 64)
+			}
+			if ps.Prop.LastModified != "" {
+				info.modTime, _ = http.ParseTime(ps.Prop.LastModified)
+			}
+			if ps.Prop.ResourceType.Collection != nil {
+				info.isDir = true
+			}
+			if ps.Prop.QuotaAvailable != "" {
+				quota = ps.Prop.QuotaAvailable
+			}
+		}
+	}
+	return info, quota, nil
+}
+
+func (d *webdavDestination) Stat(p string) (fs.FileInfo, error) {
+	info, _, err := d.propfind(p)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// Create streams the file to the server in a single PUT request.
+func (d *webdavDestination) Create(p string) (io.WriteCloser, error) {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		resp, err := d.do(http.MethodPut, p, pr, nil)
+		if err == nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+			if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+				err = webdavStatusError("create", p, resp)
+			}
+		}
+		_ = pr.CloseWithError(err)
+		done <- err
+	}()
+	return &pipeUpload{writer: pw, done: done}, nil
+}
+
+func (d *webdavDestination) Rename(oldPath, newPath string) error {
+	header := http.Header{"Destination": {d.url(newPath)}, "Overwrite": {"T"}}
+	resp, err := d.do("MOVE", oldPath, nil, header)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		return webdavStatusError("rename", oldPath, resp)
+	}
+	return nil
+}
+
+// MkdirAll creates each missing collection along p. Collections created or
+// found during this session are remembered so concurrent copies into the
+// same directory do not repeat the requests.
+func (d *webdavDestination) MkdirAll(p string) error {
+	target := remotePath(p)
+	var current string
+	for _, part := range strings.Split(strings.Trim(target, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		current += "/" + part
+		d.mu.Lock()
+		done := d.created[current]
+		d.mu.Unlock()
+		if done {
+			continue
+		}
+
+		resp, err := d.do("MKCOL", current, nil, nil)
+		if err != nil {
+			return err
+		}
+		_ = resp.Body.Close()
+		// 405 means the collection already exists
+		if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusMethodNotAllowed {
+			return webdavStatusError("mkdir", current, resp)
+		}
+		d.mu.Lock()
+		d.created[current] = true
+		d.mu.Unlock()
+	}
+	return nil
+}
+
+func (d *webdavDestination) Remove(p string) error {
+	resp, err := d.do(http.MethodDelete, p, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return webdavStatusError("remove", p, resp)
+	}
+	return nil
+}
+
+// FreeSpace reads the RFC 4331 quota-available-bytes property of the nearest
+// existing collection.
+func (d *webdavDestination) FreeSpace(p string) (uint64, error) {
+	current := remotePath(p)
+	for {
+		_, quota, err := d.propfind(current)
+		if err == nil {
+			if quota == "" {
+				return 0, errFreeSpaceUnknown
+			}
+			return strconv.ParseUint(quota, 10, 64)
+		}
+		if !errors.Is(err, fs.ErrNotExist) || current == "/" {
+			return 0, err
+		}
+		current = path.Dir(current)
+	}
+}
+
+func (d *webdavDestination) Hash(p string) (string, error) {
+	resp, err := d.do(http.MethodGet, p, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return "", webdavStatusError("hash", p, resp)
+	}
+	return hashReader(resp.Body)
+}
+
+// Chtimes is a no-op: getlastmodified is read-only on most WebDAV servers.
+func (d *webdavDestination) Chtimes(string, time.Time) error {
+	return nil
+}
+
+func (d *webdavDestination) Close() error {
+	d.client.CloseIdleConnections()
+	return nil
+}
+
+// pipeUpload feeds a request body that is sent while the file is written
+type pipeUpload struct {
+	writer *io.PipeWriter
+	done   chan error
+}
+
+func (u *pipeUpload) Write(p []byte) (int, error) {
+	return u.writer.Write(p)
+}
+
+func (u *pipeUpload) Close() error {
+	_ = u.writer.Close()
+	return <-u.done
+}
+
+func (u *pipeUpload) Abort() error {
+	_ = u.writer.CloseWithError(errUploadAborted)
+	<-u.done
+	return nil
+}
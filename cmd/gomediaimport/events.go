@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// OrganizeMode defines how organize_by groups files into folders
+type OrganizeMode string
+
+const (
+	OrganizeModeDate  OrganizeMode = "date"
+	OrganizeModeEvent OrganizeMode = "event"
+)
+
+const (
+	// defaultEventGap is the capture time gap that starts a new event
+	defaultEventGap = 3 * time.Hour
+	// defaultEventName names events without an --event-name
+	defaultEventName = "Event"
+	// earthRadiusKm is the mean radius of the earth
+	earthRadiusKm = 6371.0
+)
+
+// isValidOrganizeMode returns true if the mode is a valid OrganizeMode value.
+// Empty is valid and leaves folders to organize_by_date.
+func isValidOrganizeMode(mode OrganizeMode) bool {
+	switch mode {
+	case "", OrganizeModeDate, OrganizeModeEvent:
+		return true
+	default:
+		return false
+	}
+}
+
+// effectiveEventGap returns the gap to apply, defaulting to 3 hours.
+func effectiveEventGap(gap time.Duration) time.Duration {
+	if gap <= 0 {
+		return defaultEventGap
+	}
+	return gap
+}
+
+// EventInfo describes a cluster of files captured close together in time
+// and, optionally, space
+type EventInfo struct {
+	Start  time.Time
+	End    time.Time
+	Files  int
+	Place  *placeInfo // Place of the first file with one, nil if N/A
+	Name   string
+	Folder string // Folder below the year folder, like "2026-10-14 Event"
+}
+
+// eventUnit is a set of files that always land in the same event: a media
+// file with its RAW/JPEG sibling, sidecars, and proxies, or all chapters of
+// a recording
+type eventUnit struct {
+	Files    []int
+	Time     time.Time
+	Position *gpsLocation
+	Place    *placeInfo
+}
+
+// eventUnits groups files into units by source directory and base name,
+// the key sidecars and RAW/JPEG siblings share with their media file.
+// Proxies join the unit of their clip, and chapters join their recording.
+func eventUnits(files []FileInfo) []*eventUnit {
+	// Chapters are detected on a copy so planning still starts from scratch
+	probe := append([]FileInfo(nil), files...)
+	detectChapters(probe)
+
+	byKey := make(map[string]*eventUnit)
+	var units []*eventUnit
+	unitFor := func(key string) *eventUnit {
+		unit, ok := byKey[key]
+		if !ok {
+			unit = &eventUnit{}
+			byKey[key] = unit
+			units = append(units, unit)
+		}
+		return unit
+	}
+	baseKey := func(dir, name string) string {
+		key := newParentKey(dir, strings.TrimSuffix(name, filepath.Ext(name)))
+		return key.dir + "\x00" + key.baseName
+	}
+	// Chapters share the unit of their recording, and so do the sidecars
+	// and proxies named after any chapter
+	recordings := make(map[string]string)
+	for _, file := range probe {
+		if file.Chapter != nil {
+			recordings[baseKey(file.SourceDir, file.SourceName)] = "chapter\x00" + file.SourceDir + "\x00" + file.Chapter.Recording
+		}
+	}
+	unitKey := func(key string) string {
+		if recording, ok := recordings[key]; ok {
+			return recording
+		}
+		return key
+	}
+	var proxies []int
+	for i, file := range probe {
+		if file.MediaCategory == Proxy {
+			proxies = append(proxies, i)
+			continue
+		}
+		unit := unitFor(unitKey(baseKey(file.SourceDir, file.SourceName)))
+		unit.Files = append(unit.Files, i)
+	}
+	for _, i := range proxies {
+		key := unitKey(baseKey(probe[i].SourceDir, probe[i].SourceName))
+		for _, parent := range proxyParentKeys(probe[i]) {
+			if parentKey := unitKey(parent.dir + "\x00" + parent.baseName); byKey[parentKey] != nil {
+				key = parentKey
+				break
+			}
+		}
+		unit := unitFor(key)
+		unit.Files = append(unit.Files, i)
+	}
+
+	for _, unit := range units {
+		// Sidecar and proxy times are file times, so media times come first
+		hasMedia := false
+		for _, i := range unit.Files {
+			if files[i].MediaCategory != Sidecar && files[i].MediaCategory != Proxy {
+				hasMedia = true
+				break
+			}
+		}
+		for _, i := range unit.Files {
+			file := files[i]
+			if hasMedia && (file.MediaCategory == Sidecar || file.MediaCategory == Proxy) {
+				continue
+			}
+			if unit.Time.IsZero() || file.CreationDateTime.Before(unit.Time) {
+				unit.Time = file.CreationDateTime
+			}
+			if unit.Position == nil {
+				if latitude, longitude, ok := filePosition(file); ok {
+					unit.Position = &gpsLocation{Latitude: latitude, Longitude: longitude}
+				}
+			}
+			if unit.Place == nil {
+				unit.Place = file.Place
+			}
+		}
+	}
+	return units
+}
+
+// greatCircleKm returns the distance between two positions in kilometers
+func greatCircleKm(a, b gpsLocation) float64 {
+	lat1, lat2 := a.Latitude*math.Pi/180, b.Latitude*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (b.Longitude - a.Longitude) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// clusterEvents sorts the files of one import into events and sets their
+// Event. A new event starts when a file was captured more than event_gap
+// after the previous one or, with event_distance_km, further than that from
+// the last position of the current event. Frames of a burst are never split
+// by distance. Events are named by event_name and still need their folders
+// assigned by assignEventFolders.
+func clusterEvents(files []FileInfo, cfg config) []*EventInfo {
+	units := eventUnits(files)
+	sort.SliceStable(units, func(i, j int) bool { return units[i].Time.Before(units[j].Time) })
+
+	name := cfg.EventName
+	if name == "" {
+		name = defaultEventName
+	}
+	gap := effectiveEventGap(cfg.EventGap)
+	var events []*EventInfo
+	var current *EventInfo
+	var lastPosition *gpsLocation
+	for _, unit := range units {
+		split := current == nil || unit.Time.Sub(current.End) > gap
+		if !split && cfg.EventDistanceKm > 0 && unit.Position != nil && lastPosition != nil &&
+			unit.Time.Sub(current.End) > sequenceMaxGap && greatCircleKm(*lastPosition, *unit.Position) > cfg.EventDistanceKm {
+			split = true
+		}
+		if split {
+			current = &EventInfo{Start: unit.Time, End: unit.Time, Name: name}
+			events = append(events, current)
+			lastPosition = nil
+		}
+		if unit.Time.After(current.End) {
+			current.End = unit.Time
+		}
+		if unit.Position != nil {
+			lastPosition = unit.Position
+		}
+		if current.Place == nil {
+			current.Place = unit.Place
+		}
+		for _, i := range unit.Files {
+			files[i].Event = current
+			if files[i].MediaCategory != Sidecar && files[i].MediaCategory != Proxy {
+				current.Files++
+			}
+		}
+	}
+	return events
+}
+
+// assignEventFolders names each event's folder after its first day and
+// name. Events that would share a folder get a number from the second on.
+func assignEventFolders(events []*EventInfo) {
+	used := make(map[string]int)
+	for _, event := range events {
+		folder := event.Start.Format("2006-01-02") + " " + event.Name
+		key := event.Start.Format("2006") + "/" + strings.ToLower(folder)
+		used[key]++
+		if n := used[key]; n > 1 {
+			folder = fmt.Sprintf("%s %d", folder, n)
+		}
+		event.Folder = folder
+	}
+}
+
+// validateEventConfig checks organize_by and the event settings
+func validateEventConfig(cfg *config) error {
+	if !isValidOrganizeMode(cfg.OrganizeBy) {
+		return fmt.Errorf("invalid organize_by: %q (must be date or event)", cfg.OrganizeBy)
+	}
+	if cfg.OrganizeBy == OrganizeModeEvent && cfg.OrganizeByDate {
+		return fmt.Errorf("organize_by event cannot be combined with organize_by_date")
+	}
+	if cfg.EventGap < 0 {
+		return fmt.Errorf("event_gap must be non-negative, got %s", cfg.EventGap)
+	}
+	if cfg.EventDistanceKm < 0 || math.IsNaN(cfg.EventDistanceKm) || math.IsInf(cfg.EventDistanceKm, 0) {
+		return fmt.Errorf("event_distance_km must be a non-negative number, got %v", cfg.EventDistanceKm)
+	}
+	if cfg.EventName != "" && !validEventName(cfg.EventName) {
+		return fmt.Errorf("invalid event_name: %q (must be usable as a folder name)", cfg.EventName)
+	}
+	if cfg.NameEvents && cfg.OrganizeBy != OrganizeModeEvent {
+		return fmt.Errorf("--name-events requires organize_by event")
+	}
+	return nil
+}
+
+// eventDir returns the directory of an event's files, below the year the
+// event started in so an event over New Year is not split
+func eventDir(destDir string, event *EventInfo) string {
+	return filepath.Join(destDir, event.Start.Format("2006"), event.Folder)
+}
+
+// validEventName returns true if the name can be used in a folder name
+func validEventName(name string) bool {
+	return name != "" && sanitizePathSegment(name) == name
+}
+
+// promptEventNames asks for the name of each event, keeping the current
+// name on an empty answer or at the end of the input
+func promptEventNames(events []*EventInfo, in io.Reader, out io.Writer) error {
+	reader := bufio.NewReader(in)
+	for n, event := range events {
+		where := ""
+		if event.Place != nil {
+			where = fmt.Sprintf(" near %s, %s", event.Place.City, event.Place.Country)
+		}
+		_, _ = fmt.Fprintf(out, "Event %d of %d: %s to %s, %d files%s\n", n+1, len(events),
+			event.Start.Format("2006-01-02 15:04"), event.End.Format("2006-01-02 15:04"), event.Files, where)
+		for {
+			_, _ = fmt.Fprintf(out, "Name [%s]: ", event.Name)
+			line, err := reader.ReadString('\n')
+			if err != nil && err != io.EOF {
+				return fmt.Errorf("failed to read event name: %w", err)
+			}
+			answer := strings.TrimSpace(line)
+			if answer == "" {
+				if err == io.EOF {
+					_, _ = fmt.Fprintln(out)
+					return nil
+				}
+				break
+			}
+			if validEventName(answer) {
+				event.Name = answer
+				break
+			}
+			_, _ = fmt.Fprintf(out, "%q cannot be used in a folder name\n", answer)
+			if err == io.EOF {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// printEvents lists the events of the import with their folders
+func printEvents(events []*EventInfo) {
+	fmt.Printf("Events: %d\n", len(events))
+	for _, event := range events {
+		fmt.Printf("  %s: %d files, %s to %s\n", filepath.Join(event.Start.Format("2006"), event.Folder), event.Files,
+			event.Start.Format("2006-01-02 15:04"), event.End.Format("2006-01-02 15:04"))
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClusterEvents(t *testing.T) {
+	day := time.Date(2024, 6, 15, 0, 0, 0, 0, time.UTC)
+	at := func(h, m int) time.Time { return day.Add(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute) }
+	helsinki, tampere := 60.17, 61.50
+	long := 24.94
+	files := []FileInfo{
+		{SourceDir: "DCIM", SourceName: "IMG_0001.CR2", MediaCategory: RawPicture, CreationDateTime: at(9, 0)},
+		{SourceDir: "DCIM", SourceName: "IMG_0001.JPG", MediaCategory: ProcessedPicture, FileType: JPEG, CreationDateTime: at(9, 0)},
+		// A sidecar written days later still follows its picture
+		{SourceDir: "DCIM", SourceName: "IMG_0001.XMP", MediaCategory: Sidecar, CreationDateTime: day.AddDate(0, 0, 3)},
+		{SourceDir: "DCIM", SourceName: "IMG_0002.JPG", MediaCategory: ProcessedPicture, CreationDateTime: at(11, 30)},
+		// More than three hours later starts a new event
+		{SourceDir: "DCIM", SourceName: "IMG_0003.JPG", MediaCategory: ProcessedPicture, CreationDateTime: at(15, 0),
+			ImageMetadata: &ImageMetadata{GPSLatitude: &helsinki, GPSLongitude: &long}},
+		// Far from the previous picture, but event_distance_km is not set
+		{SourceDir: "DCIM", SourceName: "IMG_0004.JPG", MediaCategory: ProcessedPicture, CreationDateTime: at(15, 30),
+			ImageMetadata: &ImageMetadata{GPSLatitude: &tampere, GPSLongitude: &long}},
+		{SourceDir: "DCIM", SourceName: "GL010042.LRV", MediaCategory: Proxy, CreationDateTime: day.AddDate(0, 0, 5)},
+		{SourceDir: "DCIM", SourceName: "GX010042.MP4", MediaCategory: Video, CreationDateTime: at(16, 0)},
+		{SourceDir: "DCIM", SourceName: "GX020042.MP4", MediaCategory: Video, CreationDateTime: at(16, 20)},
+	}
+	eventOf := func(files []FileInfo) []int {
+		index := make(map[*EventInfo]int)
+		var got []int
+		for _, file := range files {
+			if _, ok := index[file.Event]; !ok {
+				index[file.Event] = len(index)
+			}
+			got = append(got, index[file.Event])
+		}
+		return got
+	}
+
+	cfg := config{EventName: "Wedding"}
+	events := clusterEvents(files, cfg)
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2", len(events))
+	}
+	if got, want := eventOf(files), []int{0, 0, 0, 0, 1, 1, 1, 1, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+	first := events[0]
+	if first.Name != "Wedding" || !first.Start.Equal(at(9, 0)) || !first.End.Equal(at(11, 30)) || first.Files != 3 {
+		t.Errorf("first event = %+v", first)
+	}
+	if files[0].Chapter != nil {
+		t.Error("clusterEvents left chapters on the files")
+	}
+
+	// A short gap splits everything but the chapters of one recording
+	cfg.EventGap = 10 * time.Minute
+	clusterEvents(files, cfg)
+	if got, want := eventOf(files), []int{0, 0, 0, 1, 2, 3, 4, 4, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("events with a 10 minute gap = %v, want %v", got, want)
+	}
+
+	// Distance splits pictures close in time
+	cfg.EventGap = 0
+	cfg.EventDistanceKm = 50
+	clusterEvents(files, cfg)
+	if got, want := eventOf(files), []int{0, 0, 0, 0, 1, 2, 2, 2, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("events with a 50 km distance = %v, want %v", got, want)
+	}
+}
+
+func TestAssignEventFolders(t *testing.T) {
+	day := time.Date(2024, 6, 15, 9, 0, 0, 0, time.UTC)
+	events := []*EventInfo{
+		{Start: day, Name: "Event"},
+		{Start: day.Add(6 * time.Hour), Name: "Event"},
+		{Start: day.Add(12 * time.Hour), Name: "Dinner"},
+		{Start: day.AddDate(0, 0, 1), Name: "Event"},
+	}
+	assignEventFolders(events)
+	var got []string
+	for _, event := range events {
+		got = append(got, event.Folder)
+	}
+	want := []string{"2024-06-15 Event", "2024-06-15 Event 2", "2024-06-15 Dinner", "2024-06-16 Event"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("folders = %q, want %q", got, want)
+	}
+	if dir := eventDir("/photos", events[1]); dir != filepath.Join("/photos", "2024", "2024-06-15 Event 2") {
+		t.Errorf("eventDir = %q", dir)
+	}
+}
+
+func TestPromptEventNames(t *testing.T) {
+	day := time.Date(2024, 6, 15, 9, 0, 0, 0, time.UTC)
+	events := []*EventInfo{
+		{Start: day, End: day, Files: 2, Name: "Event", Place: &placeInfo{City: "Helsinki", Country: "Finland"}},
+		{Start: day, End: day, Files: 1, Name: "Event"},
+		{Start: day, End: day, Files: 1, Name: "Event"},
+	}
+	var out bytes.Buffer
+	if err := promptEventNames(events, strings.NewReader("Wedding\n\nbad/name\nDinner"), &out); err != nil {
+		t.Fatalf("promptEventNames failed: %v", err)
+	}
+	var got []string
+	for _, event := range events {
+		got = append(got, event.Name)
+	}
+	if want := []string{"Wedding", "Event", "Dinner"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("names = %q, want %q", got, want)
+	}
+	for _, want := range []string{"near Helsinki, Finland", `"bad/name" cannot be used`, "Name [Event]: "} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("prompt output %q does not contain %q", out.String(), want)
+		}
+	}
+
+	// Running out of input keeps the remaining names
+	events[1].Name = "Event"
+	if err := promptEventNames(events[1:], strings.NewReader(""), &out); err != nil || events[1].Name != "Event" {
+		t.Errorf("promptEventNames at end of input = %v, name %q", err, events[1].Name)
+	}
+}
+
+func TestValidateConfigEvents(t *testing.T) {
+	tmpDir := t.TempDir()
+	base := config{SourceDir: tmpDir, DestDir: filepath.Join(tmpDir, "dest"), SidecarDefault: SidecarDelete, OrganizeBy: OrganizeModeEvent}
+	tests := []struct {
+		name    string
+		modify  func(*config)
+		wantErr string
+	}{
+		{"defaults", func(*config) {}, ""},
+		{"date", func(c *config) { c.OrganizeBy = OrganizeModeDate }, ""},
+		{"invalid mode", func(c *config) { c.OrganizeBy = "camera" }, "invalid organize_by"},
+		{"with organize_by_date", func(c *config) { c.OrganizeByDate = true }, "organize_by_date"},
+		{"with template", func(c *config) { c.DestinationTemplate = "{year}" }, "destination_template"},
+		{"negative gap", func(c *config) { c.EventGap = -time.Hour }, "event_gap"},
+		{"negative distance", func(c *config) { c.EventDistanceKm = -1 }, "event_distance_km"},
+		{"name", func(c *config) { c.EventName = "Sofia's Wedding" }, ""},
+		{"invalid name", func(c *config) { c.EventName = "Wedding/Party" }, "invalid event_name"},
+		{"name events by date", func(c *config) { c.OrganizeBy = OrganizeModeDate; c.NameEvents = true }, "--name-events"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := base
+			tt.modify(&cfg)
+			err := validateCommonConfig(&cfg)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateCommonConfig returned error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateCommonConfig = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestImportMediaOrganizeByEvent(t *testing.T) {
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+	writeTestJPEGWithEXIF(t, filepath.Join(sourceDir, "IMG_0001.JPG"), testEXIFASCII(exifTagDateTimeOriginal, "2024:06:15 14:00:00"))
+	writeTestJPEGWithEXIF(t, filepath.Join(sourceDir, "IMG_0002.JPG"), testEXIFASCII(exifTagDateTimeOriginal, "2024:06:15 16:30:00"))
+	writeTestJPEGWithEXIF(t, filepath.Join(sourceDir, "IMG_0003.JPG"), testEXIFASCII(exifTagDateTimeOriginal, "2024:06:16 12:00:00"))
+	if err := os.WriteFile(filepath.Join(sourceDir, "IMG_0002.XMP"), []byte("<x:xmpmeta/>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config{
+		SourceDir:      sourceDir,
+		DestDir:        destDir,
+		SidecarDefault: SidecarCopy,
+		OrganizeBy:     OrganizeModeEvent,
+		EventName:      "Wedding",
+	}
+	if err := importMedia(cfg); err != nil {
+		t.Fatalf("importMedia failed: %v", err)
+	}
+	want := []string{
+		"2024/2024-06-15 Wedding/IMG_0001.JPG",
+		"2024/2024-06-15 Wedding/IMG_0002.JPG",
+		"2024/2024-06-15 Wedding/IMG_0002.XMP",
+		"2024/2024-06-16 Wedding/IMG_0003.JPG",
+	}
+	if got := listTree(t, destDir); !reflect.DeepEqual(got, want) {
+		t.Errorf("destination = %v, want %v", got, want)
+	}
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"io/fs"
 	"os"
@@ -18,10 +20,13 @@ import (
 type sourceArtifactKind string
 
 const (
-	sourceArtifactTrash         sourceArtifactKind = "system trash"
-	sourceArtifactSonyThumbnail sourceArtifactKind = "Sony thumbnail directory"
-	sourceArtifactSonyXML       sourceArtifactKind = "Sony XML companion"
-	sourceArtifactAppleDouble   sourceArtifactKind = "AppleDouble file"
+	sourceArtifactTrash          sourceArtifactKind = "system trash"
+	sourceArtifactSonyThumbnail  sourceArtifactKind = "Sony thumbnail directory"
+	sourceArtifactSonyXML        sourceArtifactKind = "Sony XML companion"
+	sourceArtifactAppleDouble    sourceArtifactKind = "AppleDouble file"
+	sourceArtifactProxy          sourceArtifactKind = "low-resolution proxy"
+	sourceArtifactThumbnail      sourceArtifactKind = "camera thumbnail"
+	sourceArtifactCameraMetadata sourceArtifactKind = "camera metadata companion"
 )
 
 type sourceCleanupTarget struct {
@@ -47,8 +52,14 @@ func enumerateFiles(sourceDir string, cfg config) (enumerationResult, error) {
 		result.CleanupTargets = append(result.CleanupTargets, sourceCleanupTarget{Path: path, Kind: kind})
 	}
 
+	src := sourceFor(cfg)
+	walkable, ok := src.(walkableSource)
+	if !ok {
+		return enumerationResult{}, fmt.Errorf("source %s cannot be walked", src.Volume())
+	}
+
 	// Check if the source directory exists
-	_, err := os.Stat(sourceDir)
+	_, err := src.Stat(sourceDir)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return enumerationResult{}, fmt.Errorf("source directory does not exist: %w", err)
@@ -56,8 +67,15 @@ func enumerateFiles(sourceDir string, cfg config) (enumerationResult, error) {
 		return enumerationResult{}, fmt.Errorf("error accessing source directory: %w", err)
 	}
 
+	fsys, err := walkable.FS(sourceDir)
+	if err != nil {
+		return enumerationResult{}, fmt.Errorf("error accessing source directory: %w", err)
+	}
+
 	// Walk through the directory
-	err = filepath.WalkDir(sourceDir, func(path string, d fs.DirEntry, err error) error {
+	err = fs.WalkDir(fsys, ".", func(member string, d fs.DirEntry, err error) error {
+		relPath := filepath.FromSlash(member)
+		path := filepath.Join(sourceDir, relPath)
 		if err != nil {
 			if os.IsPermission(err) {
 				fmt.Fprintf(os.Stderr, "Warning: Permission denied accessing %s, skipping\n", path)
@@ -74,11 +92,6 @@ func enumerateFiles(sourceDir string, cfg config) (enumerationResult, error) {
 			return nil
 		}
 
-		relPath, err := filepath.Rel(sourceDir, path)
-		if err != nil {
-			return fmt.Errorf("getting relative path for %q: %w", path, err)
-		}
-
 		// Trash is classified before camera-specific artifacts so anything moved
 		// into trash can never be mistaken for live media.
 		if d.IsDir() && isTopLevelTrashDir(relPath) {
@@ -86,13 +99,19 @@ func enumerateFiles(sourceDir string, cfg config) (enumerationResult, error) {
 			return filepath.SkipDir
 		}
 
-		if d.IsDir() && isSonyThumbnailDir(relPath) {
-			addCleanupTarget(path, sourceArtifactSonyThumbnail)
-			return filepath.SkipDir
-		}
-
-		// Skip directories
+		// Proxies, thumbnails, and metadata files that cameras write next to
+		// their clips are recognized from the camera's folder layout.
+		layout := matchCameraLayout(relPath, d.IsDir())
 		if d.IsDir() {
+			switch layout.Artifact {
+			case sourceArtifactSonyThumbnail:
+				addCleanupTarget(path, layout.Artifact)
+				return filepath.SkipDir
+			case sourceArtifactProxy:
+				if effectiveProxyAction(cfg.ProxyAction) == ProxyIgnore {
+					return filepath.SkipDir
+				}
+			}
 			return nil
 		}
 
@@ -101,9 +120,18 @@ func enumerateFiles(sourceDir string, cfg config) (enumerationResult, error) {
 			return nil
 		}
 
-		if isSonyClipXML(relPath) {
-			addCleanupTarget(path, sourceArtifactSonyXML)
+		// Only artifacts that are never media are cleaned up. Proxies follow
+		// proxy_action and thumbnails and metadata companions the sidecar
+		// settings, so nothing is deleted that was neither copied nor
+		// configured to be deleted.
+		switch layout.Artifact {
+		case sourceArtifactSonyXML:
+			addCleanupTarget(path, layout.Artifact)
 			return nil
+		case sourceArtifactThumbnail, sourceArtifactCameraMetadata:
+			if !isCompanionName(d.Name(), cfg) {
+				return nil
+			}
 		}
 
 		info, err := d.Info()
@@ -117,38 +145,39 @@ func enumerateFiles(sourceDir string, cfg config) (enumerationResult, error) {
 			SourceDir:        filepath.Dir(path),
 			Size:             info.Size(),
 			CreationDateTime: info.ModTime(), // Using ModTime as default CreationDateTime
+			CameraFamily:     layout.Family,
 		}
 
-		// Get media type information
-		category, fileType := getMediaTypeInfo(fileInfo)
-		if category == "" {
-			// Check if it's a sidecar file
-			ext := strings.ToLower(filepath.Ext(info.Name()))
-			if ext != "" {
-				ext = ext[1:] // Remove leading dot
-			}
-			if isSidecarExtension(ext) {
-				action := getSidecarAction(ext, cfg.Sidecars, cfg.SidecarDefault)
-				if action == SidecarIgnore {
-					return nil
-				}
-				fileInfo.MediaCategory = Sidecar
+		if layout.Artifact == sourceArtifactProxy {
+			if markProxy(&fileInfo, cfg) {
 				result.Files = append(result.Files, fileInfo)
-				return nil
 			}
-			// Skip non-media, non-sidecar files
 			return nil
 		}
-
-		fileInfo.MediaCategory = category
-		fileInfo.FileType = fileType
+		known := classifySourceFile(&fileInfo, cfg)
+		if known && (fileInfo.MediaCategory == Sidecar || fileInfo.MediaCategory == Proxy) {
+			result.Files = append(result.Files, fileInfo)
+			return nil
+		}
+		if !known && isCompanionName(fileInfo.SourceName, cfg) {
+			// An ignored sidecar or proxy, such as a THM thumbnail, may well
+			// hold JPEG or MP4 content but is still not imported
+			return nil
+		}
+		// The content confirms or overrides the extension, and finds media
+		// whose extension is missing or unknown
+		if !sniffSourceFile(src, &fileInfo) {
+			return nil
+		}
 
 		// Extract creation date and time from metadata
-		extractedMetadata, err := extractMetadata(fileInfo)
+		extractedMetadata, err := extractMetadata(src, fileInfo)
 		if err == nil {
 			fileInfo.CreationDateTime = extractedMetadata.CreationDateTime
 			fileInfo.VideoMetadata = extractedMetadata.VideoMetadata
+			fileInfo.ImageMetadata = extractedMetadata.ImageMetadata
 		}
+		fileInfo.TimeIsFallback = err != nil || (fileInfo.VideoMetadata != nil && fileInfo.VideoMetadata.TimestampSource == videoTimestampSourceFallback)
 
 		result.Files = append(result.Files, fileInfo)
 		return nil
@@ -164,6 +193,46 @@ func enumerateFiles(sourceDir string, cfg config) (enumerationResult, error) {
 	return result, nil
 }
 
+// classifySourceFile sets the media category and file type of fileInfo and
+// returns true if it should be imported. Files that are neither media nor
+// sidecars, and sidecars and proxies whose action is ignore, are left out.
+func classifySourceFile(fileInfo *FileInfo, cfg config) bool {
+	types := cfg.mediaTables()
+	category, fileType := types.getMediaTypeInfo(*fileInfo)
+	if category == "" {
+		ext := strings.ToLower(filepath.Ext(fileInfo.SourceName))
+		if ext != "" {
+			ext = ext[1:] // Remove leading dot
+		}
+		if isProxyExtension(ext) {
+			return markProxy(fileInfo, cfg)
+		}
+		// Check if it's a sidecar file
+		if !types.isSidecarExtension(ext) || types.getSidecarAction(ext, cfg.Sidecars, cfg.SidecarDefault) == SidecarIgnore {
+			return false
+		}
+		fileInfo.MediaCategory = Sidecar
+		return true
+	}
+
+	fileInfo.MediaCategory = category
+	fileInfo.FileType = fileType
+	fileInfo.MetadataParser = types.metadataParsers[fileType]
+	return true
+}
+
+// isCompanionName returns true if the file's extension marks it as a sidecar
+// or proxy
+func isCompanionName(name string, cfg config) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == "" {
+		return false
+	}
+	ext = ext[1:]
+	_, overridden := cfg.Sidecars[ext]
+	return overridden || cfg.mediaTables().isSidecarExtension(ext) || isProxyExtension(ext)
+}
+
 func relativePathComponents(path string) []string {
 	cleaned := filepath.Clean(path)
 	if cleaned == "." {
@@ -224,6 +293,23 @@ func isSonyClipXML(relPath string) bool {
 	return false
 }
 
+// renamedExtension returns the extension a file gets when it is renamed by
+// date and time: the one its content calls for, the source extension for RAW
+// pictures, whose formats often share one type, and otherwise the type's
+// usual one.
+func renamedExtension(file FileInfo, ext string, cfg config) string {
+	if file.ContentExt != "" {
+		return strings.ToLower(file.ContentExt)
+	}
+	if file.MediaCategory == RawPicture {
+		return strings.ToLower(ext)
+	}
+	if newExt := cfg.mediaTables().getFirstExtensionForFileType(file.FileType); newExt != "" {
+		return "." + newExt
+	}
+	return ext
+}
+
 // fileSizeTime is a composite key for the duplicate detection index
 type fileSizeTime struct {
 	Size      int64
@@ -237,31 +323,34 @@ func setFinalDestinationFilename(files *[]FileInfo, currentIndex int, initialFil
 	baseFilename := strings.TrimSuffix(initialFilename, ext)
 
 	if cfg.RenameByDateTime {
-		newExt := getFirstExtensionForFileType(file.FileType)
-		if newExt != "" {
-			ext = "." + newExt
-		}
+		ext = renamedExtension(*file, ext, cfg)
 	}
 
 	initialFilename = baseFilename + ext
 
-	if isDuplicateInPreviousFiles(files, currentIndex, cfg.ChecksumDuplicates, sizeTimeIndex) {
+	if isDuplicateInPreviousFiles(sourceFor(cfg), files, currentIndex, cfg.ChecksumDuplicates, sizeTimeIndex) {
 		file.Status = StatusPreExisting
 		file.DestName = initialFilename
 		return nil
 	}
 
 	fullPath := filepath.Join(baseDir, initialFilename)
-	fileExists, err := exists(fullPath)
+	fileExists, err := exists(destinationFor(cfg), fullPath)
 	if err != nil {
 		return fmt.Errorf("error checking file %s: %w", fullPath, err)
 	}
-	if !fileExists && !isNameTakenByPreviousFile(files, currentIndex, initialFilename) {
-		file.DestName = initialFilename
-		return nil
+	if !fileExists && !isNameTakenByPlannedFile(files, currentIndex, initialFilename) {
+		pairAvailable, err := isPairedNameAvailable(files, currentIndex, baseFilename, cfg)
+		if err != nil {
+			return err
+		}
+		if pairAvailable {
+			file.DestName = initialFilename
+			return nil
+		}
 	}
 
-	dup, err := isDuplicate(file, fullPath, cfg.ChecksumDuplicates)
+	dup, err := isDuplicate(sourceFor(cfg), destinationFor(cfg), file, fullPath, cfg.ChecksumDuplicates)
 	if err != nil {
 		return err
 	}
@@ -275,15 +364,21 @@ func setFinalDestinationFilename(files *[]FileInfo, currentIndex int, initialFil
 		suffix := fmt.Sprintf("_%03d", i) // Ensure three-digit suffix
 		newFilename := baseFilename + suffix + ext
 		fullPath = filepath.Join(baseDir, newFilename)
-		fileExists, err = exists(fullPath)
+		fileExists, err = exists(destinationFor(cfg), fullPath)
 		if err != nil {
 			return fmt.Errorf("error checking file %s: %w", fullPath, err)
 		}
-		if !fileExists && !isNameTakenByPreviousFile(files, currentIndex, newFilename) {
-			file.DestName = newFilename
-			return nil
+		if !fileExists && !isNameTakenByPlannedFile(files, currentIndex, newFilename) {
+			pairAvailable, err := isPairedNameAvailable(files, currentIndex, baseFilename+suffix, cfg)
+			if err != nil {
+				return err
+			}
+			if pairAvailable {
+				file.DestName = newFilename
+				return nil
+			}
 		}
-		dup, err = isDuplicate(file, fullPath, cfg.ChecksumDuplicates)
+		dup, err = isDuplicate(sourceFor(cfg), destinationFor(cfg), file, fullPath, cfg.ChecksumDuplicates)
 		if err != nil {
 			return err
 		}
@@ -297,7 +392,7 @@ func setFinalDestinationFilename(files *[]FileInfo, currentIndex int, initialFil
 	return fmt.Errorf("couldn't find a unique filename after 999,999 attempts")
 }
 
-func isDuplicateInPreviousFiles(files *[]FileInfo, currentIndex int, checksumDuplicates bool, sizeTimeIndex map[fileSizeTime][]int) bool {
+func isDuplicateInPreviousFiles(src mediaSource, files *[]FileInfo, currentIndex int, checksumDuplicates bool, sizeTimeIndex map[fileSizeTime][]int) bool {
 	currentFile := &(*files)[currentIndex]
 	key := fileSizeTime{Size: currentFile.Size, Timestamp: currentFile.CreationDateTime}
 
@@ -311,24 +406,16 @@ func isDuplicateInPreviousFiles(files *[]FileInfo, currentIndex int, checksumDup
 	}
 
 	// Calculate current file checksum if needed
-	if currentFile.SourceChecksum == "" {
-		checksum, err := calculateXXHash(filepath.Join(currentFile.SourceDir, currentFile.SourceName))
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to calculate checksum for %s: %v\n", filepath.Join(currentFile.SourceDir, currentFile.SourceName), err)
-			return false
-		}
-		currentFile.SourceChecksum = checksum
+	if _, err := sourceChecksum(src, currentFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to calculate checksum for %s: %v\n", sourcePath(*currentFile), err)
+		return false
 	}
 
 	for _, i := range indices {
 		previousFile := &(*files)[i]
-		if previousFile.SourceChecksum == "" {
-			checksum, err := calculateXXHash(filepath.Join(previousFile.SourceDir, previousFile.SourceName))
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Warning: failed to calculate checksum for %s: %v\n", filepath.Join(previousFile.SourceDir, previousFile.SourceName), err)
-				continue
-			}
-			previousFile.SourceChecksum = checksum
+		if _, err := sourceChecksum(src, previousFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to calculate checksum for %s: %v\n", sourcePath(*previousFile), err)
+			continue
 		}
 
 		if currentFile.SourceChecksum == previousFile.SourceChecksum {
@@ -339,50 +426,58 @@ func isDuplicateInPreviousFiles(files *[]FileInfo, currentIndex int, checksumDup
 	return false
 }
 
-func isNameTakenByPreviousFile(files *[]FileInfo, currentIndex int, proposedName string) bool {
-	for i := 0; i < currentIndex; i++ {
-		if (*files)[i].DestDir == (*files)[currentIndex].DestDir && (*files)[i].DestName == proposedName {
+// isNameTakenByPlannedFile returns true if another file is already planned to
+// proposedName in the current file's destination directory.
+func isNameTakenByPlannedFile(files *[]FileInfo, currentIndex int, proposedName string) bool {
+	return isDestinationPlanned(*files, currentIndex, (*files)[currentIndex].DestDir, proposedName)
+}
+
+// isDestinationPlanned returns true if any file other than skipIndex is
+// already planned to destName in destDir.
+func isDestinationPlanned(files []FileInfo, skipIndex int, destDir, destName string) bool {
+	for i := range files {
+		if i != skipIndex && files[i].DestDir == destDir && files[i].DestName == destName {
 			return true
 		}
 	}
 	return false
 }
 
-func exists(destPath string) (bool, error) {
-	_, err := os.Stat(destPath)
+func exists(dest destination, destPath string) (bool, error) {
+	_, err := dest.Stat(destPath)
 	if err == nil {
 		return true, nil
 	}
-	if os.IsNotExist(err) {
+	if errors.Is(err, fs.ErrNotExist) {
 		return false, nil
 	}
 	return false, err
 }
 
-func isDuplicate(file *FileInfo, destPath string, checksumDuplicates bool) (bool, error) {
-	destInfo, err := os.Stat(destPath)
+func isDuplicate(src mediaSource, dest destination, file *FileInfo, destPath string, checksumDuplicates bool) (bool, error) {
+	destInfo, err := dest.Stat(destPath)
 	if err != nil {
-		if os.IsNotExist(err) {
+		if errors.Is(err, fs.ErrNotExist) {
 			return false, nil
 		}
 		return false, fmt.Errorf("error checking destination file %s: %w", destPath, err)
 	}
 
-	if destInfo.Size() != file.Size {
+	size, err := destinationSize(src, file)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", sourcePath(*file), err)
+	}
+	if destInfo.Size() != size {
 		return false, nil
 	}
 
 	if checksumDuplicates {
-		srcChecksum := file.SourceChecksum
-		if srcChecksum == "" {
-			srcChecksum, err = calculateXXHash(filepath.Join(file.SourceDir, file.SourceName))
-			if err != nil {
-				return false, fmt.Errorf("failed to calculate checksum for %s: %w", filepath.Join(file.SourceDir, file.SourceName), err)
-			}
-			file.SourceChecksum = srcChecksum
+		srcChecksum, err := destinationChecksum(src, file)
+		if err != nil {
+			return false, fmt.Errorf("failed to calculate checksum for %s: %w", sourcePath(*file), err)
 		}
 
-		destChecksum, err := calculateXXHash(destPath)
+		destChecksum, err := dest.Hash(destPath)
 		if err != nil {
 			return false, fmt.Errorf("failed to calculate checksum for %s: %w", destPath, err)
 		}
@@ -394,18 +489,28 @@ func isDuplicate(file *FileInfo, destPath string, checksumDuplicates bool) (bool
 }
 
 func calculateXXHash(filepath string) (string, error) {
+	digest := xxhash.New()
+	if err := hashFile(filepath, digest); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%016x", digest.Sum64()), nil
+}
+
+// hashFile reads the file once and feeds it to every hash.
+func hashFile(filepath string, hashes ...hash.Hash) error {
 	file, err := os.Open(filepath)
 	if err != nil {
-		return "", err
+		return err
 	}
 	defer func() { _ = file.Close() }()
 
-	hash := xxhash.New()
-	if _, err := io.Copy(hash, file); err != nil {
-		return "", err
+	writers := make([]io.Writer, len(hashes))
+	for i, h := range hashes {
+		writers[i] = h
 	}
-
-	return fmt.Sprintf("%016x", hash.Sum64()), nil
+	_, err = io.Copy(io.MultiWriter(writers...), file)
+	return err
 }
 
 func setFileTimes(path string, modTime time.Time) error {
@@ -416,35 +521,60 @@ func setFileTimes(path string, modTime time.Time) error {
 	return nil
 }
 
-func copyFile(src, dst string) error {
-	sourceFile, err := os.Open(src)
+// copyFile copies srcPath on the source to dstPath on the destination. Unless
+// the destination stores files atomically, the data is written under a hidden
+// temporary name and renamed into place, so an interrupted copy never leaves
+// a partial file under the final name.
+func copyFile(src mediaSource, dest destination, srcPath, dstPath string) error {
+	sourceInfo, err := src.Stat(srcPath)
 	if err != nil {
 		return err
 	}
-	defer func() { _ = sourceFile.Close() }()
-
-	sourceInfo, err := sourceFile.Stat()
+	sourceFile, err := src.Open(srcPath)
 	if err != nil {
 		return err
 	}
+	defer func() { _ = sourceFile.Close() }()
+
+	writePath := dstPath
+	if creator, ok := dest.(atomicCreator); !ok || !creator.createsAtomically() {
+		writePath = filepath.Join(filepath.Dir(dstPath), "."+filepath.Base(dstPath)+".gomediaimport-partial")
+	}
 
-	destFile, err := os.Create(dst)
+	destFile, err := dest.Create(writePath)
 	if err != nil {
 		return err
 	}
 
 	written, err := io.Copy(destFile, sourceFile)
+	if err == nil && written != sourceInfo.Size() {
+		err = fmt.Errorf("incomplete copy: wrote %d of %d bytes", written, sourceInfo.Size())
+	}
 	if err != nil {
-		_ = destFile.Close()
+		if a, ok := destFile.(aborter); ok {
+			_ = a.Abort()
+		} else {
+			_ = destFile.Close()
+		}
+		if writePath != dstPath {
+			_ = dest.Remove(writePath)
+		}
 		return err
 	}
 
-	if written != sourceInfo.Size() {
-		_ = destFile.Close()
-		return fmt.Errorf("incomplete copy: wrote %d of %d bytes", written, sourceInfo.Size())
+	if err := destFile.Close(); err != nil {
+		if writePath != dstPath {
+			_ = dest.Remove(writePath)
+		}
+		return err
 	}
-
-	return destFile.Close()
+	if writePath != dstPath {
+		if err := dest.Rename(writePath, dstPath); err != nil {
+			_ = dest.Remove(writePath)
+			return err
+		}
+	}
+	return nil
 }
 
 // ejectDrive ejects the specified drive using platform-appropriate tools.
@@ -511,13 +641,17 @@ func availableDiskSpace(path string) (uint64, error) {
 }
 
 // checkDiskSpace verifies there is enough free space on the destination
-// filesystem for the given totalBytes. Returns nil if sufficient, or an error
-// describing the shortfall.
-func checkDiskSpace(destDir string, totalBytes int64) error {
+// filesystem for the given totalBytes. Returns nil if sufficient or if the
+// destination does not report free space, or an error describing the
+// shortfall.
+func checkDiskSpace(dest destination, destDir string, totalBytes int64) error {
 	if totalBytes <= 0 {
 		return nil
 	}
-	available, err := availableDiskSpace(destDir)
+	available, err := dest.FreeSpace(destDir)
+	if errors.Is(err, errFreeSpaceUnknown) {
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("unable to check disk space: %w", err)
 	}
@@ -218,7 +218,7 @@ func TestIsDuplicate(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			// Reset checksum so each subtest recalculates
 			fileInfo.SourceChecksum = ""
-			result, err := isDuplicate(fileInfo, tt.destPath, tt.checksumDuplicates)
+			result, err := isDuplicate(localSource{}, localDestination{}, fileInfo, tt.destPath, tt.checksumDuplicates)
 			if tt.expectErr && err == nil {
 				t.Error("expected error, got nil")
 			}
@@ -248,7 +248,7 @@ func TestIsDuplicate(t *testing.T) {
 		defer func() { _ = os.Chmod(unreadableDir, 0755) }()
 
 		fileInfo.SourceChecksum = ""
-		_, err := isDuplicate(fileInfo, unreadableFile, true)
+		_, err := isDuplicate(localSource{}, localDestination{}, fileInfo, unreadableFile, true)
 		if err == nil {
 			t.Error("expected error for inaccessible file, got nil")
 		}
@@ -345,7 +345,7 @@ func TestIsDuplicateInPreviousFiles(t *testing.T) {
 		{Size: int64(len(content)), Timestamp: now}: {0},
 	}
 
-	result := isDuplicateInPreviousFiles(&files, 1, false, sizeTimeIndex)
+	result := isDuplicateInPreviousFiles(localSource{}, &files, 1, false, sizeTimeIndex)
 	if !result {
 		t.Error("Expected duplicate without checksum (same size+time), got false")
 	}
@@ -353,7 +353,7 @@ func TestIsDuplicateInPreviousFiles(t *testing.T) {
 	// Test with checksum-enabled path — matching content
 	files[0].SourceChecksum = ""
 	files[1].SourceChecksum = ""
-	result = isDuplicateInPreviousFiles(&files, 1, true, sizeTimeIndex)
+	result = isDuplicateInPreviousFiles(localSource{}, &files, 1, true, sizeTimeIndex)
 	if !result {
 		t.Error("Expected duplicate with checksum (same content), got false")
 	}
@@ -368,14 +368,14 @@ func TestIsDuplicateInPreviousFiles(t *testing.T) {
 		{Size: int64(len(differentContent)), Timestamp: now}: {0}, // deliberately share index entry
 	}
 
-	result = isDuplicateInPreviousFiles(&files2, 1, true, sizeTimeIndex2)
+	result = isDuplicateInPreviousFiles(localSource{}, &files2, 1, true, sizeTimeIndex2)
 	if result {
 		t.Error("Expected no duplicate with checksum (different content), got true")
 	}
 
 	// Test with no matching index entry
 	emptyIndex := map[fileSizeTime][]int{}
-	result = isDuplicateInPreviousFiles(&files, 1, false, emptyIndex)
+	result = isDuplicateInPreviousFiles(localSource{}, &files, 1, false, emptyIndex)
 	if result {
 		t.Error("Expected no duplicate with empty index, got true")
 	}
@@ -527,7 +527,7 @@ func TestEnumerateFilesWithSidecars(t *testing.T) {
 	})
 }
 
-func TestIsNameTakenByPreviousFile(t *testing.T) {
+func TestIsNameTakenByPlannedFile(t *testing.T) {
 	files := []FileInfo{
 		{DestDir: "/dest", DestName: "IMG_001.jpg"},
 		{DestDir: "/dest", DestName: "IMG_002.jpg"},
@@ -550,9 +550,9 @@ func TestIsNameTakenByPreviousFile(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := isNameTakenByPreviousFile(&files, tt.currentIndex, tt.proposedName)
+			result := isNameTakenByPlannedFile(&files, tt.currentIndex, tt.proposedName)
 			if result != tt.expected {
-				t.Errorf("isNameTakenByPreviousFile(index=%d, name=%q) = %v, want %v",
+				t.Errorf("isNameTakenByPlannedFile(index=%d, name=%q) = %v, want %v",
 					tt.currentIndex, tt.proposedName, result, tt.expected)
 			}
 		})
@@ -909,18 +909,18 @@ func TestCheckDiskSpace(t *testing.T) {
 	defer os.RemoveAll(tmpDir)
 
 	// 0 bytes requirement is always met
-	if err := checkDiskSpace(tmpDir, 0); err != nil {
+	if err := checkDiskSpace(localDestination{}, tmpDir, 0); err != nil {
 		t.Errorf("expected no error for 0 bytes, got %v", err)
 	}
 
 	// A very small requirement is practically met
-	if err := checkDiskSpace(tmpDir, 10); err != nil {
+	if err := checkDiskSpace(localDestination{}, tmpDir, 10); err != nil {
 		t.Errorf("expected no error for 10 bytes, got %v", err)
 	}
 
 	// A huge requirement should result in insufficient disk space error
 	hugeSize := int64(1 << 62) // Unrealistic Exabyte size
-	err = checkDiskSpace(tmpDir, hugeSize)
+	err = checkDiskSpace(localDestination{}, tmpDir, hugeSize)
 	if err == nil {
 		t.Error("expected insufficient disk space error for impossibly huge size requirement")
 	}
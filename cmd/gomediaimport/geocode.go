@@ -0,0 +1,272 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// cityPopulation is the population from which a GeoNames place counts as a
+// city for the {city} token. It matches the smallest places in GeoNames'
+// cities15000 dataset.
+const cityPopulation = 15000
+
+// placeInfo names the place a file was captured at
+type placeInfo struct {
+	Country string // Country name, or its ISO code if the name is unknown
+	City    string // Nearest place with at least cityPopulation inhabitants
+	Place   string // Nearest place of any size
+}
+
+// geoPlace is a populated place of the GeoNames dataset
+type geoPlace struct {
+	Name        string
+	CountryCode string
+	Population  int
+	Latitude    float64
+	Longitude   float64
+}
+
+// placeIndex finds the nearest places to a position. Positions are compared
+// as points on the unit sphere, so the straight-line distance between them
+// orders places like the great-circle distance and needs no special case at
+// the poles or the antimeridian.
+type placeIndex struct {
+	Places []geoPlace
+	all    *kdNode
+	cities *kdNode
+}
+
+// kdNode is a node of a 3-d tree of places
+type kdNode struct {
+	Point       [3]float64
+	Place       int
+	Axis        int
+	Left, Right *kdNode
+}
+
+func unitVector(latitude, longitude float64) [3]float64 {
+	lat := latitude * math.Pi / 180
+	lon := longitude * math.Pi / 180
+	return [3]float64{math.Cos(lat) * math.Cos(lon), math.Cos(lat) * math.Sin(lon), math.Sin(lat)}
+}
+
+type kdPoint struct {
+	Point [3]float64
+	Place int
+}
+
+// buildKDTree builds a balanced tree by splitting on the median of each axis
+// in turn. It reorders points.
+func buildKDTree(points []kdPoint, depth int) *kdNode {
+	if len(points) == 0 {
+		return nil
+	}
+	axis := depth % 3
+	sort.Slice(points, func(i, j int) bool { return points[i].Point[axis] < points[j].Point[axis] })
+	median := len(points) / 2
+	return &kdNode{
+		Point: points[median].Point,
+		Place: points[median].Place,
+		Axis:  axis,
+		Left:  buildKDTree(points[:median], depth+1),
+		Right: buildKDTree(points[median+1:], depth+1),
+	}
+}
+
+func squaredDistance(a, b [3]float64) float64 {
+	dx, dy, dz := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return dx*dx + dy*dy + dz*dz
+}
+
+// nearest returns the place closest to target, or -1 for an empty tree
+func (n *kdNode) nearest(target [3]float64) int {
+	best, bestDistance := -1, math.Inf(1)
+	var search func(*kdNode)
+	search = func(n *kdNode) {
+		if n == nil {
+			return
+		}
+		if d := squaredDistance(n.Point, target); d < bestDistance {
+			best, bestDistance = n.Place, d
+		}
+		diff := target[n.Axis] - n.Point[n.Axis]
+		near, far := n.Left, n.Right
+		if diff > 0 {
+			near, far = n.Right, n.Left
+		}
+		search(near)
+		// The other side can only hold a closer place if the splitting
+		// plane is closer than the best place so far
+		if diff*diff < bestDistance {
+			search(far)
+		}
+	}
+	search(n)
+	return best
+}
+
+func newPlaceIndex(places []geoPlace) *placeIndex {
+	var all, cities []kdPoint
+	for i, place := range places {
+		point := kdPoint{Point: unitVector(place.Latitude, place.Longitude), Place: i}
+		all = append(all, point)
+		if place.Population >= cityPopulation {
+			cities = append(cities, point)
+		}
+	}
+	return &placeIndex{Places: places, all: buildKDTree(all, 0), cities: buildKDTree(cities, 0)}
+}
+
+// lookup returns the places nearest to a position. When the dataset has no
+// city-sized places, the nearest place also names the city.
+func (idx *placeIndex) lookup(latitude, longitude float64) (placeInfo, bool) {
+	target := unitVector(latitude, longitude)
+	nearest := idx.all.nearest(target)
+	if nearest < 0 {
+		return placeInfo{}, false
+	}
+	city := idx.cities.nearest(target)
+	if city < 0 {
+		city = nearest
+	}
+	place := idx.Places[nearest]
+	return placeInfo{
+		Country: countryName(place.CountryCode),
+		City:    idx.Places[city].Name,
+		Place:   place.Name,
+	}, true
+}
+
+// parseGeoNamesCities reads a GeoNames dump in its tab-separated format:
+// geonameid, name, asciiname, alternatenames, latitude, longitude, feature
+// class, feature code, country code, cc2, four admin codes, population, and
+// more columns that are not used.
+func parseGeoNamesCities(r io.Reader) ([]geoPlace, error) {
+	var places []geoPlace
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		if len(fields) < 15 {
+			return nil, fmt.Errorf("line %d: expected at least 15 tab-separated fields, got %d", line, len(fields))
+		}
+		latitude, err := strconv.ParseFloat(fields[4], 64)
+		if err != nil || latitude < -90 || latitude > 90 {
+			return nil, fmt.Errorf("line %d: invalid latitude %q", line, fields[4])
+		}
+		longitude, err := strconv.ParseFloat(fields[5], 64)
+		if err != nil || longitude < -180 || longitude > 180 {
+			return nil, fmt.Errorf("line %d: invalid longitude %q", line, fields[5])
+		}
+		population, _ := strconv.Atoi(fields[14])
+		places = append(places, geoPlace{
+			Name:        fields[1],
+			CountryCode: fields[8],
+			Population:  population,
+			Latitude:    latitude,
+			Longitude:   longitude,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return places, nil
+}
+
+// loadPlaceIndex reads a GeoNames cities file, either as the .txt dump or
+// as the .zip GeoNames distributes it in
+func loadPlaceIndex(path string) (*placeIndex, error) {
+	var places []geoPlace
+	if strings.EqualFold(filepath.Ext(path), ".zip") {
+		archive, err := zip.OpenReader(path)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = archive.Close() }()
+		var found bool
+		for _, member := range archive.File {
+			if !strings.EqualFold(filepath.Ext(member.Name), ".txt") || strings.EqualFold(filepath.Base(member.Name), "readme.txt") {
+				continue
+			}
+			f, err := member.Open()
+			if err != nil {
+				return nil, err
+			}
+			places, err = parseGeoNamesCities(f)
+			_ = f.Close()
+			if err != nil {
+				return nil, fmt.Errorf("%s in %s: %w", member.Name, path, err)
+			}
+			found = true
+			break
+		}
+		if !found {
+			return nil, fmt.Errorf("no GeoNames dump in %s", path)
+		}
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = f.Close() }()
+		if places, err = parseGeoNamesCities(f); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if len(places) == 0 {
+		return nil, fmt.Errorf("no places in %s", path)
+	}
+	return newPlaceIndex(places), nil
+}
+
+// resolvePlaces names the place of every file with a position, from its own
+// metadata or a GPX track
+func resolvePlaces(files []FileInfo, idx *placeIndex) {
+	for i := range files {
+		latitude, longitude, ok := filePosition(files[i])
+		if !ok {
+			continue
+		}
+		if place, ok := idx.lookup(latitude, longitude); ok {
+			files[i].Place = &place
+		}
+	}
+}
+
+// printPlaces reports how many files were placed and in which places
+func printPlaces(files []FileInfo) {
+	counts := make(map[string]int)
+	var placed, candidates int
+	for _, file := range files {
+		switch file.MediaCategory {
+		case ProcessedPicture, RawPicture, Video:
+			candidates++
+		}
+		if file.Place == nil {
+			continue
+		}
+		placed++
+		counts[file.Place.City+", "+file.Place.Country]++
+	}
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("Place: %s (%d files)\n", name, counts[name])
+	}
+	fmt.Printf("Files with a known place: %d of %d\n", placed, candidates)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"archive/zip"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testGeoNames is a GeoNames cities excerpt: geonameid, name, asciiname,
+// alternatenames, latitude, longitude, feature class and code, country code,
+// cc2, four admin codes, population, elevation, dem, timezone, and date
+const testGeoNames = `658225	Helsinki	Helsinki		60.16952	24.93545	P	PPLC	FI		01	091			558457		26	Europe/Helsinki	2024-01-01
+660158	Espoo	Espoo		60.2052	24.6522	P	PPLA3	FI		01	049			256760		15	Europe/Helsinki	2024-01-01
+653281	Kauniainen	Kauniainen		60.21209	24.72756	P	PPLA3	FI		01	235			9486		30	Europe/Helsinki	2024-01-01
+2193733	Auckland	Auckland		-36.84853	174.76349	P	PPLA	NZ		E7				417910		26	Pacific/Auckland	2024-01-01
+4031574	Suva	Suva		-18.14161	178.44149	P	PPLC	FJ		01				77366		10	Pacific/Fiji	2024-01-01
+4033936	Matei	Matei		-16.6868	-179.8819	P	PPL	FJ		03				1000		10	Pacific/Fiji	2024-01-01
+`
+
+func testPlaceIndex(t *testing.T) *placeIndex {
+	t.Helper()
+	places, err := parseGeoNamesCities(strings.NewReader(testGeoNames))
+	if err != nil {
+		t.Fatalf("parseGeoNamesCities failed: %v", err)
+	}
+	return newPlaceIndex(places)
+}
+
+func TestParseGeoNamesCities(t *testing.T) {
+	places, err := parseGeoNamesCities(strings.NewReader(testGeoNames))
+	if err != nil {
+		t.Fatalf("parseGeoNamesCities failed: %v", err)
+	}
+	if len(places) != 6 {
+		t.Fatalf("got %d places, want 6", len(places))
+	}
+	want := geoPlace{Name: "Helsinki", CountryCode: "FI", Population: 558457, Latitude: 60.16952, Longitude: 24.93545}
+	if places[0] != want {
+		t.Errorf("places[0] = %+v, want %+v", places[0], want)
+	}
+
+	for _, input := range []string{
+		"658225\tHelsinki\tHelsinki\n",
+		strings.Replace(testGeoNames, "60.16952", "north", 1),
+		strings.Replace(testGeoNames, "24.93545", "190", 1),
+	} {
+		if _, err := parseGeoNamesCities(strings.NewReader(input)); err == nil {
+			t.Errorf("expected an error for %q", strings.SplitN(input, "\n", 2)[0])
+		}
+	}
+}
+
+func TestPlaceIndexLookup(t *testing.T) {
+	idx := testPlaceIndex(t)
+	tests := []struct {
+		name      string
+		lat, long float64
+		want      placeInfo
+	}{
+		{"capital", 60.17, 24.94, placeInfo{Country: "Finland", City: "Helsinki", Place: "Helsinki"}},
+		// Kauniainen is nearest, but too small to be the city
+		{"small town", 60.211, 24.725, placeInfo{Country: "Finland", City: "Espoo", Place: "Kauniainen"}},
+		// Matei lies just across the antimeridian from the position
+		{"antimeridian", -16.69, 179.99, placeInfo{Country: "Fiji", City: "Suva", Place: "Matei"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := idx.lookup(tt.lat, tt.long)
+			if !ok || got != tt.want {
+				t.Errorf("lookup(%v, %v) = %+v, %v, want %+v", tt.lat, tt.long, got, ok, tt.want)
+			}
+		})
+	}
+
+	if _, ok := newPlaceIndex(nil).lookup(0, 0); ok {
+		t.Error("lookup in an empty index succeeded")
+	}
+}
+
+func TestKDTreeNearestMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	randomPosition := func() (float64, float64) {
+		return math.Asin(2*rng.Float64()-1) * 180 / math.Pi, rng.Float64()*360 - 180
+	}
+	places := make([]geoPlace, 500)
+	for i := range places {
+		places[i].Latitude, places[i].Longitude = randomPosition()
+	}
+	idx := newPlaceIndex(places)
+	for range 200 {
+		target := unitVector(randomPosition())
+		want := -1
+		for i, place := range places {
+			if want < 0 || squaredDistance(unitVector(place.Latitude, place.Longitude), target) < squaredDistance(unitVector(places[want].Latitude, places[want].Longitude), target) {
+				want = i
+			}
+		}
+		if got := idx.all.nearest(target); got != want {
+			t.Fatalf("nearest = %d, want %d", got, want)
+		}
+	}
+}
+
+func TestLoadPlaceIndex(t *testing.T) {
+	dir := t.TempDir()
+	txt := filepath.Join(dir, "cities15000.txt")
+	if err := os.WriteFile(txt, []byte(testGeoNames), 0644); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(dir, "cities15000.zip")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(f)
+	for name, content := range map[string]string{"readme.txt": "not a dump", "cities15000.txt": testGeoNames} {
+		member, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := member.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{txt, archive} {
+		idx, err := loadPlaceIndex(path)
+		if err != nil {
+			t.Fatalf("loadPlaceIndex(%s) failed: %v", filepath.Base(path), err)
+		}
+		if len(idx.Places) != 6 {
+			t.Errorf("loadPlaceIndex(%s) loaded %d places, want 6", filepath.Base(path), len(idx.Places))
+		}
+	}
+
+	empty := filepath.Join(dir, "empty.txt")
+	if err := os.WriteFile(empty, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadPlaceIndex(empty); err == nil {
+		t.Error("expected an error for a dataset without places")
+	}
+}
+
+func TestResolvePlaces(t *testing.T) {
+	lat, long := 60.17, 24.94
+	files := []FileInfo{
+		{SourceName: "a.jpg", MediaCategory: ProcessedPicture, ImageMetadata: &ImageMetadata{GPSLatitude: &lat, GPSLongitude: &long}},
+		{SourceName: "b.mp4", MediaCategory: Video, VideoMetadata: &VideoMetadata{GPSLatitude: &lat, GPSLongitude: &long}},
+		{SourceName: "c.jpg", MediaCategory: ProcessedPicture},
+	}
+	resolvePlaces(files, testPlaceIndex(t))
+	for _, file := range files[:2] {
+		if file.Place == nil || file.Place.City != "Helsinki" {
+			t.Errorf("%s place = %+v, want Helsinki", file.SourceName, file.Place)
+		}
+	}
+	if files[2].Place != nil {
+		t.Errorf("c.jpg place = %+v, want none without a position", files[2].Place)
+	}
+}
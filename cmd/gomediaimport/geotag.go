@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// GPXTarget defines where coordinates matched from GPX tracks are written
+type GPXTarget string
+
+const (
+	GPXTargetEXIF GPXTarget = "exif"
+	GPXTargetXMP  GPXTarget = "xmp"
+)
+
+// defaultGPXMaxGap is the longest time between a file and the track points
+// its position is taken from
+const defaultGPXMaxGap = 5 * time.Minute
+
+// isValidGPXTarget returns true if the target is a valid GPXTarget value.
+// Empty is valid and means the default.
+func isValidGPXTarget(target GPXTarget) bool {
+	switch target {
+	case "", GPXTargetEXIF, GPXTargetXMP:
+		return true
+	default:
+		return false
+	}
+}
+
+// effectiveGPXTarget returns the target to apply, defaulting to exif.
+func effectiveGPXTarget(target GPXTarget) GPXTarget {
+	if target == "" {
+		return GPXTargetEXIF
+	}
+	return target
+}
+
+// effectiveGPXMaxGap returns the gap to apply, defaulting to 5 minutes.
+func effectiveGPXMaxGap(gap time.Duration) time.Duration {
+	if gap <= 0 {
+		return defaultGPXMaxGap
+	}
+	return gap
+}
+
+// geotaggingEnabled returns true if GPX tracks are configured
+func geotaggingEnabled(cfg config) bool {
+	return len(cfg.GPX) > 0 || cfg.GPXDirectory != ""
+}
+
+// gpxPoint is a timed track point
+type gpxPoint struct {
+	Time      time.Time
+	Latitude  float64
+	Longitude float64
+	Elevation *float64
+}
+
+// gpxTracks holds the track segments of every loaded GPX file, each sorted
+// by time. Positions are only interpolated within a segment, since a logger
+// starts a new one when it loses its fix or is switched off.
+type gpxTracks [][]gpxPoint
+
+// gpxFile is the part of a GPX 1.0 or 1.1 document that is read
+type gpxFile struct {
+	Tracks []struct {
+		Segments []struct {
+			Points []struct {
+				Latitude  float64  `xml:"lat,attr"`
+				Longitude float64  `xml:"lon,attr"`
+				Elevation *float64 `xml:"ele"`
+				Time      string   `xml:"time"`
+			} `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+// parseGPX returns the track segments of a GPX document. Points without a
+// time cannot be matched to files and are dropped.
+func parseGPX(r io.Reader) (gpxTracks, error) {
+	var doc gpxFile
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+	var tracks gpxTracks
+	for _, track := range doc.Tracks {
+		for _, segment := range track.Segments {
+			var points []gpxPoint
+			for _, p := range segment.Points {
+				if p.Time == "" {
+					continue
+				}
+				t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(p.Time))
+				if err != nil {
+					return nil, fmt.Errorf("invalid track point time %q", p.Time)
+				}
+				if p.Latitude < -90 || p.Latitude > 90 || p.Longitude < -180 || p.Longitude > 180 {
+					return nil, fmt.Errorf("invalid track point position %v, %v", p.Latitude, p.Longitude)
+				}
+				points = append(points, gpxPoint{Time: t, Latitude: p.Latitude, Longitude: p.Longitude, Elevation: p.Elevation})
+			}
+			if len(points) == 0 {
+				continue
+			}
+			sort.SliceStable(points, func(i, j int) bool { return points[i].Time.Before(points[j].Time) })
+			tracks = append(tracks, points)
+		}
+	}
+	return tracks, nil
+}
+
+// gpxPaths returns the GPX files to load: the configured files and every
+// .gpx file in the GPX directory
+func gpxPaths(cfg config) ([]string, error) {
+	paths := append([]string(nil), cfg.GPX...)
+	if cfg.GPXDirectory != "" {
+		entries, err := os.ReadDir(cfg.GPXDirectory)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read GPX directory: %w", err)
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() && strings.EqualFold(filepath.Ext(entry.Name()), ".gpx") {
+				paths = append(paths, filepath.Join(cfg.GPXDirectory, entry.Name()))
+			}
+		}
+	}
+	return paths, nil
+}
+
+// loadGPXTracks reads the track segments of the GPX files at paths
+func loadGPXTracks(paths []string) (gpxTracks, error) {
+	var tracks gpxTracks
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		segments, err := parseGPX(f)
+		_ = f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		tracks = append(tracks, segments...)
+	}
+	return tracks, nil
+}
+
+// locate returns the position at time t. Between two points of a segment
+// that are at most maxGap apart the position is interpolated; otherwise the
+// nearest point is used if it is at most maxGap away from t. When several
+// segments cover t, the one with the closest points wins.
+func (tracks gpxTracks) locate(t time.Time, maxGap time.Duration) (gpsLocation, bool) {
+	var best gpsLocation
+	bestDistance := time.Duration(-1)
+	for _, points := range tracks {
+		i := sort.Search(len(points), func(i int) bool { return !points[i].Time.Before(t) })
+		var location gpsLocation
+		var distance time.Duration
+		switch {
+		case i < len(points) && points[i].Time.Equal(t):
+			location, distance = points[i].location(), 0
+		case i > 0 && i < len(points) && points[i].Time.Sub(points[i-1].Time) <= maxGap:
+			before, after := points[i-1], points[i]
+			location = interpolateGPXPoints(before, after, t)
+			distance = min(t.Sub(before.Time), after.Time.Sub(t))
+		default:
+			nearest := -1
+			if i > 0 {
+				nearest, distance = i-1, t.Sub(points[i-1].Time)
+			}
+			if i < len(points) && (nearest < 0 || points[i].Time.Sub(t) < distance) {
+				nearest, distance = i, points[i].Time.Sub(t)
+			}
+			if nearest < 0 || distance > maxGap {
+				continue
+			}
+			location = points[nearest].location()
+		}
+		if bestDistance < 0 || distance < bestDistance {
+			best, bestDistance = location, distance
+		}
+	}
+	return best, bestDistance >= 0
+}
+
+func (p gpxPoint) location() gpsLocation {
+	return gpsLocation{Latitude: p.Latitude, Longitude: p.Longitude, Altitude: p.Elevation}
+}
+
+// interpolateGPXPoints returns the position at t on the straight line between
+// two track points. Points are close enough in time for the curvature of the
+// earth not to matter, but a segment crossing the antimeridian is taken the
+// short way around.
+func interpolateGPXPoints(a, b gpxPoint, t time.Time) gpsLocation {
+	f := float64(t.Sub(a.Time)) / float64(b.Time.Sub(a.Time))
+	lonDelta := b.Longitude - a.Longitude
+	if lonDelta > 180 {
+		lonDelta -= 360
+	} else if lonDelta < -180 {
+		lonDelta += 360
+	}
+	longitude := a.Longitude + f*lonDelta
+	if longitude > 180 {
+		longitude -= 360
+	} else if longitude < -180 {
+		longitude += 360
+	}
+	location := gpsLocation{
+		Latitude:  a.Latitude + f*(b.Latitude-a.Latitude),
+		Longitude: longitude,
+	}
+	if a.Elevation != nil && b.Elevation != nil {
+		elevation := *a.Elevation + f*(*b.Elevation-*a.Elevation)
+		location.Altitude = &elevation
+	}
+	return location
+}
+
+// filePosition returns the position recorded in a file's metadata
+func filePosition(file FileInfo) (latitude, longitude float64, ok bool) {
+	switch {
+	case file.ImageMetadata != nil && file.ImageMetadata.GPSLatitude != nil && file.ImageMetadata.GPSLongitude != nil:
+		return *file.ImageMetadata.GPSLatitude, *file.ImageMetadata.GPSLongitude, true
+	case file.VideoMetadata != nil && file.VideoMetadata.GPSLatitude != nil && file.VideoMetadata.GPSLongitude != nil:
+		return *file.VideoMetadata.GPSLatitude, *file.VideoMetadata.GPSLongitude, true
+	}
+	return 0, 0, false
+}
+
+// geotagFiles matches the pictures and videos that have no position of their
+// own against the tracks by their capture time, which already includes any
+// clock offset. Files dated by their file time are skipped, since that time
+// is not reliably the capture time. Matched files get the position in their
+// metadata fields and, for the exif target, as a metadata edit of their copy.
+// Copies that cannot be edited get the position in their XMP sidecar instead.
+// It returns the number of matched files whose position is written nowhere
+// because neither is possible.
+func geotagFiles(files []FileInfo, tracks gpxTracks, cfg config) int {
+	maxGap := effectiveGPXMaxGap(cfg.GPXMaxGap)
+	embed := effectiveGPXTarget(cfg.GPXTarget) == GPXTargetEXIF
+	unwritten := 0
+	for i := range files {
+		file := &files[i]
+		switch file.MediaCategory {
+		case ProcessedPicture, RawPicture, Video:
+		default:
+			continue
+		}
+		if file.TimeIsFallback || file.CreationDateTime.IsZero() {
+			continue
+		}
+		if _, _, ok := filePosition(*file); ok {
+			continue
+		}
+		location, ok := tracks.locate(file.CreationDateTime, maxGap)
+		if !ok {
+			continue
+		}
+		file.GPXLocation = &location
+		latitude, longitude := location.Latitude, location.Longitude
+		if file.MediaCategory == Video {
+			if file.VideoMetadata == nil {
+				file.VideoMetadata = &VideoMetadata{}
+			}
+			file.VideoMetadata.GPSLatitude, file.VideoMetadata.GPSLongitude = &latitude, &longitude
+		} else {
+			if file.ImageMetadata == nil {
+				file.ImageMetadata = &ImageMetadata{}
+			}
+			file.ImageMetadata.GPSLatitude, file.ImageMetadata.GPSLongitude = &latitude, &longitude
+		}
+		if embed && metadataEditFormatFor(*file) != "" {
+			var edits metadataEdits
+			if file.Edits != nil {
+				edits = *file.Edits
+			}
+			edits.GPS = &location
+			file.Edits = &edits
+		}
+		if !gpxPositionWritten(*file, cfg) {
+			unwritten++
+		}
+	}
+	return unwritten
+}
+
+// gpxPositionWritten returns true if the GPX position of a file is written
+// to its copy or its XMP sidecar
+func gpxPositionWritten(file FileInfo, cfg config) bool {
+	return file.GPXLocation != nil && (cfg.XMPSidecars || (file.Edits != nil && file.Edits.GPS != nil))
+}
+
+// printGeotags lists the files that got a position from the GPX tracks and
+// the matched files whose position cannot be written
+func printGeotags(files []FileInfo, cfg config) {
+	var count, candidates int
+	for _, file := range files {
+		switch file.MediaCategory {
+		case ProcessedPicture, RawPicture, Video:
+			candidates++
+		}
+		if file.GPXLocation == nil {
+			continue
+		}
+		if !gpxPositionWritten(file, cfg) {
+			fmt.Printf("Not geotagged (%s metadata cannot be written): %s\n", file.FileType, filepath.Join(file.SourceDir, file.SourceName))
+			continue
+		}
+		count++
+		fmt.Printf("Geotagged: %s at %.6f, %.6f\n", filepath.Join(file.SourceDir, file.SourceName), file.GPXLocation.Latitude, file.GPXLocation.Longitude)
+	}
+	fmt.Printf("Files geotagged from GPX tracks: %d of %d\n", count, candidates)
+}
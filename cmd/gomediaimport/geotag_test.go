@@ -0,0 +1,343 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bep/imagemeta"
+)
+
+// testGPX is a logger track with two segments. The first has a point every
+// minute with one three-minute pause, the second starts after the logger was
+// switched off for an hour.
+const testGPX = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="logger" xmlns="http://www.topografix.com/GPX/1/1">
+ <trk>
+  <name>Day 1</name>
+  <trkseg>
+   <trkpt lat="60.1000" lon="24.9000"><ele>10</ele><time>2024-06-15T10:01:00Z</time></trkpt>
+   <trkpt lat="60.1000" lon="24.9000"><ele>10</ele><time>2024-06-15T10:00:00Z</time></trkpt>
+   <trkpt lat="60.2000" lon="25.0000"><ele>20</ele><time>2024-06-15T10:02:00Z</time></trkpt>
+   <trkpt lat="60.3000" lon="25.1000"><time>2024-06-15T10:05:00Z</time></trkpt>
+   <trkpt lat="89.0000" lon="0.0000"></trkpt>
+  </trkseg>
+  <trkseg>
+   <trkpt lat="61.0000" lon="179.9000"><time>2024-06-15T11:05:00Z</time></trkpt>
+   <trkpt lat="61.0000" lon="-179.9000"><time>2024-06-15T11:06:00Z</time></trkpt>
+  </trkseg>
+ </trk>
+</gpx>`
+
+func testGPXTracks(t *testing.T) gpxTracks {
+	t.Helper()
+	tracks, err := parseGPX(strings.NewReader(testGPX))
+	if err != nil {
+		t.Fatalf("parseGPX failed: %v", err)
+	}
+	return tracks
+}
+
+func TestParseGPX(t *testing.T) {
+	tracks := testGPXTracks(t)
+	if len(tracks) != 2 || len(tracks[0]) != 4 || len(tracks[1]) != 2 {
+		t.Fatalf("parseGPX returned segments of %v points, want [4 2]", []int{len(tracks[0]), len(tracks[len(tracks)-1])})
+	}
+	if !tracks[0][0].Time.Equal(time.Date(2024, 6, 15, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("first point at %v, want the segment sorted by time", tracks[0][0].Time)
+	}
+	if tracks[0][3].Elevation != nil {
+		t.Errorf("point without elevation has elevation %v", *tracks[0][3].Elevation)
+	}
+
+	for name, doc := range map[string]string{
+		"not XML":      "lat,lon,time",
+		"invalid time": `<gpx><trk><trkseg><trkpt lat="1" lon="2"><time>yesterday</time></trkpt></trkseg></trk></gpx>`,
+		"invalid lat":  `<gpx><trk><trkseg><trkpt lat="91" lon="2"><time>2024-06-15T10:00:00Z</time></trkpt></trkseg></trk></gpx>`,
+	} {
+		if _, err := parseGPX(strings.NewReader(doc)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestGPXTracksLocate(t *testing.T) {
+	tracks := testGPXTracks(t)
+	at := func(h, m, s int) time.Time { return time.Date(2024, 6, 15, h, m, s, 0, time.UTC) }
+	tests := []struct {
+		name           string
+		time           time.Time
+		wantOK         bool
+		wantLat        float64
+		wantLong       float64
+		wantAltitude   float64
+		wantNoAltitude bool
+	}{
+		{name: "exact point", time: at(10, 1, 0), wantOK: true, wantLat: 60.1, wantLong: 24.9, wantAltitude: 10},
+		{name: "interpolated", time: at(10, 1, 30), wantOK: true, wantLat: 60.15, wantLong: 24.95, wantAltitude: 15},
+		// The pause is within the default gap; the last point has no elevation
+		{name: "across pause", time: at(10, 3, 0), wantOK: true, wantLat: 60.2 + 0.1/3, wantLong: 25 + 0.1/3, wantNoAltitude: true},
+		{name: "after track end", time: at(10, 9, 0), wantOK: true, wantLat: 60.3, wantLong: 25.1, wantNoAltitude: true},
+		{name: "too long after track end", time: at(10, 11, 0)},
+		{name: "before track start", time: at(9, 50, 0)},
+		{name: "antimeridian", time: at(11, 5, 30), wantOK: true, wantLat: 61, wantLong: 180, wantNoAltitude: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tracks.locate(tt.time, defaultGPXMaxGap)
+			if ok != tt.wantOK {
+				t.Fatalf("locate ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if math.Abs(got.Latitude-tt.wantLat) > 1e-9 || math.Abs(math.Abs(got.Longitude)-math.Abs(tt.wantLong)) > 1e-9 {
+				t.Errorf("locate = %v, %v, want %v, %v", got.Latitude, got.Longitude, tt.wantLat, tt.wantLong)
+			}
+			switch {
+			case tt.wantNoAltitude && got.Altitude != nil:
+				t.Errorf("altitude = %v, want none", *got.Altitude)
+			case !tt.wantNoAltitude && (got.Altitude == nil || math.Abs(*got.Altitude-tt.wantAltitude) > 1e-9):
+				t.Errorf("altitude = %v, want %v", got.Altitude, tt.wantAltitude)
+			}
+		})
+	}
+
+	// With a shorter gap the pause is not interpolated, and the time is too
+	// far from both of its points
+	if _, ok := tracks.locate(at(10, 3, 30), time.Minute); ok {
+		t.Error("locate interpolated across a pause longer than the max gap")
+	}
+}
+
+func TestXMPGPSCoordinate(t *testing.T) {
+	if got := xmpGPSCoordinate(60.1699, 'N', 'S'); got != "60,10.194000N" {
+		t.Errorf("xmpGPSCoordinate(60.1699) = %q, want 60,10.194000N", got)
+	}
+	if got := xmpGPSCoordinate(-24.9384, 'E', 'W'); got != "24,56.304000W" {
+		t.Errorf("xmpGPSCoordinate(-24.9384) = %q, want 24,56.304000W", got)
+	}
+}
+
+func TestMergeXMPPacketGPS(t *testing.T) {
+	card := strings.Replace(lightroomXMP, `xmlns:dc="http://purl.org/dc/elements/1.1/"`,
+		`xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:exif="http://ns.adobe.com/exif/1.0/" exif:GPSLatitude="1,0.000000N"`, 1)
+
+	// Without a GPX position the card's position is kept
+	merged, err := mergeXMPPacket([]byte(card), testProvenance("s"))
+	if err != nil {
+		t.Fatalf("mergeXMPPacket failed: %v", err)
+	}
+	if got := xmpProperties(t, merged)[exifNamespace+"GPSLatitude"]; !reflect.DeepEqual(got, []string{"1,0.000000N"}) {
+		t.Errorf("GPSLatitude = %q, want the card's", got)
+	}
+
+	altitude := -2.5
+	provenance := testProvenance("s")
+	provenance.GPS = &gpsLocation{Latitude: 60.1699, Longitude: 24.9384, Altitude: &altitude}
+	merged, err = mergeXMPPacket([]byte(card), provenance)
+	if err != nil {
+		t.Fatalf("mergeXMPPacket failed: %v", err)
+	}
+	properties := xmpProperties(t, merged)
+	for key, want := range map[string][]string{
+		exifNamespace + "GPSLatitude":    {"60,10.194000N"},
+		exifNamespace + "GPSLongitude":   {"24,56.304000E"},
+		exifNamespace + "GPSAltitude":    {"250/100"},
+		exifNamespace + "GPSAltitudeRef": {"1"},
+	} {
+		if got := properties[key]; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestValidateConfigGPX(t *testing.T) {
+	tmpDir := t.TempDir()
+	base := config{SourceDir: tmpDir, DestDir: filepath.Join(tmpDir, "dest"), SidecarDefault: SidecarDelete, GPX: []string{"track.gpx"}}
+	tests := []struct {
+		name    string
+		modify  func(*config)
+		wantErr string
+	}{
+		{"defaults", func(*config) {}, ""},
+		{"invalid target", func(c *config) { c.GPXTarget = "iptc" }, "invalid GPX target"},
+		{"negative gap", func(c *config) { c.GPXMaxGap = -time.Minute }, "gpx_max_gap"},
+		{"xmp without sidecars", func(c *config) { c.GPXTarget = GPXTargetXMP }, "requires xmp_sidecars"},
+		{"xmp with sidecars", func(c *config) { c.GPXTarget = GPXTargetXMP; c.XMPSidecars = true }, ""},
+		{"exif to remote", func(c *config) { c.DestDir = "s3://media-bucket/photos" }, "remote destination"},
+		{"xmp to remote", func(c *config) {
+			c.DestDir = "s3://media-bucket/photos"
+			c.GPXTarget = GPXTargetXMP
+			c.XMPSidecars = true
+		}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := base
+			tt.modify(&cfg)
+			err := validateCommonConfig(&cfg)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateCommonConfig returned error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateCommonConfig = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApplyRemovableVolumeOverridesGPXDirectory(t *testing.T) {
+	cfg := config{SourceDir: "/media/SOFIA", GPXDirectory: "/tracks"}
+	applyRemovableVolumeOverrides(&cfg, removableVolumeConfig{})
+	if cfg.GPXDirectory != "/tracks" {
+		t.Errorf("GPXDirectory = %q, want the global directory", cfg.GPXDirectory)
+	}
+	applyRemovableVolumeOverrides(&cfg, removableVolumeConfig{GPXDirectory: "GPS"})
+	if want := filepath.Join("/media/SOFIA", "GPS"); cfg.GPXDirectory != want {
+		t.Errorf("GPXDirectory = %q, want %q", cfg.GPXDirectory, want)
+	}
+}
+
+func TestImportMediaGeotagging(t *testing.T) {
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+	gpxDir := t.TempDir()
+
+	// EXIF times are local, the track's are UTC
+	shot := time.Date(2024, 6, 15, 10, 1, 30, 0, time.Local)
+	track := strings.NewReplacer(
+		"2024-06-15T10:00:00Z", shot.Add(-90*time.Second).UTC().Format(time.RFC3339),
+		"2024-06-15T10:01:00Z", shot.Add(-30*time.Second).UTC().Format(time.RFC3339),
+		"2024-06-15T10:02:00Z", shot.Add(30*time.Second).UTC().Format(time.RFC3339),
+	).Replace(testGPX)
+	if err := os.WriteFile(filepath.Join(gpxDir, "day1.GPX"), []byte(track), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeTestJPEGWithEXIF(t, filepath.Join(sourceDir, "IMG_0001.JPG"), testEXIFASCII(exifTagDateTimeOriginal, shot.Format("2006:01:02 15:04:05")))
+	writeTestJPEGWithEXIF(t, filepath.Join(sourceDir, "IMG_0002.JPG"), testEXIFASCII(exifTagDateTimeOriginal, "2023:01:01 12:00:00"))
+
+	cfg := config{
+		SourceDir:      sourceDir,
+		DestDir:        destDir,
+		SidecarDefault: SidecarDelete,
+		XMPSidecars:    true,
+		GPXDirectory:   gpxDir,
+	}
+	if err := importMedia(cfg); err != nil {
+		t.Fatalf("importMedia failed: %v", err)
+	}
+
+	tags, err := decodeImageTags(localSource{}, filepath.Join(destDir, "IMG_0001.JPG"), imagemeta.JPEG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lat, long, err := tags.GetLatLong()
+	if err != nil || math.Abs(lat-60.15) > 1e-5 || math.Abs(long-24.95) > 1e-5 {
+		t.Errorf("GetLatLong = %v, %v, %v, want 60.15, 24.95", lat, long, err)
+	}
+	read := func(name string) map[string][]string {
+		data, err := os.ReadFile(filepath.Join(destDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return xmpProperties(t, data)
+	}
+	if got := read("IMG_0001.xmp")[exifNamespace+"GPSLatitude"]; !reflect.DeepEqual(got, []string{"60,9.000000N"}) {
+		t.Errorf("IMG_0001.xmp GPSLatitude = %q, want 60,9.000000N", got)
+	}
+	if got := read("IMG_0002.xmp")[exifNamespace+"GPSLatitude"]; got != nil {
+		t.Errorf("IMG_0002.xmp GPSLatitude = %q, want none for a picture outside the track", got)
+	}
+	if tags, err := decodeImageTags(localSource{}, filepath.Join(destDir, "IMG_0002.JPG"), imagemeta.JPEG); err != nil || tags.EXIF()["GPSLatitude"].Value != nil {
+		t.Error("picture outside the track was geotagged")
+	}
+}
+
+func TestGeotagFiles(t *testing.T) {
+	tracks := testGPXTracks(t)
+	at := time.Date(2024, 6, 15, 10, 1, 30, 0, time.UTC)
+	lat, long := 1.0, 2.0
+	edits := metadataEdits{Artist: "Sofia Lind"}
+	files := []FileInfo{
+		{SourceName: "IMG_0001.JPG", FileType: JPEG, MediaCategory: ProcessedPicture, CreationDateTime: at, Edits: &edits},
+		{SourceName: "IMG_0002.JPG", FileType: JPEG, MediaCategory: ProcessedPicture, CreationDateTime: at, ImageMetadata: &ImageMetadata{GPSLatitude: &lat, GPSLongitude: &long}},
+		{SourceName: "IMG_0003.JPG", FileType: JPEG, MediaCategory: ProcessedPicture, CreationDateTime: at, TimeIsFallback: true},
+		{SourceName: "IMG_0001.XMP", MediaCategory: Sidecar, CreationDateTime: at},
+		{SourceName: "clip.mp4", FileType: MP4, MediaCategory: Video, CreationDateTime: at, VideoMetadata: &VideoMetadata{}},
+		{SourceName: "IMG_0004.CR2", FileType: RAW, MediaCategory: RawPicture, CreationDateTime: at},
+	}
+	if unwritten := geotagFiles(files, tracks, config{}); unwritten != 1 {
+		t.Errorf("geotagFiles = %d unwritten positions, want 1 for the CR2", unwritten)
+	}
+
+	var geotagged []string
+	for _, file := range files {
+		if file.GPXLocation != nil {
+			geotagged = append(geotagged, file.SourceName)
+		}
+	}
+	if want := []string{"IMG_0001.JPG", "clip.mp4", "IMG_0004.CR2"}; !reflect.DeepEqual(geotagged, want) {
+		t.Fatalf("geotagged %v, want %v", geotagged, want)
+	}
+	if got := files[0].Edits; got == nil || got.Artist != "Sofia Lind" || got.GPS == nil || math.Abs(got.GPS.Latitude-60.15) > 1e-9 {
+		t.Errorf("IMG_0001.JPG edits = %+v, want the artist and the GPX position", got)
+	}
+	if edits.GPS != nil {
+		t.Error("geotagFiles changed the shared edits")
+	}
+	if *files[4].VideoMetadata.GPSLatitude != files[4].GPXLocation.Latitude || *files[5].ImageMetadata.GPSLongitude != files[5].GPXLocation.Longitude {
+		t.Error("geotagFiles did not fill the metadata position fields")
+	}
+	// CR2 copies cannot be edited; their position only goes to XMP sidecars
+	if files[5].Edits != nil {
+		t.Errorf("IMG_0004.CR2 edits = %+v, want none", files[5].Edits)
+	}
+
+	files = []FileInfo{{SourceName: "IMG_0001.JPG", FileType: JPEG, MediaCategory: ProcessedPicture, CreationDateTime: at}}
+	geotagFiles(files, tracks, config{GPXTarget: GPXTargetXMP, XMPSidecars: true})
+	if files[0].GPXLocation == nil || files[0].Edits != nil {
+		t.Error("xmp target should geotag without editing the copy")
+	}
+}
+
+func TestGeotagRawPicture(t *testing.T) {
+	tracks := testGPXTracks(t)
+	at := time.Date(2024, 6, 15, 10, 1, 30, 0, time.UTC)
+	rawFiles := func() []FileInfo {
+		return []FileInfo{{SourceName: "DSC0001.ARW", SourceDir: "/src", FileType: RAW, MediaCategory: RawPicture, CreationDateTime: at}}
+	}
+
+	// The exif target cannot edit an ARW copy, so the match is reported as
+	// not geotagged
+	files := rawFiles()
+	cfg := config{}
+	if unwritten := geotagFiles(files, tracks, cfg); unwritten != 1 {
+		t.Errorf("geotagFiles = %d unwritten positions, want 1", unwritten)
+	}
+	if files[0].Edits != nil {
+		t.Errorf("ARW edits = %+v, want none", files[0].Edits)
+	}
+	out, _ := captureStdout(t, func() error { printGeotags(files, cfg); return nil })
+	if !strings.Contains(out, "Not geotagged") || !strings.Contains(out, "Files geotagged from GPX tracks: 0 of 1") {
+		t.Errorf("printGeotags output:\n%s", out)
+	}
+
+	// With XMP sidecars the position goes to the sidecar instead
+	files = rawFiles()
+	cfg.XMPSidecars = true
+	if unwritten := geotagFiles(files, tracks, cfg); unwritten != 0 {
+		t.Errorf("geotagFiles with XMP sidecars = %d unwritten positions, want 0", unwritten)
+	}
+	out, _ = captureStdout(t, func() error { printGeotags(files, cfg); return nil })
+	if !strings.Contains(out, "Geotagged: /src/DSC0001.ARW") {
+		t.Errorf("printGeotags output with XMP sidecars:\n%s", out)
+	}
+}
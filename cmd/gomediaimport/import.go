@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"sort"
@@ -24,22 +25,49 @@ const (
 	StatusUnnamable               FileStatus = "unnamable"
 	StatusDirectoryCreationFailed FileStatus = "directory creation failed"
 	StatusSidecarDeleted          FileStatus = "sidecar deleted"
+	StatusPairSkipped             FileStatus = "pair member skipped"
+	StatusProxyDeleted            FileStatus = "proxy deleted"
 )
 
+// skipsCopy returns true if a file with this status is not copied to the destination
+func (s FileStatus) skipsCopy() bool {
+	return s == StatusUnnamable || s == StatusPreExisting || s == StatusSidecarDeleted || s == StatusPairSkipped || s == StatusProxyDeleted
+}
+
 // FileInfo represents information about each file being imported
 type FileInfo struct {
 	SourceName       string
 	SourceDir        string
+	SourceVolume     string       // Volume of the source the file is read from
+	CameraFamily     cameraFamily // Camera folder layout the file was found in, "" if none
 	DestName         string
 	DestDir          string
 	SourceChecksum   string
 	CreationDateTime time.Time
+	TimeIsFallback   bool // CreationDateTime is not from the file's metadata
 	VideoMetadata    *VideoMetadata
+	ImageMetadata    *ImageMetadata
+	Sequence         *SequenceInfo // Burst or bracket group membership, nil if N/A
+	Chapter          *ChapterInfo  // Chaptered recording membership, nil if N/A
 	Size             int64
 	MediaCategory    MediaCategory
 	FileType         FileType
+	MetadataParser   metadataParser // Capture time reader of a type defined in the config file, "" for built-in types
+	ExtensionType    FileType       // Type the extension implied when the content differs, "" if N/A
+	ContentExt       string         // Extension matching the content when the name's is wrong or missing, "" if N/A
 	Status           FileStatus
-	ParentIndex      int // Index of parent media file for sidecars, -1 if N/A
+	LibraryPath      string         // Existing library file with identical content, "" if N/A
+	ParentIndex      int            // Index of parent media file for sidecars, -1 if N/A
+	PairIndex        int            // Index of RAW/JPEG sibling, -1 if N/A
+	XMPSidecarName   string         // Provenance XMP sidecar written next to the file, "" if N/A
+	ReviewDir        string         // Folder below the destination chosen in interactive review, "" if N/A
+	Edits            *metadataEdits // Metadata edits for the destination copy, nil if N/A
+	EditedSize       int64          // Size of the destination copy once edited, 0 if not yet known
+	EditedChecksum   string         // Checksum of the destination copy once edited, "" if not yet known
+	MetadataEdited   bool           // The destination copy's metadata was rewritten
+	GPXLocation      *gpsLocation   // Position matched from GPX tracks, nil if N/A
+	Place            *placeInfo     // Place named from the file's position, nil if N/A
+	Event            *EventInfo     // Event the file was clustered into, nil if N/A
 }
 
 // effectiveWorkers returns the number of copy workers to use.
@@ -53,48 +81,245 @@ func effectiveWorkers(workers int) int {
 
 func printConfig(cfg config) {
 	fmt.Println("Source directory:", cfg.SourceDir)
-	fmt.Println("Destination directory:", cfg.DestDir)
+	fmt.Println("Destination directory:", redactDestination(cfg.DestDir))
 	fmt.Println("Organize by date:", cfg.OrganizeByDate)
+	if cfg.DestinationTemplate != "" {
+		fmt.Println("Destination template:", cfg.DestinationTemplate)
+	}
+	if cfg.OrganizeBy != "" {
+		fmt.Println("Organize by:", cfg.OrganizeBy)
+	}
 	fmt.Println("Rename by date and time:", cfg.RenameByDateTime)
 	fmt.Println("Checksum duplicates:", cfg.ChecksumDuplicates)
+	fmt.Println("Library-wide duplicates:", cfg.LibraryDedup)
+	fmt.Println("Checksum manifests:", cfg.ChecksumManifests)
+	fmt.Println("XMP sidecars:", cfg.XMPSidecars)
+	fmt.Println("Contact sheet:", cfg.ContactSheet)
+	fmt.Println("Video posters:", cfg.VideoPosters)
+	if !cfg.MetadataEdits.isZero() {
+		fmt.Println("Metadata edits:", cfg.MetadataEdits.summary())
+	}
+	if geotaggingEnabled(cfg) {
+		sources := append([]string(nil), cfg.GPX...)
+		if cfg.GPXDirectory != "" {
+			sources = append(sources, cfg.GPXDirectory)
+		}
+		fmt.Printf("GPX tracks: %s (max gap %s, written to %s)\n", strings.Join(sources, ", "), effectiveGPXMaxGap(cfg.GPXMaxGap), effectiveGPXTarget(cfg.GPXTarget))
+	}
+	if cfg.ChecksumManifests {
+		fmt.Println("SHA-256 manifests:", cfg.ChecksumSHA256)
+	}
 	fmt.Println("Delete originals:", cfg.DeleteOriginals)
 	fmt.Println("Sidecar default:", cfg.SidecarDefault)
+	fmt.Println("Proxy action:", effectiveProxyAction(cfg.ProxyAction))
+	if len(cfg.MediaTypes.Types) > 0 || len(cfg.MediaTypes.Extensions) > 0 || len(cfg.MediaTypes.Sidecars) > 0 {
+		fmt.Printf("Custom media types: %d types, %d extended types, %d sidecars\n", len(cfg.MediaTypes.Types), len(cfg.MediaTypes.Extensions), len(cfg.MediaTypes.Sidecars))
+	}
+	fmt.Println("RAW+JPEG policy:", effectiveRawJPEGPolicy(cfg.RawJPEGPolicy))
+	fmt.Println("Group bursts and brackets:", cfg.GroupSequences)
+	fmt.Println("Sub-second names:", cfg.RenameSubSeconds)
 	fmt.Println("Copy workers:", effectiveWorkers(cfg.Workers))
 }
 
+// importPlan is a planned import: the files with their destinations and
+// statuses, and the source artifacts to clean up afterwards
+type importPlan struct {
+	Files          []FileInfo
+	CleanupTargets []sourceCleanupTarget
+}
+
 // importMedia handles the main functionality of the program
-func importMedia(cfg config) error {
+func importMedia(cfg config) (err error) {
+	startSession(&cfg, "import started")
+	defer func() { logSessionError(cfg.Log, err) }()
 	if cfg.Verbose {
 		printConfig(cfg)
 	}
 
-	enumeration, err := enumerateFiles(cfg.SourceDir, cfg)
+	closeImport, err := openImport(&cfg)
+	if err != nil {
+		return err
+	}
+	defer closeImport()
+
+	plan, index, err := planImport(cfg)
+	if errors.Is(err, errReviewCancelled) {
+		cfg.Log.Info("import cancelled in review")
+		if !cfg.Quiet {
+			fmt.Println("Import cancelled")
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return executeImport(cfg, plan, index)
+}
+
+// openImport opens the source and destination of an import unless cfg
+// already has them. The returned function closes what was opened.
+func openImport(cfg *config) (func(), error) {
+	var closers []func()
+	closeAll := func() {
+		for _, c := range closers {
+			c()
+		}
+	}
+	if cfg.Destination == nil {
+		dest, destRoot, err := openDestination(cfg.DestDir)
+		if err != nil {
+			return nil, err
+		}
+		closers = append(closers, func() { _ = dest.Close() })
+		cfg.Destination = dest
+		cfg.DestDir = destRoot
+	}
+
+	if cfg.Source == nil {
+		src, err := openSource(cfg.SourceDir)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		closers = append(closers, func() { _ = src.Close() })
+		cfg.Source = src
+	}
+	if cfg.Verbose {
+		fmt.Println("Source volume:", cfg.Source.Volume())
+	}
+	return closeAll, nil
+}
+
+// planImport enumerates the source, reads metadata, and plans where every
+// file goes, with the interactive review if enabled. It returns the library
+// index with library_dedup.
+func planImport(cfg config) (importPlan, *libraryIndex, error) {
+	if cfg.Interactive && !isatty.IsTerminal(os.Stdin.Fd()) && !isatty.IsCygwinTerminal(os.Stdin.Fd()) {
+		return importPlan{}, nil, fmt.Errorf("--interactive requires a terminal")
+	}
+	var enumeration enumerationResult
+	var err error
+	if enumerator, ok := cfg.Source.(sourceEnumerator); ok {
+		enumeration, err = enumerator.enumerate(cfg)
+	} else {
+		enumeration, err = enumerateFiles(cfg.SourceDir, cfg)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to enumerate files: %w", err)
+		return importPlan{}, nil, fmt.Errorf("failed to enumerate files: %w", err)
 	}
 	files := enumeration.Files
 	for i := range files {
 		files[i].ParentIndex = -1
+		files[i].SourceVolume = cfg.Source.Volume()
+	}
+	cfg.logger().Info("enumerated source", "volume", cfg.Source.Volume(), "files", len(files), "source_artifacts", len(enumeration.CleanupTargets))
+	prepareMetadataEdits(files, cfg.MetadataEdits)
+	if geotaggingEnabled(cfg) {
+		paths, err := gpxPaths(cfg)
+		if err != nil {
+			return importPlan{}, nil, err
+		}
+		tracks, err := loadGPXTracks(paths)
+		if err != nil {
+			return importPlan{}, nil, fmt.Errorf("failed to load GPX tracks: %w", err)
+		}
+		if unwritten := geotagFiles(files, tracks, cfg); unwritten > 0 {
+			cfg.logger().Warn("GPX positions cannot be written", "files", unwritten)
+			if !cfg.Quiet {
+				fmt.Fprintf(os.Stderr, "Warning: %d files matched the GPX tracks, but their metadata cannot be written; enable xmp_sidecars to record their positions in sidecars\n", unwritten)
+			}
+		}
+	}
+	if templateUsesLocation(cfg.DestinationTemplate) {
+		places, err := loadPlaceIndex(cfg.GeoNamesCities)
+		if err != nil {
+			return importPlan{}, nil, fmt.Errorf("failed to load GeoNames cities: %w", err)
+		}
+		resolvePlaces(files, places)
+	}
+	var events []*EventInfo
+	if cfg.OrganizeBy == OrganizeModeEvent {
+		events = clusterEvents(files, cfg)
+		if cfg.NameEvents {
+			if err := promptEventNames(events, os.Stdin, os.Stdout); err != nil {
+				return importPlan{}, nil, err
+			}
+		}
+		assignEventFolders(events)
 	}
 
 	if cfg.Verbose {
 		fmt.Printf("Number of files enumerated: %d\n", len(files))
+		printCameraFamilies(files)
+		printContentMismatches(files)
 		printSourceArtifactSummary(enumeration.CleanupTargets, "excluded")
+		if geotaggingEnabled(cfg) {
+			printGeotags(files, cfg)
+		}
+		if templateUsesLocation(cfg.DestinationTemplate) {
+			printPlaces(files)
+		}
+		if cfg.OrganizeBy == OrganizeModeEvent {
+			printEvents(events)
+		}
 	}
 
-	if err := planDestinations(files, cfg); err != nil {
-		return fmt.Errorf("failed to plan destinations: %w", err)
+	var index *libraryIndex
+	if cfg.LibraryDedup {
+		var stats libraryIndexStats
+		index, stats, err = refreshLibraryIndex(cfg.DestDir, false)
+		if err != nil {
+			return importPlan{}, nil, fmt.Errorf("failed to index destination library: %w", err)
+		}
+		if cfg.Verbose {
+			fmt.Printf("Library index: %d files (%d added, %d updated, %d removed)\n", len(index.Files), stats.Added, stats.Updated, stats.Removed)
+		}
+		if err := markLibraryDuplicates(cfg.Source, files, index); err != nil {
+			return importPlan{}, nil, fmt.Errorf("failed to check library duplicates: %w", err)
+		}
+		if cfg.Verbose {
+			printLibraryDuplicates(files)
+		}
 	}
 
+	excluded := 0
+	if cfg.Interactive {
+		files, excluded, err = reviewImport(files, events, cfg, os.Stdin, os.Stdout)
+		if err != nil {
+			return importPlan{}, nil, err
+		}
+		if cfg.Verbose {
+			fmt.Printf("Files excluded in review: %d\n", excluded)
+		}
+	} else if err := planDestinations(files, cfg); err != nil {
+		return importPlan{}, nil, fmt.Errorf("failed to plan destinations: %w", err)
+	}
+
+	cleanupTargets := enumeration.CleanupTargets
+	if cfg.SonyPosters {
+		cleanupTargets = keepSonyThumbnails(cleanupTargets)
+	}
+	// Source artifacts may belong to files left out in review
+	if excluded > 0 {
+		cleanupTargets = nil
+	}
+	return importPlan{Files: files, CleanupTargets: cleanupTargets}, index, nil
+}
+
+// executeImport copies the files of a plan and runs the steps that follow:
+// sidecars, the library index, manifests, the contact sheet, and deleting
+// the originals
+func executeImport(cfg config, plan importPlan, index *libraryIndex) error {
+	files := plan.Files
 	if cfg.CheckDiskSpace {
 		var totalSize int64
 		for _, file := range files {
-			if file.Status == StatusUnnamable || file.Status == StatusPreExisting || file.Status == StatusSidecarDeleted {
+			if file.Status.skipsCopy() {
 				continue
 			}
 			totalSize += file.Size
 		}
-		if err := checkDiskSpace(cfg.DestDir, totalSize); err != nil {
+		if err := checkDiskSpace(cfg.Destination, cfg.DestDir, totalSize); err != nil {
 			return err
 		}
 	}
@@ -102,26 +327,77 @@ func importMedia(cfg config) error {
 	if err := copyFiles(files, cfg); err != nil {
 		return fmt.Errorf("failed to copy files: %w", err)
 	}
+	session := cfg.Session
+	if session == "" {
+		session = newImportSessionID()
+	}
+	if cfg.Verbose && (cfg.XMPSidecars || cfg.ContactSheet) && !cfg.DryRun {
+		fmt.Println("Import session:", session)
+	}
+	if cfg.XMPSidecars && !cfg.DryRun {
+		if err := writeXMPSidecars(files, cfg, session); err != nil {
+			return fmt.Errorf("failed to write XMP sidecars: %w", err)
+		}
+	}
+	if index != nil && !cfg.DryRun {
+		if err := recordCopiedFilesInLibrary(files, index); err != nil {
+			return fmt.Errorf("failed to update library index: %w", err)
+		}
+		if err := index.save(); err != nil {
+			return fmt.Errorf("failed to update library index: %w", err)
+		}
+	}
+	if index != nil && cfg.DryRun {
+		// The refreshed index only caches hashes of files already in the
+		// library, so a dry run keeps it and the next run need not hash again
+		if err := index.saveExisting(); err != nil {
+			return fmt.Errorf("failed to update library index: %w", err)
+		}
+	}
+	if cfg.ChecksumManifests && !cfg.DryRun {
+		if err := writeChecksumManifests(files, cfg.ChecksumSHA256); err != nil {
+			return fmt.Errorf("failed to write checksum manifests: %w", err)
+		}
+	}
+	// Thumbnails of files that were not copied and Sony thumbnail posters
+	// are read from the source, so they are made before the originals are
+	// deleted
+	if cfg.ContactSheet && !cfg.DryRun {
+		sheet, err := writeContactSheet(files, cfg, session)
+		if err != nil {
+			return fmt.Errorf("failed to write contact sheet: %w", err)
+		}
+		cfg.logger().Info("wrote contact sheet", "path", sheet)
+		if !cfg.Quiet {
+			fmt.Println("Contact sheet:", sheet)
+		}
+	}
 	if err := deleteOriginalFiles(files, cfg); err != nil {
 		return fmt.Errorf("failed to delete original files: %w", err)
 	}
-	if err := cleanupSourceArtifacts(cfg.SourceDir, enumeration.CleanupTargets, cfg, os.RemoveAll); err != nil {
+	if err := cleanupSourceArtifacts(cfg.SourceDir, plan.CleanupTargets, cfg, os.RemoveAll); err != nil {
 		return fmt.Errorf("failed to clean source artifacts: %w", err)
 	}
 
 	if cfg.Verbose {
+		if isFileSource(cfg.SourceDir) {
+			printSourceMemberReport(cfg.SourceDir, files)
+		}
+		printChapteredRecordings(files)
 		printSummary(files)
 	}
 
-	if cfg.AutoEject {
-		_ = ejectAfterImport(cfg.SourceDir, cfg.Quiet)
+	if cfg.AutoEject && !isDeviceSource(cfg.SourceDir) && !isFileSource(cfg.SourceDir) {
+		_ = ejectAfterImport(cfg.SourceDir, cfg.Quiet, cfg.logger())
 	}
+	logImportSummary(cfg.logger(), files)
 
 	return nil
 }
 
 // planDestinations assigns DestDir and DestName for all files.
-// Pass 1: non-sidecar files (with duplicate detection).
+// Pass 1: non-sidecar files (with duplicate detection). RAW+JPEG siblings are
+// planned together so they share a destination base name.
 // Pass 2: sidecar files (follow parent or plan independently).
 func planDestinations(files []FileInfo, cfg config) error {
 	var planningErrors []error
@@ -130,22 +406,82 @@ func planDestinations(files []FileInfo, cfg config) error {
 		sortFilesForDestinationPlanning(files)
 	}
 
+	pairRawJPEGFiles(files)
+	for i := range files {
+		if skippedByRawJPEGPolicy(files[i], cfg.RawJPEGPolicy) {
+			files[i].Status = StatusPairSkipped
+		}
+	}
+
+	recordings := detectChapters(files)
+	if cfg.Verbose && recordings > 0 {
+		fmt.Printf("Chaptered recordings detected: %d\n", recordings)
+	}
+
+	if cfg.GroupSequences {
+		groups := detectSequenceGroups(files)
+		if cfg.Verbose {
+			fmt.Printf("Burst and bracket groups detected: %d\n", groups)
+		}
+	}
+	unifyGroupPlaces(files)
+
 	// Pass 1: Process non-sidecar files
 	sizeTimeIndex := make(map[fileSizeTime][]int)
+	chapterSuffixes := make(map[string]string)
+	planned := make([]bool, len(files))
 	for i := range files {
-		if files[i].MediaCategory == Sidecar {
+		if files[i].MediaCategory == Sidecar || files[i].MediaCategory == Proxy || files[i].Status == StatusPairSkipped || planned[i] {
 			continue
 		}
 
-		if cfg.OrganizeByDate {
-			files[i].DestDir = filepath.Join(cfg.DestDir, files[i].CreationDateTime.Format("2006/01"))
-		} else {
-			files[i].DestDir = cfg.DestDir
+		if files[i].LibraryPath != "" {
+			// Content already lives elsewhere in the library; point at it so
+			// sidecars and pair siblings follow the existing name.
+			files[i].DestDir = filepath.Dir(files[i].LibraryPath)
+			files[i].DestName = filepath.Base(files[i].LibraryPath)
+			files[i].Status = StatusPreExisting
+			planned[i] = true
+			key := fileSizeTime{Size: files[i].Size, Timestamp: files[i].CreationDateTime}
+			sizeTimeIndex[key] = append(sizeTimeIndex[key], i)
+			if follower := pairedFollowerIndex(files, i); follower != -1 {
+				planned[follower] = true
+				if err := planPairFollower(&files, i, follower, cfg, sizeTimeIndex); err != nil {
+					files[follower].Status = StatusUnnamable
+					planningErrors = append(planningErrors, fmt.Errorf("failed to plan destination for %s: %w", filepath.Join(files[follower].SourceDir, files[follower].SourceName), err))
+				}
+			}
+			continue
+		}
+
+		// Chapters of one recording land together, dated by the first chapter
+		dirTime := files[i].CreationDateTime
+		if files[i].Chapter != nil {
+			dirTime = files[i].Chapter.Start
+		}
+		pairDir := destinationDir(cfg, dirTime, files[i])
+		if files[i].Sequence != nil {
+			pairDir = filepath.Join(pairDir, files[i].Sequence.Folder())
 		}
+		files[i].DestDir = pairMemberDestDir(pairDir, files[i], cfg.RawJPEGPolicy)
 
 		var initialFilename string
-		if cfg.RenameByDateTime {
-			initialFilename = files[i].CreationDateTime.Format("20060102_150405") + filepath.Ext(files[i].SourceName)
+		if files[i].Chapter != nil {
+			suffix, ok := chapterSuffixes[chapterKeyOf(files[i])]
+			if !ok {
+				var err error
+				if suffix, err = chapterGroupSuffix(files, i, cfg); err != nil {
+					files[i].Status = StatusUnnamable
+					planningErrors = append(planningErrors, fmt.Errorf("failed to plan destination for %s: %w", filepath.Join(files[i].SourceDir, files[i].SourceName), err))
+					continue
+				}
+				chapterSuffixes[chapterKeyOf(files[i])] = suffix
+			}
+			initialFilename = chapterDestName(files[i], suffix, cfg)
+		} else if cfg.RenameByDateTime && files[i].Sequence != nil {
+			initialFilename = files[i].Sequence.DestBaseName() + filepath.Ext(files[i].SourceName)
+		} else if cfg.RenameByDateTime {
+			initialFilename = dateTimeBaseName(files[i].CreationDateTime, cfg) + filepath.Ext(files[i].SourceName)
 		} else {
 			initialFilename = files[i].SourceName
 		}
@@ -155,24 +491,34 @@ func planDestinations(files []FileInfo, cfg config) error {
 			planningErrors = append(planningErrors, fmt.Errorf("failed to plan destination for %s: %w", filepath.Join(files[i].SourceDir, files[i].SourceName), err))
 			continue
 		}
+		planned[i] = true
 
 		key := fileSizeTime{Size: files[i].Size, Timestamp: files[i].CreationDateTime}
 		sizeTimeIndex[key] = append(sizeTimeIndex[key], i)
+
+		follower := pairedFollowerIndex(files, i)
+		if follower == -1 {
+			continue
+		}
+		planned[follower] = true
+		if err := planPairFollower(&files, i, follower, cfg, sizeTimeIndex); err != nil {
+			files[follower].Status = StatusUnnamable
+			planningErrors = append(planningErrors, fmt.Errorf("failed to plan destination for %s: %w", filepath.Join(files[follower].SourceDir, files[follower].SourceName), err))
+			continue
+		}
+
+		key = fileSizeTime{Size: files[follower].Size, Timestamp: files[follower].CreationDateTime}
+		sizeTimeIndex[key] = append(sizeTimeIndex[key], follower)
 	}
 
 	// Build parent index: map (sourceDir, lowerBaseName) → first media file index
-	type parentKey struct {
-		dir      string
-		baseName string
-	}
 	parentIndex := make(map[parentKey]int)
 	for i := range files {
-		if files[i].MediaCategory == Sidecar {
+		if files[i].MediaCategory == Sidecar || files[i].MediaCategory == Proxy || files[i].Status == StatusPairSkipped {
 			continue
 		}
 		ext := filepath.Ext(files[i].SourceName)
-		base := strings.TrimSuffix(files[i].SourceName, ext)
-		key := parentKey{dir: files[i].SourceDir, baseName: strings.ToLower(base)}
+		key := newParentKey(files[i].SourceDir, strings.TrimSuffix(files[i].SourceName, ext))
 		if _, exists := parentIndex[key]; !exists {
 			parentIndex[key] = i
 		}
@@ -188,7 +534,7 @@ func planDestinations(files []FileInfo, cfg config) error {
 		if sidecarExt != "" {
 			sidecarExt = sidecarExt[1:]
 		}
-		action := getSidecarAction(sidecarExt, cfg.Sidecars, cfg.SidecarDefault)
+		action := cfg.mediaTables().getSidecarAction(sidecarExt, cfg.Sidecars, cfg.SidecarDefault)
 
 		if action == SidecarDelete {
 			files[i].Status = StatusSidecarDeleted
@@ -196,8 +542,7 @@ func planDestinations(files []FileInfo, cfg config) error {
 		}
 
 		ext := filepath.Ext(files[i].SourceName)
-		base := strings.TrimSuffix(files[i].SourceName, ext)
-		key := parentKey{dir: files[i].SourceDir, baseName: strings.ToLower(base)}
+		key := newParentKey(files[i].SourceDir, strings.TrimSuffix(files[i].SourceName, ext))
 
 		if pi, ok := parentIndex[key]; ok {
 			files[i].ParentIndex = pi
@@ -207,22 +552,91 @@ func planDestinations(files []FileInfo, cfg config) error {
 			parentDestBase := strings.TrimSuffix(parentFile.DestName, parentDestExt)
 			files[i].DestName = parentDestBase + ext
 		} else {
-			if cfg.OrganizeByDate {
-				files[i].DestDir = filepath.Join(cfg.DestDir, files[i].CreationDateTime.Format("2006/01"))
-			} else {
-				files[i].DestDir = cfg.DestDir
-			}
+			files[i].DestDir = destinationDir(cfg, files[i].CreationDateTime, files[i])
 			if cfg.RenameByDateTime {
-				files[i].DestName = files[i].CreationDateTime.Format("20060102_150405") + ext
+				files[i].DestName = dateTimeBaseName(files[i].CreationDateTime, cfg) + ext
 			} else {
 				files[i].DestName = files[i].SourceName
 			}
 		}
 	}
 
+	if err := planProxies(files, parentIndex, cfg); err != nil {
+		planningErrors = append(planningErrors, err)
+	}
+	logPlannedFiles(cfg.logger(), files)
+
+	return errors.Join(planningErrors...)
+}
+
+// parentKey identifies the media file that sidecars and proxies belong to by
+// source directory and lowercase base name
+type parentKey struct {
+	dir      string
+	baseName string
+}
+
+func newParentKey(dir, baseName string) parentKey {
+	return parentKey{dir: dir, baseName: strings.ToLower(baseName)}
+}
+
+// planProxies places each proxy in a Proxies subdirectory next to its clip,
+// named after the clip, or marks it for deletion. Names taken by other files
+// get a suffix like media files.
+func planProxies(files []FileInfo, parentIndex map[parentKey]int, cfg config) error {
+	var planningErrors []error
+	for i := range files {
+		if files[i].MediaCategory != Proxy {
+			continue
+		}
+		if effectiveProxyAction(cfg.ProxyAction) == ProxyDelete {
+			files[i].Status = StatusProxyDeleted
+			continue
+		}
+
+		ext := filepath.Ext(files[i].SourceName)
+		var pi int
+		var found bool
+		for _, key := range proxyParentKeys(files[i]) {
+			if pi, found = parentIndex[key]; found {
+				break
+			}
+		}
+		var initialFilename string
+		if found {
+			files[i].ParentIndex = pi
+			parentFile := files[pi]
+			files[i].DestDir = filepath.Join(parentFile.DestDir, proxiesSubfolder)
+			initialFilename = strings.TrimSuffix(parentFile.DestName, filepath.Ext(parentFile.DestName)) + ext
+		} else {
+			// A proxy whose clip is not part of this import is filed like a clip
+			files[i].DestDir = filepath.Join(destinationDir(cfg, files[i].CreationDateTime, files[i]), proxiesSubfolder)
+			if cfg.RenameByDateTime {
+				initialFilename = dateTimeBaseName(files[i].CreationDateTime, cfg) + ext
+			} else {
+				initialFilename = files[i].SourceName
+			}
+		}
+
+		// Proxies are only compared with their destination, not with
+		// each other, so no size and time index is needed
+		if err := setFinalDestinationFilename(&files, i, initialFilename, cfg, nil); err != nil {
+			files[i].Status = StatusUnnamable
+			planningErrors = append(planningErrors, fmt.Errorf("failed to plan destination for %s: %w", filepath.Join(files[i].SourceDir, files[i].SourceName), err))
+		}
+	}
 	return errors.Join(planningErrors...)
 }
 
+// dateTimeBaseName returns the rename_by_date_time base name for a capture time,
+// with milliseconds when sub-second names are enabled.
+func dateTimeBaseName(t time.Time, cfg config) string {
+	if cfg.RenameSubSeconds {
+		return t.Format("20060102_150405.000")
+	}
+	return t.Format("20060102_150405")
+}
+
 func sortFilesForDestinationPlanning(files []FileInfo) {
 	sort.SliceStable(files, func(i, j int) bool {
 		a := files[i]
@@ -232,6 +646,10 @@ func sortFilesForDestinationPlanning(files []FileInfo) {
 			return a.CreationDateTime.Before(b.CreationDateTime)
 		}
 
+		if sa, sb := sequenceNumber(a), sequenceNumber(b); sa != sb {
+			return sa < sb
+		}
+
 		if cmp := naturalCompare(a.SourceName, b.SourceName); cmp != 0 {
 			return cmp < 0
 		}
@@ -313,9 +731,15 @@ func isASCIIDigit(c byte) bool {
 }
 
 func printSummary(files []FileInfo) {
-	var preExisting, failed, copied, sidecarDeleted, total int
+	var preExisting, failed, copied, sidecarDeleted, proxyDeleted, pairSkipped, xmpSidecars, edited, total int
 	for _, file := range files {
 		total++
+		if file.MetadataEdited {
+			edited++
+		}
+		if file.XMPSidecarName != "" {
+			xmpSidecars++
+		}
 		switch file.Status {
 		case StatusPreExisting:
 			preExisting++
@@ -325,6 +749,10 @@ func printSummary(files []FileInfo) {
 			copied++
 		case StatusSidecarDeleted:
 			sidecarDeleted++
+		case StatusProxyDeleted:
+			proxyDeleted++
+		case StatusPairSkipped:
+			pairSkipped++
 		}
 	}
 	fmt.Printf("\nFile status summary:\n")
@@ -335,6 +763,68 @@ func printSummary(files []FileInfo) {
 	if sidecarDeleted > 0 {
 		fmt.Printf("Sidecars marked for deletion: %d\n", sidecarDeleted)
 	}
+	if proxyDeleted > 0 {
+		fmt.Printf("Proxies marked for deletion: %d\n", proxyDeleted)
+	}
+	if pairSkipped > 0 {
+		fmt.Printf("RAW+JPEG pair members skipped: %d\n", pairSkipped)
+	}
+	if xmpSidecars > 0 {
+		fmt.Printf("XMP sidecars written: %d\n", xmpSidecars)
+	}
+	if edited > 0 {
+		fmt.Printf("Metadata edited: %d\n", edited)
+	}
+}
+
+// printSourceMemberReport lists each file imported from an archive or disk
+// image by its path inside the archive, with the capture time it was filed
+// under.
+func printSourceMemberReport(archive string, files []FileInfo) {
+	fmt.Printf("\nFiles in %s:\n", archive)
+	for _, file := range files {
+		member, err := filepath.Rel(archive, filepath.Join(file.SourceDir, file.SourceName))
+		if err != nil {
+			member = file.SourceName
+		}
+		line := fmt.Sprintf("  %s  captured %s  %s", filepath.ToSlash(member), file.CreationDateTime.Format("2006-01-02 15:04:05"), file.Status)
+		if file.DestName != "" && !file.Status.skipsCopy() {
+			line += " -> " + filepath.Join(file.DestDir, file.DestName)
+		}
+		fmt.Println(line)
+	}
+}
+
+// printCameraFamilies reports how many files were found in each recognized
+// camera folder layout
+func printCameraFamilies(files []FileInfo) {
+	counts := make(map[cameraFamily]int)
+	var families []cameraFamily
+	for _, file := range files {
+		if file.CameraFamily == "" {
+			continue
+		}
+		if counts[file.CameraFamily] == 0 {
+			families = append(families, file.CameraFamily)
+		}
+		counts[file.CameraFamily]++
+	}
+	sort.Slice(families, func(i, j int) bool { return families[i] < families[j] })
+	for _, family := range families {
+		fmt.Printf("  %s camera layout: %d\n", family, counts[family])
+	}
+}
+
+func printLibraryDuplicates(files []FileInfo) {
+	var count int
+	for _, file := range files {
+		if file.LibraryPath == "" {
+			continue
+		}
+		count++
+		fmt.Printf("Already in library: %s (at %s)\n", filepath.Join(file.SourceDir, file.SourceName), file.LibraryPath)
+	}
+	fmt.Printf("Files already in library: %d\n", count)
 }
 
 func printSourceArtifactSummary(targets []sourceCleanupTarget, action string) {
@@ -349,6 +839,9 @@ func printSourceArtifactSummary(targets []sourceCleanupTarget, action string) {
 		sourceArtifactSonyThumbnail,
 		sourceArtifactSonyXML,
 		sourceArtifactAppleDouble,
+		sourceArtifactProxy,
+		sourceArtifactThumbnail,
+		sourceArtifactCameraMetadata,
 	} {
 		if count := counts[kind]; count > 0 {
 			fmt.Printf("  %s: %d\n", kind, count)
@@ -356,16 +849,18 @@ func printSourceArtifactSummary(targets []sourceCleanupTarget, action string) {
 	}
 }
 
-func ejectAfterImport(sourceDir string, quiet bool) error {
+func ejectAfterImport(sourceDir string, quiet bool, log *slog.Logger) error {
 	if !quiet {
 		fmt.Printf("Attempting auto-eject for %s...\n", sourceDir)
 	}
 	if err := ejectDrive(sourceDir); err != nil {
+		log.Warn("eject failed", "source", sourceDir, "error", err)
 		if !quiet {
 			fmt.Fprintf(os.Stderr, "Warning: eject failed for %s: %v\n", sourceDir, err)
 		}
 		return err
 	}
+	log.Info("ejected source", "source", sourceDir)
 	if !quiet {
 		fmt.Printf("Ejected %s successfully.\n", sourceDir)
 	}
@@ -447,7 +942,7 @@ func copyFiles(files []FileInfo, cfg config) error {
 	var work []int
 	var totalSize int64
 	for i, file := range files {
-		if file.Status == StatusUnnamable || file.Status == StatusPreExisting || file.Status == StatusSidecarDeleted {
+		if file.Status.skipsCopy() {
 			continue
 		}
 		work = append(work, i)
@@ -491,7 +986,10 @@ func copyFiles(files []FileInfo, cfg config) error {
 	var copyErrors []error
 	var wg sync.WaitGroup
 	numWorkers := effectiveWorkers(cfg.Workers)
+	src := sourceFor(cfg)
+	dest := destinationFor(cfg)
 	tracker := newProgressTracker(totalSize, cfg.Verbose)
+	log := cfg.logger()
 
 	for w := 0; w < numWorkers; w++ {
 		wg.Add(1)
@@ -502,33 +1000,59 @@ func copyFiles(files []FileInfo, cfg config) error {
 				destPath := filepath.Join(files[i].DestDir, files[i].DestName)
 
 				if !cfg.DryRun {
-					if err := os.MkdirAll(files[i].DestDir, 0755); err != nil {
+					if err := dest.MkdirAll(files[i].DestDir); err != nil {
 						mu.Lock()
 						files[i].Status = StatusDirectoryCreationFailed
 						copyErrors = append(copyErrors, fmt.Errorf("failed to create directory %s: %w", files[i].DestDir, err))
 						mu.Unlock()
+						log.Error("copy failed", "source", srcPath, "destination", destPath, "error", err)
 						continue
 					}
 
-					if err := copyFile(srcPath, destPath); err != nil {
-						_ = os.Remove(destPath)
+					if err := copyFile(src, dest, srcPath, destPath); err != nil {
 						errMsg := fmt.Errorf("failed to copy %s: %w", srcPath, err)
 						mu.Lock()
 						files[i].Status = StatusFailed
 						copyErrors = append(copyErrors, errMsg)
 						mu.Unlock()
+						log.Error("copy failed", "source", srcPath, "destination", destPath, "error", err)
 						fmt.Fprintf(os.Stderr, "Error: %v\n", errMsg)
 						continue
 					}
 
-					if err := setFileTimes(destPath, files[i].CreationDateTime); err != nil {
+					edited := false
+					if files[i].Edits != nil {
+						var err error
+						if edited, err = applyMetadataEdits(destPath, files[i]); err != nil {
+							// The copy lacks the requested edits, so it is removed
+							// and the original is kept for the next import
+							_ = dest.Remove(destPath)
+							errMsg := fmt.Errorf("failed to edit metadata of %s: %w", destPath, err)
+							mu.Lock()
+							files[i].Status = StatusFailed
+							copyErrors = append(copyErrors, errMsg)
+							mu.Unlock()
+							log.Error("metadata edit failed", "source", srcPath, "destination", destPath, "error", err)
+							fmt.Fprintf(os.Stderr, "Error: %v\n", errMsg)
+							continue
+						}
+					}
+
+					if err := dest.Chtimes(destPath, files[i].CreationDateTime); err != nil {
+						log.Warn("setting file times failed", "destination", destPath, "error", err)
 						fmt.Fprintf(os.Stderr, "Warning: Failed to set file times for %s: %v\n", destPath, err)
 					}
 
 					mu.Lock()
 					files[i].Status = StatusCopied
+					files[i].MetadataEdited = edited
 					mu.Unlock()
 				}
+				if cfg.DryRun {
+					log.Info("would copy", "source", srcPath, "destination", destPath, "size", files[i].Size)
+				} else {
+					log.Info("copied", "source", srcPath, "destination", destPath, "size", files[i].Size, "metadata_edited", files[i].MetadataEdited)
+				}
 
 				tracker.recordCopy(srcPath, destPath, files[i].Size)
 			}
@@ -553,13 +1077,17 @@ func deleteOriginalFiles(files []FileInfo, cfg config) error {
 	var deleteErrors []error
 	var deletedCount int
 	var deletedSize int64
+	src := sourceFor(cfg)
 
 	for _, file := range files {
-		if file.Status == StatusCopied || file.Status == StatusPreExisting || file.Status == StatusSidecarDeleted {
+		// Pair members skipped by raw_jpeg_policy were never copied and stay
+		// on the source
+		if file.Status == StatusCopied || file.Status == StatusPreExisting || file.Status == StatusSidecarDeleted || file.Status == StatusProxyDeleted {
 			sourcePath := filepath.Join(file.SourceDir, file.SourceName)
 			if !cfg.DryRun {
-				err := os.Remove(sourcePath)
+				err := src.Remove(sourcePath)
 				if err != nil {
+					cfg.logger().Error("deleting original failed", "source", sourcePath, "error", err)
 					fmt.Fprintf(os.Stderr, "Failed to delete %s: %v\n", sourcePath, err)
 					deleteErrors = append(deleteErrors, fmt.Errorf("failed to delete %s: %w", sourcePath, err))
 					continue
@@ -567,6 +1095,11 @@ func deleteOriginalFiles(files []FileInfo, cfg config) error {
 			}
 			deletedCount++
 			deletedSize += file.Size
+			if cfg.DryRun {
+				cfg.logger().Info("would delete original", "source", sourcePath, "status", file.Status)
+			} else {
+				cfg.logger().Info("deleted original", "source", sourcePath, "status", file.Status)
+			}
 			if cfg.Verbose {
 				fmt.Printf("Deleted original file: %s\n", sourcePath)
 			}
@@ -597,6 +1130,7 @@ func cleanupSourceArtifacts(sourceDir string, targets []sourceCleanupTarget, cfg
 			if os.IsNotExist(err) {
 				continue
 			}
+			cfg.logger().Error("deleting source artifact failed", "path", target.Path, "kind", target.Kind, "error", err)
 			fmt.Fprintf(os.Stderr, "Failed to delete source artifact %s: %v\n", target.Path, err)
 			cleanupErrors = append(cleanupErrors, fmt.Errorf("failed to validate source artifact %s: %w", target.Path, err))
 			continue
@@ -604,6 +1138,7 @@ func cleanupSourceArtifacts(sourceDir string, targets []sourceCleanupTarget, cfg
 
 		if !cfg.DryRun {
 			if err := removeAll(target.Path); err != nil {
+				cfg.logger().Error("deleting source artifact failed", "path", target.Path, "kind", target.Kind, "error", err)
 				fmt.Fprintf(os.Stderr, "Failed to delete source artifact %s: %v\n", target.Path, err)
 				cleanupErrors = append(cleanupErrors, fmt.Errorf("failed to delete source artifact %s: %w", target.Path, err))
 				continue
@@ -611,6 +1146,11 @@ func cleanupSourceArtifacts(sourceDir string, targets []sourceCleanupTarget, cfg
 		}
 
 		cleaned = append(cleaned, target)
+		if cfg.DryRun {
+			cfg.logger().Info("would delete source artifact", "path", target.Path, "kind", target.Kind)
+		} else {
+			cfg.logger().Info("deleted source artifact", "path", target.Path, "kind", target.Kind)
+		}
 		if cfg.Verbose {
 			verb := "Deleted"
 			if cfg.DryRun {
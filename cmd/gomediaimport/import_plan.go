@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// importPlanVersion is the format version of plan files. Version 1 plans
+// did not record their settings.
+const importPlanVersion = 2
+
+type planCmd struct {
+	Out string `arg:"--out,required" help:"File to write the import plan to"`
+}
+
+type applyCmd struct {
+	Plan string `arg:"positional,required" help:"Import plan written by the plan command"`
+}
+
+// importPlanFile is the JSON form of an import plan. Files are stored as
+// planned, with the size and modification time each source file had, so a
+// plan can be checked before it is applied.
+type importPlanFile struct {
+	Version        int                   `json:"version"`
+	Created        time.Time             `json:"created"`
+	Source         string                `json:"source"`
+	Destination    string                `json:"destination"`
+	Settings       planSettings          `json:"settings"`
+	Files          []plannedFile         `json:"files"`
+	CleanupTargets []sourceCleanupTarget `json:"cleanup_targets"`
+}
+
+// planSettings are the settings that decide what an import does besides
+// copying files. They are recorded when planning and used when applying, so
+// a plan runs the steps it was reviewed with.
+type planSettings struct {
+	DeleteOriginals   bool `json:"delete_originals"`
+	AutoEject         bool `json:"auto_eject"`
+	LibraryDedup      bool `json:"library_dedup"`
+	ChecksumManifests bool `json:"checksum_manifests"`
+	ChecksumSHA256    bool `json:"checksum_sha256"`
+	XMPSidecars       bool `json:"xmp_sidecars"`
+	ContactSheet      bool `json:"contact_sheet"`
+	VideoPosters      bool `json:"video_posters"`
+	SonyPosters       bool `json:"sony_thumbnail_posters"`
+}
+
+// planSettingFlags are the flags of the settings a plan records, which apply
+// does not accept
+var planSettingFlags = []string{
+	"--delete-originals", "--auto-eject", "--library-dedup", "--checksum-manifests",
+	"--checksum-sha256", "--xmp-sidecars", "--contact-sheet", "--video-posters",
+}
+
+func planSettingsOf(cfg config) planSettings {
+	return planSettings{
+		DeleteOriginals:   cfg.DeleteOriginals,
+		AutoEject:         cfg.AutoEject,
+		LibraryDedup:      cfg.LibraryDedup,
+		ChecksumManifests: cfg.ChecksumManifests,
+		ChecksumSHA256:    cfg.ChecksumSHA256,
+		XMPSidecars:       cfg.XMPSidecars,
+		ContactSheet:      cfg.ContactSheet,
+		VideoPosters:      cfg.VideoPosters,
+		SonyPosters:       cfg.SonyPosters,
+	}
+}
+
+// applyTo replaces the settings of cfg with those of the plan
+func (s planSettings) applyTo(cfg *config) {
+	cfg.DeleteOriginals = s.DeleteOriginals
+	cfg.AutoEject = s.AutoEject
+	cfg.LibraryDedup = s.LibraryDedup
+	cfg.ChecksumManifests = s.ChecksumManifests
+	cfg.ChecksumSHA256 = s.ChecksumSHA256
+	cfg.XMPSidecars = s.XMPSidecars
+	cfg.ContactSheet = s.ContactSheet
+	cfg.VideoPosters = s.VideoPosters
+	cfg.SonyPosters = s.SonyPosters
+}
+
+// plannedFile is a planned file with the modification time of its source
+type plannedFile struct {
+	FileInfo
+	SourceModTime time.Time `json:"source_mtime"`
+}
+
+// runPlanCommand plans an import like a normal run and writes the plan to a
+// file instead of copying anything
+func runPlanCommand(cmd *planCmd, cfg config) (err error) {
+	// The plan may be applied from another working directory
+	if !isDeviceSource(cfg.SourceDir) {
+		if abs, err := filepath.Abs(cfg.SourceDir); err == nil {
+			cfg.SourceDir = abs
+		}
+	}
+	if !isRemoteDestination(cfg.DestDir) {
+		if abs, err := filepath.Abs(cfg.DestDir); err == nil {
+			cfg.DestDir = abs
+		}
+	}
+	startSession(&cfg, "planning started")
+	defer func() { logSessionError(cfg.Log, err) }()
+	if cfg.Verbose {
+		printConfig(cfg)
+	}
+	source, destination := cfg.SourceDir, cfg.DestDir
+	closeImport, err := openImport(&cfg)
+	if err != nil {
+		return err
+	}
+	defer closeImport()
+
+	plan, _, err := planImport(cfg)
+	if errors.Is(err, errReviewCancelled) {
+		cfg.Log.Info("planning cancelled in review")
+		if !cfg.Quiet {
+			fmt.Println("Planning cancelled")
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	file := importPlanFile{
+		Version:        importPlanVersion,
+		Created:        time.Now(),
+		Source:         source,
+		Destination:    destination,
+		Settings:       planSettingsOf(cfg),
+		Files:          make([]plannedFile, len(plan.Files)),
+		CleanupTargets: plan.CleanupTargets,
+	}
+	src := sourceFor(cfg)
+	var toCopy int
+	for i, f := range plan.Files {
+		info, err := src.Stat(sourcePath(f))
+		if err != nil {
+			return fmt.Errorf("failed to stat %s: %w", sourcePath(f), err)
+		}
+		file.Files[i] = plannedFile{FileInfo: f, SourceModTime: info.ModTime()}
+		if !f.Status.skipsCopy() {
+			toCopy++
+		}
+	}
+	if err := writeImportPlan(cmd.Out, file); err != nil {
+		return fmt.Errorf("failed to write import plan: %w", err)
+	}
+	cfg.Log.Info("wrote import plan", "path", cmd.Out, "files", len(plan.Files), "to_copy", toCopy)
+	if !cfg.Quiet {
+		fmt.Printf("Planned %d files, %d to copy: %s\n", len(plan.Files), toCopy, cmd.Out)
+	}
+	return nil
+}
+
+// writeImportPlan writes a plan file, replacing any existing one only once
+// it is complete
+func writeImportPlan(path string, plan importPlanFile) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readImportPlan reads a plan file written by writeImportPlan
+func readImportPlan(path string) (importPlanFile, error) {
+	var plan importPlanFile
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return plan, err
+	}
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return plan, fmt.Errorf("invalid import plan: %w", err)
+	}
+	if plan.Version != importPlanVersion {
+		return plan, fmt.Errorf("unsupported import plan version %d", plan.Version)
+	}
+	if plan.Source == "" || plan.Destination == "" {
+		return plan, fmt.Errorf("invalid import plan: missing source or destination")
+	}
+	return plan, nil
+}
+
+// checkImportPlan returns an error for every entry of a plan that no longer
+// matches the source or destination: source files that are gone or changed
+// size or modification time, destinations of copies that now exist, and
+// existing copies that are gone
+func checkImportPlan(plan importPlanFile, cfg config) error {
+	src, dest := sourceFor(cfg), destinationFor(cfg)
+	var stale []error
+	for _, file := range plan.Files {
+		path := sourcePath(file.FileInfo)
+		info, err := src.Stat(path)
+		switch {
+		case err != nil:
+			stale = append(stale, fmt.Errorf("%s: %w", path, err))
+			continue
+		case info.Size() != file.Size:
+			stale = append(stale, fmt.Errorf("%s: size changed from %d to %d bytes", path, file.Size, info.Size()))
+			continue
+		case !info.ModTime().Equal(file.SourceModTime):
+			stale = append(stale, fmt.Errorf("%s: modified since planning", path))
+			continue
+		}
+
+		destPath := filepath.Join(file.DestDir, file.DestName)
+		switch {
+		case file.DestName == "":
+		case !file.Status.skipsCopy():
+			if _, err := dest.Stat(destPath); err == nil {
+				stale = append(stale, fmt.Errorf("%s: destination %s exists", path, destPath))
+			}
+		case file.Status == StatusPreExisting:
+			if _, err := dest.Stat(destPath); err != nil {
+				stale = append(stale, fmt.Errorf("%s: existing copy %s is gone", path, destPath))
+			}
+		}
+	}
+	return errors.Join(stale...)
+}
+
+// runApplyCommand checks an import plan against the source and destination
+// and, if nothing changed since it was written, executes exactly that plan
+func runApplyCommand(cmd *applyCmd, cfg config) (err error) {
+	file, err := readImportPlan(cmd.Plan)
+	if err != nil {
+		return fmt.Errorf("failed to read import plan: %w", err)
+	}
+	if cfg.Interactive {
+		return fmt.Errorf("--interactive cannot be used to apply a plan")
+	}
+	cfg.SourceDir, cfg.DestDir = file.Source, file.Destination
+	file.Settings.applyTo(&cfg)
+	startSession(&cfg, "applying import plan")
+	defer func() { logSessionError(cfg.Log, err) }()
+	cfg.Log.Info("read import plan", "path", cmd.Plan, "created", file.Created, "files", len(file.Files))
+	if err := validateConfig(&cfg); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	if cfg.Verbose {
+		printConfig(cfg)
+	}
+	closeImport, err := openImport(&cfg)
+	if err != nil {
+		return err
+	}
+	defer closeImport()
+
+	// A device is listed again, without reading metadata, so its files can
+	// be checked and read
+	if lister, ok := cfg.Source.(sourceLister); ok {
+		if _, err := lister.list(cfg); err != nil {
+			return err
+		}
+	}
+	if err := checkImportPlan(file, cfg); err != nil {
+		return fmt.Errorf("import plan is stale, plan again:\n%w", err)
+	}
+
+	plan := importPlan{Files: make([]FileInfo, len(file.Files)), CleanupTargets: file.CleanupTargets}
+	for i, f := range file.Files {
+		plan.Files[i] = f.FileInfo
+	}
+	var index *libraryIndex
+	if cfg.LibraryDedup && !cfg.DryRun {
+		if index, _, err = refreshLibraryIndex(cfg.DestDir, false); err != nil {
+			return fmt.Errorf("failed to index destination library: %w", err)
+		}
+	}
+	return executeImport(cfg, plan, index)
+}
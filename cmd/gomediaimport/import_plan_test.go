@@ -0,0 +1,147 @@
+package main
+
+import (
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// planTestSource writes two pictures and returns the config of an import
+// from them
+func planTestSource(t *testing.T) config {
+	t.Helper()
+	sourceDir := t.TempDir()
+	for i, name := range []string{"IMG_0001.JPG", "IMG_0002.JPG"} {
+		path := filepath.Join(sourceDir, name)
+		if err := os.WriteFile(path, testJPEG(t, 16+i, 16, color.RGBA{0, 0, 255, 255}), 0644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Date(2024, 6, 15, 10, i, 0, 0, time.Local)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return config{
+		SourceDir:      sourceDir,
+		DestDir:        filepath.Join(t.TempDir(), "library"),
+		OrganizeByDate: true,
+		SidecarDefault: SidecarDelete,
+		Quiet:          true,
+	}
+}
+
+func TestPlanAndApply(t *testing.T) {
+	cfg := planTestSource(t)
+	planPath := filepath.Join(t.TempDir(), "plan.json")
+	if err := runPlanCommand(&planCmd{Out: planPath}, cfg); err != nil {
+		t.Fatalf("runPlanCommand failed: %v", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(cfg.DestDir)); len(entries) != 0 {
+		t.Fatalf("planning wrote to the destination: %v", entries)
+	}
+
+	plan, err := readImportPlan(planPath)
+	if err != nil {
+		t.Fatalf("readImportPlan failed: %v", err)
+	}
+	if len(plan.Files) != 2 || plan.Source != cfg.SourceDir || plan.Destination != cfg.DestDir {
+		t.Fatalf("plan = %d files from %s to %s, want 2 from %s to %s", len(plan.Files), plan.Source, plan.Destination, cfg.SourceDir, cfg.DestDir)
+	}
+	for _, file := range plan.Files {
+		if want := filepath.Join(cfg.DestDir, "2024", "06"); file.DestDir != want || file.SourceModTime.IsZero() {
+			t.Errorf("%s planned to %s with mtime %v, want %s", file.SourceName, file.DestDir, file.SourceModTime, want)
+		}
+	}
+
+	// Settings that would plan differently do not change an applied plan
+	applyCfg := config{SidecarDefault: SidecarDelete, Quiet: true}
+	if err := runApplyCommand(&applyCmd{Plan: planPath}, applyCfg); err != nil {
+		t.Fatalf("runApplyCommand failed: %v", err)
+	}
+	for _, name := range []string{"IMG_0001.JPG", "IMG_0002.JPG"} {
+		if _, err := os.Stat(filepath.Join(cfg.DestDir, "2024", "06", name)); err != nil {
+			t.Errorf("%s was not copied as planned: %v", name, err)
+		}
+	}
+}
+
+func TestApplyRefusesStalePlan(t *testing.T) {
+	cfg := planTestSource(t)
+	planPath := filepath.Join(t.TempDir(), "plan.json")
+	if err := runPlanCommand(&planCmd{Out: planPath}, cfg); err != nil {
+		t.Fatalf("runPlanCommand failed: %v", err)
+	}
+
+	// One source file changed and the destination of the other was taken
+	if err := os.WriteFile(filepath.Join(cfg.SourceDir, "IMG_0001.JPG"), []byte("edited"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	taken := filepath.Join(cfg.DestDir, "2024", "06", "IMG_0002.JPG")
+	if err := os.MkdirAll(filepath.Dir(taken), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(taken, []byte("other"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := runApplyCommand(&applyCmd{Plan: planPath}, config{SidecarDefault: SidecarDelete, Quiet: true})
+	if err == nil {
+		t.Fatal("runApplyCommand applied a stale plan")
+	}
+	for _, want := range []string{"IMG_0001.JPG: size changed", "IMG_0002.JPG: destination"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+	if data, _ := os.ReadFile(taken); string(data) != "other" {
+		t.Errorf("stale plan overwrote %s", taken)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.DestDir, "2024", "06", "IMG_0001.JPG")); !os.IsNotExist(err) {
+		t.Errorf("stale plan copied IMG_0001.JPG: %v", err)
+	}
+}
+
+func TestReadImportPlanVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plan.json")
+	if err := os.WriteFile(path, []byte(`{"version": 1, "source": "/a", "destination": "/b"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readImportPlan(path); err == nil || !strings.Contains(err.Error(), "version 1") {
+		t.Errorf("readImportPlan = %v, want an unsupported version error", err)
+	}
+}
+
+func TestApplyUsesPlanSettings(t *testing.T) {
+	cfg := planTestSource(t)
+	cfg.DeleteOriginals = true
+	cfg.ChecksumManifests = true
+	planPath := filepath.Join(t.TempDir(), "plan.json")
+	if err := runPlanCommand(&planCmd{Out: planPath}, cfg); err != nil {
+		t.Fatalf("runPlanCommand failed: %v", err)
+	}
+
+	// The settings in effect when applying are replaced by the plan's
+	if err := runApplyCommand(&applyCmd{Plan: planPath}, config{SidecarDefault: SidecarDelete, XMPSidecars: true, Quiet: true}); err != nil {
+		t.Fatalf("runApplyCommand failed: %v", err)
+	}
+	destDir := filepath.Join(cfg.DestDir, "2024", "06")
+	if _, err := os.Stat(filepath.Join(destDir, xxhashManifestName)); err != nil {
+		t.Errorf("checksum manifest of the plan was not written: %v", err)
+	}
+	if sidecars, _ := filepath.Glob(filepath.Join(destDir, "*.xmp")); len(sidecars) != 0 {
+		t.Errorf("XMP sidecars written although the plan has none: %v", sidecars)
+	}
+	if entries, _ := os.ReadDir(cfg.SourceDir); len(entries) != 0 {
+		t.Errorf("originals were not deleted as planned: %v", entries)
+	}
+}
+
+func TestApplyRejectsPlanSettingFlags(t *testing.T) {
+	err := run([]string{"cmd", "--config", emptyConfigFile(t), "--delete-originals", "apply", filepath.Join(t.TempDir(), "plan.json")})
+	if err == nil || !strings.Contains(err.Error(), "--delete-originals from the plan") {
+		t.Errorf("run = %v, want an error about --delete-originals", err)
+	}
+}
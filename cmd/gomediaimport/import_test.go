@@ -205,7 +205,7 @@ func TestCopyFileErrors(t *testing.T) {
 	defer os.RemoveAll(tmpDir)
 
 	// Test with non-existent source
-	err = copyFile(filepath.Join(tmpDir, "nonexistent.jpg"), filepath.Join(tmpDir, "dest.jpg"))
+	err = copyFile(localSource{}, localDestination{}, filepath.Join(tmpDir, "nonexistent.jpg"), filepath.Join(tmpDir, "dest.jpg"))
 	if err == nil {
 		t.Error("Expected error copying non-existent source, got nil")
 	}
@@ -221,7 +221,7 @@ func TestCopyFileErrors(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = copyFile(srcFile, filepath.Join(readOnlyDir, "dest.jpg"))
+	err = copyFile(localSource{}, localDestination{}, srcFile, filepath.Join(readOnlyDir, "dest.jpg"))
 	if err == nil {
 		t.Error("Expected error copying to read-only directory, got nil")
 	}
@@ -315,7 +315,7 @@ func TestSidecarCopyFollowsParentRename(t *testing.T) {
 		if sidecarExt != "" {
 			sidecarExt = sidecarExt[1:]
 		}
-		action := getSidecarAction(sidecarExt, cfg.Sidecars, cfg.SidecarDefault)
+		action := cfg.mediaTables().getSidecarAction(sidecarExt, cfg.Sidecars, cfg.SidecarDefault)
 		if action == SidecarDelete {
 			files[i].Status = StatusSidecarDeleted
 			continue
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// libraryIndexFileName is the name of the content index kept in the root of
+// a destination library.
+const libraryIndexFileName = ".gomediaimport-index.json"
+
+const libraryIndexVersion = 1
+
+type indexCmd struct {
+	Rebuild bool `arg:"--rebuild" help:"Discard the existing index and hash every file again"`
+}
+
+// libraryIndexEntry records the content hash of one library file together
+// with the size and mtime it had when it was hashed.
+type libraryIndexEntry struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime_ns"`
+	XXHash  string `json:"xxh64"`
+}
+
+// libraryIndex maps slash-separated paths relative to the library root to
+// their content hashes.
+type libraryIndex struct {
+	Version int                          `json:"version"`
+	Files   map[string]libraryIndexEntry `json:"files"`
+
+	root   string
+	bySize map[int64][]string
+}
+
+type libraryIndexStats struct {
+	Added     int
+	Updated   int
+	Removed   int
+	Unchanged int
+}
+
+func newLibraryIndex(root string) *libraryIndex {
+	return &libraryIndex{
+		Version: libraryIndexVersion,
+		Files:   make(map[string]libraryIndexEntry),
+		root:    root,
+	}
+}
+
+// loadLibraryIndex reads the index of the library at root. A missing index
+// yields an empty one.
+func loadLibraryIndex(root string) (*libraryIndex, error) {
+	data, err := os.ReadFile(filepath.Join(root, libraryIndexFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return newLibraryIndex(root), nil
+		}
+		return nil, fmt.Errorf("failed to read library index: %w", err)
+	}
+
+	index := newLibraryIndex(root)
+	if err := json.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("failed to parse library index: %w", err)
+	}
+	if index.Version != libraryIndexVersion {
+		return nil, fmt.Errorf("unsupported library index version %d", index.Version)
+	}
+	if index.Files == nil {
+		index.Files = make(map[string]libraryIndexEntry)
+	}
+	index.root = root
+	return index, nil
+}
+
+// save writes the index atomically next to the library files.
+func (idx *libraryIndex) save() error {
+	data, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode library index: %w", err)
+	}
+	if err := os.MkdirAll(idx.root, 0755); err != nil {
+		return fmt.Errorf("failed to create library directory: %w", err)
+	}
+	path := filepath.Join(idx.root, libraryIndexFileName)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write library index: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("failed to write library index: %w", err)
+	}
+	return nil
+}
+
+// saveExisting saves the index unless the library directory does not exist
+// yet, so that nothing is created in it.
+func (idx *libraryIndex) saveExisting() error {
+	if _, err := os.Stat(idx.root); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return idx.save()
+}
+
+// isLibraryMetadataName returns true for hidden files and directories, which
+// include the tool's own index, and for checksum manifests. These are never
+// treated as library content.
+func isLibraryMetadataName(name string) bool {
+	return strings.HasPrefix(name, ".") || isChecksumManifestName(name)
+}
+
+// update walks the library and brings the index up to date. Files whose size
+// and mtime are unchanged keep their recorded hash; everything else is hashed.
+func (idx *libraryIndex) update() (libraryIndexStats, error) {
+	var stats libraryIndexStats
+	seen := make(map[string]struct{}, len(idx.Files))
+
+	if _, err := os.Stat(idx.root); err != nil {
+		if os.IsNotExist(err) {
+			stats.Removed = len(idx.Files)
+			idx.Files = make(map[string]libraryIndexEntry)
+			idx.bySize = nil
+			return stats, nil
+		}
+		return stats, fmt.Errorf("error accessing library %s: %w", idx.root, err)
+	}
+
+	err := filepath.WalkDir(idx.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsPermission(err) {
+				fmt.Fprintf(os.Stderr, "Warning: Permission denied accessing %s, skipping\n", path)
+				if d != nil && d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			return fmt.Errorf("error accessing path %q: %w", path, err)
+		}
+		if path != idx.root && isLibraryMetadataName(d.Name()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("error getting info for %q: %w", path, err)
+		}
+		relPath, err := filepath.Rel(idx.root, path)
+		if err != nil {
+			return fmt.Errorf("getting relative path for %q: %w", path, err)
+		}
+		key := filepath.ToSlash(relPath)
+		seen[key] = struct{}{}
+
+		existing, known := idx.Files[key]
+		if known && existing.Size == info.Size() && existing.ModTime == info.ModTime().UnixNano() {
+			stats.Unchanged++
+			return nil
+		}
+
+		checksum, err := calculateXXHash(path)
+		if err != nil {
+			return fmt.Errorf("failed to calculate checksum for %s: %w", path, err)
+		}
+		idx.Files[key] = libraryIndexEntry{Size: info.Size(), ModTime: info.ModTime().UnixNano(), XXHash: checksum}
+		if known {
+			stats.Updated++
+		} else {
+			stats.Added++
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, fmt.Errorf("error walking the library %s: %w", idx.root, err)
+	}
+
+	for key := range idx.Files {
+		if _, ok := seen[key]; !ok {
+			delete(idx.Files, key)
+			stats.Removed++
+		}
+	}
+	idx.bySize = nil
+	return stats, nil
+}
+
+// key returns the index key of a path in the library.
+func (idx *libraryIndex) key(path string) (string, error) {
+	relPath, err := filepath.Rel(idx.root, path)
+	if err != nil {
+		return "", fmt.Errorf("getting relative path for %q: %w", path, err)
+	}
+	if relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside the library %s", path, idx.root)
+	}
+	return filepath.ToSlash(relPath), nil
+}
+
+// add records a file that was just written into the library.
+func (idx *libraryIndex) add(path string) error {
+	key, err := idx.key(path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	checksum, err := calculateXXHash(path)
+	if err != nil {
+		return fmt.Errorf("failed to calculate checksum for %s: %w", path, err)
+	}
+	idx.Files[key] = libraryIndexEntry{Size: info.Size(), ModTime: info.ModTime().UnixNano(), XXHash: checksum}
+	idx.bySize = nil
+	return nil
+}
+
+// libraryChange records a library file that was moved, replaced in place
+// (From == To) or removed (To == "") without its content changing.
+type libraryChange struct {
+	From string
+	To   string
+}
+
+// applyChanges carries the recorded hashes of changed files over to their
+// new name, size and mtime, so they are not hashed again.
+func (idx *libraryIndex) applyChanges(changes []libraryChange) error {
+	for _, change := range changes {
+		fromKey, err := idx.key(change.From)
+		if err != nil {
+			return err
+		}
+		entry, known := idx.Files[fromKey]
+		delete(idx.Files, fromKey)
+		idx.bySize = nil
+		if change.To == "" {
+			continue
+		}
+		if !known {
+			if err := idx.add(change.To); err != nil {
+				return err
+			}
+			continue
+		}
+		toKey, err := idx.key(change.To)
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(change.To)
+		if err != nil {
+			return err
+		}
+		idx.Files[toKey] = libraryIndexEntry{Size: info.Size(), ModTime: info.ModTime().UnixNano(), XXHash: entry.XXHash}
+	}
+	return nil
+}
+
+// hasSize returns true if any library file has the given size, which lets
+// callers skip hashing files that cannot have a duplicate.
+func (idx *libraryIndex) hasSize(size int64) bool {
+	idx.buildSizeLookup()
+	return len(idx.bySize[size]) > 0
+}
+
+// lookup returns the absolute path of a library file with the given size and
+// hash, or "" if the content is not in the library.
+func (idx *libraryIndex) lookup(size int64, checksum string) string {
+	idx.buildSizeLookup()
+	for _, key := range idx.bySize[size] {
+		if idx.Files[key].XXHash == checksum {
+			return filepath.Join(idx.root, filepath.FromSlash(key))
+		}
+	}
+	return ""
+}
+
+func (idx *libraryIndex) buildSizeLookup() {
+	if idx.bySize != nil {
+		return
+	}
+	idx.bySize = make(map[int64][]string)
+	for key, entry := range idx.Files {
+		idx.bySize[entry.Size] = append(idx.bySize[entry.Size], key)
+	}
+	// Sorted keys make the reported location deterministic
+	for size := range idx.bySize {
+		sort.Strings(idx.bySize[size])
+	}
+}
+
+// refreshLibraryIndex loads the index of the library at root and updates it.
+func refreshLibraryIndex(root string, rebuild bool) (*libraryIndex, libraryIndexStats, error) {
+	index := newLibraryIndex(root)
+	if !rebuild {
+		var err error
+		index, err = loadLibraryIndex(root)
+		if err != nil {
+			return nil, libraryIndexStats{}, err
+		}
+	}
+	stats, err := index.update()
+	if err != nil {
+		return nil, stats, err
+	}
+	return index, stats, nil
+}
+
+func runIndexCommand(cmd *indexCmd, cfg config) error {
+	if cfg.DestDir == "" {
+		return fmt.Errorf("destination directory is not specified")
+	}
+	if isRemoteDestination(cfg.DestDir) {
+		return fmt.Errorf("the index command requires a local destination directory")
+	}
+
+	index, stats, err := refreshLibraryIndex(cfg.DestDir, cmd.Rebuild)
+	if err != nil {
+		return err
+	}
+	if err := index.save(); err != nil {
+		return err
+	}
+
+	if !cfg.Quiet {
+		fmt.Printf("Indexed %s: %d files (%d added, %d updated, %d removed, %d unchanged)\n",
+			cfg.DestDir, len(index.Files), stats.Added, stats.Updated, stats.Removed, stats.Unchanged)
+	}
+	return nil
+}
+
+// markLibraryDuplicates sets LibraryPath and StatusPreExisting on media files
+// whose content already exists anywhere in the library. Sizes are compared
+// first so only files with a possible match are hashed.
+func markLibraryDuplicates(src mediaSource, files []FileInfo, index *libraryIndex) error {
+	var hashErrors []error
+	for i := range files {
+		file := &files[i]
+		if file.MediaCategory == Sidecar || file.Status != "" {
+			continue
+		}
+		size, err := destinationSize(src, file)
+		if err != nil {
+			hashErrors = append(hashErrors, fmt.Errorf("failed to read %s: %w", sourcePath(*file), err))
+			continue
+		}
+		if !index.hasSize(size) {
+			continue
+		}
+		checksum, err := destinationChecksum(src, file)
+		if err != nil {
+			hashErrors = append(hashErrors, fmt.Errorf("failed to calculate checksum for %s: %w", sourcePath(*file), err))
+			continue
+		}
+		if libraryPath := index.lookup(size, checksum); libraryPath != "" {
+			file.LibraryPath = libraryPath
+			file.Status = StatusPreExisting
+		}
+	}
+	return errors.Join(hashErrors...)
+}
+
+// recordCopiedFilesInLibrary adds newly copied files to the index.
+func recordCopiedFilesInLibrary(files []FileInfo, index *libraryIndex) error {
+	var indexErrors []error
+	for _, file := range files {
+		if file.Status != StatusCopied {
+			continue
+		}
+		if err := index.add(filepath.Join(file.DestDir, file.DestName)); err != nil {
+			indexErrors = append(indexErrors, err)
+		}
+	}
+	return errors.Join(indexErrors...)
+}
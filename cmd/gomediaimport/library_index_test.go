@@ -0,0 +1,186 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeLibraryTestFile(t *testing.T, root, relativePath, content string) string {
+	t.Helper()
+	path := filepath.Join(root, filepath.FromSlash(relativePath))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLibraryIndexUpdateIsIncremental(t *testing.T) {
+	root := t.TempDir()
+	writeLibraryTestFile(t, root, "2024/03/IMG_0001.JPG", "first")
+	changed := writeLibraryTestFile(t, root, "2024/03/IMG_0002.JPG", "second")
+	removed := writeLibraryTestFile(t, root, "2024/04/IMG_0003.JPG", "third")
+	writeLibraryTestFile(t, root, ".Trashes/IMG_0004.JPG", "hidden")
+
+	index, stats, err := refreshLibraryIndex(root, false)
+	if err != nil {
+		t.Fatalf("refreshLibraryIndex failed: %v", err)
+	}
+	if stats.Added != 3 || len(index.Files) != 3 {
+		t.Fatalf("got %d added and %d files, want 3 and 3", stats.Added, len(index.Files))
+	}
+	if err := index.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(changed, []byte("second, edited"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(removed); err != nil {
+		t.Fatal(err)
+	}
+	writeLibraryTestFile(t, root, "2024/05/IMG_0005.JPG", "fifth")
+
+	index, stats, err = refreshLibraryIndex(root, false)
+	if err != nil {
+		t.Fatalf("refreshLibraryIndex failed: %v", err)
+	}
+	want := libraryIndexStats{Added: 1, Updated: 1, Removed: 1, Unchanged: 1}
+	if stats != want {
+		t.Errorf("got stats %+v, want %+v", stats, want)
+	}
+	if _, ok := index.Files[libraryIndexFileName]; ok {
+		t.Error("library index must not index itself")
+	}
+
+	checksum, err := calculateXXHash(filepath.Join(root, "2024/05/IMG_0005.JPG"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := index.lookup(int64(len("fifth")), checksum); got != filepath.Join(root, "2024/05/IMG_0005.JPG") {
+		t.Errorf("lookup returned %q", got)
+	}
+	if got := index.lookup(int64(len("fifth")), "0000000000000000"); got != "" {
+		t.Errorf("expected no match for unknown checksum, got %q", got)
+	}
+}
+
+func TestLoadLibraryIndexRejectsUnknownVersion(t *testing.T) {
+	root := t.TempDir()
+	writeLibraryTestFile(t, root, libraryIndexFileName, `{"version": 99, "files": {}}`)
+
+	if _, err := loadLibraryIndex(root); err == nil {
+		t.Fatal("expected error for unsupported index version")
+	}
+}
+
+func TestImportMediaLibraryDedup(t *testing.T) {
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+
+	// The same content was imported earlier into a dated folder
+	libraryPath := writeLibraryTestFile(t, destDir, "2024/03/IMG_0001.JPG", "same photo")
+	writeLibraryTestFile(t, sourceDir, "IMG_0001.JPG", "same photo")
+	writeLibraryTestFile(t, sourceDir, "IMG_0001.xmp", "<xmp/>")
+	newPath := writeLibraryTestFile(t, sourceDir, "IMG_0002.JPG", "new photo")
+
+	cfg := config{
+		SourceDir:          sourceDir,
+		DestDir:            destDir,
+		ChecksumDuplicates: true,
+		LibraryDedup:       true,
+		SidecarDefault:     SidecarDelete,
+		Sidecars:           map[string]SidecarAction{"xmp": SidecarCopy},
+	}
+	output, err := captureStdout(t, func() error {
+		cfg.Verbose = true
+		return importMedia(cfg)
+	})
+	if err != nil {
+		t.Fatalf("importMedia failed: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(destDir, "IMG_0001.JPG")); !os.IsNotExist(err) {
+		t.Errorf("expected library duplicate not to be copied again, got err=%v", err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "2024/03/IMG_0001.xmp")); err != nil {
+		t.Errorf("expected sidecar to follow the existing library file: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "IMG_0002.JPG")); err != nil {
+		t.Errorf("expected new photo to be copied: %v", err)
+	}
+	if !strings.Contains(output, "(at "+libraryPath+")") {
+		t.Errorf("expected verbose output to report the library location, got:\n%s", output)
+	}
+
+	index, err := loadLibraryIndex(destDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checksum, err := calculateXXHash(newPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := index.lookup(int64(len("new photo")), checksum); got != filepath.Join(destDir, "IMG_0002.JPG") {
+		t.Errorf("expected copied file to be added to the index, lookup returned %q", got)
+	}
+}
+
+func TestImportMediaLibraryDedupDryRunSavesIndex(t *testing.T) {
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+	writeLibraryTestFile(t, destDir, "2024/03/IMG_0001.JPG", "same photo")
+	writeLibraryTestFile(t, sourceDir, "IMG_0002.JPG", "new photo")
+
+	cfg := config{
+		SourceDir:          sourceDir,
+		DestDir:            destDir,
+		ChecksumDuplicates: true,
+		LibraryDedup:       true,
+		DryRun:             true,
+		Quiet:              true,
+		SidecarDefault:     SidecarDelete,
+	}
+	if err := importMedia(cfg); err != nil {
+		t.Fatalf("importMedia failed: %v", err)
+	}
+
+	index, err := loadLibraryIndex(destDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Files) != 1 || index.Files["2024/03/IMG_0001.JPG"].XXHash == "" {
+		t.Errorf("expected the dry run to save the library's hashes, got %+v", index.Files)
+	}
+
+	// A library that does not exist yet is not created by a dry run
+	cfg.DestDir = filepath.Join(t.TempDir(), "new library")
+	if err := importMedia(cfg); err != nil {
+		t.Fatalf("importMedia failed: %v", err)
+	}
+	if _, err := os.Stat(cfg.DestDir); !os.IsNotExist(err) {
+		t.Errorf("expected no library directory after a dry run, got err=%v", err)
+	}
+}
+
+func TestRunIndexCommand(t *testing.T) {
+	destDir := t.TempDir()
+	writeLibraryTestFile(t, destDir, "2024/03/IMG_0001.JPG", "photo")
+
+	output, err := captureStdout(t, func() error {
+		return run([]string{"cmd", "--config", emptyConfigFile(t), "--dest", destDir, "index"})
+	})
+	if err != nil {
+		t.Fatalf("run index failed: %v", err)
+	}
+	if !strings.Contains(output, "1 files (1 added") {
+		t.Errorf("unexpected index output:\n%s", output)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, libraryIndexFileName)); err != nil {
+		t.Errorf("expected index file to be written: %v", err)
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// LogFormat is the format of log file records
+type LogFormat string
+
+const (
+	LogFormatText LogFormat = "text"
+	LogFormatJSON LogFormat = "json"
+)
+
+// LogLevel is the lowest level of records written to the log file
+type LogLevel string
+
+const (
+	LogLevelDebug LogLevel = "debug"
+	LogLevelInfo  LogLevel = "info"
+	LogLevelWarn  LogLevel = "warn"
+	LogLevelError LogLevel = "error"
+)
+
+// Log files are rotated at 10 MiB and five rotated files are kept unless
+// configured otherwise
+const (
+	defaultLogMaxSizeMB = 10
+	defaultLogMaxFiles  = 5
+)
+
+func isValidLogFormat(format LogFormat) bool {
+	switch format {
+	case "", LogFormatText, LogFormatJSON:
+		return true
+	default:
+		return false
+	}
+}
+
+// slogLevel returns the slog level of a log level, defaulting to info
+func (l LogLevel) slogLevel() (slog.Level, bool) {
+	switch l {
+	case LogLevelDebug:
+		return slog.LevelDebug, true
+	case "", LogLevelInfo:
+		return slog.LevelInfo, true
+	case LogLevelWarn:
+		return slog.LevelWarn, true
+	case LogLevelError:
+		return slog.LevelError, true
+	default:
+		return 0, false
+	}
+}
+
+func effectiveLogMaxSizeMB(size int) int {
+	if size == 0 {
+		return defaultLogMaxSizeMB
+	}
+	return size
+}
+
+func effectiveLogMaxFiles(files int) int {
+	if files == 0 {
+		return defaultLogMaxFiles
+	}
+	return files
+}
+
+// validateLogConfig checks the log file settings
+func validateLogConfig(cfg *config) error {
+	if _, ok := cfg.LogLevel.slogLevel(); !ok {
+		return fmt.Errorf("invalid log_level: %q (must be debug, info, warn, or error)", cfg.LogLevel)
+	}
+	if !isValidLogFormat(cfg.LogFormat) {
+		return fmt.Errorf("invalid log_format: %q (must be text or json)", cfg.LogFormat)
+	}
+	if cfg.LogMaxSizeMB < 0 {
+		return fmt.Errorf("log_max_size_mb must be non-negative, got %d", cfg.LogMaxSizeMB)
+	}
+	if cfg.LogMaxFiles < 0 {
+		return fmt.Errorf("log_max_files must be non-negative, got %d", cfg.LogMaxFiles)
+	}
+	return nil
+}
+
+// openLog sets cfg.Log to a logger writing to the configured log file. The
+// returned function closes the file. Without a log file nothing is logged.
+func openLog(cfg *config) (func(), error) {
+	if cfg.LogFile == "" {
+		return func() {}, nil
+	}
+	level, _ := cfg.LogLevel.slogLevel()
+	path, err := expandHomeDir(cfg.LogFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := openRotatingFile(path, int64(effectiveLogMaxSizeMB(cfg.LogMaxSizeMB))<<20, effectiveLogMaxFiles(cfg.LogMaxFiles))
+	if err != nil {
+		return nil, err
+	}
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler = slog.NewTextHandler(file, options)
+	if cfg.LogFormat == LogFormatJSON {
+		handler = slog.NewJSONHandler(file, options)
+	}
+	cfg.Log = slog.New(handler)
+	return func() { _ = file.Close() }, nil
+}
+
+// expandHomeDir replaces a leading "~/" with the user's home directory, as a
+// shell would, so config file paths such as ~/Library/Logs work.
+func expandHomeDir(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") && !strings.HasPrefix(path, "~"+string(filepath.Separator)) {
+		return path, nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user home directory: %w", err)
+	}
+	return filepath.Join(homeDir, path[1:]), nil
+}
+
+// logger returns the logger of cfg, which discards records if no log file is
+// configured
+func (cfg config) logger() *slog.Logger {
+	if cfg.Log == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return cfg.Log
+}
+
+// startSession gives an import session its ID, which XMP sidecars and the
+// contact sheet record, and ties every log record of the session to it
+func startSession(cfg *config, msg string) {
+	cfg.Session = newImportSessionID()
+	cfg.Log = cfg.logger().With("session", cfg.Session)
+	cfg.Log.Info(msg,
+		"source", cfg.SourceDir,
+		"destination", redactDestination(cfg.DestDir),
+		"dry_run", cfg.DryRun)
+	logConfig(cfg.Log, *cfg)
+}
+
+// logSessionError logs the error an import session ended with, if any
+func logSessionError(log *slog.Logger, err error) {
+	if err != nil {
+		log.Error("session failed", "error", err)
+	}
+}
+
+// logConfig logs the settings of an import, as printConfig shows them
+func logConfig(log *slog.Logger, cfg config) {
+	attrs := []any{
+		"organize_by_date", cfg.OrganizeByDate,
+		"organize_by", cfg.OrganizeBy,
+		"destination_template", cfg.DestinationTemplate,
+		"rename_by_date_time", cfg.RenameByDateTime,
+		"rename_sub_seconds", cfg.RenameSubSeconds,
+		"group_sequences", cfg.GroupSequences,
+		"checksum_duplicates", cfg.ChecksumDuplicates,
+		"library_dedup", cfg.LibraryDedup,
+		"checksum_manifests", cfg.ChecksumManifests,
+		"checksum_sha256", cfg.ChecksumSHA256,
+		"xmp_sidecars", cfg.XMPSidecars,
+		"contact_sheet", cfg.ContactSheet,
+		"video_posters", cfg.VideoPosters,
+		"delete_originals", cfg.DeleteOriginals,
+		"auto_eject", cfg.AutoEject,
+		"sidecar_default", cfg.SidecarDefault,
+		"proxy_action", effectiveProxyAction(cfg.ProxyAction),
+		"raw_jpeg_policy", effectiveRawJPEGPolicy(cfg.RawJPEGPolicy),
+		"workers", effectiveWorkers(cfg.Workers),
+	}
+	if !cfg.MetadataEdits.isZero() {
+		attrs = append(attrs, "metadata_edits", cfg.MetadataEdits.summary())
+	}
+	if geotaggingEnabled(cfg) {
+		sources := append([]string(nil), cfg.GPX...)
+		if cfg.GPXDirectory != "" {
+			sources = append(sources, cfg.GPXDirectory)
+		}
+		attrs = append(attrs, "gpx", strings.Join(sources, ","))
+	}
+	log.Info("config", attrs...)
+}
+
+// logPlannedFiles logs where each file is planned to go
+func logPlannedFiles(log *slog.Logger, files []FileInfo) {
+	for _, file := range files {
+		attrs := []any{
+			"source", sourcePath(file),
+			"category", file.MediaCategory,
+			"status", reviewStatus(file.Status),
+		}
+		if file.DestName != "" {
+			attrs = append(attrs, "destination", filepath.Join(file.DestDir, file.DestName))
+		}
+		if file.LibraryPath != "" {
+			attrs = append(attrs, "library_path", file.LibraryPath)
+		}
+		if file.Status == StatusUnnamable {
+			log.Warn("planned", attrs...)
+			continue
+		}
+		log.Debug("planned", attrs...)
+	}
+}
+
+// logImportSummary logs how many files ended in each status
+func logImportSummary(log *slog.Logger, files []FileInfo) {
+	counts := make(map[string]int)
+	for _, file := range files {
+		counts[reviewStatus(file.Status)]++
+	}
+	statuses := make([]string, 0, len(counts))
+	for status := range counts {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	attrs := []any{"files", len(files)}
+	for _, status := range statuses {
+		attrs = append(attrs, status, counts[status])
+	}
+	log.Info("import finished", attrs...)
+}
+
+// rotatingFile is a log file that is renamed to path.1 once it would grow
+// beyond maxSize, shifting older files up to path.maxFiles
+type rotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate closes the file, shifts it and the rotated files up by one, and
+// starts a new file
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	_ = os.Remove(fmt.Sprintf("%s.%d", f.path, f.maxFiles))
+	for n := f.maxFiles - 1; n >= 1; n-- {
+		if err := os.Rename(fmt.Sprintf("%s.%d", f.path, n), fmt.Sprintf("%s.%d", f.path, n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(f.path, f.path+".1"); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
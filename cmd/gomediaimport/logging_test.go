@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImportLogsSession(t *testing.T) {
+	cfg := planTestSource(t)
+	cfg.DeleteOriginals = true
+	cfg.LogFile = filepath.Join(t.TempDir(), "logs", "import.log")
+	cfg.LogLevel = LogLevelDebug
+	cfg.LogFormat = LogFormatJSON
+	closeLog, err := openLog(&cfg)
+	if err != nil {
+		t.Fatalf("openLog failed: %v", err)
+	}
+	if err := importMedia(cfg); err != nil {
+		t.Fatalf("importMedia failed: %v", err)
+	}
+	closeLog()
+
+	f, err := os.Open(cfg.LogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	messages := make(map[string]int)
+	sessions := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("log record %q is not JSON: %v", scanner.Text(), err)
+		}
+		messages[record["msg"].(string)]++
+		session, _ := record["session"].(string)
+		sessions[session] = true
+	}
+	if len(sessions) != 1 || sessions[""] {
+		t.Errorf("log records have sessions %v, want one session ID", sessions)
+	}
+	for msg, want := range map[string]int{"import started": 1, "config": 1, "planned": 2, "copied": 2, "deleted original": 2, "import finished": 1} {
+		if messages[msg] != want {
+			t.Errorf("%d %q records, want %d (all: %v)", messages[msg], msg, want, messages)
+		}
+	}
+}
+
+func TestLogLevelFiltersRecords(t *testing.T) {
+	cfg := planTestSource(t)
+	cfg.LogFile = filepath.Join(t.TempDir(), "import.log")
+	closeLog, err := openLog(&cfg)
+	if err != nil {
+		t.Fatalf("openLog failed: %v", err)
+	}
+	if err := importMedia(cfg); err != nil {
+		t.Fatalf("importMedia failed: %v", err)
+	}
+	closeLog()
+
+	data, err := os.ReadFile(cfg.LogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := string(data)
+	if !strings.Contains(log, "level=INFO msg=copied") || strings.Contains(log, "msg=planned") {
+		t.Errorf("text log at info level:\n%s", log)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "import.log")
+	f, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{path: "fourth\n", path + ".1": "third\n", path + ".2": "second\n"}
+	for name, content := range want {
+		if data, err := os.ReadFile(name); err != nil || string(data) != content {
+			t.Errorf("%s = %q, %v, want %q", filepath.Base(name), data, err, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("more than 2 rotated files were kept: %v", err)
+	}
+}
+
+func TestValidateLogConfig(t *testing.T) {
+	tests := []struct {
+		cfg  config
+		want string
+	}{
+		{config{}, ""},
+		{config{LogLevel: LogLevelWarn, LogFormat: LogFormatJSON}, ""},
+		{config{LogLevel: "verbose"}, "invalid log_level"},
+		{config{LogFormat: "xml"}, "invalid log_format"},
+		{config{LogMaxSizeMB: -1}, "log_max_size_mb"},
+		{config{LogMaxFiles: -1}, "log_max_files"},
+	}
+	for _, tt := range tests {
+		err := validateLogConfig(&tt.cfg)
+		if tt.want == "" && err != nil {
+			t.Errorf("validateLogConfig(%+v) = %v, want nil", tt.cfg, err)
+		}
+		if tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("validateLogConfig(%+v) = %v, want an error containing %q", tt.cfg, err, tt.want)
+		}
+	}
+}
+
+func TestOpenLogExpandsHomeDir(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Chdir(t.TempDir())
+
+	cfg := config{LogFile: "~/Library/Logs/gomediaimport/import.log"}
+	closeLog, err := openLog(&cfg)
+	if err != nil {
+		t.Fatalf("openLog failed: %v", err)
+	}
+	closeLog()
+
+	if _, err := os.Stat(filepath.Join(home, "Library", "Logs", "gomediaimport", "import.log")); err != nil {
+		t.Errorf("expected log file in the home directory: %v", err)
+	}
+	if _, err := os.Stat("~"); !os.IsNotExist(err) {
+		t.Errorf("expected no literal ~ directory, got err=%v", err)
+	}
+}
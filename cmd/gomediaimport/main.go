@@ -5,9 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/alexflint/go-arg"
 	"gopkg.in/yaml.v3"
@@ -18,22 +20,46 @@ var version = "dev"
 
 // cliArgs holds the command-line arguments
 type cliArgs struct {
-	SourceDir            string      `arg:"--source" help:"Source directory for media files"`
-	DestDir              string      `arg:"--dest" help:"Destination directory for imported media"`
+	SourceDir            string      `arg:"--source" help:"Source directory for media files, a ZIP/tar archive or FAT/exFAT disk image, or ptp: for a camera or phone connected by USB"`
+	DestDir              string      `arg:"--dest" help:"Destination directory or sftp://, webdav(s)://, or s3:// URL for imported media"`
 	ConfigFile           string      `arg:"--config" help:"Path to config file"`
 	OrganizeByDate       bool        `arg:"--organize-by-date" help:"Organize files by date"`
+	OrganizeBy           string      `arg:"--organize-by" help:"Organize files into folders by date (YYYY/MM) or event (YYYY/YYYY-MM-DD Event)"`
+	EventName            string      `arg:"--event-name" help:"Name of the event folders with --organize-by event"`
+	NameEvents           bool        `arg:"--name-events" help:"Ask for the name of each event with --organize-by event"`
+	Interactive          bool        `arg:"--interactive" help:"Review the planned import and choose what to import before copying"`
 	RenameByDateTime     bool        `arg:"--rename-by-date-time" help:"Rename files by date and time"`
+	RenameSubSeconds     bool        `arg:"--rename-sub-seconds" help:"Include milliseconds in date and time names"`
+	GroupSequences       bool        `arg:"--group-sequences" help:"Place bursts and exposure brackets in their own subfolders"`
 	ChecksumDuplicates   bool        `arg:"--checksum-duplicates" help:"Use checksums to identify duplicates (default)"`
 	NoChecksumDuplicates bool        `arg:"--no-checksum-duplicates" help:"Disable checksum duplicate verification"`
+	LibraryDedup         bool        `arg:"--library-dedup" help:"Skip files whose content already exists anywhere in the destination library"`
+	ChecksumManifests    bool        `arg:"--checksum-manifests" help:"Write per-directory checksum manifests next to imported files"`
+	ChecksumSHA256       bool        `arg:"--checksum-sha256" help:"Also write SHA256SUMS manifests"`
+	XMPSidecars          bool        `arg:"--xmp-sidecars" help:"Write or update an XMP sidecar with the capture time and import provenance of each imported file"`
+	ContactSheet         bool        `arg:"--contact-sheet" help:"Write thumbnails and an HTML contact sheet of the import to the thumbnail cache"`
+	VideoPosters         bool        `arg:"--video-posters" help:"Show videos on the contact sheet with a poster from their cover art, embedded thumbnail, or first keyframe"`
+	GPX                  []string    `arg:"--gpx" help:"GPX track files to geotag pictures and videos without a position from"`
+	DestinationTemplate  string      `arg:"--destination-template" help:"Destination folder template, such as {year}/{country}/{city}"`
 	Verbose              bool        `arg:"-v,--verbose" help:"Enable verbose output"`
 	Quiet                bool        `arg:"-q,--quiet" help:"Suppress all non-error output"`
+	LogFile              string      `arg:"--log-file" help:"Log import sessions to this file"`
+	LogLevel             string      `arg:"--log-level" help:"Lowest level logged to the log file (debug/info/warn/error)"`
+	LogFormat            string      `arg:"--log-format" help:"Format of the log file (text/json)"`
 	DryRun               bool        `arg:"--dry-run" help:"Perform a dry run without making changes"`
 	DeleteOriginals      bool        `arg:"--delete-originals" help:"Delete imported originals and excluded source artifacts after successful import"`
 	AutoEject            bool        `arg:"--auto-eject" help:"Automatically eject source media after successful import"`
 	CheckDiskSpace       bool        `arg:"--check-disk-space" help:"Check for free disk space before importing" default:"true"`
 	SidecarDefault       string      `arg:"--sidecar-default" help:"Default action for unknown sidecar types (ignore/copy/delete)" default:"delete"`
+	ProxyAction          string      `arg:"--proxy-action" help:"Handling of low-resolution proxies (ignore/copy_to_proxies_subfolder/delete)" default:"delete"`
 	Workers              int         `arg:"--workers" help:"Number of concurrent copy workers (0 = default of 4)"`
+	RawJPEGPolicy        string      `arg:"--raw-jpeg-policy" help:"Handling of RAW+JPEG pairs (keep_both/raw_only/jpeg_only/jpeg_to_subfolder)" default:"keep_both"`
 	Volumes              *volumesCmd `arg:"subcommand:volumes" help:"Manage remembered removable volume labels"`
+	Index                *indexCmd   `arg:"subcommand:index" help:"Build or update the content index of the destination library"`
+	Dedupe               *dedupeCmd  `arg:"subcommand:dedupe" help:"Find and remove byte-identical copies in the destination library"`
+	Verify               *verifyCmd  `arg:"subcommand:verify" help:"Check the destination library against its checksum manifests"`
+	Plan                 *planCmd    `arg:"subcommand:plan" help:"Plan an import and write the plan to a file without copying"`
+	Apply                *applyCmd   `arg:"subcommand:apply" help:"Check an import plan and execute it"`
 }
 
 // Version returns the version string for --version flag
@@ -43,22 +69,59 @@ func (cliArgs) Version() string {
 
 // config holds the application configuration
 type config struct {
-	SourceDir          string                           `yaml:"source_directory"`
-	DestDir            string                           `yaml:"destination_directory"`
-	ConfigFile         string                           `yaml:"-"`
-	OrganizeByDate     bool                             `yaml:"organize_by_date"`
-	RenameByDateTime   bool                             `yaml:"rename_by_date_time"`
-	ChecksumDuplicates bool                             `yaml:"checksum_duplicates"`
-	Verbose            bool                             `yaml:"verbose"`
-	Quiet              bool                             `yaml:"quiet"`
-	DryRun             bool                             `yaml:"dry_run"`
-	DeleteOriginals    bool                             `yaml:"delete_originals"`
-	AutoEject          bool                             `yaml:"auto_eject"`
-	CheckDiskSpace     bool                             `yaml:"check_disk_space"`
-	SidecarDefault     SidecarAction                    `yaml:"sidecar_default"`
-	Sidecars           map[string]SidecarAction         `yaml:"sidecars"`
-	Workers            int                              `yaml:"workers"`
-	RemovableVolumes   map[string]removableVolumeConfig `yaml:"removable_volumes,omitempty"`
+	SourceDir           string                           `yaml:"source_directory"`
+	DestDir             string                           `yaml:"destination_directory"`
+	ConfigFile          string                           `yaml:"-"`
+	OrganizeByDate      bool                             `yaml:"organize_by_date"`
+	OrganizeBy          OrganizeMode                     `yaml:"organize_by,omitempty"`
+	EventGap            time.Duration                    `yaml:"event_gap,omitempty"`
+	EventDistanceKm     float64                          `yaml:"event_distance_km,omitempty"`
+	EventName           string                           `yaml:"event_name,omitempty"`
+	NameEvents          bool                             `yaml:"-"`
+	Interactive         bool                             `yaml:"-"`
+	RenameByDateTime    bool                             `yaml:"rename_by_date_time"`
+	RenameSubSeconds    bool                             `yaml:"rename_sub_seconds"`
+	GroupSequences      bool                             `yaml:"group_sequences"`
+	ChecksumDuplicates  bool                             `yaml:"checksum_duplicates"`
+	LibraryDedup        bool                             `yaml:"library_dedup"`
+	ChecksumManifests   bool                             `yaml:"checksum_manifests"`
+	ChecksumSHA256      bool                             `yaml:"checksum_sha256"`
+	XMPSidecars         bool                             `yaml:"xmp_sidecars"`
+	ContactSheet        bool                             `yaml:"contact_sheet"`
+	ThumbnailDirectory  string                           `yaml:"thumbnail_directory,omitempty"`
+	VideoPosters        bool                             `yaml:"video_posters"`
+	SonyPosters         bool                             `yaml:"sony_thumbnail_posters"`
+	MetadataEdits       metadataEdits                    `yaml:"metadata_edits,omitempty"`
+	GPX                 []string                         `yaml:"gpx,omitempty"`
+	GPXDirectory        string                           `yaml:"gpx_directory,omitempty"`
+	GPXMaxGap           time.Duration                    `yaml:"gpx_max_gap,omitempty"`
+	GPXTarget           GPXTarget                        `yaml:"gpx_target,omitempty"`
+	DestinationTemplate string                           `yaml:"destination_template,omitempty"`
+	GeoNamesCities      string                           `yaml:"geonames_cities,omitempty"`
+	LocationFallback    string                           `yaml:"location_fallback,omitempty"`
+	Verbose             bool                             `yaml:"verbose"`
+	Quiet               bool                             `yaml:"quiet"`
+	LogFile             string                           `yaml:"log_file,omitempty"`
+	LogLevel            LogLevel                         `yaml:"log_level,omitempty"`
+	LogFormat           LogFormat                        `yaml:"log_format,omitempty"`
+	LogMaxSizeMB        int                              `yaml:"log_max_size_mb,omitempty"`
+	LogMaxFiles         int                              `yaml:"log_max_files,omitempty"`
+	DryRun              bool                             `yaml:"dry_run"`
+	DeleteOriginals     bool                             `yaml:"delete_originals"`
+	AutoEject           bool                             `yaml:"auto_eject"`
+	CheckDiskSpace      bool                             `yaml:"check_disk_space"`
+	SidecarDefault      SidecarAction                    `yaml:"sidecar_default"`
+	Sidecars            map[string]SidecarAction         `yaml:"sidecars"`
+	ProxyAction         ProxyAction                      `yaml:"proxy_action"`
+	MediaTypes          mediaTypesConfig                 `yaml:"media_types,omitempty"`
+	MediaTables         *mediaTypeTables                 `yaml:"-"` // Built from MediaTypes
+	Workers             int                              `yaml:"workers"`
+	RawJPEGPolicy       RawJPEGPolicy                    `yaml:"raw_jpeg_policy"`
+	RemovableVolumes    map[string]removableVolumeConfig `yaml:"removable_volumes,omitempty"`
+	Source              mediaSource                      `yaml:"-"`
+	Destination         destination                      `yaml:"-"`
+	Session             string                           `yaml:"-"`
+	Log                 *slog.Logger                     `yaml:"-"`
 }
 
 // setDefaults initializes the config with default values
@@ -76,7 +139,13 @@ func setDefaults(cfg *config) error {
 	cfg.ConfigFile = filepath.Join(configDir, "gomediaimport", "config.yaml")
 	cfg.OrganizeByDate = false
 	cfg.RenameByDateTime = false
+	cfg.RenameSubSeconds = false
+	cfg.GroupSequences = false
 	cfg.ChecksumDuplicates = true
+	cfg.LibraryDedup = false
+	cfg.ChecksumManifests = false
+	cfg.ChecksumSHA256 = false
+	cfg.XMPSidecars = false
 	cfg.Verbose = false
 	cfg.DryRun = false
 	cfg.DeleteOriginals = false
@@ -84,7 +153,9 @@ func setDefaults(cfg *config) error {
 	cfg.CheckDiskSpace = true
 	cfg.SidecarDefault = SidecarDelete
 	cfg.Sidecars = make(map[string]SidecarAction)
+	cfg.ProxyAction = ProxyDelete
 	cfg.Workers = 0
+	cfg.RawJPEGPolicy = RawJPEGKeepBoth
 	return nil
 }
 
@@ -114,10 +185,30 @@ func validateCommonConfig(cfg *config) error {
 		return fmt.Errorf("destination directory is not specified")
 	}
 
-	// Check if destination directory's parent exists
-	destParent := filepath.Dir(cfg.DestDir)
-	if _, err := os.Stat(destParent); os.IsNotExist(err) {
-		return fmt.Errorf("destination parent directory does not exist: %s", destParent)
+	if isRemoteDestination(cfg.DestDir) {
+		if err := validateDestinationURL(cfg.DestDir); err != nil {
+			return err
+		}
+		// Both read and rewrite files across the whole library
+		if cfg.LibraryDedup {
+			return fmt.Errorf("library_dedup is not supported with a remote destination")
+		}
+		if cfg.ChecksumManifests {
+			return fmt.Errorf("checksum_manifests is not supported with a remote destination")
+		}
+		// Edits rewrite the copies in place
+		if !cfg.MetadataEdits.isZero() {
+			return fmt.Errorf("metadata_edits is not supported with a remote destination")
+		}
+		if geotaggingEnabled(*cfg) && effectiveGPXTarget(cfg.GPXTarget) == GPXTargetEXIF {
+			return fmt.Errorf("gpx_target exif is not supported with a remote destination")
+		}
+	} else {
+		// Check if destination directory's parent exists
+		destParent := filepath.Dir(cfg.DestDir)
+		if _, err := os.Stat(destParent); os.IsNotExist(err) {
+			return fmt.Errorf("destination parent directory does not exist: %s", destParent)
+		}
 	}
 
 	// Validate workers count
@@ -137,10 +228,53 @@ func validateCommonConfig(cfg *config) error {
 		}
 	}
 
-	for label := range cfg.RemovableVolumes {
+	if !isValidProxyAction(cfg.ProxyAction) {
+		return fmt.Errorf("invalid proxy action: %q (must be ignore, copy_to_proxies_subfolder, or delete)", cfg.ProxyAction)
+	}
+
+	if !isValidRawJPEGPolicy(cfg.RawJPEGPolicy) {
+		return fmt.Errorf("invalid RAW+JPEG policy: %q (must be keep_both, raw_only, jpeg_only, or jpeg_to_subfolder)", cfg.RawJPEGPolicy)
+	}
+
+	if err := validateMetadataEdits(cfg.MetadataEdits); err != nil {
+		return fmt.Errorf("invalid metadata_edits: %w", err)
+	}
+
+	if !isValidGPXTarget(cfg.GPXTarget) {
+		return fmt.Errorf("invalid GPX target: %q (must be exif or xmp)", cfg.GPXTarget)
+	}
+	if cfg.GPXMaxGap < 0 {
+		return fmt.Errorf("gpx_max_gap must be non-negative, got %s", cfg.GPXMaxGap)
+	}
+	if effectiveGPXTarget(cfg.GPXTarget) == GPXTargetXMP && geotaggingEnabled(*cfg) && !cfg.XMPSidecars {
+		return fmt.Errorf("gpx_target xmp requires xmp_sidecars")
+	}
+	if err := validateLocationConfig(cfg); err != nil {
+		return err
+	}
+	if cfg.Interactive && cfg.Quiet {
+		return fmt.Errorf("--interactive cannot be combined with --quiet")
+	}
+	if cfg.VideoPosters && !cfg.ContactSheet {
+		return fmt.Errorf("video_posters requires contact_sheet")
+	}
+	if cfg.SonyPosters && !cfg.VideoPosters {
+		return fmt.Errorf("sony_thumbnail_posters requires video_posters")
+	}
+	if err := validateEventConfig(cfg); err != nil {
+		return err
+	}
+
+	for label, entry := range cfg.RemovableVolumes {
 		if label == "" {
 			return fmt.Errorf("removable volume label cannot be empty")
 		}
+		if !isValidRawJPEGPolicy(entry.RawJPEGPolicy) {
+			return fmt.Errorf("invalid RAW+JPEG policy for removable volume %q: %q (must be keep_both, raw_only, jpeg_only, or jpeg_to_subfolder)", label, entry.RawJPEGPolicy)
+		}
+		if err := validateMetadataEdits(entry.MetadataEdits); err != nil {
+			return fmt.Errorf("invalid metadata_edits for removable volume %q: %w", label, err)
+		}
 	}
 
 	return nil
@@ -156,11 +290,19 @@ func validateConfig(cfg *config) error {
 		return fmt.Errorf("source directory is not specified")
 	}
 
+	if isDeviceSource(cfg.SourceDir) {
+		return nil
+	}
+
 	// Check if source directory exists
 	if _, err := os.Stat(cfg.SourceDir); os.IsNotExist(err) {
 		return fmt.Errorf("source directory does not exist: %s", cfg.SourceDir)
 	}
 
+	if isFileSource(cfg.SourceDir) && cfg.DeleteOriginals {
+		return fmt.Errorf("delete_originals cannot be used with an archive or disk image source, which is read-only")
+	}
+
 	return nil
 }
 
@@ -247,12 +389,50 @@ func run(osArgs []string) error {
 	if wasFlagProvided(osArgs, "--rename-by-date-time") {
 		cfg.RenameByDateTime = parsedArgs.RenameByDateTime
 	}
+	if wasFlagProvided(osArgs, "--rename-sub-seconds") {
+		cfg.RenameSubSeconds = parsedArgs.RenameSubSeconds
+	}
+	if wasFlagProvided(osArgs, "--group-sequences") {
+		cfg.GroupSequences = parsedArgs.GroupSequences
+	}
 	if wasFlagProvided(osArgs, "--checksum-duplicates") {
 		cfg.ChecksumDuplicates = parsedArgs.ChecksumDuplicates
 	}
 	if wasFlagProvided(osArgs, "--no-checksum-duplicates") {
 		cfg.ChecksumDuplicates = false
 	}
+	if wasFlagProvided(osArgs, "--library-dedup") {
+		cfg.LibraryDedup = parsedArgs.LibraryDedup
+	}
+	if wasFlagProvided(osArgs, "--checksum-manifests") {
+		cfg.ChecksumManifests = parsedArgs.ChecksumManifests
+	}
+	if wasFlagProvided(osArgs, "--checksum-sha256") {
+		cfg.ChecksumSHA256 = parsedArgs.ChecksumSHA256
+	}
+	if wasFlagProvided(osArgs, "--xmp-sidecars") {
+		cfg.XMPSidecars = parsedArgs.XMPSidecars
+	}
+	if wasFlagProvided(osArgs, "--organize-by") {
+		cfg.OrganizeBy = OrganizeMode(parsedArgs.OrganizeBy)
+	}
+	if wasFlagProvided(osArgs, "--event-name") {
+		cfg.EventName = parsedArgs.EventName
+	}
+	cfg.NameEvents = parsedArgs.NameEvents
+	cfg.Interactive = parsedArgs.Interactive
+	if wasFlagProvided(osArgs, "--contact-sheet") {
+		cfg.ContactSheet = parsedArgs.ContactSheet
+	}
+	if wasFlagProvided(osArgs, "--video-posters") {
+		cfg.VideoPosters = parsedArgs.VideoPosters
+	}
+	if wasFlagProvided(osArgs, "--destination-template") {
+		cfg.DestinationTemplate = parsedArgs.DestinationTemplate
+	}
+	if len(parsedArgs.GPX) > 0 {
+		cfg.GPX = parsedArgs.GPX
+	}
 	if wasFlagProvided(osArgs, "-v") || wasFlagProvided(osArgs, "--verbose") {
 		cfg.Verbose = parsedArgs.Verbose
 	}
@@ -271,15 +451,82 @@ func run(osArgs []string) error {
 	if wasFlagProvided(osArgs, "--sidecar-default") {
 		cfg.SidecarDefault = SidecarAction(parsedArgs.SidecarDefault)
 	}
+	if wasFlagProvided(osArgs, "--proxy-action") {
+		cfg.ProxyAction = ProxyAction(parsedArgs.ProxyAction)
+	}
 	if wasFlagProvided(osArgs, "--workers") {
 		cfg.Workers = parsedArgs.Workers
 	}
+	if wasFlagProvided(osArgs, "--raw-jpeg-policy") {
+		cfg.RawJPEGPolicy = RawJPEGPolicy(parsedArgs.RawJPEGPolicy)
+	}
 	if wasFlagProvided(osArgs, "-q") || wasFlagProvided(osArgs, "--quiet") {
 		cfg.Quiet = parsedArgs.Quiet
 	}
 	if cfg.Quiet {
 		cfg.Verbose = false
 	}
+	if wasFlagProvided(osArgs, "--log-file") {
+		cfg.LogFile = parsedArgs.LogFile
+	}
+	if wasFlagProvided(osArgs, "--log-level") {
+		cfg.LogLevel = LogLevel(parsedArgs.LogLevel)
+	}
+	if wasFlagProvided(osArgs, "--log-format") {
+		cfg.LogFormat = LogFormat(parsedArgs.LogFormat)
+	}
+
+	// Media types from the config file also classify files for index and
+	// dedupe, which do not validate the rest of the configuration, so they
+	// are validated here for every command
+	if err := validateMediaTypes(cfg.MediaTypes); err != nil {
+		return fmt.Errorf("invalid configuration: invalid media_types: %w", err)
+	}
+	cfg.MediaTables = newMediaTypeTables(cfg.MediaTypes)
+
+	if parsedArgs.Index != nil {
+		return runIndexCommand(parsedArgs.Index, cfg)
+	}
+	if parsedArgs.Dedupe != nil {
+		return runDedupeCommand(parsedArgs.Dedupe, cfg)
+	}
+	if parsedArgs.Verify != nil {
+		return runVerifyCommand(cfg)
+	}
+
+	// Imports, plans, and applied plans are logged
+	if err := validateLogConfig(&cfg); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	closeLog, err := openLog(&cfg)
+	if err != nil {
+		return fmt.Errorf("opening log file: %w", err)
+	}
+	defer closeLog()
+
+	if parsedArgs.Apply != nil {
+		if sourceProvided || wasFlagProvided(osArgs, "--dest") {
+			return fmt.Errorf("apply takes the source and destination from the plan")
+		}
+		for _, flag := range planSettingFlags {
+			if wasFlagProvided(osArgs, flag) {
+				return fmt.Errorf("apply takes %s from the plan; plan again to change it", flag)
+			}
+		}
+		if err := runApplyCommand(parsedArgs.Apply, cfg); err != nil {
+			return fmt.Errorf("applying import plan: %w", err)
+		}
+		return nil
+	}
+	if parsedArgs.Plan != nil {
+		if err := validateConfig(&cfg); err != nil {
+			return fmt.Errorf("invalid configuration: %w", err)
+		}
+		if err := runPlanCommand(parsedArgs.Plan, cfg); err != nil {
+			return fmt.Errorf("planning import: %w", err)
+		}
+		return nil
+	}
 
 	if !sourceProvided && len(cfg.RemovableVolumes) > 0 {
 		if err := validateCommonConfig(&cfg); err != nil {
@@ -399,7 +399,7 @@ func TestCopyFile(t *testing.T) {
 		t.Fatalf("Failed to write source file: %v", err)
 	}
 
-	if err := copyFile(srcPath, dstPath); err != nil {
+	if err := copyFile(localSource{}, localDestination{}, srcPath, dstPath); err != nil {
 		t.Fatalf("copyFile failed: %v", err)
 	}
 
@@ -425,7 +425,7 @@ func TestExists(t *testing.T) {
 		t.Fatalf("Failed to write file: %v", err)
 	}
 
-	found, err := exists(existingFile)
+	found, err := exists(localDestination{}, existingFile)
 	if err != nil {
 		t.Fatalf("exists() returned error for existing file: %v", err)
 	}
@@ -434,7 +434,7 @@ func TestExists(t *testing.T) {
 	}
 
 	missingFile := filepath.Join(tmpDir, "missing.txt")
-	found, err = exists(missingFile)
+	found, err = exists(localDestination{}, missingFile)
 	if err != nil {
 		t.Fatalf("exists() returned error for missing file: %v", err)
 	}
@@ -23,6 +23,7 @@ const (
 	ICO      FileType = "ico"
 	WEBP     FileType = "webp"
 	HEIF     FileType = "heif"
+	INSP     FileType = "insp"
 
 	// Raw Picture Types
 	RAW FileType = "raw"
@@ -42,16 +43,27 @@ const (
 	ASF     FileType = "asf"
 	VOB     FileType = "vob"
 	MTS     FileType = "mts"
+	MXF     FileType = "mxf"
+	INSV    FileType = "insv"
 
 	// Raw Video Types
 	RAWVIDEO FileType = "rawvideo"
 
+	// Audio Types
+	WAV  FileType = "wav"
+	AIFF FileType = "aiff"
+	MP3  FileType = "mp3"
+	FLAC FileType = "flac"
+	M4A  FileType = "m4a"
+
 	// Media Categories
 	ProcessedPicture MediaCategory = "processed_picture"
 	RawPicture       MediaCategory = "raw_picture"
 	Video            MediaCategory = "video"
 	RawVideo         MediaCategory = "raw_video"
+	Audio            MediaCategory = "audio"
 	Sidecar          MediaCategory = "sidecar"
+	Proxy            MediaCategory = "proxy"
 )
 
 // SidecarAction defines how a sidecar file type should be handled
@@ -63,31 +75,44 @@ const (
 	SidecarDelete SidecarAction = "delete"
 )
 
-// sidecarDefaults maps sidecar extensions to their default actions
-var sidecarDefaults = map[string]SidecarAction{
+// builtinSidecarDefaults maps sidecar extensions to their default actions
+var builtinSidecarDefaults = map[string]SidecarAction{
 	"thm": SidecarDelete,
 	"ctg": SidecarDelete,
 	"xmp": SidecarCopy,
 	"aae": SidecarDelete,
-	"lrf": SidecarDelete,
 	"srt": SidecarCopy,
 	"mpl": SidecarDelete,
 	"cpi": SidecarDelete,
 }
 
+// mediaTypeTables are the tables files are classified by: the compiled-in
+// file types and sidecars, extended by the media_types section of the config
+// file
+type mediaTypeTables struct {
+	fileTypes       []FileTypeInfo
+	sidecarDefaults map[string]SidecarAction
+	// metadataParsers maps the file types defined in the config file to
+	// their metadata parser
+	metadataParsers map[FileType]metadataParser
+}
+
+// builtinMediaTypes are the tables without a media_types section
+var builtinMediaTypes = &mediaTypeTables{fileTypes: builtinFileTypes, sidecarDefaults: builtinSidecarDefaults}
+
 // isSidecarExtension returns true if the extension (without dot, lowercase) is a known sidecar type
-func isSidecarExtension(ext string) bool {
-	_, ok := sidecarDefaults[ext]
+func (t *mediaTypeTables) isSidecarExtension(ext string) bool {
+	_, ok := t.sidecarDefaults[ext]
 	return ok
 }
 
 // getSidecarAction returns the action for a sidecar extension, checking overrides first,
 // then built-in defaults, then the global default
-func getSidecarAction(ext string, sidecarOverrides map[string]SidecarAction, sidecarDefault SidecarAction) SidecarAction {
+func (t *mediaTypeTables) getSidecarAction(ext string, sidecarOverrides map[string]SidecarAction, sidecarDefault SidecarAction) SidecarAction {
 	if action, ok := sidecarOverrides[ext]; ok {
 		return action
 	}
-	if action, ok := sidecarDefaults[ext]; ok {
+	if action, ok := t.sidecarDefaults[ext]; ok {
 		return action
 	}
 	return sidecarDefault
@@ -104,7 +129,7 @@ type FileTypeInfo struct {
 	Extensions    []string
 }
 
-var fileTypes = []FileTypeInfo{
+var builtinFileTypes = []FileTypeInfo{
 	{JPEG, ProcessedPicture, []string{"jpg", "jpeg", "jpe", "jif", "jfif", "jfi"}},
 	{JPEG2000, ProcessedPicture, []string{"jp2", "j2k", "jpf", "jpm", "jpg2", "j2c", "jpc", "jpx", "mj2"}},
 	{JPEGXL, ProcessedPicture, []string{"jxl"}},
@@ -118,6 +143,7 @@ var fileTypes = []FileTypeInfo{
 	{ICO, ProcessedPicture, []string{"ico"}},
 	{WEBP, ProcessedPicture, []string{"webp"}},
 	{HEIF, ProcessedPicture, []string{"heif", "heifs", "heic", "heics", "avci", "avcs", "hif"}},
+	{INSP, ProcessedPicture, []string{"insp"}},
 	{RAW, RawPicture, []string{"arw", "cr2", "cr3", "crw", "dng", "erf", "kdc", "mrw", "nef", "orf", "pef", "raf", "raw", "rw2", "sr2", "srf", "x3f"}},
 	{MP4, Video, []string{"mp4"}},
 	{AVI, Video, []string{"avi"}},
@@ -133,17 +159,24 @@ var fileTypes = []FileTypeInfo{
 	{ASF, Video, []string{"asf"}},
 	{VOB, Video, []string{"vob"}},
 	{MTS, Video, []string{"mts", "m2ts"}},
+	{MXF, Video, []string{"mxf"}},
+	{INSV, Video, []string{"insv"}},
 	{RAWVIDEO, RawVideo, []string{"braw", "r3d", "ari"}},
+	{WAV, Audio, []string{"wav", "bwf"}},
+	{AIFF, Audio, []string{"aiff", "aif", "aifc"}},
+	{MP3, Audio, []string{"mp3"}},
+	{FLAC, Audio, []string{"flac"}},
+	{M4A, Audio, []string{"m4a"}},
 }
 
-func getMediaTypeInfo(fi FileInfo) (MediaCategory, FileType) {
+func (t *mediaTypeTables) getMediaTypeInfo(fi FileInfo) (MediaCategory, FileType) {
 	ext := strings.ToLower(filepath.Ext(fi.SourceName))
 	if ext == "" {
 		return "", ""
 	}
 	ext = ext[1:] // Remove the leading dot
 
-	for _, ft := range fileTypes {
+	for _, ft := range t.fileTypes {
 		for _, e := range ft.Extensions {
 			if e == ext {
 				return ft.MediaCategory, ft.FileType
@@ -154,8 +187,8 @@ func getMediaTypeInfo(fi FileInfo) (MediaCategory, FileType) {
 	return "", ""
 }
 
-func getFirstExtensionForFileType(fileType FileType) string {
-	for _, ft := range fileTypes {
+func (t *mediaTypeTables) getFirstExtensionForFileType(fileType FileType) string {
+	for _, ft := range t.fileTypes {
 		if ft.FileType == fileType && len(ft.Extensions) > 0 {
 			return ft.Extensions[0]
 		}
@@ -18,6 +18,12 @@ func TestGetMediaTypeInfo(t *testing.T) {
 		{"RAW file", FileInfo{SourceName: "photo.cr2"}, RawPicture, RAW, false},
 		{"MP4 video", FileInfo{SourceName: "video.mp4"}, Video, MP4, false},
 		{"Raw video", FileInfo{SourceName: "footage.braw"}, RawVideo, RAWVIDEO, false},
+		{"WAV audio", FileInfo{SourceName: "ZOOM0001_LR.WAV"}, Audio, WAV, false},
+		{"BWF audio", FileInfo{SourceName: "take.bwf"}, Audio, WAV, false},
+		{"AIFF audio", FileInfo{SourceName: "take.aif"}, Audio, AIFF, false},
+		{"MP3 audio", FileInfo{SourceName: "memo.mp3"}, Audio, MP3, false},
+		{"FLAC audio", FileInfo{SourceName: "take.flac"}, Audio, FLAC, false},
+		{"M4A audio", FileInfo{SourceName: "memo.m4a"}, Audio, M4A, false},
 
 		// Test different extensions for the same type
 		{"JPEG alternate extension", FileInfo{SourceName: "photo.jpeg"}, ProcessedPicture, JPEG, false},
@@ -46,7 +52,7 @@ func TestGetMediaTypeInfo(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			cat, fileType := getMediaTypeInfo(tc.input)
+			cat, fileType := builtinMediaTypes.getMediaTypeInfo(tc.input)
 
 			if tc.shouldBeEmpty {
 				if cat != "" || fileType != "" {
@@ -64,7 +70,7 @@ func TestGetMediaTypeInfo(t *testing.T) {
 	}
 }
 
-// TestFileTypesCompleteness checks if all FileType constants are included in the fileTypes slice
+// TestFileTypesCompleteness checks if all FileType constants are included in the builtinFileTypes slice
 func TestFileTypesCompleteness(t *testing.T) {
 	allFileTypes := []FileType{
 		JPEG, JPEG2000, JPEGXL, PNG, GIF, BMP, TIFF, PSD, EPS, SVG, ICO, WEBP, HEIF,
@@ -75,21 +81,21 @@ func TestFileTypesCompleteness(t *testing.T) {
 
 	for _, fileType := range allFileTypes {
 		found := false
-		for _, ft := range fileTypes {
+		for _, ft := range builtinFileTypes {
 			if ft.FileType == fileType {
 				found = true
 				break
 			}
 		}
 		if !found {
-			t.Errorf("FileType %v is not included in the fileTypes slice", fileType)
+			t.Errorf("FileType %v is not included in the builtinFileTypes slice", fileType)
 		}
 	}
 }
 
 // TestJPEGDefaultExtension checks if "jpg" is the default extension for JPEG files
 func TestJPEGDefaultExtension(t *testing.T) {
-	jpegExtension := getFirstExtensionForFileType(JPEG)
+	jpegExtension := builtinMediaTypes.getFirstExtensionForFileType(JPEG)
 	if jpegExtension != "jpg" {
 		t.Errorf("Expected 'jpg' to be the default extension for JPEG files, but got '%s'", jpegExtension)
 	}
@@ -110,7 +116,7 @@ func TestGetFirstExtensionForFileType(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(string(tc.fileType), func(t *testing.T) {
-			extension := getFirstExtensionForFileType(tc.fileType)
+			extension := builtinMediaTypes.getFirstExtensionForFileType(tc.fileType)
 			if extension != tc.expectedExtension {
 				t.Errorf("Expected extension %s for %s, but got %s", tc.expectedExtension, tc.fileType, extension)
 			}
@@ -127,7 +133,7 @@ func TestIsSidecarExtension(t *testing.T) {
 		{"ctg", true},
 		{"xmp", true},
 		{"aae", true},
-		{"lrf", true},
+		{"lrf", false},
 		{"srt", true},
 		{"jpg", false},
 		{"mp4", false},
@@ -137,7 +143,7 @@ func TestIsSidecarExtension(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.ext, func(t *testing.T) {
-			if got := isSidecarExtension(tt.ext); got != tt.expected {
+			if got := builtinMediaTypes.isSidecarExtension(tt.ext); got != tt.expected {
 				t.Errorf("isSidecarExtension(%q) = %v, want %v", tt.ext, got, tt.expected)
 			}
 		})
@@ -162,7 +168,7 @@ func TestGetSidecarAction(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := getSidecarAction(tt.ext, tt.overrides, tt.defAction)
+			got := builtinMediaTypes.getSidecarAction(tt.ext, tt.overrides, tt.defAction)
 			if got != tt.expected {
 				t.Errorf("getSidecarAction(%q, ...) = %q, want %q", tt.ext, got, tt.expected)
 			}
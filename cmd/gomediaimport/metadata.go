@@ -1,9 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
-	"os"
+	"io"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -41,9 +43,24 @@ type VideoMetadata struct {
 	Warnings                []string
 }
 
+// ImageMetadata stores the subset of decoded still image metadata that is
+// used for burst and bracket grouping, sub-second naming, geotagging, and
+// thumbnails.
+type ImageMetadata struct {
+	SubSecond      time.Duration
+	SequenceNumber int
+	Bracketed      bool
+	GPSLatitude    *float64
+	GPSLongitude   *float64
+	Make           string
+	Model          string
+	Orientation    int // EXIF orientation, 0 if not recorded
+}
+
 type mediaMetadata struct {
 	CreationDateTime time.Time
 	VideoMetadata    *VideoMetadata
+	ImageMetadata    *ImageMetadata
 }
 
 // resolveImageFormat maps a FileInfo to the bep/imagemeta ImageFormat.
@@ -52,7 +69,8 @@ func resolveImageFormat(fileInfo FileInfo) (imagemeta.ImageFormat, bool) {
 	switch fileInfo.MediaCategory {
 	case ProcessedPicture:
 		switch fileInfo.FileType {
-		case JPEG:
+		case JPEG, INSP:
+			// Insta360 INSP photos are JPEG files
 			return imagemeta.JPEG, true
 		case TIFF:
 			return imagemeta.TIFF, true
@@ -66,7 +84,7 @@ func resolveImageFormat(fileInfo FileInfo) (imagemeta.ImageFormat, bool) {
 			return 0, false
 		}
 	case RawPicture:
-		ext := strings.ToLower(filepath.Ext(fileInfo.SourceName))
+		ext := strings.ToLower(mediaExtension(fileInfo))
 		if ext != "" {
 			ext = ext[1:]
 		}
@@ -89,12 +107,12 @@ func resolveImageFormat(fileInfo FileInfo) (imagemeta.ImageFormat, bool) {
 	}
 }
 
-// extractImageDateTime opens an image file and extracts the creation date/time
-// from EXIF or XMP metadata using the bep/imagemeta library.
-func extractImageDateTime(filePath string, format imagemeta.ImageFormat) (time.Time, error) {
-	file, err := os.Open(filePath)
+// decodeImageTags opens an image file and decodes its EXIF and XMP tags using
+// the bep/imagemeta library.
+func decodeImageTags(src mediaSource, filePath string, format imagemeta.ImageFormat) (imagemeta.Tags, error) {
+	file, err := openSeekable(src, filePath)
 	if err != nil {
-		return time.Time{}, fmt.Errorf("error opening file: %v", err)
+		return imagemeta.Tags{}, fmt.Errorf("error opening file: %v", err)
 	}
 	defer func() { _ = file.Close() }()
 
@@ -113,18 +131,201 @@ func extractImageDateTime(filePath string, format imagemeta.ImageFormat) (time.T
 		},
 	})
 	if err != nil {
-		return time.Time{}, fmt.Errorf("error decoding metadata: %v", err)
+		return imagemeta.Tags{}, fmt.Errorf("error decoding metadata: %v", err)
 	}
 
-	t, err := tags.GetDateTime()
+	// imagemeta decodes SubSecTimeOriginal as an integer, which loses the
+	// leading zeros that give its digits their place
+	if tag, ok := tags.EXIF()["SubSecTimeOriginal"]; ok {
+		if size, err := file.Seek(0, io.SeekEnd); err == nil {
+			if raw, ok := rawSubSecond(asReaderAt(file), size); ok {
+				tag.Value = raw
+				tags.Add(tag)
+			}
+		}
+	}
+
+	return tags, nil
+}
+
+// rawSubSecond reads SubSecTimeOriginal as the ASCII digits it is stored as
+// from the EXIF of a JPEG or TIFF-based file
+func rawSubSecond(r io.ReaderAt, size int64) (string, bool) {
+	head, err := readAtFull(r, 0, 2)
+	if err != nil {
+		return "", false
+	}
+	if head[0] == 0xFF && head[1] == 0xD8 {
+		block, _, _, err := jpegEXIFSegment(r, size)
+		if err != nil || block == nil {
+			return "", false
+		}
+		r = bytes.NewReader(block)
+	}
+	order, ifd0Offset, err := readTIFFHeader(r, 0)
+	if err != nil {
+		return "", false
+	}
+	ifd0, _, err := readTIFFIFD(r, 0, order, ifd0Offset)
+	if err != nil {
+		return "", false
+	}
+	pointer, ok := findTIFFEntry(ifd0, tiffTagExifIFD)
+	if !ok {
+		return "", false
+	}
+	exif, _, err := readTIFFIFD(r, 0, order, order.Uint32(pointer.Value[:]))
 	if err != nil {
-		return time.Time{}, fmt.Errorf("no valid date found in image metadata")
+		return "", false
+	}
+	e, ok := findTIFFEntry(exif, exifTagSubSecTimeOrig)
+	if !ok || e.Type != tiffTypeASCII || e.Count == 0 || e.Count > 16 {
+		return "", false
 	}
+	raw := e.Value[:min(e.Count, 4)]
+	if e.Count > 4 {
+		if raw, err = readAtFull(r, int64(order.Uint32(e.Value[:])), int(e.Count)); err != nil {
+			return "", false
+		}
+	}
+	return strings.TrimRight(string(raw), "\x00 "), true
+}
 
-	return t, nil
+// imageMetadataFromTags collects sub-second time and burst/bracket markers.
+// SequenceNumber and BracketMode are read when the decoder exposes them; an
+// EXIF ExposureMode of auto bracket also marks a frame as bracketed.
+func imageMetadataFromTags(tags imagemeta.Tags) *ImageMetadata {
+	all := tags.All()
+	imageMetadata := &ImageMetadata{}
+
+	if tag, ok := all["SubSecTimeOriginal"]; ok {
+		if value, ok := tag.Value.(string); ok {
+			imageMetadata.SubSecond = parseSubSecond(value)
+		} else {
+			imageMetadata.SubSecond = decodedSubSecond(tag.Value)
+		}
+	}
+	if tag, ok := all["SequenceNumber"]; ok {
+		if n, ok := imageTagInt(tag.Value); ok && n > 0 {
+			imageMetadata.SequenceNumber = n
+		}
+	}
+	if tag, ok := all["BracketMode"]; ok {
+		if n, ok := imageTagInt(tag.Value); ok {
+			imageMetadata.Bracketed = n != 0
+		} else {
+			value := strings.TrimSpace(fmt.Sprint(tag.Value))
+			imageMetadata.Bracketed = value != "" && !strings.EqualFold(value, "off")
+		}
+	}
+	if tag, ok := all["ExposureMode"]; ok {
+		if n, ok := imageTagInt(tag.Value); ok && n == exifExposureModeAutoBracket {
+			imageMetadata.Bracketed = true
+		}
+	}
+	if tag, ok := all["Make"]; ok {
+		imageMetadata.Make = strings.TrimSpace(fmt.Sprint(tag.Value))
+	}
+	if tag, ok := all["Model"]; ok {
+		imageMetadata.Model = strings.TrimSpace(fmt.Sprint(tag.Value))
+	}
+	if tag, ok := all["Orientation"]; ok {
+		if n, ok := imageTagInt(tag.Value); ok && n >= 1 && n <= 8 {
+			imageMetadata.Orientation = n
+		}
+	}
+	// GetLatLong reports a missing position as 0, 0
+	if _, ok := all["GPSLatitude"]; ok {
+		if lat, long, err := tags.GetLatLong(); err == nil {
+			imageMetadata.GPSLatitude = &lat
+			imageMetadata.GPSLongitude = &long
+		}
+	}
+
+	return imageMetadata
+}
+
+// exifExposureModeAutoBracket is the EXIF ExposureMode value for auto bracketing
+const exifExposureModeAutoBracket = 2
+
+// parseSubSecond converts an EXIF SubSecTime value, which holds the decimal
+// digits of a fraction of a second, into a duration
+func parseSubSecond(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" || len(value) > 9 {
+		return 0
+	}
+	var nanos int64
+	for i := 0; i < 9; i++ {
+		nanos *= 10
+		if i < len(value) {
+			if value[i] < '0' || value[i] > '9' {
+				return 0
+			}
+			nanos += int64(value[i] - '0')
+		}
+	}
+	return time.Duration(nanos)
+}
+
+// decodedSubSecond converts SubSecTimeOriginal as imagemeta decodes it when
+// the stored digits cannot be read. Without its leading zeros, only a value
+// of three digits, milliseconds as most cameras write them, is unambiguous;
+// anything else is ignored.
+func decodedSubSecond(v any) time.Duration {
+	n, ok := imageTagInt(v)
+	if !ok || n < 100 || n > 999 {
+		return 0
+	}
+	return time.Duration(n) * time.Millisecond
 }
 
-func extractMetadata(fileInfo FileInfo) (mediaMetadata, error) {
+func imageTagInt(v any) (int, bool) {
+	switch n := v.(type) {
+	case int:
+		return n, true
+	case int8:
+		return int(n), true
+	case int16:
+		return int(n), true
+	case int32:
+		return int(n), true
+	case int64:
+		return int(n), true
+	case uint8:
+		return int(n), true
+	case uint16:
+		return int(n), true
+	case uint32:
+		return int(n), true
+	case uint64:
+		return int(n), true
+	case string:
+		parsed, err := strconv.Atoi(strings.TrimSpace(n))
+		return parsed, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// extractImageMetadata reads the EXIF capture time of a picture
+func extractImageMetadata(src mediaSource, filePath string, imgFormat imagemeta.ImageFormat) (mediaMetadata, error) {
+	tags, err := decodeImageTags(src, filePath, imgFormat)
+	if err != nil {
+		return mediaMetadata{}, err
+	}
+	t, err := tags.GetDateTime()
+	if err != nil || t.IsZero() {
+		return mediaMetadata{}, fmt.Errorf("no valid date found in image metadata")
+	}
+	imageMetadata := imageMetadataFromTags(tags)
+	return mediaMetadata{CreationDateTime: t.Add(imageMetadata.SubSecond), ImageMetadata: imageMetadata}, nil
+}
+
+func extractMetadata(src mediaSource, fileInfo FileInfo) (mediaMetadata, error) {
+	if fileInfo.MetadataParser != "" {
+		return extractCustomMetadata(src, fileInfo, fileInfo.MetadataParser)
+	}
 	switch fileInfo.MediaCategory {
 	case Sidecar:
 		return mediaMetadata{}, fmt.Errorf("sidecar files do not have embedded metadata")
@@ -134,19 +335,19 @@ func extractMetadata(fileInfo FileInfo) (mediaMetadata, error) {
 		if !supported {
 			return mediaMetadata{}, fmt.Errorf("unsupported format for EXIF: %s", fileInfo.FileType)
 		}
-		t, err := extractImageDateTime(filepath.Join(fileInfo.SourceDir, fileInfo.SourceName), imgFormat)
-		if err != nil {
-			return mediaMetadata{}, err
-		}
-		return mediaMetadata{CreationDateTime: t}, nil
+		return extractImageMetadata(src, filepath.Join(fileInfo.SourceDir, fileInfo.SourceName), imgFormat)
 
 	case Video:
 		filePath := filepath.Join(fileInfo.SourceDir, fileInfo.SourceName)
-		return extractVideoMetadata(filePath, fileInfo.FileType, fileInfo.CreationDateTime)
+		return extractVideoMetadata(src, filePath, fileInfo.FileType, fileInfo.CreationDateTime)
 
 	case RawVideo:
 		return mediaMetadata{}, fmt.Errorf("raw video formats do not use ISO BMFF containers")
 
+	case Audio:
+		filePath := filepath.Join(fileInfo.SourceDir, fileInfo.SourceName)
+		return extractAudioMetadata(src, filePath, fileInfo.FileType, fileInfo.CreationDateTime)
+
 	default:
 		return mediaMetadata{}, fmt.Errorf("unsupported media category: %v", fileInfo.MediaCategory)
 	}
@@ -160,9 +361,10 @@ var isoBaseMediaFileTypes = map[FileType]bool{
 	M4V:     true,
 	THREEGP: true,
 	THREEG2: true,
+	INSV:    true, // Insta360 INSV videos are MP4 files
 }
 
-func extractVideoMetadata(filePath string, fileType FileType, fallbackTime time.Time) (mediaMetadata, error) {
+func extractVideoMetadata(src mediaSource, filePath string, fileType FileType, fallbackTime time.Time) (mediaMetadata, error) {
 	videoMetadata := &VideoMetadata{
 		ChosenTimestamp: fallbackTime,
 	}
@@ -176,7 +378,7 @@ func extractVideoMetadata(filePath string, fileType FileType, fallbackTime time.
 		}, nil
 	}
 
-	file, err := os.Open(filePath)
+	file, err := openSeekable(src, filePath)
 	if err != nil {
 		videoMetadata.TimestampSource = videoTimestampSourceFallback
 		videoMetadata.TimestampFallbackReason = videoTimestampFallbackDecodeError
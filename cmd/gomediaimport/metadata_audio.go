@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Broadcast Wave (EBU Tech 3285) files record when they were made in the bext
+// chunk, and field recorders repeat it in the iXML chunk. Both hold local
+// time without a zone, like EXIF.
+
+const (
+	// bextOriginationOffset is where OriginationDate follows the
+	// Description, Originator and OriginatorReference fields of bext
+	bextOriginationOffset = 256 + 32 + 32
+	bextOriginationSize   = 10 + 8
+
+	// riffChunkLimit bounds the size of a bext or iXML chunk that is read
+	riffChunkLimit = 1 << 20
+)
+
+var errNoAudioDateTime = errors.New("no BWF origination date found")
+
+// extractAudioMetadata reads the capture time of an audio recording. WAV files
+// use their BWF bext or iXML origination date; M4A files use the QuickTime
+// creation time. Other formats have no dependable recording time.
+func extractAudioMetadata(src mediaSource, filePath string, fileType FileType, fallbackTime time.Time) (mediaMetadata, error) {
+	switch fileType {
+	case WAV:
+		file, err := openSeekable(src, filePath)
+		if err != nil {
+			return mediaMetadata{}, err
+		}
+		defer func() { _ = file.Close() }()
+		t, err := readBWFOrigination(file)
+		if err != nil {
+			return mediaMetadata{}, err
+		}
+		return mediaMetadata{CreationDateTime: t}, nil
+
+	case M4A:
+		decoded, err := extractVideoMetadata(src, filePath, MP4, fallbackTime)
+		if err != nil {
+			return mediaMetadata{}, err
+		}
+		if decoded.VideoMetadata.TimestampSource == videoTimestampSourceFallback {
+			return mediaMetadata{}, fmt.Errorf("no creation time in M4A file: %s", decoded.VideoMetadata.TimestampFallbackReason)
+		}
+		return mediaMetadata{CreationDateTime: decoded.CreationDateTime}, nil
+
+	default:
+		return mediaMetadata{}, fmt.Errorf("no embedded recording time in %s files", fileType)
+	}
+}
+
+// readBWFOrigination walks the chunks of a RIFF or RF64 WAVE file and returns
+// the bext origination date and time, or the iXML copy of it when bext is
+// missing or blank.
+func readBWFOrigination(r io.ReadSeeker) (time.Time, error) {
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return time.Time{}, fmt.Errorf("error reading WAVE header: %w", err)
+	}
+	form := string(header[0:4])
+	if (form != "RIFF" && form != "RF64" && form != "BW64") || string(header[8:12]) != "WAVE" {
+		return time.Time{}, fmt.Errorf("not a WAVE file")
+	}
+
+	var ixmlTime time.Time
+	var ds64DataSize int64 = -1
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			return time.Time{}, fmt.Errorf("error reading WAVE chunk: %w", err)
+		}
+		id := string(chunk[0:4])
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+		if id == "data" && size == 0xFFFFFFFF && ds64DataSize >= 0 {
+			// RF64 keeps the real size of large data chunks in ds64
+			size = ds64DataSize
+		}
+
+		switch id {
+		case "ds64", "bext", "iXML":
+			if size > riffChunkLimit {
+				return time.Time{}, fmt.Errorf("%s chunk too large: %d bytes", id, size)
+			}
+			data := make([]byte, size)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return time.Time{}, fmt.Errorf("error reading %s chunk: %w", id, err)
+			}
+			switch id {
+			case "ds64":
+				if len(data) >= 16 {
+					ds64DataSize = int64(binary.LittleEndian.Uint64(data[8:16]))
+				}
+			case "bext":
+				if len(data) >= bextOriginationOffset+bextOriginationSize {
+					origination := data[bextOriginationOffset : bextOriginationOffset+bextOriginationSize]
+					if t, ok := parseBWFDateTime(string(origination[:10]), string(origination[10:])); ok {
+						return t, nil
+					}
+				}
+			case "iXML":
+				if t, ok := parseIXMLOrigination(data); ok {
+					ixmlTime = t
+				}
+			}
+		default:
+			if _, err := r.Seek(size, io.SeekCurrent); err != nil {
+				return time.Time{}, fmt.Errorf("error skipping %s chunk: %w", id, err)
+			}
+		}
+		// Chunks are padded to an even size
+		if size%2 == 1 {
+			if _, err := r.Seek(1, io.SeekCurrent); err != nil {
+				return time.Time{}, fmt.Errorf("error skipping chunk padding: %w", err)
+			}
+		}
+	}
+
+	if ixmlTime.IsZero() {
+		return time.Time{}, errNoAudioDateTime
+	}
+	return ixmlTime, nil
+}
+
+// ixmlDocument is the part of an iXML chunk that mirrors bext
+type ixmlDocument struct {
+	BEXT struct {
+		OriginationDate string `xml:"BWF_ORIGINATION_DATE"`
+		OriginationTime string `xml:"BWF_ORIGINATION_TIME"`
+	} `xml:"BEXT"`
+}
+
+func parseIXMLOrigination(data []byte) (time.Time, bool) {
+	var doc ixmlDocument
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return time.Time{}, false
+	}
+	return parseBWFDateTime(doc.BEXT.OriginationDate, doc.BEXT.OriginationTime)
+}
+
+// parseBWFDateTime parses a yyyy-mm-dd date and hh:mm:ss time. The standard
+// allows any separator, and recorders use '-', ':', '/', '.' and ' '.
+func parseBWFDateTime(date, clock string) (time.Time, bool) {
+	digits := func(s string, n int) (string, bool) {
+		var b strings.Builder
+		for i := 0; i < len(s); i++ {
+			if isASCIIDigit(s[i]) {
+				b.WriteByte(s[i])
+			}
+		}
+		return b.String(), b.Len() == n
+	}
+	d, ok := digits(strings.TrimRight(date, "\x00"), 8)
+	if !ok {
+		return time.Time{}, false
+	}
+	c, ok := digits(strings.TrimRight(clock, "\x00"), 6)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation("20060102150405", d+c, time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
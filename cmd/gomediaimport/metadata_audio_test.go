@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// wavChunk encodes a RIFF chunk with its padding byte
+func wavChunk(id string, data []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(id)
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(len(data)))
+	buf.Write(data)
+	if len(data)%2 == 1 {
+		buf.WriteByte(0)
+	}
+	return buf.Bytes()
+}
+
+// bextChunk returns bext data with the given origination date and time
+func bextChunk(date, clock string) []byte {
+	data := make([]byte, 602)
+	copy(data[256:], "ZOOM F6")
+	copy(data[bextOriginationOffset:], date)
+	copy(data[bextOriginationOffset+10:], clock)
+	return data
+}
+
+func buildWAV(form string, chunks ...[]byte) []byte {
+	var body bytes.Buffer
+	body.WriteString("WAVE")
+	for _, chunk := range chunks {
+		body.Write(chunk)
+	}
+	var buf bytes.Buffer
+	buf.WriteString(form)
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(body.Len()))
+	buf.Write(body.Bytes())
+	return buf.Bytes()
+}
+
+func TestReadBWFOrigination(t *testing.T) {
+	want := time.Date(2024, 6, 15, 10, 30, 5, 0, time.Local)
+	fmtChunk := wavChunk("fmt ", make([]byte, 16))
+	ixml := []byte(`<?xml version="1.0" encoding="UTF-8"?><BWFXML><BEXT><BWF_ORIGINATION_DATE>2024-06-15</BWF_ORIGINATION_DATE><BWF_ORIGINATION_TIME>10:30:05</BWF_ORIGINATION_TIME></BEXT></BWFXML>`)
+
+	// RF64 data chunks carry their size in ds64
+	ds64 := make([]byte, 28)
+	binary.LittleEndian.PutUint64(ds64[8:16], 3)
+	rf64Data := append([]byte("data"), 0xFF, 0xFF, 0xFF, 0xFF, 1, 2, 3, 0)
+
+	tests := []struct {
+		name string
+		data []byte
+		want time.Time
+		err  bool
+	}{
+		{"bext", buildWAV("RIFF", fmtChunk, wavChunk("bext", bextChunk("2024-06-15", "10:30:05")), wavChunk("data", []byte{1, 2, 3})), want, false},
+		{"bext with other separators", buildWAV("RIFF", wavChunk("bext", bextChunk("2024:06:15", "10.30.05"))), want, false},
+		{"iXML after data", buildWAV("RIFF", fmtChunk, wavChunk("data", []byte{1, 2, 3}), wavChunk("iXML", ixml)), want, false},
+		{"blank bext falls back to iXML", buildWAV("RIFF", wavChunk("bext", bextChunk("", "")), wavChunk("iXML", ixml)), want, false},
+		{"RF64", buildWAV("RF64", wavChunk("ds64", ds64), fmtChunk, rf64Data, wavChunk("iXML", ixml)), want, false},
+		{"no origination", buildWAV("RIFF", fmtChunk, wavChunk("data", []byte{1, 2})), time.Time{}, true},
+		{"not a WAVE file", []byte("ID3\x03\x00\x00\x00\x00\x00\x00\x00\x00"), time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readBWFOrigination(bytes.NewReader(tt.data))
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readBWFOrigination failed: %v", err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadBWFOriginationWithoutDate(t *testing.T) {
+	_, err := readBWFOrigination(bytes.NewReader(buildWAV("RIFF", wavChunk("fmt ", make([]byte, 16)))))
+	if !errors.Is(err, errNoAudioDateTime) {
+		t.Errorf("expected errNoAudioDateTime, got %v", err)
+	}
+}
+
+func TestImportMediaAudio(t *testing.T) {
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+	files := map[string][]byte{
+		"ZOOM0001_LR.WAV": buildWAV("RIFF", wavChunk("bext", bextChunk("2023-11-02", "18:45:00")), wavChunk("data", []byte{1, 2, 3, 4})),
+		"memo.mp3":        []byte("ID3 memo"),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(sourceDir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	march := time.Date(2024, 3, 1, 9, 0, 0, 0, time.Local)
+	if err := os.Chtimes(filepath.Join(sourceDir, "memo.mp3"), march, march); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config{
+		SourceDir:        sourceDir,
+		DestDir:          destDir,
+		OrganizeByDate:   true,
+		RenameByDateTime: true,
+		SidecarDefault:   SidecarDelete,
+	}
+	if err := importMedia(cfg); err != nil {
+		t.Fatalf("importMedia failed: %v", err)
+	}
+	for _, rel := range []string{
+		// Recorded time from the BWF bext chunk
+		"2023/11/20231102_184500.wav",
+		// MP3 has no dependable recording time and keeps the file time
+		"2024/03/20240301_090000.mp3",
+	} {
+		if _, err := os.Stat(filepath.Join(destDir, filepath.FromSlash(rel))); err != nil {
+			t.Errorf("expected %s: %v", rel, err)
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bep/imagemeta"
+)
+
+// metadataEdits is the metadata_edits section of the config file and of a
+// removable volume entry. The edits are written into the embedded metadata
+// of the destination copies; sources are never modified.
+type metadataEdits struct {
+	Artist    string   `yaml:"artist,omitempty"`
+	Copyright string   `yaml:"copyright,omitempty"`
+	Keywords  []string `yaml:"keywords,omitempty"`
+	// ClockOffset corrects a camera clock that was set wrong. It is added to
+	// capture times before files are named and to the dates embedded in the
+	// copies.
+	ClockOffset time.Duration `yaml:"clock_offset,omitempty"`
+	// GPS is written to files that do not already have a position
+	GPS *gpsLocation `yaml:"gps,omitempty"`
+}
+
+// gpsLocation is a fixed position in decimal degrees and meters above sea
+// level
+type gpsLocation struct {
+	Latitude  float64  `yaml:"latitude"`
+	Longitude float64  `yaml:"longitude"`
+	Altitude  *float64 `yaml:"altitude,omitempty"`
+}
+
+// metadataEditFormat is the writer that can edit a file type
+type metadataEditFormat string
+
+const (
+	metadataEditJPEG      metadataEditFormat = "jpeg"
+	metadataEditTIFF      metadataEditFormat = "tiff"
+	metadataEditQuickTime metadataEditFormat = "quicktime"
+)
+
+// isZero returns true if the edits change nothing
+func (e metadataEdits) isZero() bool {
+	return e.Artist == "" && e.Copyright == "" && len(e.Keywords) == 0 && e.ClockOffset == 0 && e.GPS == nil
+}
+
+// summary lists the edits for the verbose configuration report
+func (e metadataEdits) summary() string {
+	var parts []string
+	if e.Artist != "" {
+		parts = append(parts, fmt.Sprintf("artist %q", e.Artist))
+	}
+	if e.Copyright != "" {
+		parts = append(parts, fmt.Sprintf("copyright %q", e.Copyright))
+	}
+	if len(e.Keywords) > 0 {
+		parts = append(parts, fmt.Sprintf("keywords %s", strings.Join(e.Keywords, ", ")))
+	}
+	if e.ClockOffset != 0 {
+		parts = append(parts, fmt.Sprintf("clock offset %s", e.ClockOffset))
+	}
+	if e.GPS != nil {
+		parts = append(parts, fmt.Sprintf("GPS %s", iso6709(*e.GPS)))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// clockOffsetSeconds returns the clock offset in whole seconds, the
+// resolution of EXIF and QuickTime dates
+func (e metadataEdits) clockOffsetSeconds() int64 {
+	return int64(e.ClockOffset / time.Second)
+}
+
+// mergeMetadataEdits returns base with every field override sets replaced.
+func mergeMetadataEdits(base, override metadataEdits) metadataEdits {
+	if override.Artist != "" {
+		base.Artist = override.Artist
+	}
+	if override.Copyright != "" {
+		base.Copyright = override.Copyright
+	}
+	if len(override.Keywords) > 0 {
+		base.Keywords = override.Keywords
+	}
+	if override.ClockOffset != 0 {
+		base.ClockOffset = override.ClockOffset
+	}
+	if override.GPS != nil {
+		base.GPS = override.GPS
+	}
+	return base
+}
+
+func validateMetadataEdits(e metadataEdits) error {
+	if e.ClockOffset%time.Second != 0 {
+		return fmt.Errorf("clock_offset must be a whole number of seconds, got %s", e.ClockOffset)
+	}
+	for _, keyword := range e.Keywords {
+		if strings.TrimSpace(keyword) == "" {
+			return fmt.Errorf("keywords cannot be empty")
+		}
+		if strings.ContainsAny(keyword, ";,") {
+			return fmt.Errorf("keyword %q cannot contain a comma or semicolon", keyword)
+		}
+	}
+	if e.GPS != nil {
+		if math.IsNaN(e.GPS.Latitude) || e.GPS.Latitude < -90 || e.GPS.Latitude > 90 {
+			return fmt.Errorf("invalid GPS latitude: %v (must be between -90 and 90)", e.GPS.Latitude)
+		}
+		if math.IsNaN(e.GPS.Longitude) || e.GPS.Longitude < -180 || e.GPS.Longitude > 180 {
+			return fmt.Errorf("invalid GPS longitude: %v (must be between -180 and 180)", e.GPS.Longitude)
+		}
+		if e.GPS.Altitude != nil && (math.IsNaN(*e.GPS.Altitude) || math.IsInf(*e.GPS.Altitude, 0)) {
+			return fmt.Errorf("invalid GPS altitude: %v", *e.GPS.Altitude)
+		}
+	}
+	return nil
+}
+
+// metadataEditFormatFor returns the writer for file's type, or "" if its
+// metadata cannot be edited.
+func metadataEditFormatFor(file FileInfo) metadataEditFormat {
+	if parser := file.MetadataParser; parser != "" {
+		switch {
+		case parser == "jpeg":
+			return metadataEditJPEG
+		case parser == "tiff" || parser == "dng":
+			return metadataEditTIFF
+		case parser == metadataParserISOBMFF && file.MediaCategory == Video:
+			return metadataEditQuickTime
+		}
+		return ""
+	}
+	switch file.MediaCategory {
+	case ProcessedPicture, RawPicture:
+		format, ok := resolveImageFormat(file)
+		if !ok {
+			return ""
+		}
+		switch format {
+		case imagemeta.JPEG:
+			return metadataEditJPEG
+		case imagemeta.TIFF, imagemeta.DNG:
+			return metadataEditTIFF
+		}
+	case Video:
+		if isoBaseMediaFileTypes[file.FileType] {
+			return metadataEditQuickTime
+		}
+	}
+	return ""
+}
+
+// prepareMetadataEdits applies the clock offset to every capture time and
+// attaches the edits to the files whose copies can be edited.
+func prepareMetadataEdits(files []FileInfo, edits metadataEdits) {
+	if edits.isZero() {
+		return
+	}
+	for i := range files {
+		file := &files[i]
+		if edits.ClockOffset != 0 && !file.CreationDateTime.IsZero() {
+			file.CreationDateTime = file.CreationDateTime.Add(edits.ClockOffset)
+		}
+		if metadataEditFormatFor(*file) != "" {
+			file.Edits = &edits
+		}
+	}
+}
+
+// metadataSplice replaces the bytes in [Start, End) with Data
+type metadataSplice struct {
+	Start, End int64
+	Data       []byte
+}
+
+// planMetadataEdits returns the splices that apply edits to the file of the
+// given format read from r.
+func planMetadataEdits(r io.ReaderAt, size int64, format metadataEditFormat, edits metadataEdits) ([]metadataSplice, error) {
+	var splices []metadataSplice
+	var err error
+	switch format {
+	case metadataEditJPEG:
+		splices, err = planJPEGEdits(r, size, edits)
+	case metadataEditTIFF:
+		splices, err = planTIFFEdits(r, 0, size, edits)
+	case metadataEditQuickTime:
+		splices, err = planQuickTimeEdits(r, size, edits)
+	default:
+		return nil, fmt.Errorf("metadata of %s files cannot be edited", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(splices, func(a, b int) bool { return splices[a].Start < splices[b].Start })
+	return splices, nil
+}
+
+// spliceDelta returns how much the splices grow the file
+func spliceDelta(splices []metadataSplice) int64 {
+	var delta int64
+	for _, s := range splices {
+		delta += int64(len(s.Data)) - (s.End - s.Start)
+	}
+	return delta
+}
+
+// splicedReader returns the content of r with the sorted splices applied
+func splicedReader(r io.ReaderAt, size int64, splices []metadataSplice) io.Reader {
+	var parts []io.Reader
+	var pos int64
+	for _, s := range splices {
+		parts = append(parts, io.NewSectionReader(r, pos, s.Start-pos), bytes.NewReader(s.Data))
+		pos = s.End
+	}
+	parts = append(parts, io.NewSectionReader(r, pos, size-pos))
+	return io.MultiReader(parts...)
+}
+
+// seekReaderAt adapts a source that can only seek for the writers, which
+// read at random offsets
+type seekReaderAt struct {
+	r io.ReadSeeker
+}
+
+func (s seekReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if _, err := s.r.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.ReadFull(s.r, p)
+}
+
+func asReaderAt(r io.ReadSeeker) io.ReaderAt {
+	if ra, ok := r.(io.ReaderAt); ok {
+		return ra
+	}
+	return seekReaderAt{r}
+}
+
+// planSourceEdits plans file's edits against its source. Files whose edits
+// cannot be planned are copied unedited, so they get no splices.
+func planSourceEdits(src mediaSource, file *FileInfo) ([]metadataSplice, io.ReadSeekCloser, error) {
+	r, err := openSeekable(src, sourcePath(*file))
+	if err != nil {
+		return nil, nil, err
+	}
+	splices, err := planMetadataEdits(asReaderAt(r), file.Size, metadataEditFormatFor(*file), *file.Edits)
+	if err != nil {
+		return nil, r, nil
+	}
+	return splices, r, nil
+}
+
+// destinationSize returns the size file's destination copy has once its
+// metadata edits are applied.
+func destinationSize(src mediaSource, file *FileInfo) (int64, error) {
+	if file.Edits == nil {
+		return file.Size, nil
+	}
+	if file.EditedSize != 0 {
+		return file.EditedSize, nil
+	}
+	splices, r, err := planSourceEdits(src, file)
+	if err != nil {
+		return 0, err
+	}
+	_ = r.Close()
+	file.EditedSize = file.Size + spliceDelta(splices)
+	return file.EditedSize, nil
+}
+
+// destinationChecksum returns the xxHash64 checksum of file's destination
+// copy once its metadata edits are applied.
+func destinationChecksum(src mediaSource, file *FileInfo) (string, error) {
+	if file.Edits == nil {
+		return sourceChecksum(src, file)
+	}
+	if file.EditedChecksum != "" {
+		return file.EditedChecksum, nil
+	}
+	splices, r, err := planSourceEdits(src, file)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = r.Close() }()
+	if len(splices) == 0 {
+		return sourceChecksum(src, file)
+	}
+	checksum, err := hashReader(splicedReader(asReaderAt(r), file.Size, splices))
+	if err != nil {
+		return "", fmt.Errorf("error reading %s: %w", sourcePath(*file), err)
+	}
+	file.EditedSize = file.Size + spliceDelta(splices)
+	file.EditedChecksum = checksum
+	return checksum, nil
+}
+
+// applyMetadataEdits rewrites the embedded metadata of the destination copy
+// at path. The edited file is written next to it and renamed into place, so
+// the copy is either fully edited or left as it was.
+func applyMetadataEdits(path string, file FileInfo) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = f.Close() }()
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	splices, err := planMetadataEdits(f, info.Size(), metadataEditFormatFor(file), *file.Edits)
+	if err != nil || len(splices) == 0 {
+		return false, err
+	}
+
+	tempPath := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".gomediaimport-partial")
+	temp, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return false, err
+	}
+	_, err = io.Copy(temp, splicedReader(f, info.Size(), splices))
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// Windows cannot replace a file that is still open
+		err = f.Close()
+	}
+	if err == nil {
+		err = os.Rename(tempPath, path)
+	}
+	if err != nil {
+		_ = os.Remove(tempPath)
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// TIFF and EXIF tags the metadata writer reads or writes
+const (
+	tiffTagDateTime          = 0x0132
+	tiffTagArtist            = 0x013B
+	tiffTagCopyright         = 0x8298
+	tiffTagExifIFD           = 0x8769
+	tiffTagGPSIFD            = 0x8825
+	tiffTagXPKeywords        = 0x9C9E
+	exifTagDateTimeOriginal  = 0x9003
+	exifTagDateTimeDigitized = 0x9004
+	exifTagSubSecTimeOrig    = 0x9291
+)
+
+// TIFF field types
+const (
+	tiffTypeByte     = 1
+	tiffTypeASCII    = 2
+	tiffTypeLong     = 4
+	tiffTypeRational = 5
+)
+
+const exifDateLayout = "2006:01:02 15:04:05"
+
+// tiffByteOrder is the byte order of a TIFF block, used to read and append
+type tiffByteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// maxJPEGSegmentData is the most an APP1 segment can hold after its length
+// field and "Exif\0\0" identifier
+const maxJPEGSegmentData = math.MaxUint16 - 2 - 6
+
+// tiffEntry is an IFD entry. Entries read from a file keep their inline
+// value or data offset in Value; new entries carry their data in Data.
+type tiffEntry struct {
+	Tag, Type uint16
+	Count     uint32
+	Value     [4]byte
+	Data      []byte
+}
+
+func readAtFull(r io.ReaderAt, off int64, n int) ([]byte, error) {
+	buf := make([]byte, n)
+	read, err := r.ReadAt(buf, off)
+	if read == n {
+		return buf, nil
+	}
+	if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return nil, err
+}
+
+// readTIFFIFD reads the IFD at offset of the TIFF block starting at base
+func readTIFFIFD(r io.ReaderAt, base int64, order tiffByteOrder, offset uint32) ([]tiffEntry, uint32, error) {
+	countBytes, err := readAtFull(r, base+int64(offset), 2)
+	if err != nil {
+		return nil, 0, fmt.Errorf("reading IFD at %d: %w", offset, err)
+	}
+	count := int(order.Uint16(countBytes))
+	data, err := readAtFull(r, base+int64(offset)+2, 12*count+4)
+	if err != nil {
+		return nil, 0, fmt.Errorf("reading IFD at %d: %w", offset, err)
+	}
+	entries := make([]tiffEntry, count)
+	for i := range entries {
+		raw := data[12*i:]
+		entries[i] = tiffEntry{Tag: order.Uint16(raw), Type: order.Uint16(raw[2:]), Count: order.Uint32(raw[4:])}
+		copy(entries[i].Value[:], raw[8:12])
+	}
+	return entries, order.Uint32(data[12*count:]), nil
+}
+
+// encodeTIFFIFD encodes entries as an IFD at offset, followed by the data of
+// new entries that does not fit inline.
+func encodeTIFFIFD(order tiffByteOrder, entries []tiffEntry, offset, next uint32) []byte {
+	valuesAt := offset + 2 + 12*uint32(len(entries)) + 4
+	var ifd, values []byte
+	ifd = order.AppendUint16(ifd, uint16(len(entries)))
+	for _, e := range entries {
+		ifd = order.AppendUint16(ifd, e.Tag)
+		ifd = order.AppendUint16(ifd, e.Type)
+		ifd = order.AppendUint32(ifd, e.Count)
+		switch {
+		case e.Data == nil:
+			ifd = append(ifd, e.Value[:]...)
+		case len(e.Data) <= 4:
+			ifd = append(ifd, e.Data...)
+			ifd = append(ifd, make([]byte, 4-len(e.Data))...)
+		default:
+			ifd = order.AppendUint32(ifd, valuesAt+uint32(len(values)))
+			values = append(values, e.Data...)
+			if len(values)%2 == 1 {
+				values = append(values, 0)
+			}
+		}
+	}
+	ifd = order.AppendUint32(ifd, next)
+	return append(ifd, values...)
+}
+
+func findTIFFEntry(entries []tiffEntry, tag uint16) (tiffEntry, bool) {
+	for _, e := range entries {
+		if e.Tag == tag {
+			return e, true
+		}
+	}
+	return tiffEntry{}, false
+}
+
+// shiftEXIFDate returns the splice that moves the date of an ASCII date
+// entry by secs, or false if the entry holds no valid date.
+func shiftEXIFDate(r io.ReaderAt, base int64, order tiffByteOrder, e tiffEntry, secs int64) (metadataSplice, bool) {
+	if e.Type != tiffTypeASCII || e.Count < uint32(len(exifDateLayout)) || e.Count <= 4 {
+		return metadataSplice{}, false
+	}
+	offset := base + int64(order.Uint32(e.Value[:]))
+	raw, err := readAtFull(r, offset, len(exifDateLayout))
+	if err != nil {
+		return metadataSplice{}, false
+	}
+	t, err := time.Parse(exifDateLayout, string(raw))
+	if err != nil {
+		return metadataSplice{}, false
+	}
+	shifted := t.Add(time.Duration(secs) * time.Second).Format(exifDateLayout)
+	return metadataSplice{Start: offset, End: offset + int64(len(raw)), Data: []byte(shifted)}, true
+}
+
+func tiffASCIIEntry(tag uint16, value string) tiffEntry {
+	data := append([]byte(value), 0)
+	return tiffEntry{Tag: tag, Type: tiffTypeASCII, Count: uint32(len(data)), Data: data}
+}
+
+// tiffXPKeywordsEntry encodes keywords the way Windows does: UCS-2 little
+// endian, separated by semicolons, regardless of the file's byte order
+func tiffXPKeywordsEntry(keywords []string) tiffEntry {
+	var data []byte
+	for _, unit := range utf16.Encode([]rune(strings.Join(keywords, ";"))) {
+		data = binary.LittleEndian.AppendUint16(data, unit)
+	}
+	data = append(data, 0, 0)
+	return tiffEntry{Tag: tiffTagXPKeywords, Type: tiffTypeByte, Count: uint32(len(data)), Data: data}
+}
+
+// tiffRationals encodes unsigned rationals
+func tiffRationals(order tiffByteOrder, values ...[2]uint32) []byte {
+	var data []byte
+	for _, v := range values {
+		data = order.AppendUint32(data, v[0])
+		data = order.AppendUint32(data, v[1])
+	}
+	return data
+}
+
+// gpsIFDEntries returns the GPS IFD of a position, with coordinates as
+// degrees, minutes and thousandths of seconds
+func gpsIFDEntries(order tiffByteOrder, gps gpsLocation) []tiffEntry {
+	dms := func(decimal float64) []byte {
+		decimal = math.Abs(decimal)
+		degrees := math.Floor(decimal)
+		minutes := math.Floor((decimal - degrees) * 60)
+		seconds := math.Round(((decimal-degrees)*60 - minutes) * 60 * 1000)
+		return tiffRationals(order, [2]uint32{uint32(degrees), 1}, [2]uint32{uint32(minutes), 1}, [2]uint32{uint32(seconds), 1000})
+	}
+	latRef, lonRef := "N", "E"
+	if gps.Latitude < 0 {
+		latRef = "S"
+	}
+	if gps.Longitude < 0 {
+		lonRef = "W"
+	}
+	entries := []tiffEntry{
+		{Tag: 0x0000, Type: tiffTypeByte, Count: 4, Data: []byte{2, 3, 0, 0}},
+		tiffASCIIEntry(0x0001, latRef),
+		{Tag: 0x0002, Type: tiffTypeRational, Count: 3, Data: dms(gps.Latitude)},
+		tiffASCIIEntry(0x0003, lonRef),
+		{Tag: 0x0004, Type: tiffTypeRational, Count: 3, Data: dms(gps.Longitude)},
+	}
+	if gps.Altitude != nil {
+		var ref byte
+		if *gps.Altitude < 0 {
+			ref = 1
+		}
+		altitude := [2]uint32{uint32(math.Round(math.Abs(*gps.Altitude) * 100)), 100}
+		entries = append(entries,
+			tiffEntry{Tag: 0x0005, Type: tiffTypeByte, Count: 1, Data: []byte{ref}},
+			tiffEntry{Tag: 0x0006, Type: tiffTypeRational, Count: 1, Data: tiffRationals(order, altitude)},
+		)
+	}
+	return entries
+}
+
+// readTIFFHeader returns the byte order and IFD0 offset of the TIFF block
+// starting at base
+func readTIFFHeader(r io.ReaderAt, base int64) (tiffByteOrder, uint32, error) {
+	header, err := readAtFull(r, base, 8)
+	if err != nil {
+		return nil, 0, fmt.Errorf("reading TIFF header: %w", err)
+	}
+	var order tiffByteOrder
+	switch string(header[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, 0, fmt.Errorf("not a TIFF file")
+	}
+	switch order.Uint16(header[2:]) {
+	case 42:
+	case 43:
+		return nil, 0, fmt.Errorf("BigTIFF files are not supported")
+	default:
+		return nil, 0, fmt.Errorf("not a TIFF file")
+	}
+	return order, order.Uint32(header[4:]), nil
+}
+
+// planTIFFEdits plans edits of the TIFF block in [base, end) of r. Dates are
+// patched where they are. Other tags go into a copy of IFD0 appended to the
+// block, so no existing data moves and the offsets pointing into it, like
+// those of maker notes and RAW strips, stay valid.
+func planTIFFEdits(r io.ReaderAt, base, end int64, edits metadataEdits) ([]metadataSplice, error) {
+	order, ifd0Offset, err := readTIFFHeader(r, base)
+	if err != nil {
+		return nil, err
+	}
+	ifd0, next, err := readTIFFIFD(r, base, order, ifd0Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	var splices []metadataSplice
+	if secs := edits.clockOffsetSeconds(); secs != 0 {
+		var dates []tiffEntry
+		if e, ok := findTIFFEntry(ifd0, tiffTagDateTime); ok {
+			dates = append(dates, e)
+		}
+		if pointer, ok := findTIFFEntry(ifd0, tiffTagExifIFD); ok {
+			exif, _, err := readTIFFIFD(r, base, order, order.Uint32(pointer.Value[:]))
+			if err != nil {
+				return nil, fmt.Errorf("reading Exif IFD: %w", err)
+			}
+			for _, tag := range []uint16{exifTagDateTimeOriginal, exifTagDateTimeDigitized} {
+				if e, ok := findTIFFEntry(exif, tag); ok {
+					dates = append(dates, e)
+				}
+			}
+		}
+		for _, e := range dates {
+			if splice, ok := shiftEXIFDate(r, base, order, e, secs); ok {
+				splices = append(splices, splice)
+			}
+		}
+	}
+
+	_, hasGPS := findTIFFEntry(ifd0, tiffTagGPSIFD)
+	addGPS := edits.GPS != nil && !hasGPS
+	if edits.Artist == "" && edits.Copyright == "" && len(edits.Keywords) == 0 && !addGPS {
+		return splices, nil
+	}
+
+	replaced := map[uint16]bool{}
+	var added []tiffEntry
+	if edits.Artist != "" {
+		replaced[tiffTagArtist] = true
+		added = append(added, tiffASCIIEntry(tiffTagArtist, edits.Artist))
+	}
+	if edits.Copyright != "" {
+		replaced[tiffTagCopyright] = true
+		added = append(added, tiffASCIIEntry(tiffTagCopyright, edits.Copyright))
+	}
+	if len(edits.Keywords) > 0 {
+		replaced[tiffTagXPKeywords] = true
+		added = append(added, tiffXPKeywordsEntry(edits.Keywords))
+	}
+	entries := make([]tiffEntry, 0, len(ifd0)+len(added)+1)
+	for _, e := range ifd0 {
+		if !replaced[e.Tag] {
+			entries = append(entries, e)
+		}
+	}
+	entries = append(entries, added...)
+	gpsPointer := -1
+	if addGPS {
+		entries = append(entries, tiffEntry{Tag: tiffTagGPSIFD, Type: tiffTypeLong, Count: 1})
+	}
+	sort.SliceStable(entries, func(a, b int) bool { return entries[a].Tag < entries[b].Tag })
+	for i, e := range entries {
+		if addGPS && e.Tag == tiffTagGPSIFD {
+			gpsPointer = i
+		}
+	}
+
+	blockSize := end - base
+	padding := blockSize % 2
+	if blockSize+padding > math.MaxUint32 {
+		return nil, fmt.Errorf("TIFF files over 4 GB are not supported")
+	}
+	ifdOffset := uint32(blockSize + padding)
+	appended := encodeTIFFIFD(order, entries, ifdOffset, next)
+	if gpsPointer >= 0 {
+		gpsOffset := ifdOffset + uint32(len(appended))
+		entries[gpsPointer].Data = order.AppendUint32(nil, gpsOffset)
+		appended = encodeTIFFIFD(order, entries, ifdOffset, next)
+		appended = append(appended, encodeTIFFIFD(order, gpsIFDEntries(order, *edits.GPS), gpsOffset, 0)...)
+	}
+
+	splices = append(splices,
+		metadataSplice{Start: base + 4, End: base + 8, Data: order.AppendUint32(nil, ifdOffset)},
+		metadataSplice{Start: end, End: end, Data: append(make([]byte, padding), appended...)},
+	)
+	return splices, nil
+}
+
+// applySplices returns data with the splices applied
+func applySplices(data []byte, splices []metadataSplice) []byte {
+	sort.Slice(splices, func(a, b int) bool { return splices[a].Start < splices[b].Start })
+	var out []byte
+	var pos int64
+	for _, s := range splices {
+		out = append(out, data[pos:s.Start]...)
+		out = append(out, s.Data...)
+		pos = s.End
+	}
+	return append(out, data[pos:]...)
+}
+
+// planJPEGEdits edits the TIFF block of the Exif APP1 segment, which is
+// rewritten whole. A JPEG without one gets a new segment after SOI and any
+// JFIF header.
+func planJPEGEdits(r io.ReaderAt, size int64, edits metadataEdits) ([]metadataSplice, error) {
+	block, segmentStart, segmentEnd, err := jpegEXIFSegment(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	if block == nil {
+		if edits.Artist == "" && edits.Copyright == "" && len(edits.Keywords) == 0 && edits.GPS == nil {
+			return nil, nil
+		}
+		// An empty IFD0 to add the tags to
+		block = []byte{'I', 'I', 42, 0, 8, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	}
+
+	splices, err := planTIFFEdits(bytes.NewReader(block), 0, int64(len(block)), edits)
+	if err != nil {
+		return nil, err
+	}
+	if len(splices) == 0 {
+		return nil, nil
+	}
+	block = applySplices(block, splices)
+	if len(block) > maxJPEGSegmentData {
+		return nil, fmt.Errorf("edited EXIF data does not fit in a JPEG segment")
+	}
+	segment := []byte{0xFF, 0xE1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(2+6+len(block)))
+	segment = append(segment, "Exif\x00\x00"...)
+	segment = append(segment, block...)
+	return []metadataSplice{{Start: segmentStart, End: segmentEnd, Data: segment}}, nil
+}
+
+// jpegEXIFSegment finds the APP1 Exif segment of a JPEG file and returns its
+// TIFF block and bounds. Without one, the block is nil and both bounds are
+// where a new segment goes: after the JFIF APP0 segment, if any.
+func jpegEXIFSegment(r io.ReaderAt, size int64) ([]byte, int64, int64, error) {
+	soi, err := readAtFull(r, 0, 2)
+	if err != nil || soi[0] != 0xFF || soi[1] != 0xD8 {
+		return nil, 0, 0, fmt.Errorf("not a JPEG file")
+	}
+
+	insertAt := int64(2)
+	var segmentStart, segmentEnd int64
+	var block []byte
+	for pos := int64(2); pos+4 <= size; {
+		header, err := readAtFull(r, pos, 4)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		if header[0] != 0xFF {
+			return nil, 0, 0, fmt.Errorf("invalid JPEG marker at %d", pos)
+		}
+		marker := header[1]
+		if marker == 0xFF {
+			pos++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			pos += 2
+			continue
+		}
+		length := int64(binary.BigEndian.Uint16(header[2:]))
+		next := pos + 2 + length
+		if length < 2 || next > size {
+			return nil, 0, 0, fmt.Errorf("truncated JPEG segment at %d", pos)
+		}
+		if marker == 0xE0 && insertAt == pos {
+			insertAt = next
+		}
+		if marker == 0xE1 && length >= 2+6+8 && block == nil {
+			id, err := readAtFull(r, pos+4, 6)
+			if err != nil {
+				return nil, 0, 0, err
+			}
+			if string(id) == "Exif\x00\x00" {
+				if block, err = readAtFull(r, pos+10, int(length)-8); err != nil {
+					return nil, 0, 0, err
+				}
+				segmentStart, segmentEnd = pos, next
+			}
+		}
+		pos = next
+	}
+
+	if block == nil {
+		return nil, insertAt, insertAt, nil
+	}
+	return block, segmentStart, segmentEnd, nil
+}
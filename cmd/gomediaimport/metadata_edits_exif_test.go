@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bep/imagemeta"
+)
+
+// testEdits is a full set of edits for the writer tests
+func testEdits() metadataEdits {
+	altitude := 12.5
+	return metadataEdits{
+		Artist:      "Sofia Lind",
+		Copyright:   "© 2024 Sofia Lind",
+		Keywords:    []string{"wedding", "archipelago"},
+		ClockOffset: -90 * time.Minute,
+		GPS:         &gpsLocation{Latitude: 60.1699, Longitude: -24.9384, Altitude: &altitude},
+	}
+}
+
+// editFile applies edits to the file at path as if it had just been copied
+func editFile(t *testing.T, path string, fileType FileType, category MediaCategory, edits metadataEdits) {
+	t.Helper()
+	file := FileInfo{SourceName: filepath.Base(path), FileType: fileType, MediaCategory: category, Edits: &edits}
+	edited, err := applyMetadataEdits(path, file)
+	if err != nil {
+		t.Fatalf("applyMetadataEdits failed: %v", err)
+	}
+	if !edited {
+		t.Fatal("applyMetadataEdits left the file unchanged")
+	}
+}
+
+func assertEditedImageTags(t *testing.T, path string, format imagemeta.ImageFormat, wantDate string) {
+	t.Helper()
+	tags, err := decodeImageTags(localSource{}, path, format)
+	if err != nil {
+		t.Fatalf("decodeImageTags failed: %v", err)
+	}
+	exif := tags.EXIF()
+	if wantDate != "" {
+		if got := exif["DateTimeOriginal"].Value; got != wantDate {
+			t.Errorf("DateTimeOriginal = %v, want %s", got, wantDate)
+		}
+	}
+	if got := exif["Artist"].Value; got != "Sofia Lind" {
+		t.Errorf("Artist = %v, want Sofia Lind", got)
+	}
+	if got := exif["Copyright"].Value; got != "© 2024 Sofia Lind" {
+		t.Errorf("Copyright = %v, want © 2024 Sofia Lind", got)
+	}
+	// imagemeta drops the NUL high bytes of the UCS-2 text
+	if got := exif["XPKeywords"].Value; got != "wedding;archipelago" {
+		t.Errorf("XPKeywords = %q, want wedding;archipelago", got)
+	}
+	lat, long, err := tags.GetLatLong()
+	if err != nil || math.Abs(lat-60.1699) > 1e-5 || math.Abs(long+24.9384) > 1e-5 {
+		t.Errorf("GetLatLong = %v, %v, %v, want 60.1699, -24.9384", lat, long, err)
+	}
+}
+
+func TestMetadataEditsJPEGRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "IMG_0001.JPG")
+	writeTestJPEGWithEXIF(t, path,
+		testEXIFASCII(exifTagDateTimeOriginal, "2024:06:15 10:30:00"),
+		testEXIFASCII(exifTagDateTimeDigitized, "2024:06:15 10:30:00"),
+	)
+
+	editFile(t, path, JPEG, ProcessedPicture, testEdits())
+	assertEditedImageTags(t, path, imagemeta.JPEG, "2024:06:15 09:00:00")
+
+	tags, err := decodeImageTags(localSource{}, path, imagemeta.JPEG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tags.EXIF()["CreateDate"].Value; got != "2024:06:15 09:00:00" {
+		t.Errorf("CreateDate = %v, want 2024:06:15 09:00:00", got)
+	}
+	metadata, err := extractImageMetadata(localSource{}, path, imagemeta.JPEG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := metadata.ImageMetadata; m.GPSLatitude == nil || math.Abs(*m.GPSLatitude-60.1699) > 1e-5 || math.Abs(*m.GPSLongitude+24.9384) > 1e-5 {
+		t.Errorf("ImageMetadata position = %v, %v, want 60.1699, -24.9384", m.GPSLatitude, m.GPSLongitude)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(data, []byte{0xFF, 0xD9}) {
+		t.Error("edited JPEG lost its end of image marker")
+	}
+}
+
+func TestMetadataEditsJPEGDateFixInPlace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "IMG_0001.JPG")
+	writeTestJPEGWithEXIF(t, path, testEXIFASCII(exifTagDateTimeOriginal, "2024:12:31 23:30:00"))
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	editFile(t, path, JPEG, ProcessedPicture, metadataEdits{ClockOffset: time.Hour})
+
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(before) {
+		t.Errorf("date fix changed the size from %d to %d", len(before), len(after))
+	}
+	tags, err := decodeImageTags(localSource{}, path, imagemeta.JPEG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tags.EXIF()["DateTimeOriginal"].Value; got != "2025:01:01 00:30:00" {
+		t.Errorf("DateTimeOriginal = %v, want 2025:01:01 00:30:00", got)
+	}
+}
+
+func TestMetadataEditsJPEGWithoutEXIF(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scan.jpg")
+	jfif := []byte{0xFF, 0xD8, 0xFF, 0xE0, 0, 16, 'J', 'F', 'I', 'F', 0, 1, 1, 0, 0, 1, 0, 1, 0, 0, 0xFF, 0xD9}
+	if err := os.WriteFile(path, jfif, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	editFile(t, path, JPEG, ProcessedPicture, testEdits())
+	assertEditedImageTags(t, path, imagemeta.JPEG, "")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The Exif segment goes after the JFIF header, which must stay first
+	if !bytes.HasPrefix(data, jfif[:20]) || !bytes.Equal(data[20:24], []byte{0xFF, 0xE1, data[22], data[23]}) {
+		t.Errorf("Exif segment not inserted after the JFIF header: % x", data[:24])
+	}
+}
+
+func TestMetadataEditsJPEGWithoutEXIFDateFixOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scan.jpg")
+	if err := os.WriteFile(path, []byte{0xFF, 0xD8, 0xFF, 0xD9}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	edits := metadataEdits{ClockOffset: time.Hour}
+	edited, err := applyMetadataEdits(path, FileInfo{FileType: JPEG, MediaCategory: ProcessedPicture, Edits: &edits})
+	if err != nil || edited {
+		t.Errorf("applyMetadataEdits = %v, %v, want no edit for a JPEG without dates", edited, err)
+	}
+}
+
+// writeTestTIFF writes a big-endian TIFF whose IFD0 holds DateTime and an
+// Exif IFD with DateTimeOriginal, followed by stand-in image data
+func writeTestTIFF(t *testing.T, path string, extra ...testEXIFTag) {
+	t.Helper()
+	be := binary.BigEndian
+	const date = "2024:06:15 10:30:00\x00"
+	const ifd0Offset = 8
+	ifd0Entries := 2 + len(extra)
+	exifOffset := ifd0Offset + 2 + 12*ifd0Entries + 4
+	dataOffset := exifOffset + 2 + 12 + 4
+
+	tiff := []byte{'M', 'M', 0, 42}
+	tiff = be.AppendUint32(tiff, ifd0Offset)
+	tiff = be.AppendUint16(tiff, uint16(ifd0Entries))
+	tiff = be.AppendUint16(tiff, tiffTagDateTime)
+	tiff = be.AppendUint16(tiff, tiffTypeASCII)
+	tiff = be.AppendUint32(tiff, uint32(len(date)))
+	tiff = be.AppendUint32(tiff, uint32(dataOffset))
+	for _, tag := range extra {
+		tiff = be.AppendUint16(tiff, tag.Tag)
+		tiff = be.AppendUint16(tiff, tag.Type)
+		tiff = be.AppendUint32(tiff, tag.Count)
+		tiff = append(tiff, tag.Data...)
+	}
+	tiff = be.AppendUint16(tiff, tiffTagExifIFD)
+	tiff = be.AppendUint16(tiff, tiffTypeLong)
+	tiff = be.AppendUint32(tiff, 1)
+	tiff = be.AppendUint32(tiff, uint32(exifOffset))
+	tiff = be.AppendUint32(tiff, 0)
+	tiff = be.AppendUint16(tiff, 1)
+	tiff = be.AppendUint16(tiff, exifTagDateTimeOriginal)
+	tiff = be.AppendUint16(tiff, tiffTypeASCII)
+	tiff = be.AppendUint32(tiff, uint32(len(date)))
+	tiff = be.AppendUint32(tiff, uint32(dataOffset+len(date)))
+	tiff = be.AppendUint32(tiff, 0)
+	tiff = append(tiff, date+date...)
+	tiff = append(tiff, "raw sensor data"...)
+
+	if err := os.WriteFile(path, tiff, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMetadataEditsTIFFRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "DSC_0001.TIF")
+	writeTestTIFF(t, path)
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	editFile(t, path, TIFF, ProcessedPicture, testEdits())
+	assertEditedImageTags(t, path, imagemeta.TIFF, "2024:06:15 09:00:00")
+
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Apart from the IFD0 offset and the dates, the original bytes stay put
+	if !bytes.Contains(after, []byte("raw sensor data")) || !bytes.Equal(after[8:56], before[8:56]) {
+		t.Error("edit moved the original TIFF data")
+	}
+	tags, err := decodeImageTags(localSource{}, path, imagemeta.TIFF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tags.EXIF()["ModifyDate"].Value; got != "2024:06:15 09:00:00" {
+		t.Errorf("ModifyDate = %v, want 2024:06:15 09:00:00", got)
+	}
+}
+
+func TestMetadataEditsKeepExistingGPS(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "IMG_0001.DNG")
+	// A GPS IFD pointer at an empty IFD is enough to mark the file as located
+	writeTestTIFF(t, path, testEXIFTag{Tag: tiffTagGPSIFD, Type: tiffTypeLong, Count: 1, Data: []byte{0, 0, 0, 8}})
+
+	edits := testEdits()
+	splices, err := planMetadataEdits(fileReaderAt(t, path), fileSize(t, path), metadataEditTIFF, edits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edits.GPS = nil
+	withoutGPS, err := planMetadataEdits(fileReaderAt(t, path), fileSize(t, path), metadataEditTIFF, edits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spliceDelta(splices) != spliceDelta(withoutGPS) {
+		t.Error("GPS was written to a file that already has a position")
+	}
+}
+
+func TestPlanTIFFEditsRejectsBigTIFF(t *testing.T) {
+	bigTIFF := bytes.NewReader([]byte{'I', 'I', 43, 0, 8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	if _, err := planTIFFEdits(bigTIFF, 0, 16, testEdits()); err == nil {
+		t.Error("planTIFFEdits should reject BigTIFF files")
+	}
+}
+
+func fileReaderAt(t *testing.T, path string) *os.File {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = f.Close() })
+	return f
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
@@ -0,0 +1,543 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// maxMoovSize bounds the movie atom the writer loads into memory
+const maxMoovSize = 256 << 20
+
+// QuickTime metadata keys of the mdta handler the writer reads
+const (
+	quickTimeKeyCreationDate = "com.apple.quicktime.creationdate"
+	quickTimeKeyLocation     = "com.apple.quicktime.location.ISO6709"
+	quickTimeKeyArtist       = "com.apple.quicktime.artist"
+	quickTimeKeyCopyright    = "com.apple.quicktime.copyright"
+	quickTimeKeyKeywords     = "com.apple.quicktime.keywords"
+)
+
+// quickTimeLanguageEnglish is the packed ISO 639-2 code of user data text
+const quickTimeLanguageEnglish = 0x15C7
+
+// qtContainers are the atoms the writer descends into
+var qtContainers = map[string]bool{
+	"moov": true, "trak": true, "mdia": true, "minf": true, "stbl": true,
+	"udta": true, "meta": true, "ilst": true,
+}
+
+// qtBox is an atom of the movie atom tree. Leaves keep their payload in
+// Data; containers keep their children, plus the version and flags of a meta
+// atom in Prefix and any bytes after the last child in Trailer.
+type qtBox struct {
+	Type      string
+	Data      []byte
+	Prefix    []byte
+	Children  []*qtBox
+	Trailer   []byte
+	container bool
+}
+
+func (b *qtBox) child(typ string) *qtBox {
+	for _, c := range b.Children {
+		if c.Type == typ {
+			return c
+		}
+	}
+	return nil
+}
+
+// walk calls fn for b and every atom below it
+func (b *qtBox) walk(fn func(*qtBox)) {
+	fn(b)
+	for _, c := range b.Children {
+		c.walk(fn)
+	}
+}
+
+func (b *qtBox) encode() []byte {
+	content := b.Data
+	if b.container {
+		content = append([]byte{}, b.Prefix...)
+		for _, c := range b.Children {
+			content = append(content, c.encode()...)
+		}
+		content = append(content, b.Trailer...)
+	}
+	var out []byte
+	if size := 8 + len(content); uint64(size) <= math.MaxUint32 {
+		out = binary.BigEndian.AppendUint32(out, uint32(size))
+		out = append(out, b.Type...)
+	} else {
+		out = binary.BigEndian.AppendUint32(out, 1)
+		out = append(out, b.Type...)
+		out = binary.BigEndian.AppendUint64(out, uint64(size+8))
+	}
+	return append(out, content...)
+}
+
+// isQuickTimeBoxHeader returns true if data starts with a plausible atom
+// header. Meta atoms are full boxes in MP4 files but not in QuickTime ones.
+func isQuickTimeBoxHeader(data []byte) bool {
+	if len(data) < 8 {
+		return false
+	}
+	size := binary.BigEndian.Uint32(data)
+	if size < 8 || int64(size) > int64(len(data)) {
+		return false
+	}
+	for _, c := range data[4:8] {
+		if c < 0x20 || c > 0x7E {
+			return false
+		}
+	}
+	return true
+}
+
+// parseQuickTimeBoxes parses the atoms in data. Bytes too short to be an
+// atom are returned as the trailer, as are unparsable ones in user data,
+// where some cameras put raw bytes.
+func parseQuickTimeBoxes(data []byte, parent string) ([]*qtBox, []byte, error) {
+	var boxes []*qtBox
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data))
+		headerSize := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, nil, fmt.Errorf("truncated atom in %s", parent)
+			}
+			size = binary.BigEndian.Uint64(data[8:])
+			headerSize = 16
+		}
+		if size < headerSize || size > uint64(len(data)) {
+			if parent == "udta" {
+				return boxes, data, nil
+			}
+			return nil, nil, fmt.Errorf("invalid atom size in %s", parent)
+		}
+		box := &qtBox{Type: string(data[4:8])}
+		payload := data[headerSize:size]
+		if qtContainers[box.Type] {
+			box.container = true
+			if box.Type == "meta" && !isQuickTimeBoxHeader(payload) && len(payload) >= 4 {
+				box.Prefix = payload[:4]
+				payload = payload[4:]
+			}
+			var err error
+			if box.Children, box.Trailer, err = parseQuickTimeBoxes(payload, box.Type); err != nil {
+				return nil, nil, err
+			}
+		} else {
+			box.Data = payload
+		}
+		boxes = append(boxes, box)
+		data = data[size:]
+	}
+	return boxes, data, nil
+}
+
+// shiftQuickTimeHeaderTimes moves the creation and modification times of an
+// mvhd, tkhd or mdhd atom, counted in seconds since 1904, by secs. Unset
+// times stay zero.
+func shiftQuickTimeHeaderTimes(data []byte, secs int64) bool {
+	width := 4
+	if len(data) > 0 && data[0] == 1 {
+		width = 8
+	}
+	if len(data) < 4+2*width {
+		return false
+	}
+	changed := false
+	for i := 0; i < 2; i++ {
+		field := data[4+i*width : 4+(i+1)*width]
+		var value int64
+		if width == 8 {
+			value = int64(binary.BigEndian.Uint64(field))
+		} else {
+			value = int64(binary.BigEndian.Uint32(field))
+		}
+		shifted := value + secs
+		if value == 0 || shifted <= 0 || (width == 4 && shifted > math.MaxUint32) {
+			continue
+		}
+		if width == 8 {
+			binary.BigEndian.PutUint64(field, uint64(shifted))
+		} else {
+			binary.BigEndian.PutUint32(field, uint32(shifted))
+		}
+		changed = true
+	}
+	return changed
+}
+
+// quickTimeDateLayouts are the date formats of ©day and creationdate values
+var quickTimeDateLayouts = []string{
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05",
+}
+
+// shiftQuickTimeDate moves a date string by secs, keeping its format.
+// Values that are only a year, like many ©day atoms, are left alone.
+func shiftQuickTimeDate(value string, secs int64) (string, bool) {
+	for _, layout := range quickTimeDateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.Add(time.Duration(secs) * time.Second).Format(layout), true
+		}
+	}
+	return "", false
+}
+
+// quickTimeItemValue returns the value of the data atom of a metadata item
+// together with its type and locale
+func quickTimeItemValue(item *qtBox) (value, typeAndLocale []byte, ok bool) {
+	boxes, _, err := parseQuickTimeBoxes(item.Data, item.Type)
+	if err != nil {
+		return nil, nil, false
+	}
+	for _, b := range boxes {
+		if b.Type == "data" && len(b.Data) >= 8 {
+			return b.Data[8:], b.Data[:8], true
+		}
+	}
+	return nil, nil, false
+}
+
+// setQuickTimeItemValue replaces a metadata item's content with one data atom
+func setQuickTimeItemValue(item *qtBox, value, typeAndLocale []byte) {
+	data := &qtBox{Type: "data", Data: append(append([]byte{}, typeAndLocale...), value...)}
+	item.Data = data.encode()
+}
+
+// quickTimeUTF8 is the type and locale of a UTF-8 metadata value
+var quickTimeUTF8 = []byte{0, 0, 0, 1, 0, 0, 0, 0}
+
+// quickTimeKeys returns the key names of a meta atom's keys atom, indexed
+// from 1 like the items that refer to them
+func quickTimeKeys(meta *qtBox) map[uint32]string {
+	keysBox := meta.child("keys")
+	if keysBox == nil || len(keysBox.Data) < 8 {
+		return nil
+	}
+	keys := map[uint32]string{}
+	data := keysBox.Data[8:]
+	for index := uint32(1); len(data) >= 8; index++ {
+		size := binary.BigEndian.Uint32(data)
+		if size < 8 || int(size) > len(data) {
+			break
+		}
+		keys[index] = string(data[8:size])
+		data = data[size:]
+	}
+	return keys
+}
+
+// quickTimeUserDataText returns the text of an old-style ©xxx user data atom
+func quickTimeUserDataText(box *qtBox) (string, bool) {
+	if len(box.Data) < 4 {
+		return "", false
+	}
+	size := int(binary.BigEndian.Uint16(box.Data))
+	if size > len(box.Data)-4 {
+		return "", false
+	}
+	return string(box.Data[4 : 4+size]), true
+}
+
+func setQuickTimeUserDataText(box *qtBox, text string) {
+	data := binary.BigEndian.AppendUint16(nil, uint16(len(text)))
+	data = binary.BigEndian.AppendUint16(data, quickTimeLanguageEnglish)
+	box.Data = append(data, text...)
+}
+
+// shiftQuickTimeDates moves every creation date in the movie atom by secs
+func shiftQuickTimeDates(moov *qtBox, secs int64) bool {
+	changed := false
+	moov.walk(func(b *qtBox) {
+		switch b.Type {
+		case "mvhd", "tkhd", "mdhd":
+			if shiftQuickTimeHeaderTimes(b.Data, secs) {
+				changed = true
+			}
+		case "udta":
+			for _, c := range b.Children {
+				if c.Type != "\xa9day" {
+					continue
+				}
+				if text, ok := quickTimeUserDataText(c); ok {
+					if shifted, ok := shiftQuickTimeDate(text, secs); ok {
+						setQuickTimeUserDataText(c, shifted)
+						changed = true
+					}
+				}
+			}
+		case "meta":
+			ilst := b.child("ilst")
+			if ilst == nil {
+				return
+			}
+			keys := quickTimeKeys(b)
+			for _, item := range ilst.Children {
+				isDate := item.Type == "\xa9day"
+				if keys != nil {
+					isDate = keys[binary.BigEndian.Uint32([]byte(item.Type))] == quickTimeKeyCreationDate
+				}
+				if !isDate {
+					continue
+				}
+				value, typeAndLocale, ok := quickTimeItemValue(item)
+				if !ok {
+					continue
+				}
+				if shifted, ok := shiftQuickTimeDate(string(value), secs); ok {
+					setQuickTimeItemValue(item, []byte(shifted), typeAndLocale)
+					changed = true
+				}
+			}
+		}
+	})
+	return changed
+}
+
+// hasQuickTimeLocation returns true if the movie already records where it
+// was taken
+func hasQuickTimeLocation(moov *qtBox) bool {
+	found := false
+	moov.walk(func(b *qtBox) {
+		switch b.Type {
+		case "\xa9xyz":
+			found = true
+		case "meta":
+			for _, key := range quickTimeKeys(b) {
+				if key == quickTimeKeyLocation {
+					found = true
+				}
+			}
+		}
+	})
+	return found
+}
+
+// quickTimeItemList returns the item list of the iTunes-style metadata in
+// the movie's user data, creating the atoms that are missing
+func quickTimeItemList(moov *qtBox) *qtBox {
+	udta := moov.child("udta")
+	if udta == nil {
+		udta = &qtBox{Type: "udta", container: true}
+		moov.Children = append(moov.Children, udta)
+	}
+	var meta *qtBox
+	for _, c := range udta.Children {
+		if c.Type == "meta" && c.child("keys") == nil {
+			meta = c
+			break
+		}
+	}
+	if meta == nil {
+		handler := &qtBox{Type: "hdlr", Data: []byte("\x00\x00\x00\x00\x00\x00\x00\x00mdirappl\x00\x00\x00\x00\x00\x00\x00\x00\x00")}
+		meta = &qtBox{Type: "meta", container: true, Prefix: []byte{0, 0, 0, 0}, Children: []*qtBox{handler}}
+		udta.Children = append(udta.Children, meta)
+	}
+	ilst := meta.child("ilst")
+	if ilst == nil {
+		ilst = &qtBox{Type: "ilst", container: true}
+		meta.Children = append(meta.Children, ilst)
+	}
+	return ilst
+}
+
+func setQuickTimeItemText(ilst *qtBox, typ, text string) {
+	item := ilst.child(typ)
+	if item == nil {
+		item = &qtBox{Type: typ}
+		ilst.Children = append(ilst.Children, item)
+	}
+	setQuickTimeItemValue(item, []byte(text), quickTimeUTF8)
+}
+
+// replaceQuickTimeText replaces existing copies of a value that readers may
+// prefer over the item list: old-style user data atoms of type typ and
+// metadata items named key
+func replaceQuickTimeText(moov *qtBox, typ, key, text string) {
+	moov.walk(func(b *qtBox) {
+		switch b.Type {
+		case "udta":
+			for _, c := range b.Children {
+				if c.Type == typ {
+					setQuickTimeUserDataText(c, text)
+				}
+			}
+		case "meta":
+			ilst := b.child("ilst")
+			keys := quickTimeKeys(b)
+			if ilst == nil || keys == nil {
+				return
+			}
+			for _, item := range ilst.Children {
+				if keys[binary.BigEndian.Uint32([]byte(item.Type))] == key {
+					setQuickTimeItemValue(item, []byte(text), quickTimeUTF8)
+				}
+			}
+		}
+	})
+}
+
+// iso6709 formats a position the way cameras write ©xyz
+func iso6709(gps gpsLocation) string {
+	s := fmt.Sprintf("%+08.4f%+09.4f", gps.Latitude, gps.Longitude)
+	if gps.Altitude != nil {
+		s += fmt.Sprintf("%+.3f", *gps.Altitude)
+	}
+	return s + "/"
+}
+
+// shiftChunkOffsets moves the sample data offsets at or after from by delta
+func shiftChunkOffsets(moov *qtBox, from, delta int64) error {
+	var shiftErr error
+	moov.walk(func(b *qtBox) {
+		width := 0
+		switch b.Type {
+		case "stco":
+			width = 4
+		case "co64":
+			width = 8
+		default:
+			return
+		}
+		if len(b.Data) < 8 {
+			return
+		}
+		count := int(binary.BigEndian.Uint32(b.Data[4:]))
+		if 8+count*width > len(b.Data) {
+			shiftErr = fmt.Errorf("truncated %s atom", b.Type)
+			return
+		}
+		for i := 0; i < count; i++ {
+			field := b.Data[8+i*width : 8+(i+1)*width]
+			if width == 8 {
+				if offset := int64(binary.BigEndian.Uint64(field)); offset >= from {
+					binary.BigEndian.PutUint64(field, uint64(offset+delta))
+				}
+				continue
+			}
+			offset := int64(binary.BigEndian.Uint32(field))
+			if offset < from {
+				continue
+			}
+			if offset+delta > math.MaxUint32 {
+				shiftErr = fmt.Errorf("chunk offset does not fit in stco atom")
+				return
+			}
+			binary.BigEndian.PutUint32(field, uint32(offset+delta))
+		}
+	})
+	return shiftErr
+}
+
+// readQuickTimeMovie finds the movie atom among the top-level atoms and
+// parses it, returning it with its start and end offsets
+func readQuickTimeMovie(r io.ReaderAt, size int64) (*qtBox, int64, int64, error) {
+	var moovStart, moovEnd, moovHeader int64 = -1, 0, 0
+	for pos := int64(0); pos+8 <= size; {
+		header, err := readAtFull(r, pos, 8)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		boxSize, headerSize := int64(binary.BigEndian.Uint32(header)), int64(8)
+		switch boxSize {
+		case 0:
+			boxSize = size - pos
+		case 1:
+			large, err := readAtFull(r, pos+8, 8)
+			if err != nil {
+				return nil, 0, 0, err
+			}
+			boxSize, headerSize = int64(binary.BigEndian.Uint64(large)), 16
+		}
+		if boxSize < headerSize || pos+boxSize > size {
+			return nil, 0, 0, fmt.Errorf("invalid atom size at %d", pos)
+		}
+		if string(header[4:8]) == "moov" {
+			moovStart, moovEnd, moovHeader = pos, pos+boxSize, headerSize
+			break
+		}
+		pos += boxSize
+	}
+	if moovStart < 0 {
+		return nil, 0, 0, fmt.Errorf("no movie atom")
+	}
+	if moovEnd-moovStart > maxMoovSize {
+		return nil, 0, 0, fmt.Errorf("movie atom is too large")
+	}
+
+	data, err := readAtFull(r, moovStart+moovHeader, int(moovEnd-moovStart-moovHeader))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	children, trailer, err := parseQuickTimeBoxes(data, "moov")
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return &qtBox{Type: "moov", container: true, Children: children, Trailer: trailer}, moovStart, moovEnd, nil
+}
+
+// planQuickTimeEdits rewrites the movie atom. When it changes size and media
+// data follows it, the chunk offsets pointing past it are moved too.
+func planQuickTimeEdits(r io.ReaderAt, size int64, edits metadataEdits) ([]metadataSplice, error) {
+	moov, moovStart, moovEnd, err := readQuickTimeMovie(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := false
+	if secs := edits.clockOffsetSeconds(); secs != 0 {
+		changed = shiftQuickTimeDates(moov, secs)
+	}
+	if edits.Artist != "" || edits.Copyright != "" || len(edits.Keywords) > 0 {
+		ilst := quickTimeItemList(moov)
+		if edits.Artist != "" {
+			setQuickTimeItemText(ilst, "\xa9ART", edits.Artist)
+			replaceQuickTimeText(moov, "\xa9ART", quickTimeKeyArtist, edits.Artist)
+		}
+		if edits.Copyright != "" {
+			setQuickTimeItemText(ilst, "cprt", edits.Copyright)
+			replaceQuickTimeText(moov, "\xa9cpy", quickTimeKeyCopyright, edits.Copyright)
+		}
+		if len(edits.Keywords) > 0 {
+			keywords := strings.Join(edits.Keywords, ",")
+			setQuickTimeItemText(ilst, "keyw", keywords)
+			replaceQuickTimeText(moov, "", quickTimeKeyKeywords, keywords)
+		}
+		changed = true
+	}
+	if edits.GPS != nil && !hasQuickTimeLocation(moov) {
+		udta := moov.child("udta")
+		if udta == nil {
+			udta = &qtBox{Type: "udta", container: true}
+			moov.Children = append(moov.Children, udta)
+		}
+		location := &qtBox{Type: "\xa9xyz"}
+		setQuickTimeUserDataText(location, iso6709(*edits.GPS))
+		udta.Children = append(udta.Children, location)
+		changed = true
+	}
+	if !changed {
+		return nil, nil
+	}
+
+	encoded := moov.encode()
+	if delta := int64(len(encoded)) - (moovEnd - moovStart); delta != 0 {
+		if err := shiftChunkOffsets(moov, moovEnd, delta); err != nil {
+			return nil, err
+		}
+		encoded = moov.encode()
+	}
+	return []metadataSplice{{Start: moovStart, End: moovEnd, Data: encoded}}, nil
+}
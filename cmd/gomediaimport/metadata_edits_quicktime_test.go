@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/tonimelisma/videometa"
+)
+
+// decodeQuickTimeTags re-reads a movie with videometa
+func decodeQuickTimeTags(t *testing.T, path string) videometa.Tags {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	decoded, err := videometa.DecodeAll(videometa.Options{R: f, Sources: videometa.QUICKTIME})
+	if err != nil {
+		t.Fatalf("videometa.DecodeAll failed: %v", err)
+	}
+	return decoded.Tags
+}
+
+// quickTimeValues returns the values of every QuickTime tag named name
+func quickTimeValues(tags videometa.Tags, name string) []any {
+	var values []any
+	for _, tag := range tags.QuickTime().Find(name) {
+		values = append(values, tag.Value)
+	}
+	return values
+}
+
+func quickTimeValue(tags videometa.Tags, name string) any {
+	if values := quickTimeValues(tags, name); len(values) > 0 {
+		return values[0]
+	}
+	return nil
+}
+
+// firstChunk returns the first sample data chunk the movie's stco atom
+// points to
+func firstChunk(t *testing.T, data []byte) []byte {
+	t.Helper()
+	i := bytes.Index(data, []byte("stco"))
+	if i < 0 {
+		t.Fatal("no stco atom")
+	}
+	offset := binary.BigEndian.Uint32(data[i+12:])
+	return data[offset : offset+64]
+}
+
+func TestMetadataEditsQuickTimeRoundTrip(t *testing.T) {
+	path := copyFixtureToTempDir(t, t.TempDir(), "minimal.mp4", "clip.mp4")
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edits := testEdits()
+	edits.ClockOffset = 2 * time.Hour
+	editFile(t, path, MP4, Video, edits)
+
+	metadata, err := extractVideoMetadata(localSource{}, path, MP4, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 6, 15, 12, 30, 0, 0, time.UTC); !metadata.CreationDateTime.Equal(want) {
+		t.Errorf("creation time = %v, want %v", metadata.CreationDateTime, want)
+	}
+
+	tags := decodeQuickTimeTags(t, path)
+	for name, want := range map[string]string{
+		"Artist":    "Sofia Lind",
+		"Copyright": "© 2024 Sofia Lind",
+		"Keyword":   "wedding,archipelago",
+		"Encoder":   "Lavf62.3.100",
+	} {
+		if got := quickTimeValue(tags, name); got != want {
+			t.Errorf("%s = %v, want %s", name, got, want)
+		}
+	}
+	lat, long, err := tags.GetLatLong()
+	if err != nil || math.Abs(lat-60.1699) > 1e-4 || math.Abs(long+24.9384) > 1e-4 {
+		t.Errorf("GetLatLong = %v, %v, %v, want 60.1699, -24.9384", lat, long, err)
+	}
+
+	// The movie atom grew and comes before the media data, so the chunk
+	// offsets must have moved with the data
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) <= len(before) {
+		t.Fatalf("edited movie is %d bytes, want more than %d", len(after), len(before))
+	}
+	if !bytes.Equal(firstChunk(t, after), firstChunk(t, before)) {
+		t.Error("chunk offsets do not point at the sample data after the edit")
+	}
+}
+
+func TestMetadataEditsQuickTimeDateFixInPlace(t *testing.T) {
+	path := copyFixtureToTempDir(t, t.TempDir(), "with_gps.mp4", "clip.mp4")
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	altitude := 5.0
+	editFile(t, path, MP4, Video, metadataEdits{ClockOffset: time.Hour, GPS: &gpsLocation{Latitude: 1, Longitude: 2, Altitude: &altitude}})
+
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(before) {
+		t.Errorf("date fix changed the size from %d to %d", len(before), len(after))
+	}
+	tags := decodeQuickTimeTags(t, path)
+	if got := quickTimeValue(tags, "CreationDate"); got != "2024:06:15 11:30:00-07:00" {
+		t.Errorf("CreationDate = %v, want 2024:06:15 11:30:00-07:00", got)
+	}
+	if got, ok := quickTimeValue(tags, "CreateDate").(time.Time); !ok || !got.Equal(time.Date(2024, 6, 15, 11, 30, 0, 0, time.UTC)) {
+		t.Errorf("CreateDate = %v, want 2024-06-15 11:30:00 UTC", quickTimeValue(tags, "CreateDate"))
+	}
+	// The camera's own position is kept
+	if got := quickTimeValue(tags, "GPSCoordinates"); got != "34.0592 -118.446 42.938" {
+		t.Errorf("GPSCoordinates = %v, want the original position", got)
+	}
+}
+
+func TestMetadataEditsQuickTimeReplacesItems(t *testing.T) {
+	path := copyFixtureToTempDir(t, t.TempDir(), "exiftool_quicktime.mov", "clip.mov")
+
+	editFile(t, path, MOV, Video, metadataEdits{Artist: "Sofia Lind", ClockOffset: time.Hour})
+
+	// The keyed metadata, user data text and item list copies are all replaced
+	tags := decodeQuickTimeTags(t, path)
+	artists := quickTimeValues(tags, "Artist")
+	if len(artists) != 3 {
+		t.Fatalf("Artist = %v, want three copies", artists)
+	}
+	for _, artist := range artists {
+		if artist != "Sofia Lind" {
+			t.Errorf("Artist = %v, want Sofia Lind everywhere", artists)
+			break
+		}
+	}
+	// A year alone cannot be shifted
+	if got := quickTimeValue(tags, "ContentCreateDate"); got != "2010" && got != 2010 {
+		t.Errorf("ContentCreateDate = %v, want 2010", got)
+	}
+}
+
+func TestShiftQuickTimeDate(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+		ok    bool
+	}{
+		{"2024-06-15T10:30:00-0700", "2024-06-15T09:30:00-0700", true},
+		{"2024-06-15T10:30:00+03:00", "2024-06-15T09:30:00+03:00", true},
+		{"2024-06-15T10:30:00Z", "2024-06-15T09:30:00Z", true},
+		{"2024-06-15T10:30:00", "2024-06-15T09:30:00", true},
+		{"2010", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := shiftQuickTimeDate(tt.value, -3600)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("shiftQuickTimeDate(%q) = %q, %v, want %q, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestPlanQuickTimeEditsWithoutMovie(t *testing.T) {
+	data := []byte("\x00\x00\x00\x10ftypisom\x00\x00\x02\x00\x00\x00\x00\x08mdat")
+	if _, err := planQuickTimeEdits(bytes.NewReader(data), int64(len(data)), metadataEdits{Artist: "x"}); err == nil {
+		t.Error("planQuickTimeEdits should fail without a movie atom")
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bep/imagemeta"
+)
+
+func TestValidateMetadataEdits(t *testing.T) {
+	altitude := math.Inf(1)
+	tests := []struct {
+		name    string
+		edits   metadataEdits
+		wantErr string
+	}{
+		{"empty", metadataEdits{}, ""},
+		{"full", testEdits(), ""},
+		{"fractional clock offset", metadataEdits{ClockOffset: 1500 * time.Millisecond}, "whole number of seconds"},
+		{"empty keyword", metadataEdits{Keywords: []string{"wedding", " "}}, "keywords cannot be empty"},
+		{"keyword separator", metadataEdits{Keywords: []string{"wedding;party"}}, "comma or semicolon"},
+		{"latitude", metadataEdits{GPS: &gpsLocation{Latitude: 91}}, "latitude"},
+		{"longitude", metadataEdits{GPS: &gpsLocation{Longitude: -180.5}}, "longitude"},
+		{"altitude", metadataEdits{GPS: &gpsLocation{Altitude: &altitude}}, "altitude"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateMetadataEdits(tt.edits)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateMetadataEdits returned error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateMetadataEdits = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMetadataEditsConfigParsing(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	content := `
+metadata_edits:
+  artist: Sofia Lind
+  keywords: [wedding, archipelago]
+  clock_offset: -1h30m
+  gps:
+    latitude: 60.1699
+    longitude: 24.9384
+removable_volumes:
+  SOFIA:
+    metadata_edits:
+      copyright: © 2024 Sofia Lind
+      clock_offset: 2h
+`
+	if err := os.WriteFile(configFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config{ConfigFile: configFile}
+	if err := parseConfigFile(cfg); err != nil {
+		t.Fatalf("parseConfigFile failed: %v", err)
+	}
+	want := metadataEdits{
+		Artist:      "Sofia Lind",
+		Keywords:    []string{"wedding", "archipelago"},
+		ClockOffset: -90 * time.Minute,
+		GPS:         &gpsLocation{Latitude: 60.1699, Longitude: 24.9384},
+	}
+	if !reflect.DeepEqual(cfg.MetadataEdits, want) {
+		t.Errorf("metadata_edits = %+v, want %+v", cfg.MetadataEdits, want)
+	}
+
+	// A volume's edits override the global ones field by field
+	applyRemovableVolumeOverrides(cfg, cfg.RemovableVolumes["SOFIA"])
+	want.Copyright = "© 2024 Sofia Lind"
+	want.ClockOffset = 2 * time.Hour
+	if !reflect.DeepEqual(cfg.MetadataEdits, want) {
+		t.Errorf("metadata_edits with volume overrides = %+v, want %+v", cfg.MetadataEdits, want)
+	}
+}
+
+func TestValidateConfigMetadataEdits(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config{
+		SourceDir:      tmpDir,
+		DestDir:        filepath.Join(tmpDir, "dest"),
+		SidecarDefault: SidecarDelete,
+		RemovableVolumes: map[string]removableVolumeConfig{
+			"SOFIA": {MetadataEdits: metadataEdits{Keywords: []string{""}}},
+		},
+	}
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), `removable volume "SOFIA"`) {
+		t.Errorf("validateConfig = %v, want an error for the SOFIA metadata edits", err)
+	}
+
+	remote := config{DestDir: "s3://media-bucket/photos", SidecarDefault: SidecarDelete, MetadataEdits: metadataEdits{Artist: "Sofia Lind"}}
+	if err := validateCommonConfig(&remote); err == nil {
+		t.Error("expected metadata_edits to be rejected for a remote destination")
+	}
+}
+
+func TestImportMediaMetadataEdits(t *testing.T) {
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+	source := filepath.Join(sourceDir, "IMG_0001.JPG")
+	writeTestJPEGWithEXIF(t, source, testEXIFASCII(exifTagDateTimeOriginal, "2024:06:15 10:30:00"))
+	original, err := os.ReadFile(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copyFixtureToTempDir(t, sourceDir, "minimal.mp4", "clip.mp4")
+
+	cfg := config{
+		SourceDir:        sourceDir,
+		DestDir:          destDir,
+		SidecarDefault:   SidecarDelete,
+		RenameByDateTime: true,
+		MetadataEdits:    metadataEdits{Artist: "Sofia Lind", ClockOffset: -90 * time.Minute},
+	}
+	if err := importMedia(cfg); err != nil {
+		t.Fatalf("importMedia failed: %v", err)
+	}
+
+	// Names follow the corrected capture times
+	wantTree := []string{"20240615_090000.jpg", "20240615_090000.mp4"}
+	if got := listTree(t, destDir); !reflect.DeepEqual(got, wantTree) {
+		t.Fatalf("destination = %v, want %v", got, wantTree)
+	}
+	if after, err := os.ReadFile(source); err != nil || !bytes.Equal(after, original) {
+		t.Error("importMedia modified the source file")
+	}
+	tags, err := decodeImageTags(localSource{}, filepath.Join(destDir, wantTree[0]), imagemeta.JPEG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exif := tags.EXIF()
+	if got := exif["DateTimeOriginal"].Value; got != "2024:06:15 09:00:00" {
+		t.Errorf("DateTimeOriginal = %v, want 2024:06:15 09:00:00", got)
+	}
+	if got := exif["Artist"].Value; got != "Sofia Lind" {
+		t.Errorf("Artist = %v, want Sofia Lind", got)
+	}
+	if got := quickTimeValue(decodeQuickTimeTags(t, filepath.Join(destDir, wantTree[1])), "Artist"); got != "Sofia Lind" {
+		t.Errorf("video Artist = %v, want Sofia Lind", got)
+	}
+
+	// The edited copies are recognized as already imported
+	if err := importMedia(cfg); err != nil {
+		t.Fatalf("second importMedia failed: %v", err)
+	}
+	if got := listTree(t, destDir); !reflect.DeepEqual(got, wantTree) {
+		t.Errorf("destination after second import = %v, want %v", got, wantTree)
+	}
+}
+
+func TestCopyFilesMetadataEditFailure(t *testing.T) {
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(sourceDir, "IMG_0001.JPG"), []byte("not a JPEG"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	edits := metadataEdits{Artist: "Sofia Lind"}
+	files := []FileInfo{{
+		SourceName: "IMG_0001.JPG", SourceDir: sourceDir, Size: 10, FileType: JPEG, MediaCategory: ProcessedPicture,
+		DestDir: destDir, DestName: "IMG_0001.JPG", Edits: &edits, ParentIndex: -1,
+	}}
+	cfg := config{SourceDir: sourceDir, DestDir: destDir, DeleteOriginals: true, Quiet: true}
+
+	if err := copyFiles(files, cfg); err == nil || !strings.Contains(err.Error(), "failed to edit metadata") {
+		t.Fatalf("copyFiles = %v, want a metadata edit error", err)
+	}
+	if files[0].Status != StatusFailed {
+		t.Errorf("status = %q, want %q", files[0].Status, StatusFailed)
+	}
+	if got := listTree(t, destDir); len(got) != 0 {
+		t.Errorf("destination = %v, want the unedited copy removed", got)
+	}
+	if err := deleteOriginalFiles(files, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(sourceDir, "IMG_0001.JPG")); err != nil {
+		t.Errorf("original of a failed edit was deleted: %v", err)
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	"encoding/binary"
 	"math"
 	"os"
 	"path/filepath"
@@ -30,6 +32,74 @@ func copyFixtureToTempDir(t *testing.T, dir, srcName, destName string) string {
 	return destPath
 }
 
+// testEXIFTag is a raw little-endian EXIF IFD entry used to build test JPEGs.
+type testEXIFTag struct {
+	Tag   uint16
+	Type  uint16
+	Count uint32
+	Data  []byte
+}
+
+func testEXIFASCII(tag uint16, value string) testEXIFTag {
+	data := append([]byte(value), 0)
+	return testEXIFTag{Tag: tag, Type: 2, Count: uint32(len(data)), Data: data}
+}
+
+func testEXIFShort(tag uint16, value uint16) testEXIFTag {
+	return testEXIFTag{Tag: tag, Type: 3, Count: 1, Data: binary.LittleEndian.AppendUint16(nil, value)}
+}
+
+// writeTestJPEGWithEXIF writes a minimal JPEG whose Exif IFD holds the given
+// tags, which must be sorted by tag number.
+func writeTestJPEGWithEXIF(t *testing.T, path string, tags ...testEXIFTag) {
+	t.Helper()
+
+	const ifd0Offset = 8
+	const exifIFDOffset = ifd0Offset + 2 + 12 + 4
+	dataOffset := exifIFDOffset + 2 + 12*len(tags) + 4
+
+	le := binary.LittleEndian
+	tiff := []byte{'I', 'I', 42, 0}
+	tiff = le.AppendUint32(tiff, ifd0Offset)
+	tiff = le.AppendUint16(tiff, 1)
+	tiff = le.AppendUint16(tiff, 0x8769) // ExifIFDPointer
+	tiff = le.AppendUint16(tiff, 4)
+	tiff = le.AppendUint32(tiff, 1)
+	tiff = le.AppendUint32(tiff, exifIFDOffset)
+	tiff = le.AppendUint32(tiff, 0)
+
+	var data []byte
+	tiff = le.AppendUint16(tiff, uint16(len(tags)))
+	for _, tag := range tags {
+		tiff = le.AppendUint16(tiff, tag.Tag)
+		tiff = le.AppendUint16(tiff, tag.Type)
+		tiff = le.AppendUint32(tiff, tag.Count)
+		if len(tag.Data) <= 4 {
+			tiff = append(tiff, tag.Data...)
+			tiff = append(tiff, make([]byte, 4-len(tag.Data))...)
+			continue
+		}
+		tiff = le.AppendUint32(tiff, uint32(dataOffset+len(data)))
+		data = append(data, tag.Data...)
+		if len(data)%2 == 1 {
+			data = append(data, 0)
+		}
+	}
+	tiff = le.AppendUint32(tiff, 0)
+	tiff = append(tiff, data...)
+
+	var jpeg bytes.Buffer
+	jpeg.Write([]byte{0xFF, 0xD8, 0xFF, 0xE1})
+	_ = binary.Write(&jpeg, binary.BigEndian, uint16(2+6+len(tiff)))
+	jpeg.WriteString("Exif\x00\x00")
+	jpeg.Write(tiff)
+	jpeg.Write([]byte{0xFF, 0xD9})
+
+	if err := os.WriteFile(path, jpeg.Bytes(), 0644); err != nil {
+		t.Fatalf("failed to write test JPEG %s: %v", path, err)
+	}
+}
+
 func requireVideoMetadata(t *testing.T, metadata mediaMetadata) *VideoMetadata {
 	t.Helper()
 	if metadata.VideoMetadata == nil {
@@ -41,7 +111,7 @@ func requireVideoMetadata(t *testing.T, metadata mediaMetadata) *VideoMetadata {
 func TestExtractVideoMetadataMP4Fixture(t *testing.T) {
 	fallbackTime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
 
-	metadata, err := extractVideoMetadata(testFixturePath("minimal.mp4"), MP4, fallbackTime)
+	metadata, err := extractVideoMetadata(localSource{}, testFixturePath("minimal.mp4"), MP4, fallbackTime)
 	if err != nil {
 		t.Fatalf("extractVideoMetadata returned error: %v", err)
 	}
@@ -90,7 +160,7 @@ func TestExtractVideoMetadataMP4Fixture(t *testing.T) {
 func TestExtractVideoMetadataMOVFixture(t *testing.T) {
 	fallbackTime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
 
-	metadata, err := extractVideoMetadata(testFixturePath("exiftool_quicktime.mov"), MOV, fallbackTime)
+	metadata, err := extractVideoMetadata(localSource{}, testFixturePath("exiftool_quicktime.mov"), MOV, fallbackTime)
 	if err != nil {
 		t.Fatalf("extractVideoMetadata returned error: %v", err)
 	}
@@ -121,7 +191,7 @@ func TestExtractVideoMetadataMOVFixture(t *testing.T) {
 func TestExtractVideoMetadataPreservesTimezoneGPSAndCamera(t *testing.T) {
 	fallbackTime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
 
-	metadata, err := extractVideoMetadata(testFixturePath("with_gps.mp4"), MP4, fallbackTime)
+	metadata, err := extractVideoMetadata(localSource{}, testFixturePath("with_gps.mp4"), MP4, fallbackTime)
 	if err != nil {
 		t.Fatalf("extractVideoMetadata returned error: %v", err)
 	}
@@ -218,7 +288,7 @@ func TestResolveVideoTimestampProvenanceUsesVendorDateTimeOriginal(t *testing.T)
 func TestExtractVideoMetadataUnsupportedContainerFallsBack(t *testing.T) {
 	fallbackTime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
 
-	metadata, err := extractVideoMetadata("/does/not/matter.avi", AVI, fallbackTime)
+	metadata, err := extractVideoMetadata(localSource{}, "/does/not/matter.avi", AVI, fallbackTime)
 	if err != nil {
 		t.Fatalf("extractVideoMetadata returned error: %v", err)
 	}
@@ -246,7 +316,7 @@ func TestExtractVideoMetadataCorruptSupportedFileFallsBack(t *testing.T) {
 	}
 
 	fallbackTime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
-	metadata, err := extractVideoMetadata(filePath, MP4, fallbackTime)
+	metadata, err := extractVideoMetadata(localSource{}, filePath, MP4, fallbackTime)
 	if err != nil {
 		t.Fatalf("extractVideoMetadata returned error: %v", err)
 	}
@@ -274,7 +344,7 @@ func TestExtractMetadataRawVideoType(t *testing.T) {
 		FileType:      RAWVIDEO,
 	}
 
-	_, err := extractMetadata(fi)
+	_, err := extractMetadata(localSource{}, fi)
 	if err == nil {
 		t.Fatal("expected error for raw video, got nil")
 	}
@@ -326,12 +396,116 @@ func TestEnumerateFilesAttachesVideoMetadata(t *testing.T) {
 
 func TestSidecarDefaultsMPLAndCPI(t *testing.T) {
 	for _, ext := range []string{"mpl", "cpi"} {
-		if !isSidecarExtension(ext) {
+		if !builtinMediaTypes.isSidecarExtension(ext) {
 			t.Errorf("%s should be a recognized sidecar extension", ext)
 		}
-		action := getSidecarAction(ext, nil, SidecarIgnore)
+		action := builtinMediaTypes.getSidecarAction(ext, nil, SidecarIgnore)
 		if action != SidecarDelete {
 			t.Errorf("expected SidecarDelete for %s, got %v", ext, action)
 		}
 	}
 }
+
+func TestExtractMetadataImageSequenceTags(t *testing.T) {
+	tempDir := t.TempDir()
+	path := filepath.Join(tempDir, "DSC0001.JPG")
+	writeTestJPEGWithEXIF(t, path,
+		testEXIFASCII(0x9003, "2024:06:15 10:30:00"),
+		testEXIFASCII(0x9291, "25"),
+		testEXIFShort(0xa402, exifExposureModeAutoBracket),
+	)
+
+	metadata, err := extractMetadata(localSource{}, FileInfo{
+		SourceDir:     tempDir,
+		SourceName:    "DSC0001.JPG",
+		MediaCategory: ProcessedPicture,
+		FileType:      JPEG,
+	})
+	if err != nil {
+		t.Fatalf("extractMetadata failed: %v", err)
+	}
+
+	want := time.Date(2024, 6, 15, 10, 30, 0, 250*int(time.Millisecond), time.Local)
+	if !metadata.CreationDateTime.Equal(want) {
+		t.Errorf("got CreationDateTime %v, want %v", metadata.CreationDateTime, want)
+	}
+	if metadata.ImageMetadata == nil {
+		t.Fatal("expected image metadata, got nil")
+	}
+	if metadata.ImageMetadata.SubSecond != 250*time.Millisecond {
+		t.Errorf("got SubSecond %v, want 250ms", metadata.ImageMetadata.SubSecond)
+	}
+	if !metadata.ImageMetadata.Bracketed {
+		t.Error("expected auto bracket exposure mode to mark the frame as bracketed")
+	}
+}
+
+func TestParseSubSecond(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"5", 500 * time.Millisecond},
+		{"05", 50 * time.Millisecond},
+		{"005", 5 * time.Millisecond},
+		{"123", 123 * time.Millisecond},
+		{"123456", 123456 * time.Microsecond},
+		{"", 0},
+		{"abc", 0},
+		{"1234567890", 0},
+	}
+	for _, tt := range tests {
+		if got := parseSubSecond(tt.value); got != tt.want {
+			t.Errorf("parseSubSecond(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestExtractMetadataSubSecondLeadingZeros(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"05", 50 * time.Millisecond},
+		{"005", 5 * time.Millisecond},
+		{"5", 500 * time.Millisecond},
+		{"0123", 12300 * time.Microsecond},
+	}
+	for _, tt := range tests {
+		tempDir := t.TempDir()
+		writeTestJPEGWithEXIF(t, filepath.Join(tempDir, "DSC0001.JPG"),
+			testEXIFASCII(0x9003, "2024:06:15 10:30:00"),
+			testEXIFASCII(0x9291, tt.value),
+		)
+		metadata, err := extractMetadata(localSource{}, FileInfo{
+			SourceDir:     tempDir,
+			SourceName:    "DSC0001.JPG",
+			MediaCategory: ProcessedPicture,
+			FileType:      JPEG,
+		})
+		if err != nil {
+			t.Fatalf("extractMetadata failed: %v", err)
+		}
+		if got := metadata.ImageMetadata.SubSecond; got != tt.want {
+			t.Errorf("SubSecTimeOriginal %q: SubSecond = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestDecodedSubSecond(t *testing.T) {
+	tests := []struct {
+		value any
+		want  time.Duration
+	}{
+		{123, 123 * time.Millisecond},
+		{5, 0},  // "5", "05", or "005"
+		{50, 0}, // "50" or "050"
+		{1234, 0},
+		{"abc", 0},
+	}
+	for _, tt := range tests {
+		if got := decodedSubSecond(tt.value); got != tt.want {
+			t.Errorf("decodedSubSecond(%v) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
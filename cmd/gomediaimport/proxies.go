@@ -0,0 +1,97 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ProxyAction defines how low-resolution proxies that cameras record next to
+// their clips are handled
+type ProxyAction string
+
+const (
+	ProxyIgnore          ProxyAction = "ignore"
+	ProxyCopyToSubfolder ProxyAction = "copy_to_proxies_subfolder"
+	ProxyDelete          ProxyAction = "delete"
+)
+
+// proxiesSubfolder is the directory below a clip's destination that receives
+// its proxy under copy_to_proxies_subfolder
+const proxiesSubfolder = "Proxies"
+
+// proxyExtensions are recognized as proxies wherever they are found: GoPro
+// and Insta360 LRV and DJI LRF
+var proxyExtensions = map[string]bool{
+	"lrv": true,
+	"lrf": true,
+}
+
+// isValidProxyAction returns true if the action is a valid ProxyAction value.
+// An empty action is valid and means delete.
+func isValidProxyAction(action ProxyAction) bool {
+	switch action {
+	case "", ProxyIgnore, ProxyCopyToSubfolder, ProxyDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// effectiveProxyAction returns the action to apply, defaulting to delete.
+func effectiveProxyAction(action ProxyAction) ProxyAction {
+	if action == "" {
+		return ProxyDelete
+	}
+	return action
+}
+
+// isProxyExtension returns true if the extension (without dot, lowercase) is
+// a proxy type
+func isProxyExtension(ext string) bool {
+	return proxyExtensions[ext]
+}
+
+// markProxy sets the proxy category on fileInfo and returns true if the proxy
+// should be planned. Ignored proxies are left out and stay on the source.
+func markProxy(fileInfo *FileInfo, cfg config) bool {
+	if effectiveProxyAction(cfg.ProxyAction) == ProxyIgnore {
+		return false
+	}
+	fileInfo.MediaCategory = Proxy
+	fileInfo.FileType = ""
+	return true
+}
+
+var (
+	// goProProxyName matches GL010042.LRV, the proxy of GX010042.MP4 or
+	// GH010042.MP4
+	goProProxyName = regexp.MustCompile(`^(?i)GL(\d{6})$`)
+	// insta360ProxyName matches LRV_20240615_103000_01_001, the proxy of the
+	// VID_20240615_103000_00_001 and _10_001 lens files
+	insta360ProxyName = regexp.MustCompile(`^(?i)LRV_(\d{8}_\d{6})_\d{2}_(\d{3})$`)
+	// sonySubClipName matches C0001S03, the sub-clip of C0001 in M4ROOT/SUB
+	sonySubClipName = regexp.MustCompile(`^(?i)(C\d{4})S\d{2}$`)
+)
+
+// proxyParentKeys returns the keys of the clips a proxy may belong to, most
+// likely first. A proxy with the clip's base name in the same directory, as
+// DJI and older GoPro models write, is always a candidate.
+func proxyParentKeys(file FileInfo) []parentKey {
+	base := strings.TrimSuffix(file.SourceName, filepath.Ext(file.SourceName))
+	keys := []parentKey{newParentKey(file.SourceDir, base)}
+	if match := goProProxyName.FindStringSubmatch(base); match != nil {
+		keys = append(keys,
+			newParentKey(file.SourceDir, "GX"+match[1]),
+			newParentKey(file.SourceDir, "GH"+match[1]))
+	}
+	if match := insta360ProxyName.FindStringSubmatch(base); match != nil {
+		keys = append(keys,
+			newParentKey(file.SourceDir, "VID_"+match[1]+"_00_"+match[2]),
+			newParentKey(file.SourceDir, "VID_"+match[1]+"_10_"+match[2]))
+	}
+	if match := sonySubClipName.FindStringSubmatch(base); match != nil && strings.EqualFold(filepath.Base(file.SourceDir), "SUB") {
+		keys = append(keys, newParentKey(filepath.Join(filepath.Dir(file.SourceDir), "CLIP"), match[1]))
+	}
+	return keys
+}
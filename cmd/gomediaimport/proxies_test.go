@@ -0,0 +1,227 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestProxyParentKeys(t *testing.T) {
+	tests := []struct {
+		dir  string
+		name string
+		want []parentKey
+	}{
+		{"/card/DCIM/100MEDIA", "DJI_0001.LRF", []parentKey{{"/card/DCIM/100MEDIA", "dji_0001"}}},
+		{"/card/DCIM/100GOPRO", "GL010042.LRV", []parentKey{
+			{"/card/DCIM/100GOPRO", "gl010042"},
+			{"/card/DCIM/100GOPRO", "gx010042"},
+			{"/card/DCIM/100GOPRO", "gh010042"},
+		}},
+		{"/card/DCIM/Camera01", "LRV_20240615_103000_01_002.insv", []parentKey{
+			{"/card/DCIM/Camera01", "lrv_20240615_103000_01_002"},
+			{"/card/DCIM/Camera01", "vid_20240615_103000_00_002"},
+			{"/card/DCIM/Camera01", "vid_20240615_103000_10_002"},
+		}},
+		{"/card/PRIVATE/M4ROOT/SUB", "C0001S03.MP4", []parentKey{
+			{"/card/PRIVATE/M4ROOT/SUB", "c0001s03"},
+			{"/card/PRIVATE/M4ROOT/CLIP", "c0001"},
+		}},
+	}
+	for _, tt := range tests {
+		file := FileInfo{SourceDir: filepath.FromSlash(tt.dir), SourceName: tt.name}
+		var want []parentKey
+		for _, key := range tt.want {
+			want = append(want, parentKey{dir: filepath.FromSlash(key.dir), baseName: key.baseName})
+		}
+		if got := proxyParentKeys(file); !reflect.DeepEqual(got, want) {
+			t.Errorf("proxyParentKeys(%s) = %v, want %v", tt.name, got, want)
+		}
+	}
+}
+
+// writeProxyTestCard writes clips and their proxies with distinct contents so
+// no two are taken for duplicates
+func writeProxyTestCard(t *testing.T) string {
+	t.Helper()
+	sourceDir := t.TempDir()
+	for _, rel := range []string{
+		"DCIM/100GOPRO/GX010042.AVI",
+		"DCIM/100GOPRO/GL010042.LRV",
+		"PRIVATE/M4ROOT/CLIP/C0001.AVI",
+		"PRIVATE/M4ROOT/SUB/C0001S03.MP4",
+		"Flight/DJI_0001.AVI",
+		"Flight/DJI_0001.LRF",
+		"Flight/DJI_0002.LRF",
+	} {
+		path := filepath.Join(sourceDir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(rel), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return sourceDir
+}
+
+func listTree(t *testing.T, root string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func TestImportMediaProxyActions(t *testing.T) {
+	t.Run("copy_to_proxies_subfolder", func(t *testing.T) {
+		sourceDir := writeProxyTestCard(t)
+		destDir := t.TempDir()
+		cfg := config{
+			SourceDir:      sourceDir,
+			DestDir:        destDir,
+			SidecarDefault: SidecarDelete,
+			ProxyAction:    ProxyCopyToSubfolder,
+		}
+		if err := importMedia(cfg); err != nil {
+			t.Fatalf("importMedia failed: %v", err)
+		}
+		want := []string{
+			"C0001.AVI",
+			"DJI_0001.AVI",
+			"GX010042.AVI",
+			// Proxies take their clip's name; the Sony sub-clip is an MP4
+			"Proxies/C0001.MP4",
+			"Proxies/DJI_0001.LRF",
+			// A proxy without its clip keeps its own name
+			"Proxies/DJI_0002.LRF",
+			"Proxies/GX010042.LRV",
+		}
+		if got := listTree(t, destDir); !reflect.DeepEqual(got, want) {
+			t.Errorf("destination = %v, want %v", got, want)
+		}
+		data, err := os.ReadFile(filepath.Join(destDir, "Proxies", "GX010042.LRV"))
+		if err != nil || string(data) != "DCIM/100GOPRO/GL010042.LRV" {
+			t.Errorf("GoPro proxy has unexpected content %q: %v", data, err)
+		}
+	})
+
+	t.Run("ignore", func(t *testing.T) {
+		sourceDir := writeProxyTestCard(t)
+		destDir := t.TempDir()
+		cfg := config{
+			SourceDir:       sourceDir,
+			DestDir:         destDir,
+			SidecarDefault:  SidecarDelete,
+			ProxyAction:     ProxyIgnore,
+			DeleteOriginals: true,
+		}
+		if err := importMedia(cfg); err != nil {
+			t.Fatalf("importMedia failed: %v", err)
+		}
+		if got, want := listTree(t, destDir), []string{"C0001.AVI", "DJI_0001.AVI", "GX010042.AVI"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("destination = %v, want %v", got, want)
+		}
+		want := []string{
+			"DCIM/100GOPRO/GL010042.LRV",
+			"Flight/DJI_0001.LRF",
+			"Flight/DJI_0002.LRF",
+			"PRIVATE/M4ROOT/SUB/C0001S03.MP4",
+		}
+		if got := listTree(t, sourceDir); !reflect.DeepEqual(got, want) {
+			t.Errorf("source after import = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		sourceDir := writeProxyTestCard(t)
+		destDir := t.TempDir()
+		cfg := config{
+			SourceDir:       sourceDir,
+			DestDir:         destDir,
+			SidecarDefault:  SidecarDelete,
+			ProxyAction:     ProxyDelete,
+			DeleteOriginals: true,
+		}
+		if err := importMedia(cfg); err != nil {
+			t.Fatalf("importMedia failed: %v", err)
+		}
+		if got, want := listTree(t, destDir), []string{"C0001.AVI", "DJI_0001.AVI", "GX010042.AVI"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("destination = %v, want %v", got, want)
+		}
+		if got := listTree(t, sourceDir); len(got) != 0 {
+			t.Errorf("source after import = %v, want no files", got)
+		}
+	})
+}
+
+func TestPlanDestinationsProxyFollowsChapterName(t *testing.T) {
+	destDir := t.TempDir()
+	base := time.Date(2024, 6, 15, 10, 30, 0, 0, time.Local)
+	files := []FileInfo{
+		chapterTestVideo("GX010042.MP4", base, 0),
+		chapterTestVideo("GX020042.MP4", base.Add(17*time.Minute), 0),
+		{SourceName: "GL020042.LRV", SourceDir: "/src", CreationDateTime: base, MediaCategory: Proxy, Size: 10, ParentIndex: -1, PairIndex: -1},
+	}
+	cfg := config{
+		DestDir:        destDir,
+		SidecarDefault: SidecarDelete,
+		ProxyAction:    ProxyCopyToSubfolder,
+	}
+	if err := planDestinations(files, cfg); err != nil {
+		t.Fatal(err)
+	}
+	got := destNamesBySource(files)
+	if want := filepath.Join(destDir, "Proxies", "GX010042_part02.LRV"); got["GL020042.LRV"] != want {
+		t.Errorf("proxy destination = %s, want %s", got["GL020042.LRV"], want)
+	}
+}
+
+func TestPlanDestinationsProxyNameCollisions(t *testing.T) {
+	destDir := t.TempDir()
+	base := time.Date(2024, 6, 15, 10, 30, 0, 0, time.Local)
+	// A different proxy of the same name is already in the library
+	writeLibraryTestFile(t, destDir, "Proxies/GX010042.LRV", "older proxy")
+	files := []FileInfo{
+		{SourceName: "GX010042.MP4", SourceDir: "/src", CreationDateTime: base, MediaCategory: Video, FileType: MP4, Size: 100, ParentIndex: -1, PairIndex: -1},
+		{SourceName: "GL010042.LRV", SourceDir: "/src", CreationDateTime: base, MediaCategory: Proxy, Size: 10, ParentIndex: -1, PairIndex: -1},
+		{SourceName: "GX010042.LRV", SourceDir: "/src", CreationDateTime: base, MediaCategory: Proxy, Size: 12, ParentIndex: -1, PairIndex: -1},
+	}
+	cfg := config{
+		DestDir:        destDir,
+		SidecarDefault: SidecarDelete,
+		ProxyAction:    ProxyCopyToSubfolder,
+	}
+	if err := planDestinations(files, cfg); err != nil {
+		t.Fatal(err)
+	}
+	got := destNamesBySource(files)
+	want := map[string]string{
+		"GL010042.LRV": filepath.Join(destDir, "Proxies", "GX010042_001.LRV"),
+		"GX010042.LRV": filepath.Join(destDir, "Proxies", "GX010042_002.LRV"),
+	}
+	for sourceName, destPath := range want {
+		if got[sourceName] != destPath {
+			t.Errorf("%s: got destination %s, want %s", sourceName, got[sourceName], destPath)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// RawJPEGPolicy defines how RAW+JPEG siblings shot together are imported
+type RawJPEGPolicy string
+
+const (
+	RawJPEGKeepBoth        RawJPEGPolicy = "keep_both"
+	RawJPEGRawOnly         RawJPEGPolicy = "raw_only"
+	RawJPEGJPEGOnly        RawJPEGPolicy = "jpeg_only"
+	RawJPEGJPEGToSubfolder RawJPEGPolicy = "jpeg_to_subfolder"
+)
+
+// rawJPEGSubfolder is the directory below the RAW destination that receives
+// JPEG siblings under the jpeg_to_subfolder policy.
+const rawJPEGSubfolder = "JPEG"
+
+// isValidRawJPEGPolicy returns true if the policy is a valid RawJPEGPolicy value.
+// An empty policy is valid and means keep_both.
+func isValidRawJPEGPolicy(policy RawJPEGPolicy) bool {
+	switch policy {
+	case "", RawJPEGKeepBoth, RawJPEGRawOnly, RawJPEGJPEGOnly, RawJPEGJPEGToSubfolder:
+		return true
+	default:
+		return false
+	}
+}
+
+// effectiveRawJPEGPolicy returns the policy to apply, defaulting to keep_both.
+func effectiveRawJPEGPolicy(policy RawJPEGPolicy) RawJPEGPolicy {
+	if policy == "" {
+		return RawJPEGKeepBoth
+	}
+	return policy
+}
+
+// isRawJPEGPairMember returns true if the file can take part in a RAW+JPEG pair.
+func isRawJPEGPairMember(file FileInfo) bool {
+	return file.MediaCategory == RawPicture || file.FileType == JPEG
+}
+
+// pairRawJPEGFiles links RAW and JPEG files that share a source directory and
+// base name through PairIndex. The lower index of each pair is planned first
+// and leads the pair; the other member follows its destination base name.
+// Files without a sibling keep PairIndex -1.
+func pairRawJPEGFiles(files []FileInfo) {
+	type pairKey struct {
+		dir      string
+		baseName string
+	}
+	raws := make(map[pairKey]int)
+	jpegs := make(map[pairKey]int)
+
+	for i := range files {
+		files[i].PairIndex = -1
+		if !isRawJPEGPairMember(files[i]) {
+			continue
+		}
+		ext := filepath.Ext(files[i].SourceName)
+		key := pairKey{dir: files[i].SourceDir, baseName: strings.ToLower(strings.TrimSuffix(files[i].SourceName, ext))}
+		members := jpegs
+		if files[i].MediaCategory == RawPicture {
+			members = raws
+		}
+		if _, exists := members[key]; !exists {
+			members[key] = i
+		}
+	}
+
+	for key, rawIndex := range raws {
+		jpegIndex, ok := jpegs[key]
+		if !ok {
+			continue
+		}
+		files[rawIndex].PairIndex = jpegIndex
+		files[jpegIndex].PairIndex = rawIndex
+	}
+}
+
+// skippedByRawJPEGPolicy returns true if the policy drops this pair member
+// from the import.
+func skippedByRawJPEGPolicy(file FileInfo, policy RawJPEGPolicy) bool {
+	if file.PairIndex == -1 {
+		return false
+	}
+	switch effectiveRawJPEGPolicy(policy) {
+	case RawJPEGRawOnly:
+		return file.FileType == JPEG
+	case RawJPEGJPEGOnly:
+		return file.MediaCategory == RawPicture
+	default:
+		return false
+	}
+}
+
+// pairMemberDestDir returns the destination directory of a pair member given
+// the directory the pair is organized into.
+func pairMemberDestDir(pairDir string, file FileInfo, policy RawJPEGPolicy) string {
+	if file.PairIndex != -1 && file.FileType == JPEG && effectiveRawJPEGPolicy(policy) == RawJPEGJPEGToSubfolder {
+		return filepath.Join(pairDir, rawJPEGSubfolder)
+	}
+	return pairDir
+}
+
+// pairFollowerDestDir returns the destination directory of the pair member
+// that follows the already planned leader.
+func pairFollowerDestDir(leader, follower FileInfo, policy RawJPEGPolicy) string {
+	if effectiveRawJPEGPolicy(policy) != RawJPEGJPEGToSubfolder {
+		return leader.DestDir
+	}
+	if follower.FileType == JPEG {
+		return filepath.Join(leader.DestDir, rawJPEGSubfolder)
+	}
+	return filepath.Dir(leader.DestDir)
+}
+
+// pairedFollowerIndex returns the index of the pair member that will follow
+// the file at currentIndex, or -1 if the file does not lead an active pair.
+func pairedFollowerIndex(files []FileInfo, currentIndex int) int {
+	partner := files[currentIndex].PairIndex
+	if partner <= currentIndex || files[partner].Status == StatusPairSkipped || files[partner].LibraryPath != "" {
+		return -1
+	}
+	return partner
+}
+
+// pairedDestName returns the destination name a pair member gets when it
+// shares the given base name with its sibling.
+func pairedDestName(file FileInfo, baseName string, cfg config) string {
+	ext := filepath.Ext(file.SourceName)
+	if cfg.RenameByDateTime {
+		ext = renamedExtension(file, ext, cfg)
+	}
+	return baseName + ext
+}
+
+// isPairedNameAvailable reports whether the sibling that follows the file at
+// currentIndex can take the given base name as well, so a leader only settles
+// on a name both pair members can use.
+func isPairedNameAvailable(files *[]FileInfo, currentIndex int, baseName string, cfg config) (bool, error) {
+	followerIndex := pairedFollowerIndex(*files, currentIndex)
+	if followerIndex == -1 {
+		return true, nil
+	}
+	follower := &(*files)[followerIndex]
+	destDir := pairFollowerDestDir((*files)[currentIndex], *follower, cfg.RawJPEGPolicy)
+	destName := pairedDestName(*follower, baseName, cfg)
+	if isDestinationPlanned(*files, followerIndex, destDir, destName) {
+		return false, nil
+	}
+
+	fullPath := filepath.Join(destDir, destName)
+	fileExists, err := exists(destinationFor(cfg), fullPath)
+	if err != nil {
+		return false, fmt.Errorf("error checking file %s: %w", fullPath, err)
+	}
+	if !fileExists {
+		return true, nil
+	}
+	return isDuplicate(sourceFor(cfg), destinationFor(cfg), follower, fullPath, cfg.ChecksumDuplicates)
+}
+
+// planPairFollower gives the follower of a RAW+JPEG pair the same destination
+// base name as its already planned leader.
+func planPairFollower(files *[]FileInfo, leaderIndex, followerIndex int, cfg config, sizeTimeIndex map[fileSizeTime][]int) error {
+	leader := (*files)[leaderIndex]
+	follower := &(*files)[followerIndex]
+	follower.DestDir = pairFollowerDestDir(leader, *follower, cfg.RawJPEGPolicy)
+	destName := pairedDestName(*follower, strings.TrimSuffix(leader.DestName, filepath.Ext(leader.DestName)), cfg)
+
+	if isDuplicateInPreviousFiles(sourceFor(cfg), files, followerIndex, cfg.ChecksumDuplicates, sizeTimeIndex) {
+		follower.Status = StatusPreExisting
+		follower.DestName = destName
+		return nil
+	}
+
+	fullPath := filepath.Join(follower.DestDir, destName)
+	fileExists, err := exists(destinationFor(cfg), fullPath)
+	if err != nil {
+		return fmt.Errorf("error checking file %s: %w", fullPath, err)
+	}
+	if !fileExists && !isNameTakenByPlannedFile(files, followerIndex, destName) {
+		follower.DestName = destName
+		return nil
+	}
+
+	dup, err := isDuplicate(sourceFor(cfg), destinationFor(cfg), follower, fullPath, cfg.ChecksumDuplicates)
+	if err != nil {
+		return err
+	}
+	if dup {
+		follower.Status = StatusPreExisting
+		follower.DestName = destName
+		return nil
+	}
+
+	// The leader only takes names its follower can share, so this is reached
+	// when the leader itself matched an existing duplicate. Plan independently.
+	return setFinalDestinationFilename(files, followerIndex, destName, cfg, sizeTimeIndex)
+}
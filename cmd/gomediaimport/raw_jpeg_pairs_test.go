@@ -0,0 +1,331 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func rawJPEGPairTestFiles(capturedAt time.Time) []FileInfo {
+	return []FileInfo{
+		{
+			SourceName:       "DSC0001.ARW",
+			SourceDir:        "/src",
+			CreationDateTime: capturedAt,
+			Size:             2000,
+			MediaCategory:    RawPicture,
+			FileType:         RAW,
+			ParentIndex:      -1,
+		},
+		{
+			SourceName:       "DSC0001.JPG",
+			SourceDir:        "/src",
+			CreationDateTime: capturedAt,
+			Size:             500,
+			MediaCategory:    ProcessedPicture,
+			FileType:         JPEG,
+			ParentIndex:      -1,
+		},
+		{
+			SourceName:       "DSC0002.JPG",
+			SourceDir:        "/src",
+			CreationDateTime: capturedAt.Add(time.Second),
+			Size:             600,
+			MediaCategory:    ProcessedPicture,
+			FileType:         JPEG,
+			ParentIndex:      -1,
+		},
+	}
+}
+
+func destNamesBySource(files []FileInfo) map[string]string {
+	got := make(map[string]string, len(files))
+	for _, file := range files {
+		got[file.SourceName] = filepath.Join(file.DestDir, file.DestName)
+	}
+	return got
+}
+
+func TestPairRawJPEGFiles(t *testing.T) {
+	files := rawJPEGPairTestFiles(time.Date(2024, 6, 15, 10, 30, 0, 0, time.Local))
+	files = append(files, FileInfo{
+		SourceName:    "DSC0001.ARW",
+		SourceDir:     "/src/other",
+		MediaCategory: RawPicture,
+		FileType:      RAW,
+	})
+
+	pairRawJPEGFiles(files)
+
+	want := []int{1, 0, -1, -1}
+	for i, pairIndex := range want {
+		if files[i].PairIndex != pairIndex {
+			t.Errorf("%s in %s: got PairIndex=%d, want %d", files[i].SourceName, files[i].SourceDir, files[i].PairIndex, pairIndex)
+		}
+	}
+}
+
+func TestPlanDestinationsRawJPEGPolicies(t *testing.T) {
+	capturedAt := time.Date(2024, 6, 15, 10, 30, 0, 0, time.Local)
+
+	tests := []struct {
+		name   string
+		policy RawJPEGPolicy
+		want   map[string]string
+		status map[string]FileStatus
+	}{
+		{
+			name:   "KeepBoth",
+			policy: RawJPEGKeepBoth,
+			want: map[string]string{
+				"DSC0001.ARW": "20240615_103000.arw",
+				"DSC0001.JPG": "20240615_103000.jpg",
+				"DSC0002.JPG": "20240615_103001.jpg",
+			},
+		},
+		{
+			name:   "DefaultsToKeepBoth",
+			policy: "",
+			want: map[string]string{
+				"DSC0001.ARW": "20240615_103000.arw",
+				"DSC0001.JPG": "20240615_103000.jpg",
+			},
+		},
+		{
+			name:   "RawOnly",
+			policy: RawJPEGRawOnly,
+			want: map[string]string{
+				"DSC0001.ARW": "20240615_103000.arw",
+				"DSC0002.JPG": "20240615_103001.jpg",
+			},
+			status: map[string]FileStatus{"DSC0001.JPG": StatusPairSkipped},
+		},
+		{
+			name:   "JPEGOnly",
+			policy: RawJPEGJPEGOnly,
+			want: map[string]string{
+				"DSC0001.JPG": "20240615_103000.jpg",
+				"DSC0002.JPG": "20240615_103001.jpg",
+			},
+			status: map[string]FileStatus{"DSC0001.ARW": StatusPairSkipped},
+		},
+		{
+			name:   "JPEGToSubfolder",
+			policy: RawJPEGJPEGToSubfolder,
+			want: map[string]string{
+				"DSC0001.ARW": "20240615_103000.arw",
+				"DSC0001.JPG": filepath.Join(rawJPEGSubfolder, "20240615_103000.jpg"),
+				"DSC0002.JPG": "20240615_103001.jpg",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			destDir := t.TempDir()
+			files := rawJPEGPairTestFiles(capturedAt)
+			cfg := config{
+				DestDir:          destDir,
+				RenameByDateTime: true,
+				SidecarDefault:   SidecarDelete,
+				Sidecars:         make(map[string]SidecarAction),
+				RawJPEGPolicy:    tt.policy,
+			}
+			if err := planDestinations(files, cfg); err != nil {
+				t.Fatal(err)
+			}
+
+			got := destNamesBySource(files)
+			for sourceName, destName := range tt.want {
+				if want := filepath.Join(destDir, destName); got[sourceName] != want {
+					t.Errorf("%s: got destination %s, want %s", sourceName, got[sourceName], want)
+				}
+			}
+			for _, file := range files {
+				if want, ok := tt.status[file.SourceName]; ok && file.Status != want {
+					t.Errorf("%s: got status %q, want %q", file.SourceName, file.Status, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPlanDestinationsRawJPEGPairSharesSuffixOnCollision(t *testing.T) {
+	destDir := t.TempDir()
+	// Only the JPEG name is taken at the destination; the RAW must skip the
+	// free name so both siblings end up with the same suffix.
+	if err := os.WriteFile(filepath.Join(destDir, "DSC0001.JPG"), []byte("another camera"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	files := rawJPEGPairTestFiles(time.Date(2024, 6, 15, 10, 30, 0, 0, time.Local))
+	cfg := config{
+		DestDir:            destDir,
+		ChecksumDuplicates: true,
+		SidecarDefault:     SidecarDelete,
+		Sidecars:           make(map[string]SidecarAction),
+	}
+	if err := planDestinations(files, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if files[0].DestName != "DSC0001_001.ARW" {
+		t.Errorf("expected RAW DestName=DSC0001_001.ARW, got %s", files[0].DestName)
+	}
+	if files[1].DestName != "DSC0001_001.JPG" {
+		t.Errorf("expected JPEG DestName=DSC0001_001.JPG, got %s", files[1].DestName)
+	}
+}
+
+func TestPlanDestinationsRawJPEGPairReservesFollowerName(t *testing.T) {
+	destDir := t.TempDir()
+	capturedAt := time.Date(2024, 6, 15, 10, 30, 0, 0, time.Local)
+	files := []FileInfo{
+		{SourceName: "DSC0001.ARW", SourceDir: "/src/a", CreationDateTime: capturedAt, Size: 2000, MediaCategory: RawPicture, FileType: RAW, ParentIndex: -1},
+		{SourceName: "DSC0001.JPG", SourceDir: "/src/b", CreationDateTime: capturedAt, Size: 400, MediaCategory: ProcessedPicture, FileType: JPEG, ParentIndex: -1},
+		{SourceName: "DSC0001.JPG", SourceDir: "/src/a", CreationDateTime: capturedAt, Size: 500, MediaCategory: ProcessedPicture, FileType: JPEG, ParentIndex: -1},
+	}
+	cfg := config{
+		DestDir:        destDir,
+		SidecarDefault: SidecarDelete,
+		Sidecars:       make(map[string]SidecarAction),
+	}
+	if err := planDestinations(files, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if files[0].DestName != "DSC0001.ARW" || files[2].DestName != "DSC0001.JPG" {
+		t.Errorf("expected pair to keep DSC0001, got %s and %s", files[0].DestName, files[2].DestName)
+	}
+	if files[1].DestName != "DSC0001_001.JPG" {
+		t.Errorf("expected unpaired JPEG to avoid the reserved name, got %s", files[1].DestName)
+	}
+}
+
+func TestPlanDestinationsSidecarFollowsKeptPairMember(t *testing.T) {
+	destDir := t.TempDir()
+	files := rawJPEGPairTestFiles(time.Date(2024, 6, 15, 10, 30, 0, 0, time.Local))
+	files = append(files, FileInfo{
+		SourceName:    "DSC0001.xmp",
+		SourceDir:     "/src",
+		Size:          50,
+		MediaCategory: Sidecar,
+		ParentIndex:   -1,
+	})
+	cfg := config{
+		DestDir:        destDir,
+		SidecarDefault: SidecarCopy,
+		Sidecars:       make(map[string]SidecarAction),
+		RawJPEGPolicy:  RawJPEGJPEGOnly,
+	}
+	if err := planDestinations(files, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if files[3].ParentIndex != 1 {
+		t.Errorf("expected sidecar to follow the kept JPEG, got ParentIndex=%d", files[3].ParentIndex)
+	}
+	if files[3].DestName != "DSC0001.xmp" {
+		t.Errorf("expected sidecar DestName=DSC0001.xmp, got %s", files[3].DestName)
+	}
+}
+
+func TestImportMediaRawOnlyKeepsSkippedJPEG(t *testing.T) {
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+	for _, name := range []string{"DSC0001.ARW", "DSC0001.JPG"} {
+		if err := os.WriteFile(filepath.Join(sourceDir, name), []byte("data for "+name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := config{
+		SourceDir:       sourceDir,
+		DestDir:         destDir,
+		DeleteOriginals: true,
+		Quiet:           true,
+		SidecarDefault:  SidecarDelete,
+		Sidecars:        make(map[string]SidecarAction),
+		RawJPEGPolicy:   RawJPEGRawOnly,
+	}
+	if err := importMedia(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(destDir, "DSC0001.ARW")); err != nil {
+		t.Errorf("expected RAW to be imported: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "DSC0001.JPG")); !os.IsNotExist(err) {
+		t.Errorf("expected JPEG not to be imported, got err=%v", err)
+	}
+	// The skipped JPEG was never copied, so it stays on the card
+	if _, err := os.Stat(filepath.Join(sourceDir, "DSC0001.JPG")); err != nil {
+		t.Errorf("expected skipped JPEG to be kept on the source: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(sourceDir, "DSC0001.ARW")); !os.IsNotExist(err) {
+		t.Errorf("expected imported RAW to be deleted from source, got err=%v", err)
+	}
+}
+
+func TestValidateConfigRawJPEGPolicy(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config{
+		SourceDir:      tmpDir,
+		DestDir:        filepath.Join(tmpDir, "dest"),
+		SidecarDefault: SidecarDelete,
+		RawJPEGPolicy:  "raw_and_jpeg",
+	}
+	if err := validateConfig(cfg); err == nil {
+		t.Error("validateConfig should return error for invalid RAW+JPEG policy")
+	}
+
+	cfg.RawJPEGPolicy = RawJPEGJPEGToSubfolder
+	cfg.RemovableVolumes = map[string]removableVolumeConfig{"CAM": {RawJPEGPolicy: "everything"}}
+	if err := validateConfig(cfg); err == nil {
+		t.Error("validateConfig should return error for invalid per-volume RAW+JPEG policy")
+	}
+
+	cfg.RemovableVolumes = map[string]removableVolumeConfig{"CAM": {RawJPEGPolicy: RawJPEGRawOnly}}
+	if err := validateConfig(cfg); err != nil {
+		t.Errorf("validateConfig failed for valid RAW+JPEG policies: %v", err)
+	}
+}
+
+func TestImportConfiguredRemovableVolumesAppliesRawJPEGPolicy(t *testing.T) {
+	camMount := t.TempDir()
+	sofiaMount := t.TempDir()
+	withMountedRemovableVolumes(t, []mountedRemovableVolume{
+		{Label: "CAM", MountPath: camMount},
+		{Label: "SOFIA", MountPath: sofiaMount},
+	})
+
+	var calls []config
+	withImportMediaRunner(t, func(cfg config) error {
+		calls = append(calls, cfg)
+		return nil
+	})
+
+	cfg := config{
+		DestDir:        t.TempDir(),
+		SidecarDefault: SidecarDelete,
+		RawJPEGPolicy:  RawJPEGKeepBoth,
+		RemovableVolumes: map[string]removableVolumeConfig{
+			"CAM":   {RawJPEGPolicy: RawJPEGRawOnly},
+			"SOFIA": {},
+		},
+	}
+	if err := importConfiguredRemovableVolumes(cfg); err != nil {
+		t.Fatalf("importConfiguredRemovableVolumes failed: %v", err)
+	}
+
+	if len(calls) != 2 {
+		t.Fatalf("got %d import calls, want 2", len(calls))
+	}
+	if calls[0].RawJPEGPolicy != RawJPEGRawOnly {
+		t.Errorf("CAM got policy %q, want %q", calls[0].RawJPEGPolicy, RawJPEGRawOnly)
+	}
+	if calls[1].RawJPEGPolicy != RawJPEGKeepBoth {
+		t.Errorf("SOFIA got policy %q, want %q", calls[1].RawJPEGPolicy, RawJPEGKeepBoth)
+	}
+}
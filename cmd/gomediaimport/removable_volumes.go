@@ -27,7 +27,12 @@ type volumesAddCmd struct {
 }
 
 type removableVolumeConfig struct {
-	DestDir string `yaml:"destination_directory,omitempty"`
+	DestDir       string        `yaml:"destination_directory,omitempty"`
+	RawJPEGPolicy RawJPEGPolicy `yaml:"raw_jpeg_policy,omitempty"`
+	MetadataEdits metadataEdits `yaml:"metadata_edits,omitempty"`
+	// GPXDirectory holds the GPX tracks for the volume's files. A relative
+	// path is resolved against the volume's mount path.
+	GPXDirectory string `yaml:"gpx_directory,omitempty"`
 }
 
 type mountedRemovableVolume struct {
@@ -39,6 +44,7 @@ type removableVolumeImport struct {
 	Label     string
 	SourceDir string
 	DestDir   string
+	Config    removableVolumeConfig
 }
 
 var mountedRemovableVolumes = listMountedRemovableVolumes
@@ -96,7 +102,11 @@ func addRemovableVolume(cfg config, cmd *volumesAddCmd) error {
 		return fmt.Errorf("selected removable volume does not have a label")
 	}
 
-	if cmd.DestDir != "" {
+	if isRemoteDestination(cmd.DestDir) {
+		if err := validateDestinationURL(cmd.DestDir); err != nil {
+			return err
+		}
+	} else if cmd.DestDir != "" {
 		destParent := filepath.Dir(cmd.DestDir)
 		if _, err := os.Stat(destParent); os.IsNotExist(err) {
 			return fmt.Errorf("destination parent directory does not exist: %s", destParent)
@@ -110,7 +120,7 @@ func addRemovableVolume(cfg config, cmd *volumesAddCmd) error {
 	if cmd.DestDir == "" {
 		fmt.Printf("Saved removable volume label %q using default destination.\n", label)
 	} else {
-		fmt.Printf("Saved removable volume label %q with destination %s.\n", label, cmd.DestDir)
+		fmt.Printf("Saved removable volume label %q with destination %s.\n", label, redactDestination(cmd.DestDir))
 	}
 	return nil
 }
@@ -162,10 +172,12 @@ func importConfiguredRemovableVolumes(cfg config) error {
 	for _, volumeImport := range imports {
 		importCfg := cfg
 		importCfg.SourceDir = volumeImport.SourceDir
+		importCfg.Source = localSource{root: volumeImport.SourceDir, volume: volumeImport.Label}
 		importCfg.DestDir = volumeImport.DestDir
+		applyRemovableVolumeOverrides(&importCfg, volumeImport.Config)
 
 		if !cfg.Quiet {
-			fmt.Printf("Importing removable volume %q from %s to %s\n", volumeImport.Label, volumeImport.SourceDir, volumeImport.DestDir)
+			fmt.Printf("Importing removable volume %q from %s to %s\n", volumeImport.Label, volumeImport.SourceDir, redactDestination(volumeImport.DestDir))
 		}
 
 		if err := validateConfig(&importCfg); err != nil {
@@ -183,6 +195,21 @@ func importConfiguredRemovableVolumes(cfg config) error {
 	return nil
 }
 
+// applyRemovableVolumeOverrides replaces global settings with the ones a saved
+// removable volume label sets for itself.
+func applyRemovableVolumeOverrides(cfg *config, entry removableVolumeConfig) {
+	if entry.RawJPEGPolicy != "" {
+		cfg.RawJPEGPolicy = entry.RawJPEGPolicy
+	}
+	cfg.MetadataEdits = mergeMetadataEdits(cfg.MetadataEdits, entry.MetadataEdits)
+	if entry.GPXDirectory != "" {
+		cfg.GPXDirectory = entry.GPXDirectory
+		if !filepath.IsAbs(cfg.GPXDirectory) {
+			cfg.GPXDirectory = filepath.Join(cfg.SourceDir, cfg.GPXDirectory)
+		}
+	}
+}
+
 func plannedRemovableVolumeImports(cfg config) ([]removableVolumeImport, error) {
 	volumes, err := sortedMountedRemovableVolumes()
 	if err != nil {
@@ -208,6 +235,7 @@ func plannedRemovableVolumeImports(cfg config) ([]removableVolumeImport, error)
 					Label:     label,
 					SourceDir: volume.MountPath,
 					DestDir:   destDir,
+					Config:    entry,
 				})
 			}
 		}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mattn/go-isatty"
+)
+
+// errReviewCancelled is returned when the user quits the interactive review
+var errReviewCancelled = errors.New("import cancelled in review")
+
+// reviewUnit is a row of the interactive review: a media file together with
+// the siblings, sidecars, proxies, or chapters it is always imported with
+type reviewUnit struct {
+	Files    []int // Indexes into the unplanned files, main file first
+	Excluded bool
+	Dir      string // Destination folder chosen in review, "" if unchanged
+}
+
+// reviewGroup is the units captured on one day with one camera
+type reviewGroup struct {
+	Day    string
+	Camera string
+	Units  []*reviewUnit
+}
+
+// importReview is the state of an interactive review
+type importReview struct {
+	files  []FileInfo // Unplanned files, as enumerated
+	events []*EventInfo
+	cfg    config
+	groups []*reviewGroup
+	units  []*reviewUnit // In display order, numbered from 1
+}
+
+// newImportReview groups the units of files by capture day and camera
+func newImportReview(files []FileInfo, events []*EventInfo, cfg config) *importReview {
+	review := &importReview{files: files, events: events, cfg: cfg}
+	byKey := make(map[string]*reviewGroup)
+	for _, unit := range eventUnits(files) {
+		// The main file is the first media file, so a row is named after
+		// the picture or clip rather than its sidecar
+		sort.SliceStable(unit.Files, func(a, b int) bool {
+			return isReviewMedia(files[unit.Files[a]]) && !isReviewMedia(files[unit.Files[b]])
+		})
+		first := files[unit.Files[0]]
+		day := unit.Time.Format("2006-01-02")
+		camera := fileCamera(first)
+		key := day + "\x00" + camera
+		group, ok := byKey[key]
+		if !ok {
+			group = &reviewGroup{Day: day, Camera: camera}
+			byKey[key] = group
+			review.groups = append(review.groups, group)
+		}
+		group.Units = append(group.Units, &reviewUnit{Files: unit.Files})
+	}
+	sort.SliceStable(review.groups, func(i, j int) bool {
+		if review.groups[i].Day != review.groups[j].Day {
+			return review.groups[i].Day < review.groups[j].Day
+		}
+		return review.groups[i].Camera < review.groups[j].Camera
+	})
+	for _, group := range review.groups {
+		sort.SliceStable(group.Units, func(i, j int) bool {
+			a, b := files[group.Units[i].Files[0]], files[group.Units[j].Files[0]]
+			if !a.CreationDateTime.Equal(b.CreationDateTime) {
+				return a.CreationDateTime.Before(b.CreationDateTime)
+			}
+			return naturalCompare(a.SourceName, b.SourceName) < 0
+		})
+		review.units = append(review.units, group.Units...)
+	}
+	return review
+}
+
+func isReviewMedia(file FileInfo) bool {
+	return file.MediaCategory != Sidecar && file.MediaCategory != Proxy
+}
+
+// plan plans the destinations of the included files on a fresh copy, so
+// every change is planned from scratch. Source checksums computed while
+// planning are kept, so a file is read at most once however often the
+// review plans again.
+func (r *importReview) plan(cfg config) ([]FileInfo, int, error) {
+	unitOf := make([]*reviewUnit, len(r.files))
+	for _, unit := range r.units {
+		for _, i := range unit.Files {
+			unitOf[i] = unit
+		}
+	}
+	// Planning keeps the enumeration order, which sidecars and pairs rely on
+	var planned []FileInfo
+	excluded := 0
+	for i, file := range r.files {
+		if unitOf[i].Excluded {
+			excluded++
+			continue
+		}
+		file.ReviewDir = unitOf[i].Dir
+		planned = append(planned, file)
+	}
+	err := planDestinations(planned, cfg)
+	// Planning may sort the copy, so checksums are matched by source path
+	checksums := make(map[string]string)
+	for _, file := range planned {
+		if file.SourceChecksum != "" {
+			checksums[sourcePath(file)] = file.SourceChecksum
+		}
+	}
+	for i := range r.files {
+		if checksum, ok := checksums[sourcePath(r.files[i])]; ok {
+			r.files[i].SourceChecksum = checksum
+		}
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return planned, excluded, nil
+}
+
+// reviewLine is a line of the review screen: a group header, with Unit -1,
+// or a unit, indexing r.units
+type reviewLine struct {
+	Group int
+	Unit  int
+	Text  string
+}
+
+// lines lays out the review screen: each group with its units, their planned
+// destination and status
+func (r *importReview) lines(planned []FileInfo) []reviewLine {
+	byPath := make(map[string]FileInfo, len(planned))
+	for _, file := range planned {
+		byPath[sourcePath(file)] = file
+	}
+	var lines []reviewLine
+	n := 0
+	for g, group := range r.groups {
+		included := 0
+		for _, unit := range group.Units {
+			if !unit.Excluded {
+				included++
+			}
+		}
+		camera := group.Camera
+		if camera == "" {
+			camera = "unknown camera"
+		}
+		lines = append(lines, reviewLine{Group: g, Unit: -1,
+			Text: fmt.Sprintf("g%d  %s  %s  (%d of %d included)", g+1, group.Day, camera, included, len(group.Units))})
+		for _, unit := range group.Units {
+			n++
+			mark, destination, status := "x", "", "excluded"
+			if unit.Excluded {
+				mark = " "
+			} else {
+				file := byPath[sourcePath(r.files[unit.Files[0]])]
+				status = reviewStatus(file.Status)
+				if file.DestName != "" {
+					destination = r.relativeDestination(filepath.Join(file.DestDir, file.DestName))
+				}
+			}
+			name := r.files[unit.Files[0]].SourceName
+			if extra := len(unit.Files) - 1; extra > 0 {
+				name = fmt.Sprintf("%s +%d", name, extra)
+			}
+			lines = append(lines, reviewLine{Group: g, Unit: n - 1,
+				Text: fmt.Sprintf("  %3d [%s] %-28s %-40s %s", n, mark, name, destination, status)})
+		}
+	}
+	return lines
+}
+
+// render writes the review screen
+func (r *importReview) render(out io.Writer, planned []FileInfo) {
+	for _, line := range r.lines(planned) {
+		_, _ = fmt.Fprintln(out, line.Text)
+	}
+}
+
+// reviewStatus names a planned status; files without one will be copied
+func reviewStatus(status FileStatus) string {
+	if status == "" {
+		return "copy"
+	}
+	return string(status)
+}
+
+// relativeDestination shows a destination path below the destination
+func (r *importReview) relativeDestination(path string) string {
+	if rel, err := filepath.Rel(r.cfg.DestDir, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
+
+const reviewEventsDisabled = "Event names require --organize-by event"
+
+const reviewHelp = `Commands:
+  3, 3-7, 3,5    Include or exclude files
+  g2             Include or exclude a group
+  d 3 FOLDER     Put files in FOLDER below the destination ("d g2 FOLDER" for a group, no FOLDER to undo)
+  e g2 NAME      Rename the event of a group (with --organize-by event)
+  c              Continue with the import
+  q              Quit without importing
+`
+
+// reviewImport shows the planned import and lets the user exclude files and
+// change destination folders and event names before anything is copied. It
+// returns the planned files to import and the number excluded.
+func reviewImport(files []FileInfo, events []*EventInfo, cfg config, in io.Reader, out io.Writer) ([]FileInfo, int, error) {
+	review := newImportReview(files, events, cfg)
+	// Previews are planned quietly; the final plan reports as usual
+	quiet := cfg
+	quiet.Verbose = false
+	quiet.Log = nil
+	redraw := false
+	if f, ok := out.(*os.File); ok {
+		redraw = isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd())
+		// Keys can be read one by one only from a native terminal
+		if inFile, ok := in.(*os.File); ok && isatty.IsTerminal(f.Fd()) && isatty.IsTerminal(inFile.Fd()) {
+			return runReviewTerminal(review, inFile, f, quiet, cfg)
+		}
+	}
+	reader := bufio.NewReader(in)
+	message := ""
+	for {
+		planned, _, err := review.plan(quiet)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to plan destinations: %w", err)
+		}
+		if redraw {
+			_, _ = fmt.Fprint(out, "\x1b[H\x1b[2J")
+		}
+		review.render(out, planned)
+		if message != "" {
+			_, _ = fmt.Fprintln(out, message)
+		}
+		_, _ = fmt.Fprint(out, "Command (? for help, c to continue, q to quit): ")
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, 0, fmt.Errorf("failed to read command: %w", err)
+		}
+		if err == io.EOF && strings.TrimSpace(line) == "" {
+			_, _ = fmt.Fprintln(out)
+			return nil, 0, errReviewCancelled
+		}
+		done, cancel, reply := review.apply(strings.TrimSpace(line))
+		switch {
+		case cancel:
+			return nil, 0, errReviewCancelled
+		case done:
+			return review.plan(cfg)
+		}
+		message = reply
+	}
+}
+
+// apply runs one review command and returns whether to continue with the
+// import or quit, and a message to show
+func (r *importReview) apply(command string) (done, cancel bool, message string) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return false, false, ""
+	}
+	switch fields[0] {
+	case "c":
+		return true, false, ""
+	case "q":
+		return false, true, ""
+	case "?", "h", "help":
+		return false, false, reviewHelp
+	case "d":
+		if len(fields) < 2 {
+			return false, false, "Usage: d 3 FOLDER or d g2 FOLDER"
+		}
+		units, err := r.selectUnits(fields[1])
+		if err != nil {
+			return false, false, err.Error()
+		}
+		folder := strings.Join(fields[2:], " ")
+		if folder != "" && !validReviewFolder(folder) {
+			return false, false, fmt.Sprintf("%q cannot be used as a folder below the destination", folder)
+		}
+		for _, unit := range units {
+			unit.Dir = filepath.FromSlash(folder)
+		}
+		return false, false, ""
+	case "e":
+		if r.cfg.OrganizeBy != OrganizeModeEvent {
+			return false, false, reviewEventsDisabled
+		}
+		if len(fields) < 3 || !strings.HasPrefix(fields[1], "g") {
+			return false, false, "Usage: e g2 NAME"
+		}
+		units, err := r.selectUnits(fields[1])
+		if err != nil {
+			return false, false, err.Error()
+		}
+		name := strings.Join(fields[2:], " ")
+		if !validEventName(name) {
+			return false, false, fmt.Sprintf("%q cannot be used in a folder name", name)
+		}
+		renamed := make(map[*EventInfo]bool)
+		for _, unit := range units {
+			if event := r.files[unit.Files[0]].Event; event != nil {
+				event.Name = name
+				renamed[event] = true
+			}
+		}
+		assignEventFolders(r.events)
+		return false, false, fmt.Sprintf("Renamed %d event(s) to %q", len(renamed), name)
+	}
+
+	units, err := r.selectUnits(fields[0])
+	if err != nil {
+		return false, false, err.Error()
+	}
+	if !strings.HasPrefix(fields[0], "g") {
+		for _, unit := range units {
+			unit.Excluded = !unit.Excluded
+		}
+		return false, false, ""
+	}
+	// A group is toggled as a whole: included again only if all of it was
+	// excluded
+	exclude := false
+	for _, unit := range units {
+		if !unit.Excluded {
+			exclude = true
+		}
+	}
+	for _, unit := range units {
+		unit.Excluded = exclude
+	}
+	return false, false, ""
+}
+
+// selectUnits parses a group like g2 or file numbers like 3, 3-7, or 3,5
+func (r *importReview) selectUnits(selection string) ([]*reviewUnit, error) {
+	if rest, ok := strings.CutPrefix(selection, "g"); ok {
+		g, err := strconv.Atoi(rest)
+		if err != nil || g < 1 || g > len(r.groups) {
+			return nil, fmt.Errorf("no group %s", selection)
+		}
+		return r.groups[g-1].Units, nil
+	}
+	var units []*reviewUnit
+	for _, part := range strings.Split(selection, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		from, err := strconv.Atoi(first)
+		to := from
+		if err == nil && isRange {
+			to, err = strconv.Atoi(last)
+		}
+		if err != nil || from < 1 || to > len(r.units) || from > to {
+			return nil, fmt.Errorf("no file %s (? for help)", part)
+		}
+		units = append(units, r.units[from-1:to]...)
+	}
+	return units, nil
+}
+
+// validReviewFolder returns true if folder is a relative path of folder
+// names usable on every platform
+func validReviewFolder(folder string) bool {
+	for _, segment := range strings.Split(folder, "/") {
+		if !validEventName(segment) {
+			return false
+		}
+	}
+	return true
+}
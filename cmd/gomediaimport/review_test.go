@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// reviewTestFiles returns two pictures and a sidecar from one camera on one
+// day and a clip from another camera the next day
+func reviewTestFiles() []FileInfo {
+	day := time.Date(2024, 6, 15, 10, 0, 0, 0, time.Local)
+	canon := &ImageMetadata{Make: "Canon", Model: "Canon EOS R5"}
+	return []FileInfo{
+		{SourceName: "IMG_0001.JPG", SourceDir: "/src", CreationDateTime: day, Size: 100, MediaCategory: ProcessedPicture, FileType: JPEG, ImageMetadata: canon, ParentIndex: -1},
+		{SourceName: "IMG_0001.XMP", SourceDir: "/src", CreationDateTime: day, Size: 10, MediaCategory: Sidecar, ParentIndex: -1},
+		{SourceName: "IMG_0002.JPG", SourceDir: "/src", CreationDateTime: day.Add(time.Minute), Size: 200, MediaCategory: ProcessedPicture, FileType: JPEG, ImageMetadata: canon, ParentIndex: -1},
+		{SourceName: "GX010001.MP4", SourceDir: "/src", CreationDateTime: day.AddDate(0, 0, 1), Size: 300, MediaCategory: Video, FileType: MP4,
+			VideoMetadata: &VideoMetadata{Model: "HERO12 Black"}, ParentIndex: -1},
+	}
+}
+
+func TestReviewImport(t *testing.T) {
+	destDir := t.TempDir()
+	cfg := config{DestDir: destDir, OrganizeByDate: true, SidecarDefault: SidecarCopy, Sidecars: map[string]SidecarAction{}}
+
+	var out bytes.Buffer
+	input := strings.Join([]string{"2", "d g2 Trips/Lake", "c"}, "\n") + "\n"
+	planned, excluded, err := reviewImport(reviewTestFiles(), nil, cfg, strings.NewReader(input), &out)
+	if err != nil {
+		t.Fatalf("reviewImport failed: %v", err)
+	}
+	if excluded != 1 {
+		t.Errorf("excluded = %d, want 1", excluded)
+	}
+
+	dests := make(map[string]string)
+	for _, file := range planned {
+		dests[file.SourceName] = filepath.Join(file.DestDir, file.DestName)
+	}
+	want := map[string]string{
+		"IMG_0001.JPG": filepath.Join(destDir, "2024", "06", "IMG_0001.JPG"),
+		"IMG_0001.XMP": filepath.Join(destDir, "2024", "06", "IMG_0001.XMP"),
+		"GX010001.MP4": filepath.Join(destDir, "Trips", "Lake", "GX010001.MP4"),
+	}
+	if len(dests) != len(want) {
+		t.Fatalf("planned files = %v, want %v", dests, want)
+	}
+	for name, dest := range want {
+		if dests[name] != dest {
+			t.Errorf("%s planned at %q, want %q", name, dests[name], dest)
+		}
+	}
+
+	screen := out.String()
+	for _, line := range []string{"g1  2024-06-15  Canon EOS R5  (2 of 2 included)", "IMG_0001.JPG +1", "g2  2024-06-16  HERO12 Black"} {
+		if !strings.Contains(screen, line) {
+			t.Errorf("review screen does not contain %q:\n%s", line, screen)
+		}
+	}
+}
+
+func TestReviewImportCancel(t *testing.T) {
+	cfg := config{DestDir: t.TempDir(), SidecarDefault: SidecarDelete}
+	for _, input := range []string{"q\n", ""} {
+		var out bytes.Buffer
+		if _, _, err := reviewImport(reviewTestFiles(), nil, cfg, strings.NewReader(input), &out); !errors.Is(err, errReviewCancelled) {
+			t.Errorf("reviewImport with input %q = %v, want errReviewCancelled", input, err)
+		}
+	}
+}
+
+func TestImportReviewApply(t *testing.T) {
+	review := newImportReview(reviewTestFiles(), nil, config{})
+
+	tests := []struct {
+		command      string
+		wantExcluded []bool
+		wantMessage  string
+	}{
+		{"1-3", []bool{true, true, true}, ""},
+		{"g1", []bool{false, false, true}, ""},
+		{"g1", []bool{true, true, true}, ""},
+		{"1,3", []bool{false, true, false}, ""},
+		{"4", []bool{false, true, false}, "no file 4"},
+		{"g3", []bool{false, true, false}, "no group g3"},
+		{"d 1 ../up", []bool{false, true, false}, "cannot be used as a folder"},
+		{"e g1 Trip", []bool{false, true, false}, "--organize-by event"},
+	}
+	for _, tt := range tests {
+		_, _, message := review.apply(tt.command)
+		if tt.wantMessage != "" && !strings.Contains(message, tt.wantMessage) {
+			t.Errorf("%q: message %q, want it to contain %q", tt.command, message, tt.wantMessage)
+		}
+		for i, unit := range review.units {
+			if unit.Excluded != tt.wantExcluded[i] {
+				t.Errorf("%q: file %d excluded = %v, want %v", tt.command, i+1, unit.Excluded, tt.wantExcluded[i])
+			}
+		}
+	}
+}
+
+func TestImportReviewRenameEvent(t *testing.T) {
+	files := reviewTestFiles()
+	cfg := config{DestDir: t.TempDir(), OrganizeBy: OrganizeModeEvent, SidecarDefault: SidecarCopy}
+	events := clusterEvents(files, cfg)
+	assignEventFolders(events)
+
+	review := newImportReview(files, events, cfg)
+	if _, _, message := review.apply("e g2 Lake Trip"); !strings.Contains(message, "Renamed 1 event") {
+		t.Fatalf("rename message = %q", message)
+	}
+	if got := events[1].Folder; got != "2024-06-16 Lake Trip" {
+		t.Errorf("event folder = %q, want 2024-06-16 Lake Trip", got)
+	}
+	if got := events[0].Folder; got != "2024-06-15 Event" {
+		t.Errorf("other event folder = %q, want it unchanged", got)
+	}
+}
+
+func TestImportReviewKeepsSourceChecksums(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+	day := time.Date(2024, 6, 15, 10, 0, 0, 0, time.Local)
+	writeLibraryTestFile(t, srcDir, "IMG_0001.JPG", "photo one")
+	// A different file of the same size is in the way, so planning hashes
+	// the source to tell them apart
+	writeLibraryTestFile(t, destDir, "IMG_0001.JPG", "photo two")
+	files := []FileInfo{{SourceName: "IMG_0001.JPG", SourceDir: srcDir, CreationDateTime: day, Size: int64(len("photo one")),
+		MediaCategory: ProcessedPicture, FileType: JPEG, ParentIndex: -1}}
+	cfg := config{DestDir: destDir, ChecksumDuplicates: true, SidecarDefault: SidecarCopy}
+
+	review := newImportReview(files, nil, cfg)
+	planned, _, err := review.plan(cfg)
+	if err != nil {
+		t.Fatalf("plan failed: %v", err)
+	}
+	if planned[0].DestName != "IMG_0001_001.JPG" {
+		t.Fatalf("planned %s, want a suffixed name", planned[0].DestName)
+	}
+	checksum := review.files[0].SourceChecksum
+	if checksum == "" {
+		t.Fatal("expected the source checksum to be kept between plans")
+	}
+
+	// Planning again reuses the checksum instead of reading the source
+	if err := os.Remove(filepath.Join(srcDir, "IMG_0001.JPG")); err != nil {
+		t.Fatal(err)
+	}
+	planned, _, err = review.plan(cfg)
+	if err != nil {
+		t.Fatalf("second plan failed: %v", err)
+	}
+	if planned[0].DestName != "IMG_0001_001.JPG" || planned[0].SourceChecksum != checksum {
+		t.Errorf("second plan gave %s with checksum %q, want IMG_0001_001.JPG with %q", planned[0].DestName, planned[0].SourceChecksum, checksum)
+	}
+}
+
+func TestValidateConfigInteractiveQuiet(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := config{SourceDir: tmpDir, DestDir: filepath.Join(tmpDir, "dest"), SidecarDefault: SidecarDelete, Interactive: true, Quiet: true}
+	if err := validateCommonConfig(&cfg); err == nil || !strings.Contains(err.Error(), "--quiet") {
+		t.Errorf("validateCommonConfig = %v, want an error about --quiet", err)
+	}
+}
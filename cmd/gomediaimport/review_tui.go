@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/term"
+)
+
+// reviewKey is a key press on the review screen: a rune, or one of the
+// special keys below
+type reviewKey struct {
+	Rune    rune
+	Special int
+}
+
+const (
+	keyNone = iota
+	keyUp
+	keyDown
+	keyPageUp
+	keyPageDown
+	keyHome
+	keyEnd
+	keyEnter
+	keyEscape
+	keyBackspace
+	keyInterrupt
+)
+
+const reviewKeysHelp = "↑/↓ move  space include/exclude  d folder  e event name  c continue  q quit"
+
+const reviewContinueHint = "Press c to continue with the import"
+
+// reviewScreen is the full-screen review shown when both standard input and
+// output are terminals. Each key press becomes one of the review commands
+// of the line-based review, so both behave alike.
+type reviewScreen struct {
+	review  *importReview
+	height  func() int
+	cursor  int // Index into the lines of the screen
+	top     int // First line shown
+	message string
+
+	// A prompt reads a folder or event name; Enter runs command followed
+	// by the input
+	prompt  string
+	command string
+	input   []rune
+}
+
+func newReviewScreen(review *importReview, height func() int) *reviewScreen {
+	return &reviewScreen{review: review, height: height}
+}
+
+// runReviewTerminal puts the terminal in raw mode on the alternate screen and
+// runs the review screen until the user continues or quits.
+func runReviewTerminal(review *importReview, in, out *os.File, quiet, cfg config) ([]FileInfo, int, error) {
+	state, err := term.MakeRaw(int(in.Fd()))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to set up the terminal: %w", err)
+	}
+	defer func() { _ = term.Restore(int(in.Fd()), state) }()
+	_, _ = fmt.Fprint(out, "\x1b[?1049h")
+	defer func() { _, _ = fmt.Fprint(out, "\x1b[?1049l") }()
+
+	screen := newReviewScreen(review, func() int {
+		if _, height, err := term.GetSize(int(out.Fd())); err == nil && height > 0 {
+			return height
+		}
+		return 24
+	})
+	return screen.run(bufio.NewReader(in), out, quiet, cfg)
+}
+
+// run draws the screen and handles key presses. The review is planned again
+// after every change, quietly; the final plan reports as usual.
+func (s *reviewScreen) run(in *bufio.Reader, out io.Writer, quiet, cfg config) ([]FileInfo, int, error) {
+	var planned []FileInfo
+	replan := true
+	for {
+		if replan {
+			var err error
+			if planned, _, err = s.review.plan(quiet); err != nil {
+				return nil, 0, fmt.Errorf("failed to plan destinations: %w", err)
+			}
+		}
+		s.draw(out, planned)
+
+		key, err := readReviewKey(in)
+		if errors.Is(err, io.EOF) {
+			return nil, 0, errReviewCancelled
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read key: %w", err)
+		}
+		done, cancel, changed := s.handle(key)
+		switch {
+		case cancel:
+			return nil, 0, errReviewCancelled
+		case done:
+			return s.review.plan(cfg)
+		}
+		replan = changed
+	}
+}
+
+// handle applies a key press and returns whether to continue with the
+// import, quit, or plan again
+func (s *reviewScreen) handle(key reviewKey) (done, cancel, changed bool) {
+	if s.prompt != "" {
+		return false, false, s.handlePrompt(key)
+	}
+	s.message = ""
+	lines := s.review.lines(nil)
+	page := s.listHeight()
+	switch key.Special {
+	case keyUp:
+		s.moveTo(s.cursor-1, len(lines))
+	case keyDown:
+		s.moveTo(s.cursor+1, len(lines))
+	case keyPageUp:
+		s.moveTo(s.cursor-page, len(lines))
+	case keyPageDown:
+		s.moveTo(s.cursor+page, len(lines))
+	case keyHome:
+		s.moveTo(0, len(lines))
+	case keyEnd:
+		s.moveTo(len(lines)-1, len(lines))
+	case keyEnter:
+		// A stray Enter must not start copying; c continues
+		s.message = reviewContinueHint
+	case keyEscape, keyInterrupt:
+		return false, true, false
+	}
+	if key.Special != keyNone || len(lines) == 0 {
+		return false, false, false
+	}
+
+	line := lines[s.cursor]
+	selection := "g" + strconv.Itoa(line.Group+1)
+	if line.Unit >= 0 {
+		selection = strconv.Itoa(line.Unit + 1)
+	}
+	switch key.Rune {
+	case 'k':
+		s.moveTo(s.cursor-1, len(lines))
+	case 'j':
+		s.moveTo(s.cursor+1, len(lines))
+	case ' ', 'x':
+		_, _, s.message = s.review.apply(selection)
+		return false, false, true
+	case 'd':
+		s.prompt, s.command = "Folder below the destination (empty to undo): ", "d "+selection
+	case 'e':
+		if s.review.cfg.OrganizeBy != OrganizeModeEvent {
+			s.message = reviewEventsDisabled
+			break
+		}
+		s.prompt, s.command = "Event name: ", "e g"+strconv.Itoa(line.Group+1)
+	case 'c':
+		return true, false, false
+	case 'q':
+		return false, true, false
+	case '?', 'h':
+		s.message = reviewKeysHelp
+	}
+	return false, false, false
+}
+
+// handlePrompt edits the prompt input and runs its command on Enter
+func (s *reviewScreen) handlePrompt(key reviewKey) bool {
+	switch key.Special {
+	case keyEnter:
+		_, _, s.message = s.review.apply(s.command + " " + string(s.input))
+		s.prompt, s.command, s.input = "", "", nil
+		return true
+	case keyEscape, keyInterrupt:
+		s.prompt, s.command, s.input = "", "", nil
+	case keyBackspace:
+		if len(s.input) > 0 {
+			s.input = s.input[:len(s.input)-1]
+		}
+	case keyNone:
+		if unicode.IsPrint(key.Rune) {
+			s.input = append(s.input, key.Rune)
+		}
+	}
+	return false
+}
+
+func (s *reviewScreen) moveTo(line, lines int) {
+	s.cursor = max(0, min(line, lines-1))
+}
+
+// listHeight is the number of lines available for groups and files, below
+// which the screen shows a message or prompt and the keys
+func (s *reviewScreen) listHeight() int {
+	return max(1, s.height()-2)
+}
+
+// draw redraws the whole screen, scrolled so the cursor is visible. Raw mode
+// needs explicit carriage returns.
+func (s *reviewScreen) draw(out io.Writer, planned []FileInfo) {
+	lines := s.review.lines(planned)
+	height := s.listHeight()
+	if s.cursor < s.top {
+		s.top = s.cursor
+	}
+	if s.cursor >= s.top+height {
+		s.top = s.cursor - height + 1
+	}
+
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	for i := s.top; i < len(lines) && i < s.top+height; i++ {
+		if i == s.cursor {
+			b.WriteString("\x1b[7m" + lines[i].Text + "\x1b[0m\r\n")
+		} else {
+			b.WriteString(lines[i].Text + "\r\n")
+		}
+	}
+	for i := len(lines) - s.top; i < height; i++ {
+		b.WriteString("\r\n")
+	}
+	if s.prompt != "" {
+		b.WriteString(s.prompt + string(s.input) + "\r\n")
+	} else {
+		b.WriteString(strings.ReplaceAll(strings.TrimRight(s.message, "\n"), "\n", " ") + "\r\n")
+	}
+	b.WriteString(reviewKeysHelp)
+	_, _ = io.WriteString(out, b.String())
+}
+
+// readReviewKey reads one key press. Arrow and paging keys arrive as escape
+// sequences in a single read, so an escape with nothing buffered after it is
+// the Escape key itself.
+func readReviewKey(in *bufio.Reader) (reviewKey, error) {
+	r, _, err := in.ReadRune()
+	if err != nil {
+		return reviewKey{}, err
+	}
+	switch r {
+	case '\r', '\n':
+		return reviewKey{Special: keyEnter}, nil
+	case 0x7f, '\b':
+		return reviewKey{Special: keyBackspace}, nil
+	case 0x03:
+		return reviewKey{Special: keyInterrupt}, nil
+	case 0x1b:
+		if in.Buffered() == 0 {
+			return reviewKey{Special: keyEscape}, nil
+		}
+	default:
+		return reviewKey{Rune: r}, nil
+	}
+
+	// CSI ("\x1b[") or SS3 ("\x1bO") sequences; anything else is ignored
+	introducer, err := in.ReadByte()
+	if err != nil || (introducer != '[' && introducer != 'O') {
+		return reviewKey{}, err
+	}
+	var params []byte
+	for {
+		c, err := in.ReadByte()
+		if err != nil {
+			return reviewKey{}, err
+		}
+		if c < 0x40 || c > 0x7e {
+			params = append(params, c)
+			continue
+		}
+		switch {
+		case c == 'A':
+			return reviewKey{Special: keyUp}, nil
+		case c == 'B':
+			return reviewKey{Special: keyDown}, nil
+		case c == 'H':
+			return reviewKey{Special: keyHome}, nil
+		case c == 'F':
+			return reviewKey{Special: keyEnd}, nil
+		case c == '~' && string(params) == "5":
+			return reviewKey{Special: keyPageUp}, nil
+		case c == '~' && string(params) == "6":
+			return reviewKey{Special: keyPageDown}, nil
+		case c == '~' && (string(params) == "1" || string(params) == "7"):
+			return reviewKey{Special: keyHome}, nil
+		case c == '~' && (string(params) == "4" || string(params) == "8"):
+			return reviewKey{Special: keyEnd}, nil
+		}
+		return reviewKey{}, nil
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadReviewKey(t *testing.T) {
+	tests := []struct {
+		input string
+		want  reviewKey
+	}{
+		{"j", reviewKey{Rune: 'j'}},
+		{"é", reviewKey{Rune: 'é'}},
+		{"\r", reviewKey{Special: keyEnter}},
+		{"\x7f", reviewKey{Special: keyBackspace}},
+		{"\x03", reviewKey{Special: keyInterrupt}},
+		{"\x1b", reviewKey{Special: keyEscape}},
+		{"\x1b[A", reviewKey{Special: keyUp}},
+		{"\x1bOB", reviewKey{Special: keyDown}},
+		{"\x1b[5~", reviewKey{Special: keyPageUp}},
+		{"\x1b[6~", reviewKey{Special: keyPageDown}},
+		{"\x1b[H", reviewKey{Special: keyHome}},
+		{"\x1b[4~", reviewKey{Special: keyEnd}},
+		{"\x1b[1;5C", reviewKey{}},
+	}
+	for _, tt := range tests {
+		got, err := readReviewKey(bufio.NewReader(strings.NewReader(tt.input)))
+		if err != nil {
+			t.Errorf("readReviewKey(%q) failed: %v", tt.input, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("readReviewKey(%q) = %+v, want %+v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestReviewScreen(t *testing.T) {
+	destDir := t.TempDir()
+	cfg := config{DestDir: destDir, OrganizeByDate: true, SidecarDefault: SidecarCopy, Sidecars: map[string]SidecarAction{}}
+	review := newImportReview(reviewTestFiles(), nil, cfg)
+	screen := newReviewScreen(review, func() int { return 4 })
+
+	// Exclude the first row, then file the clip of the second group in a
+	// folder; the screen scrolls to keep the cursor visible
+	input := "\x1b[B " + "\x1b[B\x1b[B\x1b[B" + "dTrips/Lakx\x7fe\r" + "c"
+	var out bytes.Buffer
+	planned, excluded, err := screen.run(bufio.NewReader(strings.NewReader(input)), &out, cfg, cfg)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if excluded != 2 {
+		t.Errorf("excluded = %d, want 2", excluded)
+	}
+	dests := make(map[string]string)
+	for _, file := range planned {
+		dests[file.SourceName] = filepath.Join(file.DestDir, file.DestName)
+	}
+	want := map[string]string{
+		"IMG_0002.JPG": filepath.Join(destDir, "2024", "06", "IMG_0002.JPG"),
+		"GX010001.MP4": filepath.Join(destDir, "Trips", "Lake", "GX010001.MP4"),
+	}
+	if len(dests) != len(want) {
+		t.Fatalf("planned files = %v, want %v", dests, want)
+	}
+	for name, dest := range want {
+		if dests[name] != dest {
+			t.Errorf("%s planned at %q, want %q", name, dests[name], dest)
+		}
+	}
+
+	frames := strings.Split(out.String(), "\x1b[H\x1b[2J")
+	last := frames[len(frames)-1]
+	if !strings.Contains(last, "\x1b[7m    3 [x] GX010001.MP4") || strings.Contains(last, "g1  2024-06-15") {
+		t.Errorf("expected the last frame to be scrolled to the highlighted clip:\n%q", last)
+	}
+	if !strings.Contains(out.String(), "Folder below the destination (empty to undo): Trips/Lak\r\n") {
+		t.Errorf("expected the folder prompt to echo the input:\n%q", out.String())
+	}
+}
+
+func TestReviewScreenCancel(t *testing.T) {
+	cfg := config{DestDir: t.TempDir(), SidecarDefault: SidecarDelete}
+	for _, input := range []string{"q", "\x03", "", "d\x1b"} {
+		screen := newReviewScreen(newImportReview(reviewTestFiles(), nil, cfg), func() int { return 24 })
+		var out bytes.Buffer
+		if _, _, err := screen.run(bufio.NewReader(strings.NewReader(input)), &out, cfg, cfg); !errors.Is(err, errReviewCancelled) {
+			t.Errorf("run with input %q = %v, want errReviewCancelled", input, err)
+		}
+	}
+
+	// Escape leaves a prompt without quitting
+	screen := newReviewScreen(newImportReview(reviewTestFiles(), nil, cfg), func() int { return 24 })
+	if done, cancel, _ := screen.handle(reviewKey{Rune: 'd'}); done || cancel || screen.prompt == "" {
+		t.Fatal("expected d to open the folder prompt")
+	}
+	if done, cancel, _ := screen.handle(reviewKey{Special: keyEscape}); done || cancel || screen.prompt != "" {
+		t.Error("expected Escape to close the prompt only")
+	}
+	if done, cancel, _ := screen.handle(reviewKey{Special: keyEnter}); done || cancel || screen.message != reviewContinueHint {
+		t.Error("expected Enter outside a prompt to only show how to continue")
+	}
+	if _, _, _ = screen.handle(reviewKey{Rune: 'e'}); screen.message != reviewEventsDisabled {
+		t.Errorf("message = %q, want %q", screen.message, reviewEventsDisabled)
+	}
+}
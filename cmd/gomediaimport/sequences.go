@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// SequenceKind identifies the kind of shot sequence a picture belongs to
+type SequenceKind string
+
+const (
+	SequenceBurst   SequenceKind = "burst"
+	SequenceBracket SequenceKind = "bracket"
+)
+
+const (
+	// sequenceMaxGap is the largest capture time gap between consecutive
+	// frames of one burst or bracket.
+	sequenceMaxGap = time.Second
+	// minBurstFrames is the smallest number of frames grouped as a burst.
+	// Brackets are flagged explicitly by the camera and need only two.
+	minBurstFrames   = 3
+	minBracketFrames = 2
+)
+
+// SequenceInfo describes a file's membership in a burst or bracket group
+type SequenceInfo struct {
+	Kind     SequenceKind
+	Start    time.Time
+	Position int // 1-based position within the group in shooting order
+	Count    int
+}
+
+// Folder returns the name of the subfolder that holds the group.
+func (s SequenceInfo) Folder() string {
+	return s.Start.Format("20060102_150405") + "_" + string(s.Kind)
+}
+
+// DestBaseName returns the date-time base name of a group member.
+func (s SequenceInfo) DestBaseName() string {
+	return fmt.Sprintf("%s_%03d", s.Start.Format("20060102_150405"), s.Position)
+}
+
+func sequenceNumber(file FileInfo) int {
+	if file.ImageMetadata == nil {
+		return 0
+	}
+	return file.ImageMetadata.SequenceNumber
+}
+
+func isBracketed(file FileInfo) bool {
+	return file.ImageMetadata != nil && file.ImageMetadata.Bracketed
+}
+
+// isSequenceCandidate returns true if the file can be part of a burst or
+// bracket. RAW+JPEG followers are excluded because they follow their leader.
+func isSequenceCandidate(files []FileInfo, i int) bool {
+	file := files[i]
+	if file.MediaCategory != ProcessedPicture && file.MediaCategory != RawPicture {
+		return false
+	}
+	if file.Status == StatusPairSkipped {
+		return false
+	}
+	if p := file.PairIndex; p != -1 && p < i && files[p].Status != StatusPairSkipped {
+		return false
+	}
+	return true
+}
+
+// continuesSequence returns true if next was shot in the same sequence as prev.
+func continuesSequence(prev, next FileInfo) bool {
+	if prev.SourceDir != next.SourceDir || isBracketed(prev) != isBracketed(next) {
+		return false
+	}
+	prevSeq, nextSeq := sequenceNumber(prev), sequenceNumber(next)
+	if prevSeq > 0 && nextSeq > 0 {
+		if nextSeq <= prevSeq {
+			// The camera restarted its counter, so a new sequence began
+			return false
+		}
+		if nextSeq == prevSeq+1 {
+			return true
+		}
+	}
+	gap := next.CreationDateTime.Sub(prev.CreationDateTime)
+	return gap >= 0 && gap <= sequenceMaxGap
+}
+
+// detectSequenceGroups finds bursts and exposure brackets among pictures and
+// sets Sequence on each member. Frames are ordered by capture time including
+// sub-seconds, then camera sequence number, then natural filename order.
+func detectSequenceGroups(files []FileInfo) int {
+	var candidates []int
+	for i := range files {
+		files[i].Sequence = nil
+		if isSequenceCandidate(files, i) {
+			candidates = append(candidates, i)
+		}
+	}
+
+	sort.SliceStable(candidates, func(a, b int) bool {
+		fa, fb := files[candidates[a]], files[candidates[b]]
+		if fa.SourceDir != fb.SourceDir {
+			return fa.SourceDir < fb.SourceDir
+		}
+		if !fa.CreationDateTime.Equal(fb.CreationDateTime) {
+			return fa.CreationDateTime.Before(fb.CreationDateTime)
+		}
+		if sa, sb := sequenceNumber(fa), sequenceNumber(fb); sa != sb {
+			return sa < sb
+		}
+		return naturalCompare(fa.SourceName, fb.SourceName) < 0
+	})
+
+	groups := 0
+	flush := func(members []int) {
+		if len(members) == 0 {
+			return
+		}
+		kind := SequenceBurst
+		minFrames := minBurstFrames
+		if isBracketed(files[members[0]]) {
+			kind = SequenceBracket
+			minFrames = minBracketFrames
+		}
+		if len(members) < minFrames {
+			return
+		}
+		groups++
+		start := files[members[0]].CreationDateTime.Truncate(time.Second)
+		for position, i := range members {
+			files[i].Sequence = &SequenceInfo{
+				Kind:     kind,
+				Start:    start,
+				Position: position + 1,
+				Count:    len(members),
+			}
+		}
+	}
+
+	var current []int
+	for _, i := range candidates {
+		if len(current) > 0 && !continuesSequence(files[current[len(current)-1]], files[i]) {
+			flush(current)
+			current = nil
+		}
+		current = append(current, i)
+	}
+	flush(current)
+
+	return groups
+}
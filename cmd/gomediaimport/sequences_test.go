@@ -0,0 +1,163 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func sequenceTestPicture(name string, capturedAt time.Time, imageMetadata *ImageMetadata) FileInfo {
+	return FileInfo{
+		SourceName:       name,
+		SourceDir:        "/src",
+		CreationDateTime: capturedAt,
+		Size:             int64(100 + len(name)),
+		MediaCategory:    ProcessedPicture,
+		FileType:         JPEG,
+		ParentIndex:      -1,
+		PairIndex:        -1,
+		ImageMetadata:    imageMetadata,
+	}
+}
+
+func TestDetectSequenceGroups(t *testing.T) {
+	base := time.Date(2024, 6, 15, 10, 30, 0, 0, time.Local)
+	files := []FileInfo{
+		// Burst with identical second timestamps, ordered by sub-second time
+		sequenceTestPicture("DSC0003.JPG", base.Add(600*time.Millisecond), &ImageMetadata{SubSecond: 600 * time.Millisecond}),
+		sequenceTestPicture("DSC0001.JPG", base.Add(200*time.Millisecond), &ImageMetadata{SubSecond: 200 * time.Millisecond}),
+		sequenceTestPicture("DSC0002.JPG", base.Add(400*time.Millisecond), &ImageMetadata{SubSecond: 400 * time.Millisecond}),
+		// A single shot a minute later is not a sequence
+		sequenceTestPicture("DSC0004.JPG", base.Add(time.Minute), nil),
+		// Bracket ordered by camera sequence number
+		sequenceTestPicture("DSC0007.JPG", base.Add(2*time.Minute), &ImageMetadata{Bracketed: true, SequenceNumber: 3}),
+		sequenceTestPicture("DSC0005.JPG", base.Add(2*time.Minute), &ImageMetadata{Bracketed: true, SequenceNumber: 1}),
+		sequenceTestPicture("DSC0006.JPG", base.Add(2*time.Minute), &ImageMetadata{Bracketed: true, SequenceNumber: 2}),
+		// Two casual shots a second apart are too short for a burst
+		sequenceTestPicture("DSC0008.JPG", base.Add(3*time.Minute), nil),
+		sequenceTestPicture("DSC0009.JPG", base.Add(3*time.Minute+time.Second), nil),
+	}
+
+	if groups := detectSequenceGroups(files); groups != 2 {
+		t.Fatalf("got %d groups, want 2", groups)
+	}
+
+	want := map[string]struct {
+		kind     SequenceKind
+		position int
+	}{
+		"DSC0001.JPG": {SequenceBurst, 1},
+		"DSC0002.JPG": {SequenceBurst, 2},
+		"DSC0003.JPG": {SequenceBurst, 3},
+		"DSC0005.JPG": {SequenceBracket, 1},
+		"DSC0006.JPG": {SequenceBracket, 2},
+		"DSC0007.JPG": {SequenceBracket, 3},
+	}
+	for _, file := range files {
+		expected, grouped := want[file.SourceName]
+		if !grouped {
+			if file.Sequence != nil {
+				t.Errorf("%s: expected no sequence, got %+v", file.SourceName, *file.Sequence)
+			}
+			continue
+		}
+		if file.Sequence == nil {
+			t.Errorf("%s: expected %s position %d, got no sequence", file.SourceName, expected.kind, expected.position)
+			continue
+		}
+		if file.Sequence.Kind != expected.kind || file.Sequence.Position != expected.position || file.Sequence.Count != 3 {
+			t.Errorf("%s: got %s position %d of %d, want %s position %d of 3", file.SourceName, file.Sequence.Kind, file.Sequence.Position, file.Sequence.Count, expected.kind, expected.position)
+		}
+	}
+}
+
+func TestDetectSequenceGroupsSplitsOnCounterRestart(t *testing.T) {
+	base := time.Date(2024, 6, 15, 10, 30, 0, 0, time.Local)
+	var files []FileInfo
+	for i, seq := range []int{1, 2, 3, 1, 2, 3} {
+		name := "DSC000" + string(rune('1'+i)) + ".JPG"
+		files = append(files, sequenceTestPicture(name, base, &ImageMetadata{Bracketed: true, SequenceNumber: seq}))
+	}
+
+	if groups := detectSequenceGroups(files); groups != 2 {
+		t.Fatalf("got %d groups, want 2", groups)
+	}
+}
+
+func TestPlanDestinationsGroupSequences(t *testing.T) {
+	destDir := t.TempDir()
+	base := time.Date(2024, 6, 15, 10, 30, 0, 0, time.Local)
+	files := []FileInfo{
+		sequenceTestPicture("DSC0002.JPG", base.Add(500*time.Millisecond), &ImageMetadata{SubSecond: 500 * time.Millisecond}),
+		sequenceTestPicture("DSC0001.JPG", base, &ImageMetadata{}),
+		sequenceTestPicture("DSC0003.JPG", base.Add(time.Second), &ImageMetadata{}),
+		sequenceTestPicture("DSC0009.JPG", base.Add(time.Hour), &ImageMetadata{}),
+		{SourceName: "DSC0002.xmp", SourceDir: "/src", CreationDateTime: base, Size: 10, MediaCategory: Sidecar, ParentIndex: -1},
+	}
+	cfg := config{
+		DestDir:          destDir,
+		RenameByDateTime: true,
+		GroupSequences:   true,
+		SidecarDefault:   SidecarCopy,
+		Sidecars:         make(map[string]SidecarAction),
+	}
+	if err := planDestinations(files, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	folder := filepath.Join(destDir, "20240615_103000_burst")
+	want := map[string]string{
+		"DSC0001.JPG": filepath.Join(folder, "20240615_103000_001.jpg"),
+		"DSC0002.JPG": filepath.Join(folder, "20240615_103000_002.jpg"),
+		"DSC0003.JPG": filepath.Join(folder, "20240615_103000_003.jpg"),
+		"DSC0002.xmp": filepath.Join(folder, "20240615_103000_002.xmp"),
+		"DSC0009.JPG": filepath.Join(destDir, "20240615_113000.jpg"),
+	}
+	got := destNamesBySource(files)
+	for sourceName, destPath := range want {
+		if got[sourceName] != destPath {
+			t.Errorf("%s: got destination %s, want %s", sourceName, got[sourceName], destPath)
+		}
+	}
+}
+
+func TestPlanDestinationsRenameSubSeconds(t *testing.T) {
+	destDir := t.TempDir()
+	base := time.Date(2024, 6, 15, 10, 30, 0, 0, time.Local)
+	files := []FileInfo{
+		sequenceTestPicture("DSC0002.JPG", base.Add(750*time.Millisecond), &ImageMetadata{SubSecond: 750 * time.Millisecond}),
+		sequenceTestPicture("DSC0001.JPG", base.Add(120*time.Millisecond), &ImageMetadata{SubSecond: 120 * time.Millisecond}),
+	}
+	cfg := config{
+		DestDir:          destDir,
+		RenameByDateTime: true,
+		RenameSubSeconds: true,
+		SidecarDefault:   SidecarDelete,
+		Sidecars:         make(map[string]SidecarAction),
+	}
+	if err := planDestinations(files, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	got := destNamesBySource(files)
+	if want := filepath.Join(destDir, "20240615_103000.120.jpg"); got["DSC0001.JPG"] != want {
+		t.Errorf("DSC0001.JPG: got %s, want %s", got["DSC0001.JPG"], want)
+	}
+	if want := filepath.Join(destDir, "20240615_103000.750.jpg"); got["DSC0002.JPG"] != want {
+		t.Errorf("DSC0002.JPG: got %s, want %s", got["DSC0002.JPG"], want)
+	}
+}
+
+func TestSortFilesForDestinationPlanningUsesSequenceNumber(t *testing.T) {
+	capturedAt := time.Date(2024, 6, 15, 10, 30, 0, 0, time.Local)
+	files := []FileInfo{
+		sequenceTestPicture("A0002.JPG", capturedAt, &ImageMetadata{SequenceNumber: 2}),
+		sequenceTestPicture("Z0001.JPG", capturedAt, &ImageMetadata{SequenceNumber: 1}),
+	}
+
+	sortFilesForDestinationPlanning(files)
+
+	if files[0].SourceName != "Z0001.JPG" {
+		t.Errorf("expected camera sequence number to win over filename order, got %s first", files[0].SourceName)
+	}
+}
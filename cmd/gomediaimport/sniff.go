@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// sniffHeaderSize is how much of a file is read to recognize its format. It
+// covers three MPEG-TS packets and the EBML header of Matroska files.
+const sniffHeaderSize = 512
+
+// sniffedType is the format a file's content identifies
+type sniffedType struct {
+	FileType FileType
+	// Ext is the usual extension for the content, without a dot
+	Ext string
+	// Compatible lists the extension-based types the content confirms. A
+	// TIFF header, for example, is also what most RAW formats look like.
+	Compatible []FileType
+}
+
+// confirms returns true if a file named with an extension of fileType may
+// hold this content
+func (s sniffedType) confirms(fileType FileType) bool {
+	if fileType == s.FileType {
+		return true
+	}
+	for _, compatible := range s.Compatible {
+		if compatible == fileType {
+			return true
+		}
+	}
+	return false
+}
+
+// isoBaseMediaVideoTypes are the video types stored in ISO BMFF containers,
+// which share brands loosely enough that any of them confirms the others
+var isoBaseMediaVideoTypes = []FileType{MP4, MOV, M4V, THREEGP, THREEG2, INSV}
+
+// sniffFileType recognizes a media format from the first bytes of a file:
+// JPEG SOI, TIFF and RAW headers, ISO BMFF ftyp brands, RIFF, EBML, MPEG-TS
+// sync bytes, and a few other signatures. ok is false for unknown content.
+func sniffFileType(header []byte) (sniffed sniffedType, ok bool) {
+	switch {
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8, 0xFF}):
+		return sniffedType{FileType: JPEG, Ext: "jpg", Compatible: []FileType{INSP}}, true
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n")):
+		return sniffedType{FileType: PNG, Ext: "png"}, true
+	case bytes.HasPrefix(header, []byte("GIF87a")), bytes.HasPrefix(header, []byte("GIF89a")):
+		return sniffedType{FileType: GIF, Ext: "gif"}, true
+	case bytes.HasPrefix(header, []byte("8BPS")):
+		return sniffedType{FileType: PSD, Ext: "psd"}, true
+	case bytes.HasPrefix(header, []byte{0xFF, 0x0A}), bytes.HasPrefix(header, []byte("\x00\x00\x00\x0cJXL \r\n\x87\n")):
+		return sniffedType{FileType: JPEGXL, Ext: "jxl"}, true
+	case bytes.HasPrefix(header, []byte("\x00\x00\x00\x0cjP  \r\n\x87\n")):
+		return sniffedType{FileType: JPEG2000, Ext: "jp2"}, true
+	case bytes.HasPrefix(header, []byte("FUJIFILMCCD-RAW")):
+		return sniffedType{FileType: RAW, Ext: "raf"}, true
+	case bytes.HasPrefix(header, []byte("II*\x00")), bytes.HasPrefix(header, []byte("MM\x00*")):
+		if len(header) >= 11 && string(header[8:11]) == "CR\x02" {
+			return sniffedType{FileType: RAW, Ext: "cr2"}, true
+		}
+		// NEF, ARW, DNG, PEF and most other RAW formats are TIFF files
+		return sniffedType{FileType: TIFF, Ext: "tif", Compatible: []FileType{RAW}}, true
+	case bytes.HasPrefix(header, []byte("IIRO")), bytes.HasPrefix(header, []byte("IIRS")), bytes.HasPrefix(header, []byte("MMOR")):
+		return sniffedType{FileType: RAW, Ext: "orf"}, true
+	case bytes.HasPrefix(header, []byte("IIU\x00")):
+		return sniffedType{FileType: RAW, Ext: "rw2"}, true
+	case len(header) >= 12 && string(header[4:8]) == "ftyp":
+		return sniffISOBaseMedia(header)
+	case len(header) >= 12 && (string(header[0:4]) == "RIFF" || string(header[0:4]) == "RF64" || string(header[0:4]) == "BW64"):
+		switch string(header[8:12]) {
+		case "WAVE":
+			return sniffedType{FileType: WAV, Ext: "wav"}, true
+		case "AVI ":
+			return sniffedType{FileType: AVI, Ext: "avi"}, true
+		case "WEBP":
+			return sniffedType{FileType: WEBP, Ext: "webp"}, true
+		}
+	case len(header) >= 12 && string(header[0:4]) == "FORM" && (string(header[8:12]) == "AIFF" || string(header[8:12]) == "AIFC"):
+		return sniffedType{FileType: AIFF, Ext: "aiff"}, true
+	case bytes.HasPrefix(header, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		// The EBML header names the document type within its first bytes
+		if bytes.Contains(header[:min(len(header), 64)], []byte("webm")) {
+			return sniffedType{FileType: WEBM, Ext: "webm", Compatible: []FileType{MKV}}, true
+		}
+		return sniffedType{FileType: MKV, Ext: "mkv", Compatible: []FileType{WEBM}}, true
+	case bytes.HasPrefix(header, []byte{0x30, 0x26, 0xB2, 0x75, 0x8E, 0x66, 0xCF, 0x11}):
+		return sniffedType{FileType: ASF, Ext: "asf", Compatible: []FileType{WMV}}, true
+	case bytes.HasPrefix(header, []byte("fLaC")):
+		return sniffedType{FileType: FLAC, Ext: "flac"}, true
+	case bytes.HasPrefix(header, []byte("ID3")):
+		return sniffedType{FileType: MP3, Ext: "mp3"}, true
+	case isMPEGTransportStream(header, 188, 0), isMPEGTransportStream(header, 192, 4):
+		return sniffedType{FileType: MTS, Ext: "mts"}, true
+	}
+	return sniffedType{}, false
+}
+
+// isHEIFBrand returns true for the ftyp brands of HEIF still images and
+// image sequences
+func isHEIFBrand(brand string) bool {
+	switch brand {
+	case "heic", "heix", "heim", "heis", "hevc", "hevx", "mif1", "msf1":
+		return true
+	}
+	return false
+}
+
+// sniffISOBaseMedia tells ISO BMFF files apart by the major brand of their
+// ftyp box
+func sniffISOBaseMedia(header []byte) (sniffedType, bool) {
+	brand := string(header[8:12])
+	switch {
+	case brand == "crx ":
+		return sniffedType{FileType: RAW, Ext: "cr3"}, true
+	case isHEIFBrand(brand):
+		return sniffedType{FileType: HEIF, Ext: "heic"}, true
+	case brand == "M4A " || brand == "M4B ":
+		return sniffedType{FileType: M4A, Ext: "m4a", Compatible: []FileType{MP4}}, true
+	case brand == "qt  ":
+		return sniffedType{FileType: MOV, Ext: "mov", Compatible: isoBaseMediaVideoTypes}, true
+	case strings.HasPrefix(brand, "3g2"):
+		return sniffedType{FileType: THREEG2, Ext: "3g2", Compatible: isoBaseMediaVideoTypes}, true
+	case strings.HasPrefix(brand, "3gp"):
+		return sniffedType{FileType: THREEGP, Ext: "3gp", Compatible: isoBaseMediaVideoTypes}, true
+	case brand == "M4V " || brand == "M4VH" || brand == "M4VP":
+		return sniffedType{FileType: M4V, Ext: "m4v", Compatible: isoBaseMediaVideoTypes}, true
+	case brand == "avif" || brand == "avis":
+		// AVIF is not an imported type
+		return sniffedType{}, false
+	}
+	// isom, mp41, mp42, avc1, XAVC and other camera brands
+	return sniffedType{FileType: MP4, Ext: "mp4", Compatible: append([]FileType{M4A}, isoBaseMediaVideoTypes...)}, true
+}
+
+// isMPEGTransportStream checks for the sync byte at the start of three
+// consecutive packets. M2TS packets carry a four-byte timestamp before it.
+func isMPEGTransportStream(header []byte, packetSize, offset int) bool {
+	for i := 0; i < 3; i++ {
+		at := offset + i*packetSize
+		if at >= len(header) || header[at] != 0x47 {
+			return false
+		}
+	}
+	return true
+}
+
+// readSniffHeader reads the start of a source file for sniffFileType
+func readSniffHeader(src mediaSource, path string) ([]byte, error) {
+	r, err := src.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = r.Close() }()
+	header := make([]byte, sniffHeaderSize)
+	n, err := io.ReadFull(r, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return header[:n], nil
+}
+
+// sniffSourceFile checks the content of a file against the type its extension
+// implies. When they disagree, or the extension is missing or unknown, the
+// content wins: the file's category and type are replaced and ContentExt is
+// set to the extension the content calls for. Types defined in the config
+// file are not checked. It returns false if the file is not media by either
+// measure.
+func sniffSourceFile(src mediaSource, fileInfo *FileInfo) bool {
+	known := fileInfo.FileType != ""
+	if fileInfo.MetadataParser != "" {
+		// Types from the config file are trusted as declared
+		return true
+	}
+	header, err := readSniffHeader(src, filepath.Join(fileInfo.SourceDir, fileInfo.SourceName))
+	if err != nil {
+		return known
+	}
+	sniffed, ok := sniffFileType(header)
+	if !ok || sniffed.confirms(fileInfo.FileType) {
+		return known
+	}
+	category := mediaCategoryForFileType(sniffed.FileType)
+	if category == "" {
+		return known
+	}
+	fileInfo.ExtensionType = fileInfo.FileType
+	fileInfo.FileType = sniffed.FileType
+	fileInfo.MediaCategory = category
+	fileInfo.ContentExt = "." + sniffed.Ext
+	return true
+}
+
+// mediaCategoryForFileType returns the category a built-in file type belongs
+// to
+func mediaCategoryForFileType(fileType FileType) MediaCategory {
+	for _, ft := range builtinFileTypes {
+		if ft.FileType == fileType {
+			return ft.MediaCategory
+		}
+	}
+	return ""
+}
+
+// printContentMismatches lists files whose content did not match their
+// extension
+func printContentMismatches(files []FileInfo) {
+	var count int
+	for _, file := range files {
+		if file.ContentExt == "" {
+			continue
+		}
+		count++
+		declared := string(file.ExtensionType)
+		if declared == "" {
+			declared = "no known type"
+		}
+		fmt.Printf("Content mismatch: %s is named as %s but contains %s\n", filepath.Join(file.SourceDir, file.SourceName), declared, file.FileType)
+	}
+	if count > 0 {
+		fmt.Printf("Files with mismatched or missing extensions: %d\n", count)
+	}
+}
+
+// mediaExtension returns the extension, with its dot, that matches a file's
+// content
+func mediaExtension(file FileInfo) string {
+	if file.ContentExt != "" {
+		return file.ContentExt
+	}
+	return filepath.Ext(file.SourceName)
+}
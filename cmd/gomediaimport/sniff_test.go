@@ -0,0 +1,147 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func ftypHeader(brand string) []byte {
+	return append([]byte("\x00\x00\x00\x18ftyp"), []byte(brand+"\x00\x00\x00\x00")...)
+}
+
+func TestSniffFileType(t *testing.T) {
+	transportStream := make([]byte, 400)
+	transportStream[0], transportStream[188], transportStream[376] = 0x47, 0x47, 0x47
+
+	tests := []struct {
+		name     string
+		header   []byte
+		fileType FileType
+		ext      string
+		ok       bool
+	}{
+		{"JPEG", []byte{0xFF, 0xD8, 0xFF, 0xE1}, JPEG, "jpg", true},
+		{"PNG", []byte("\x89PNG\r\n\x1a\n"), PNG, "png", true},
+		{"TIFF", []byte("II*\x00\x08\x00\x00\x00"), TIFF, "tif", true},
+		{"CR2", []byte("II*\x00\x10\x00\x00\x00CR\x02\x00"), RAW, "cr2", true},
+		{"ORF", []byte("IIRO\x08\x00\x00\x00"), RAW, "orf", true},
+		{"RAF", []byte("FUJIFILMCCD-RAW 0201"), RAW, "raf", true},
+		{"CR3", ftypHeader("crx "), RAW, "cr3", true},
+		{"HEIC", ftypHeader("heic"), HEIF, "heic", true},
+		{"MOV", ftypHeader("qt  "), MOV, "mov", true},
+		{"MP4", ftypHeader("isom"), MP4, "mp4", true},
+		{"XAVC", ftypHeader("XAVC"), MP4, "mp4", true},
+		{"M4A", ftypHeader("M4A "), M4A, "m4a", true},
+		{"AVIF", ftypHeader("avif"), "", "", false},
+		{"WAV", []byte("RIFF\x24\x00\x00\x00WAVEfmt "), WAV, "wav", true},
+		{"AVI", []byte("RIFF\x24\x00\x00\x00AVI LIST"), AVI, "avi", true},
+		{"WebP", []byte("RIFF\x24\x00\x00\x00WEBPVP8 "), WEBP, "webp", true},
+		{"Matroska", []byte("\x1a\x45\xdf\xa3\x9f\x42\x86\x81\x01\x42\x82\x88matroska"), MKV, "mkv", true},
+		{"WebM", []byte("\x1a\x45\xdf\xa3\x9f\x42\x86\x81\x01\x42\x82\x84webm"), WEBM, "webm", true},
+		{"MPEG-TS", transportStream, MTS, "mts", true},
+		{"FLAC", []byte("fLaC\x00\x00\x00\x22"), FLAC, "flac", true},
+		{"text", []byte("hello, world"), "", "", false},
+		{"empty", nil, "", "", false},
+	}
+	for _, tt := range tests {
+		sniffed, ok := sniffFileType(tt.header)
+		if ok != tt.ok || sniffed.FileType != tt.fileType || sniffed.Ext != tt.ext {
+			t.Errorf("%s: sniffFileType = %+v, %v; want %s, %s, %v", tt.name, sniffed, ok, tt.fileType, tt.ext, tt.ok)
+		}
+	}
+}
+
+func TestSniffSourceFile(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name          string
+		content       []byte
+		wantMedia     bool
+		category      MediaCategory
+		fileType      FileType
+		extensionType FileType
+		contentExt    string
+	}{
+		// Some phones save HEIC photos with a .JPG extension
+		{"IMG_0001.JPG", ftypHeader("heic"), true, ProcessedPicture, HEIF, JPEG, ".heic"},
+		{"IMG_0002", []byte{0xFF, 0xD8, 0xFF, 0xE0}, true, ProcessedPicture, JPEG, "", ".jpg"},
+		// A NEF is a TIFF file, which confirms the extension
+		{"DSC_0003.NEF", []byte("MM\x00*\x00\x00\x00\x08"), true, RawPicture, RAW, "", ""},
+		{"clip.mp4", ftypHeader("qt  "), true, Video, MP4, "", ""},
+		// Content that is not recognized leaves the extension in charge
+		{"IMG_0004.JPG", []byte("not really a JPEG"), true, ProcessedPicture, JPEG, "", ""},
+		{"notes.txt", []byte("shopping list"), false, "", "", "", ""},
+	}
+	for _, tt := range tests {
+		if err := os.WriteFile(filepath.Join(dir, tt.name), tt.content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		fileInfo := FileInfo{SourceName: tt.name, SourceDir: dir}
+		fileInfo.MediaCategory, fileInfo.FileType = builtinMediaTypes.getMediaTypeInfo(fileInfo)
+		got := sniffSourceFile(localSource{root: dir}, &fileInfo)
+		if got != tt.wantMedia {
+			t.Errorf("%s: sniffSourceFile = %v, want %v", tt.name, got, tt.wantMedia)
+			continue
+		}
+		if fileInfo.MediaCategory != tt.category || fileInfo.FileType != tt.fileType || fileInfo.ExtensionType != tt.extensionType || fileInfo.ContentExt != tt.contentExt {
+			t.Errorf("%s: got %s/%s (extension %q, content %q), want %s/%s (extension %q, content %q)", tt.name,
+				fileInfo.MediaCategory, fileInfo.FileType, fileInfo.ExtensionType, fileInfo.ContentExt,
+				tt.category, tt.fileType, tt.extensionType, tt.contentExt)
+		}
+	}
+}
+
+func TestImportMediaUsesContentExtension(t *testing.T) {
+	video, err := os.ReadFile(testFixturePath("minimal.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+	base := time.Date(2024, 3, 1, 9, 0, 0, 0, time.Local)
+	for i, file := range []struct {
+		name    string
+		content []byte
+	}{
+		{"IMG_0001", video},
+		{"IMG_0002.JPG", ftypHeader("heic")},
+		{"IMG_0003.CR2", []byte("raw data")},
+		// Ignored sidecars are not imported even though they hold a JPEG
+		{"GOPR0004.THM", []byte{0xFF, 0xD8, 0xFF, 0xE0}},
+		{"README", []byte("recovered files")},
+	} {
+		path := filepath.Join(sourceDir, file.name)
+		if err := os.WriteFile(path, file.content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := base.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := config{
+		SourceDir:        sourceDir,
+		DestDir:          destDir,
+		RenameByDateTime: true,
+		SidecarDefault:   SidecarDelete,
+		Sidecars:         map[string]SidecarAction{"thm": SidecarIgnore},
+	}
+	if err := importMedia(cfg); err != nil {
+		t.Fatalf("importMedia failed: %v", err)
+	}
+	want := []string{
+		"20240301_090100.heic",
+		// RAW files keep their own extension
+		"20240301_090200.cr2",
+		// Dated by the embedded creation time
+		"20240615_103000.mp4",
+	}
+	got := listTree(t, destDir)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("destination = %v, want %v", got, want)
+	}
+}
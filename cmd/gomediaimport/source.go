@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var errReadOnlySource = errors.New("source is read-only")
+
+// mediaSource is where an import reads files from. Paths are the joined
+// SourceDir and SourceName of a FileInfo. Missing files are reported with
+// errors matching fs.ErrNotExist.
+type mediaSource interface {
+	Stat(path string) (fs.FileInfo, error)
+	Open(path string) (io.ReadCloser, error)
+	Remove(path string) error
+	// Volume identifies the card, device, or archive files are read from,
+	// such as a removable volume label or an archive path.
+	Volume() string
+	Close() error
+}
+
+// walkableSource is implemented by sources whose directories can be walked
+// as an fs.FS rooted at a source path.
+type walkableSource interface {
+	FS(root string) (fs.FS, error)
+}
+
+// sourceEnumerator is implemented by sources that list their own files
+// instead of being walked as a directory.
+type sourceEnumerator interface {
+	enumerate(cfg config) (enumerationResult, error)
+}
+
+// sourceLister is implemented by sources that know their files only after
+// listing them, like PTP/MTP devices, which address files by their position
+// in a listing
+type sourceLister interface {
+	list(cfg config) (enumerationResult, error)
+}
+
+// removableFS is implemented by file systems that can delete files, which
+// makes an fsSource over them writable.
+type removableFS interface {
+	fs.FS
+	Remove(name string) error
+}
+
+// isDeviceSource returns true if dir names a PTP/MTP device rather than a
+// directory.
+func isDeviceSource(dir string) bool {
+	lower := strings.ToLower(dir)
+	return strings.HasPrefix(lower, "ptp:") || strings.HasPrefix(lower, "mtp:")
+}
+
+// openSource returns the source named by dir.
+func openSource(dir string) (mediaSource, error) {
+	if isDeviceSource(dir) {
+		src, err := openPTPSource(strings.ToLower(dir[:len("ptp")]), dir[len("ptp:"):])
+		if err != nil {
+			return nil, err
+		}
+		return src, nil
+	}
+	if isFileSource(dir) {
+		src, err := openFileSource(dir)
+		if err != nil {
+			return nil, err
+		}
+		return src, nil
+	}
+	return localSource{root: dir}, nil
+}
+
+// sourceFor returns the source an import reads from. Imports that did not
+// open one read from the local filesystem.
+func sourceFor(cfg config) mediaSource {
+	if cfg.Source != nil {
+		return cfg.Source
+	}
+	return localSource{root: cfg.SourceDir}
+}
+
+// sourcePath returns the path of file on its source.
+func sourcePath(file FileInfo) string {
+	return filepath.Join(file.SourceDir, file.SourceName)
+}
+
+// sourceChecksum returns the xxHash64 checksum of file's source content,
+// calculating it on first use.
+func sourceChecksum(src mediaSource, file *FileInfo) (string, error) {
+	if file.SourceChecksum != "" {
+		return file.SourceChecksum, nil
+	}
+	r, err := src.Open(sourcePath(*file))
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = r.Close() }()
+	checksum, err := hashReader(r)
+	if err != nil {
+		return "", fmt.Errorf("error reading %s: %w", sourcePath(*file), err)
+	}
+	file.SourceChecksum = checksum
+	return checksum, nil
+}
+
+// localSource reads from the local filesystem. root is the source directory
+// and volume the label of the removable volume it is on, if known; both only
+// identify the volume.
+type localSource struct {
+	root   string
+	volume string
+}
+
+func (localSource) Stat(path string) (fs.FileInfo, error) {
+	return os.Stat(path)
+}
+
+func (localSource) Open(path string) (io.ReadCloser, error) {
+	return os.Open(path)
+}
+
+func (localSource) Remove(path string) error {
+	return os.Remove(path)
+}
+
+func (s localSource) Volume() string {
+	if s.volume != "" {
+		return s.volume
+	}
+	return s.root
+}
+
+func (localSource) FS(root string) (fs.FS, error) {
+	return os.DirFS(root), nil
+}
+
+func (localSource) Close() error {
+	return nil
+}
+
+// fsSource reads from an fs.FS, such as an archive or a disk image. Paths are
+// root followed by the path within the file system, so reports show both.
+// Files can only be removed if the file system implements removableFS.
+type fsSource struct {
+	root    string
+	volume  string
+	fsys    fs.FS
+	closers []io.Closer
+}
+
+// newFSSource returns a source that serves fsys under root
+func newFSSource(root, volume string, fsys fs.FS) *fsSource {
+	return &fsSource{root: root, volume: volume, fsys: fsys}
+}
+
+// member converts a source path to a path within the file system
+func (s *fsSource) member(op, p string) (string, error) {
+	rel, err := filepath.Rel(s.root, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &fs.PathError{Op: op, Path: p, Err: fs.ErrNotExist}
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+func (s *fsSource) Stat(p string) (fs.FileInfo, error) {
+	name, err := s.member("stat", p)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Stat(s.fsys, name)
+}
+
+func (s *fsSource) Open(p string) (io.ReadCloser, error) {
+	name, err := s.member("open", p)
+	if err != nil {
+		return nil, err
+	}
+	return s.fsys.Open(name)
+}
+
+func (s *fsSource) Remove(p string) error {
+	fsys, ok := s.fsys.(removableFS)
+	if !ok {
+		return &fs.PathError{Op: "remove", Path: p, Err: errReadOnlySource}
+	}
+	name, err := s.member("remove", p)
+	if err != nil {
+		return err
+	}
+	return fsys.Remove(name)
+}
+
+func (s *fsSource) Volume() string {
+	return s.volume
+}
+
+func (s *fsSource) FS(root string) (fs.FS, error) {
+	name, err := s.member("open", root)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Sub(s.fsys, name)
+}
+
+func (s *fsSource) Close() error {
+	var errs []error
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		errs = append(errs, s.closers[i].Close())
+	}
+	return errors.Join(errs...)
+}
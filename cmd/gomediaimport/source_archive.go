@@ -0,0 +1,306 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// isFileSource returns true if dir names a regular file, which is opened as
+// an archive or disk image.
+func isFileSource(dir string) bool {
+	info, err := os.Stat(dir)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// openFileSource opens a ZIP, tar, or gzip-compressed tar archive, or a raw
+// FAT or exFAT disk image. The format is detected from the content.
+func openFileSource(name string) (*fsSource, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	src := &fsSource{root: name, volume: name, closers: []io.Closer{file}}
+	if src.fsys, err = openArchiveOrImage(src, file); err != nil {
+		_ = src.Close()
+		return nil, fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	return src, nil
+}
+
+func openArchiveOrImage(src *fsSource, file *os.File) (fs.FS, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 512)
+	n, err := file.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")), bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		return zip.NewReader(file, info.Size())
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		spooled, err := gunzipToTemp(file)
+		if err != nil {
+			return nil, err
+		}
+		src.closers = append(src.closers, spooled)
+		return asFS(newTarFS(spooled))
+	case isTarHeader(header):
+		return asFS(newTarFS(file))
+	default:
+		return asFS(openDiskImage(file, info.Size()))
+	}
+}
+
+// asFS avoids returning a typed nil fs.FS on error
+func asFS(fsys *indexedFS, err error) (fs.FS, error) {
+	if err != nil {
+		return nil, err
+	}
+	return fsys, nil
+}
+
+// isTarHeader checks for the ustar magic of POSIX and GNU tar headers
+func isTarHeader(header []byte) bool {
+	return len(header) >= 263 && bytes.HasPrefix(header[257:], []byte("ustar"))
+}
+
+// gunzipToTemp decompresses r into a temporary file, giving the tar reader
+// random access to members. The file is removed when closed.
+func gunzipToTemp(r io.Reader) (*tempFile, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = gz.Close() }()
+	return spoolToTemp(gz)
+}
+
+// tempFile is a temporary file that is removed when closed
+type tempFile struct {
+	*os.File
+}
+
+func (f *tempFile) Close() error {
+	err := f.File.Close()
+	_ = os.Remove(f.Name())
+	return err
+}
+
+func spoolToTemp(r io.Reader) (*tempFile, error) {
+	file, err := os.CreateTemp("", "gomediaimport-source-*")
+	if err != nil {
+		return nil, err
+	}
+	spooled := &tempFile{File: file}
+	if _, err := io.Copy(file, r); err != nil {
+		_ = spooled.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		_ = spooled.Close()
+		return nil, err
+	}
+	return spooled, nil
+}
+
+// newTarFS indexes the members of a tar archive. tar.Reader reads headers
+// straight from the file, so the file offset after Next is where the
+// member's data starts.
+func newTarFS(file interface {
+	io.ReadSeeker
+	io.ReaderAt
+}) (*indexedFS, error) {
+	fsys := newIndexedFS()
+	tr := tar.NewReader(file)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return fsys, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := path.Clean(strings.TrimPrefix(header.Name, "/"))
+		if !fs.ValidPath(name) || name == "." {
+			continue
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			fsys.addDir(name, header.ModTime)
+		case tar.TypeReg:
+			offset, err := file.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return nil, err
+			}
+			fsys.addFile(name, header.Size, header.ModTime, io.NewSectionReader(file, offset, header.Size))
+		}
+	}
+}
+
+// indexedFS is an fs.FS over an index of files whose data is available for
+// random access. Parent directories are created as files are added.
+type indexedFS struct {
+	root *indexNode
+}
+
+type indexNode struct {
+	info     remoteFileInfo
+	data     io.ReaderAt
+	children map[string]*indexNode
+}
+
+func newIndexedFS() *indexedFS {
+	return &indexedFS{root: &indexNode{info: remoteFileInfo{name: ".", isDir: true}, children: make(map[string]*indexNode)}}
+}
+
+func (fsys *indexedFS) addDir(name string, modTime time.Time) *indexNode {
+	node := fsys.root
+	for _, part := range strings.Split(name, "/") {
+		child, ok := node.children[part]
+		if !ok || !child.info.isDir {
+			child = &indexNode{info: remoteFileInfo{name: part, isDir: true}, children: make(map[string]*indexNode)}
+			node.children[part] = child
+		}
+		node = child
+	}
+	if !modTime.IsZero() {
+		node.info.modTime = modTime
+	}
+	return node
+}
+
+func (fsys *indexedFS) addFile(name string, size int64, modTime time.Time, data io.ReaderAt) {
+	parent := fsys.root
+	if dir := path.Dir(name); dir != "." {
+		parent = fsys.addDir(dir, time.Time{})
+	}
+	base := path.Base(name)
+	parent.children[base] = &indexNode{info: remoteFileInfo{name: base, size: size, modTime: modTime}, data: data}
+}
+
+func (fsys *indexedFS) lookup(op, name string) (*indexNode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	node := fsys.root
+	if name != "." {
+		for _, part := range strings.Split(name, "/") {
+			child, ok := node.children[part]
+			if !ok {
+				return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+			}
+			node = child
+		}
+	}
+	return node, nil
+}
+
+func (fsys *indexedFS) Open(name string) (fs.File, error) {
+	node, err := fsys.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if node.info.isDir {
+		return &indexDir{node: node}, nil
+	}
+	return &indexFile{node: node, SectionReader: io.NewSectionReader(node.data, 0, node.info.size)}, nil
+}
+
+func (fsys *indexedFS) Stat(name string) (fs.FileInfo, error) {
+	node, err := fsys.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return node.info, nil
+}
+
+func (fsys *indexedFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	node, err := fsys.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !node.info.isDir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return node.entries(), nil
+}
+
+func (node *indexNode) entries() []fs.DirEntry {
+	entries := make([]fs.DirEntry, 0, len(node.children))
+	for _, child := range node.children {
+		entries = append(entries, fs.FileInfoToDirEntry(child.info))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries
+}
+
+// indexFile is an open file in an indexedFS. It supports Seek and ReadAt.
+type indexFile struct {
+	node *indexNode
+	*io.SectionReader
+}
+
+func (f *indexFile) Stat() (fs.FileInfo, error) { return f.node.info, nil }
+func (f *indexFile) Close() error               { return nil }
+
+// indexDir is an open directory in an indexedFS
+type indexDir struct {
+	node    *indexNode
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *indexDir) Stat() (fs.FileInfo, error) { return d.node.info, nil }
+func (d *indexDir) Close() error               { return nil }
+
+func (d *indexDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.node.info.name, Err: errors.New("is a directory")}
+}
+
+func (d *indexDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.entries == nil {
+		d.entries = d.node.entries()
+	}
+	remaining := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(remaining))
+	d.offset += n
+	return remaining[:n], nil
+}
+
+// openSeekable opens path for decoders that need to seek. Members that can
+// only be streamed, such as compressed ZIP entries, are copied to a temporary
+// file first.
+func openSeekable(src mediaSource, path string) (io.ReadSeekCloser, error) {
+	r, err := src.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if seeker, ok := r.(io.ReadSeekCloser); ok {
+		return seeker, nil
+	}
+	defer func() { _ = r.Close() }()
+	return spoolToTemp(r)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type archiveTestFile struct {
+	name    string
+	content []byte
+	time    time.Time
+}
+
+func archiveTestFiles(t *testing.T) []archiveTestFile {
+	t.Helper()
+	video, err := os.ReadFile(testFixturePath("minimal.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	march := time.Date(2023, 3, 4, 12, 0, 0, 0, time.Local)
+	return []archiveTestFile{
+		// The video's QuickTime creation time, 2024-06-15, wins over the
+		// member time
+		{name: "Camera/VID_0001.mp4", content: video, time: march},
+		{name: "Camera/MVI_0002.avi", content: []byte("avi two"), time: march},
+		{name: "Camera/notes.txt", content: []byte("not media"), time: march},
+		{name: "._MVI_0002.avi", content: []byte("apple double"), time: march},
+	}
+}
+
+func writeZipArchive(t *testing.T, path string, files []archiveTestFile) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, file := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: file.time})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(file.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTarArchive(t *testing.T, path string, files []archiveTestFile, compress bool) {
+	t.Helper()
+	var buf bytes.Buffer
+	var gz *gzip.Writer
+	tw := tar.NewWriter(&buf)
+	if compress {
+		gz = gzip.NewWriter(&buf)
+		tw = tar.NewWriter(gz)
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: "Camera/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: files[0].time}); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: file.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(file.content)), ModTime: file.time}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(file.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportMediaFromArchives(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(t *testing.T, path string, files []archiveTestFile)
+	}{
+		{"export.zip", writeZipArchive},
+		{"export.tar", func(t *testing.T, path string, files []archiveTestFile) { writeTarArchive(t, path, files, false) }},
+		{"export.tar.gz", func(t *testing.T, path string, files []archiveTestFile) { writeTarArchive(t, path, files, true) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := archiveTestFiles(t)
+			archive := filepath.Join(t.TempDir(), tt.name)
+			tt.write(t, archive, files)
+			destDir := t.TempDir()
+
+			cfg := config{
+				SourceDir:          archive,
+				DestDir:            destDir,
+				OrganizeByDate:     true,
+				ChecksumDuplicates: true,
+				SidecarDefault:     SidecarDelete,
+			}
+			if err := validateConfig(&cfg); err != nil {
+				t.Fatalf("validateConfig failed: %v", err)
+			}
+			if err := importMedia(cfg); err != nil {
+				t.Fatalf("importMedia failed: %v", err)
+			}
+
+			for rel, want := range map[string][]byte{
+				"2024/06/VID_0001.mp4": files[0].content,
+				"2023/03/MVI_0002.avi": files[1].content,
+			} {
+				data, err := os.ReadFile(filepath.Join(destDir, rel))
+				if err != nil {
+					t.Errorf("expected %s: %v", rel, err)
+					continue
+				}
+				if !bytes.Equal(data, want) {
+					t.Errorf("%s has unexpected content", rel)
+				}
+			}
+			info, err := os.Stat(filepath.Join(destDir, "2023/03/MVI_0002.avi"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !info.ModTime().Equal(files[1].time) {
+				t.Errorf("modification time = %v, want member time %v", info.ModTime(), files[1].time)
+			}
+			if _, err := os.Stat(archive); err != nil {
+				t.Errorf("archive should be left in place: %v", err)
+			}
+
+			// A second import finds everything already there
+			if err := importMedia(cfg); err != nil {
+				t.Fatalf("second importMedia failed: %v", err)
+			}
+			entries, err := os.ReadDir(filepath.Join(destDir, "2023/03"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Errorf("expected 1 file after reimport, got %d", len(entries))
+			}
+		})
+	}
+}
+
+func TestFileSourceRejectsDeleteOriginals(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "export.zip")
+	writeZipArchive(t, archive, archiveTestFiles(t))
+
+	cfg := config{SourceDir: archive, DestDir: t.TempDir(), DeleteOriginals: true, SidecarDefault: SidecarDelete}
+	err := validateConfig(&cfg)
+	if err == nil || !strings.Contains(err.Error(), "read-only") {
+		t.Fatalf("expected read-only error, got %v", err)
+	}
+}
+
+func TestOpenFileSourceUnsupported(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(name, []byte(strings.Repeat("not an archive\n", 100)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openFileSource(name); err == nil || !strings.Contains(err.Error(), "not a ZIP or tar archive") {
+		t.Fatalf("expected unsupported format error, got %v", err)
+	}
+}
+
+func TestFileSourcePaths(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "export.zip")
+	writeZipArchive(t, archive, archiveTestFiles(t))
+	src, err := openFileSource(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = src.Close() }()
+
+	info, err := src.Stat(filepath.Join(archive, "Camera", "MVI_0002.avi"))
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Size() != int64(len("avi two")) {
+		t.Errorf("size = %d, want %d", info.Size(), len("avi two"))
+	}
+	if _, err := src.Stat(filepath.Join(archive, "..", "outside.jpg")); !os.IsNotExist(err) {
+		t.Errorf("expected not-exist error for a path outside the archive, got %v", err)
+	}
+	if err := src.Remove(filepath.Join(archive, "Camera", "MVI_0002.avi")); err == nil {
+		t.Error("expected Remove to fail on an archive")
+	}
+}
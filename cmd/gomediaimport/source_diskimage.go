@@ -0,0 +1,660 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Raw disk images are read without mounting them, so cards that no longer
+// mount can still be imported. Damaged directory entries and cluster chains
+// are reported as warnings and skipped.
+
+var errUnsupportedImage = errors.New("not a ZIP or tar archive, or a FAT or exFAT disk image")
+
+// mbrPartitionTypes are the MBR partition types that can hold FAT or exFAT
+var mbrPartitionTypes = map[byte]bool{
+	0x01: true, // FAT12
+	0x04: true, // FAT16 < 32 MiB
+	0x06: true, // FAT16
+	0x07: true, // exFAT (shared with NTFS)
+	0x0b: true, // FAT32 CHS
+	0x0c: true, // FAT32 LBA
+	0x0e: true, // FAT16 LBA
+}
+
+// openDiskImage reads a FAT or exFAT volume, either at the start of the image
+// or in the first usable partition of an MBR partition table.
+func openDiskImage(image io.ReaderAt, size int64) (*indexedFS, error) {
+	boot := make([]byte, 512)
+	if _, err := image.ReadAt(boot, 0); err != nil {
+		return nil, errUnsupportedImage
+	}
+	if fsys, err := openVolume(io.NewSectionReader(image, 0, size), boot); !errors.Is(err, errUnsupportedImage) {
+		return fsys, err
+	}
+	if boot[510] != 0x55 || boot[511] != 0xaa {
+		return nil, errUnsupportedImage
+	}
+
+	for i := 0; i < 4; i++ {
+		entry := boot[446+16*i : 446+16*(i+1)]
+		partType := entry[4]
+		if partType == 0xee {
+			return nil, errors.New("GPT partition tables are not supported; extract the partition first")
+		}
+		if !mbrPartitionTypes[partType] {
+			continue
+		}
+		start := int64(binary.LittleEndian.Uint32(entry[8:])) * 512
+		length := int64(binary.LittleEndian.Uint32(entry[12:])) * 512
+		if start <= 0 || start >= size {
+			continue
+		}
+		length = min(length, size-start)
+		volume := io.NewSectionReader(image, start, length)
+		if _, err := volume.ReadAt(boot, 0); err != nil {
+			continue
+		}
+		if fsys, err := openVolume(volume, boot); !errors.Is(err, errUnsupportedImage) {
+			return fsys, err
+		}
+	}
+	return nil, errUnsupportedImage
+}
+
+func openVolume(volume *io.SectionReader, boot []byte) (*indexedFS, error) {
+	if bytes.Equal(boot[3:11], []byte("EXFAT   ")) {
+		return openExFAT(volume, boot)
+	}
+	if (boot[0] == 0xeb || boot[0] == 0xe9) && boot[510] == 0x55 && boot[511] == 0xaa {
+		return openFAT(volume, boot)
+	}
+	return nil, errUnsupportedImage
+}
+
+// clusterReader reads a file's data from its clusters. Reads past validSize
+// return zeros, as exFAT requires for preallocated space.
+type clusterReader struct {
+	volume      io.ReaderAt
+	heapOffset  int64 // byte offset of cluster 2
+	clusterSize int64
+	clusters    []uint32
+	validSize   int64
+}
+
+func (r *clusterReader) ReadAt(p []byte, off int64) (int, error) {
+	var n int
+	for n < len(p) {
+		pos := off + int64(n)
+		index := pos / r.clusterSize
+		if index >= int64(len(r.clusters)) {
+			return n, io.EOF
+		}
+		within := pos % r.clusterSize
+		chunk := min(int64(len(p)-n), r.clusterSize-within)
+		if pos >= r.validSize {
+			clear(p[n : n+int(chunk)])
+			n += int(chunk)
+			continue
+		}
+		chunk = min(chunk, r.validSize-pos)
+		physical := r.heapOffset + int64(r.clusters[index]-2)*r.clusterSize + within
+		read, err := r.volume.ReadAt(p[n:n+int(chunk)], physical)
+		n += read
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// warnDamaged reports an entry that is skipped because the image is damaged
+func warnDamaged(name string, err error) {
+	fmt.Fprintf(os.Stderr, "Warning: skipping damaged %s: %v\n", name, err)
+}
+
+// fatVolume is a FAT12, FAT16 or FAT32 file system
+type fatVolume struct {
+	volume          io.ReaderAt
+	bits            int
+	bytesPerSector  int64
+	clusterSize     int64
+	heapOffset      int64
+	clusterCount    uint32
+	fat             []byte
+	rootDirOffset   int64 // FAT12/16 fixed root directory
+	rootDirSize     int64
+	rootDirCluster  uint32 // FAT32 root directory
+	maxChainEntries int
+}
+
+func openFAT(volume *io.SectionReader, boot []byte) (*indexedFS, error) {
+	bytesPerSector := int64(binary.LittleEndian.Uint16(boot[11:]))
+	sectorsPerCluster := int64(boot[13])
+	reservedSectors := int64(binary.LittleEndian.Uint16(boot[14:]))
+	numFATs := int64(boot[16])
+	rootEntries := int64(binary.LittleEndian.Uint16(boot[17:]))
+	totalSectors := int64(binary.LittleEndian.Uint16(boot[19:]))
+	if totalSectors == 0 {
+		totalSectors = int64(binary.LittleEndian.Uint32(boot[32:]))
+	}
+	fatSectors := int64(binary.LittleEndian.Uint16(boot[22:]))
+	if fatSectors == 0 {
+		fatSectors = int64(binary.LittleEndian.Uint32(boot[36:]))
+	}
+
+	switch bytesPerSector {
+	case 512, 1024, 2048, 4096:
+	default:
+		return nil, errUnsupportedImage
+	}
+	if sectorsPerCluster == 0 || sectorsPerCluster&(sectorsPerCluster-1) != 0 || reservedSectors == 0 || numFATs == 0 || fatSectors == 0 || totalSectors == 0 {
+		return nil, errUnsupportedImage
+	}
+
+	rootDirSectors := (rootEntries*32 + bytesPerSector - 1) / bytesPerSector
+	firstDataSector := reservedSectors + numFATs*fatSectors + rootDirSectors
+	if firstDataSector >= totalSectors {
+		return nil, fmt.Errorf("invalid FAT geometry")
+	}
+	// The boot sector is not trusted: the volume and its FAT must fit in the
+	// image before anything is allocated from their sizes
+	if totalSectors*bytesPerSector > volume.Size() {
+		return nil, fmt.Errorf("FAT volume of %d sectors does not fit in the %d byte image", totalSectors, volume.Size())
+	}
+	clusterCount := (totalSectors - firstDataSector) / sectorsPerCluster
+
+	v := &fatVolume{
+		volume:         volume,
+		bytesPerSector: bytesPerSector,
+		clusterSize:    bytesPerSector * sectorsPerCluster,
+		heapOffset:     firstDataSector * bytesPerSector,
+		clusterCount:   uint32(clusterCount),
+	}
+	switch {
+	case clusterCount < 4085:
+		v.bits = 12
+	case clusterCount < 65525:
+		v.bits = 16
+	default:
+		v.bits = 32
+		v.rootDirCluster = binary.LittleEndian.Uint32(boot[44:])
+	}
+	if v.bits != 32 {
+		v.rootDirOffset = (reservedSectors + numFATs*fatSectors) * bytesPerSector
+		v.rootDirSize = rootEntries * 32
+	}
+	if fatSectors*bytesPerSector*8 < (clusterCount+2)*int64(v.bits) {
+		return nil, fmt.Errorf("FAT of %d sectors is too small for %d clusters", fatSectors, clusterCount)
+	}
+	v.maxChainEntries = int(clusterCount)
+
+	v.fat = make([]byte, fatSectors*bytesPerSector)
+	if _, err := volume.ReadAt(v.fat, reservedSectors*bytesPerSector); err != nil {
+		return nil, fmt.Errorf("failed to read FAT: %w", err)
+	}
+
+	fsys := newIndexedFS()
+	var rootDir []byte
+	if v.bits == 32 {
+		clusters, err := v.chain(v.rootDirCluster)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read root directory: %w", err)
+		}
+		rootDir, err = v.readClusters(clusters)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read root directory: %w", err)
+		}
+	} else {
+		rootDir = make([]byte, v.rootDirSize)
+		if _, err := volume.ReadAt(rootDir, v.rootDirOffset); err != nil {
+			return nil, fmt.Errorf("failed to read root directory: %w", err)
+		}
+	}
+	v.index(fsys, "", rootDir, map[uint32]bool{})
+	return fsys, nil
+}
+
+// next returns the FAT entry for cluster
+func (v *fatVolume) next(cluster uint32) uint32 {
+	switch v.bits {
+	case 12:
+		offset := int(cluster) + int(cluster)/2
+		if offset+1 >= len(v.fat) {
+			return 0
+		}
+		value := uint32(binary.LittleEndian.Uint16(v.fat[offset:]))
+		if cluster&1 == 1 {
+			return value >> 4
+		}
+		return value & 0xfff
+	case 16:
+		offset := int(cluster) * 2
+		if offset+1 >= len(v.fat) {
+			return 0
+		}
+		return uint32(binary.LittleEndian.Uint16(v.fat[offset:]))
+	default:
+		offset := int(cluster) * 4
+		if offset+3 >= len(v.fat) {
+			return 0
+		}
+		return binary.LittleEndian.Uint32(v.fat[offset:]) & 0x0fffffff
+	}
+}
+
+func (v *fatVolume) isEndOfChain(value uint32) bool {
+	switch v.bits {
+	case 12:
+		return value >= 0xff8
+	case 16:
+		return value >= 0xfff8
+	default:
+		return value >= 0x0ffffff8
+	}
+}
+
+// chain follows the cluster chain starting at first
+func (v *fatVolume) chain(first uint32) ([]uint32, error) {
+	var clusters []uint32
+	cluster := first
+	for {
+		if cluster < 2 || cluster >= v.clusterCount+2 {
+			return nil, fmt.Errorf("cluster chain points to invalid cluster %d", cluster)
+		}
+		clusters = append(clusters, cluster)
+		if len(clusters) > v.maxChainEntries {
+			return nil, errors.New("cluster chain loops")
+		}
+		next := v.next(cluster)
+		if v.isEndOfChain(next) {
+			return clusters, nil
+		}
+		cluster = next
+	}
+}
+
+func (v *fatVolume) reader(clusters []uint32, size int64) *clusterReader {
+	return &clusterReader{volume: v.volume, heapOffset: v.heapOffset, clusterSize: v.clusterSize, clusters: clusters, validSize: size}
+}
+
+func (v *fatVolume) readClusters(clusters []uint32) ([]byte, error) {
+	data := make([]byte, int64(len(clusters))*v.clusterSize)
+	if _, err := v.reader(clusters, int64(len(data))).ReadAt(data, 0); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// index adds the entries of a directory and, recursively, its
+// subdirectories. visited guards against directory loops.
+func (v *fatVolume) index(fsys *indexedFS, dir string, data []byte, visited map[uint32]bool) {
+	var longName []uint16
+	var longChecksum byte
+	nextOrdinal := 0
+
+	for offset := 0; offset+32 <= len(data); offset += 32 {
+		entry := data[offset : offset+32]
+		if entry[0] == 0x00 {
+			return
+		}
+		if entry[0] == 0xe5 {
+			longName = nil
+			continue
+		}
+
+		attr := entry[11]
+		if attr&0x3f == 0x0f {
+			ordinal := int(entry[0] & 0x3f)
+			if entry[0]&0x40 != 0 {
+				longName = make([]uint16, 13*ordinal)
+				longChecksum = entry[13]
+				nextOrdinal = ordinal
+			}
+			if longName == nil || ordinal != nextOrdinal || ordinal == 0 || entry[13] != longChecksum {
+				longName = nil
+				continue
+			}
+			part := longName[13*(ordinal-1):]
+			for i, pos := range []int{1, 3, 5, 7, 9, 14, 16, 18, 20, 22, 24, 28, 30} {
+				part[i] = binary.LittleEndian.Uint16(entry[pos:])
+			}
+			nextOrdinal--
+			continue
+		}
+
+		name := fatShortName(entry)
+		if longName != nil && nextOrdinal == 0 && fatShortNameChecksum(entry[:11]) == longChecksum {
+			name = decodeFATLongName(longName)
+		}
+		longName = nil
+
+		// Volume labels, and the dot entries of subdirectories
+		if attr&0x08 != 0 || name == "." || name == ".." || name == "" {
+			continue
+		}
+
+		memberPath := path.Join(dir, name)
+		first := uint32(binary.LittleEndian.Uint16(entry[26:]))
+		if v.bits == 32 {
+			first |= uint32(binary.LittleEndian.Uint16(entry[20:])) << 16
+		}
+		modTime := fatTimestamp(binary.LittleEndian.Uint16(entry[24:]), binary.LittleEndian.Uint16(entry[22:]), time.Local)
+
+		if attr&0x10 != 0 {
+			if visited[first] {
+				continue
+			}
+			visited[first] = true
+			clusters, err := v.chain(first)
+			if err != nil {
+				warnDamaged("directory "+memberPath, err)
+				continue
+			}
+			subdir, err := v.readClusters(clusters)
+			if err != nil {
+				warnDamaged("directory "+memberPath, err)
+				continue
+			}
+			fsys.addDir(memberPath, modTime)
+			v.index(fsys, memberPath, subdir, visited)
+			continue
+		}
+
+		size := int64(binary.LittleEndian.Uint32(entry[28:]))
+		var clusters []uint32
+		if size > 0 {
+			var err error
+			clusters, err = v.chain(first)
+			if err == nil && int64(len(clusters))*v.clusterSize < size {
+				err = errors.New("cluster chain is shorter than the file")
+			}
+			if err != nil {
+				warnDamaged("file "+memberPath, err)
+				continue
+			}
+		}
+		fsys.addFile(memberPath, size, modTime, v.reader(clusters, size))
+	}
+}
+
+// fatShortName returns the 8.3 name of a directory entry, lowercased where
+// the Windows NT case flags say so.
+func fatShortName(entry []byte) string {
+	base := make([]byte, 8)
+	copy(base, entry[:8])
+	if base[0] == 0x05 {
+		base[0] = 0xe5
+	}
+	name := strings.TrimRight(string(base), " ")
+	ext := strings.TrimRight(string(entry[8:11]), " ")
+	if entry[12]&0x08 != 0 {
+		name = strings.ToLower(name)
+	}
+	if entry[12]&0x10 != 0 {
+		ext = strings.ToLower(ext)
+	}
+	if ext != "" {
+		name += "." + ext
+	}
+	return name
+}
+
+func fatShortNameChecksum(name []byte) byte {
+	var sum byte
+	for _, c := range name {
+		sum = (sum&1)<<7 + sum>>1 + c
+	}
+	return sum
+}
+
+func decodeFATLongName(units []uint16) string {
+	for i, u := range units {
+		if u == 0x0000 {
+			units = units[:i]
+			break
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+// fatTimestamp decodes a FAT date and time, which have two-second precision
+func fatTimestamp(date, clock uint16, loc *time.Location) time.Time {
+	if date == 0 {
+		return time.Time{}
+	}
+	return time.Date(1980+int(date>>9), time.Month(date>>5&0x0f), int(date&0x1f),
+		int(clock>>11), int(clock>>5&0x3f), int(clock&0x1f)*2, 0, loc)
+}
+
+// exfatVolume is an exFAT file system
+type exfatVolume struct {
+	volume       io.ReaderAt
+	clusterSize  int64
+	heapOffset   int64
+	clusterCount uint32
+	fatOffset    int64
+}
+
+func openExFAT(volume *io.SectionReader, boot []byte) (*indexedFS, error) {
+	sectorShift := boot[108]
+	clusterShift := boot[109]
+	if sectorShift < 9 || sectorShift > 12 || int(sectorShift)+int(clusterShift) > 25 {
+		return nil, fmt.Errorf("invalid exFAT geometry")
+	}
+	bytesPerSector := int64(1) << sectorShift
+	v := &exfatVolume{
+		volume:       volume,
+		clusterSize:  bytesPerSector << clusterShift,
+		fatOffset:    int64(binary.LittleEndian.Uint32(boot[80:])) * bytesPerSector,
+		heapOffset:   int64(binary.LittleEndian.Uint32(boot[88:])) * bytesPerSector,
+		clusterCount: binary.LittleEndian.Uint32(boot[92:]),
+	}
+	if v.heapOffset+int64(v.clusterCount)*v.clusterSize > volume.Size() || v.fatOffset+(int64(v.clusterCount)+2)*4 > volume.Size() {
+		return nil, fmt.Errorf("exFAT volume of %d clusters does not fit in the %d byte image", v.clusterCount, volume.Size())
+	}
+	rootCluster := binary.LittleEndian.Uint32(boot[96:])
+
+	clusters, err := v.chain(rootCluster)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read root directory: %w", err)
+	}
+	rootDir, err := v.readClusters(clusters)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read root directory: %w", err)
+	}
+	fsys := newIndexedFS()
+	v.index(fsys, "", rootDir, map[uint32]bool{})
+	return fsys, nil
+}
+
+// chain follows a cluster chain in the FAT. The FAT is read entry by entry
+// because exFAT volumes can be large and most files are contiguous.
+func (v *exfatVolume) chain(first uint32) ([]uint32, error) {
+	var clusters []uint32
+	entry := make([]byte, 4)
+	cluster := first
+	for {
+		if cluster < 2 || cluster >= v.clusterCount+2 {
+			return nil, fmt.Errorf("cluster chain points to invalid cluster %d", cluster)
+		}
+		clusters = append(clusters, cluster)
+		if len(clusters) > int(v.clusterCount) {
+			return nil, errors.New("cluster chain loops")
+		}
+		if _, err := v.volume.ReadAt(entry, v.fatOffset+int64(cluster)*4); err != nil {
+			return nil, fmt.Errorf("failed to read FAT: %w", err)
+		}
+		next := binary.LittleEndian.Uint32(entry)
+		if next == 0xffffffff {
+			return clusters, nil
+		}
+		cluster = next
+	}
+}
+
+// contiguous returns the clusters of a file stored without a FAT chain
+func (v *exfatVolume) contiguous(first uint32, size int64) ([]uint32, error) {
+	count := (size + v.clusterSize - 1) / v.clusterSize
+	if first < 2 || int64(first)+count > int64(v.clusterCount)+2 {
+		return nil, fmt.Errorf("clusters %d+%d are outside the volume", first, count)
+	}
+	clusters := make([]uint32, count)
+	for i := range clusters {
+		clusters[i] = first + uint32(i)
+	}
+	return clusters, nil
+}
+
+func (v *exfatVolume) reader(clusters []uint32, validSize int64) *clusterReader {
+	return &clusterReader{volume: v.volume, heapOffset: v.heapOffset, clusterSize: v.clusterSize, clusters: clusters, validSize: validSize}
+}
+
+func (v *exfatVolume) readClusters(clusters []uint32) ([]byte, error) {
+	data := make([]byte, int64(len(clusters))*v.clusterSize)
+	if _, err := v.reader(clusters, int64(len(data))).ReadAt(data, 0); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// index adds the file entry sets of a directory and, recursively, its
+// subdirectories.
+func (v *exfatVolume) index(fsys *indexedFS, dir string, data []byte, visited map[uint32]bool) {
+	for offset := 0; offset+32 <= len(data); offset += 32 {
+		entryType := data[offset]
+		if entryType == 0x00 {
+			return
+		}
+		// Only file entry sets are of interest; bitmap, up-case table, label
+		// and deleted entries are skipped.
+		if entryType != 0x85 {
+			continue
+		}
+		secondaryCount := int(data[offset+1])
+		end := offset + 32*(secondaryCount+1)
+		if secondaryCount < 2 || end > len(data) {
+			warnDamaged("directory entry in "+displayDir(dir), errors.New("truncated entry set"))
+			continue
+		}
+		set := data[offset:end]
+		offset = end - 32
+
+		if exfatSetChecksum(set) != binary.LittleEndian.Uint16(set[2:]) {
+			warnDamaged("directory entry in "+displayDir(dir), errors.New("entry set checksum mismatch"))
+			continue
+		}
+		stream := set[32:64]
+		if stream[0] != 0xc0 {
+			warnDamaged("directory entry in "+displayDir(dir), errors.New("missing stream extension"))
+			continue
+		}
+		nameLength := int(stream[3])
+		var units []uint16
+		for i := 2; i <= secondaryCount && len(units) < nameLength; i++ {
+			nameEntry := set[32*i : 32*(i+1)]
+			if nameEntry[0] != 0xc1 {
+				break
+			}
+			for pos := 2; pos < 32 && len(units) < nameLength; pos += 2 {
+				units = append(units, binary.LittleEndian.Uint16(nameEntry[pos:]))
+			}
+		}
+		name := string(utf16.Decode(units))
+		if len(units) != nameLength || name == "" {
+			warnDamaged("directory entry in "+displayDir(dir), errors.New("incomplete file name"))
+			continue
+		}
+
+		memberPath := path.Join(dir, name)
+		attributes := binary.LittleEndian.Uint16(set[4:])
+		modTime := exfatTimestamp(binary.LittleEndian.Uint32(set[12:]), set[21], set[23])
+		noFATChain := stream[1]&0x02 != 0
+		validSize := int64(binary.LittleEndian.Uint64(stream[8:]))
+		first := binary.LittleEndian.Uint32(stream[20:])
+		size := int64(binary.LittleEndian.Uint64(stream[24:]))
+
+		var clusters []uint32
+		var err error
+		switch {
+		case size == 0:
+		case noFATChain:
+			clusters, err = v.contiguous(first, size)
+		default:
+			clusters, err = v.chain(first)
+			if err == nil && int64(len(clusters))*v.clusterSize < size {
+				err = errors.New("cluster chain is shorter than the file")
+			}
+		}
+
+		if attributes&0x10 != 0 {
+			if err == nil && len(clusters) > 0 && visited[clusters[0]] {
+				continue
+			}
+			var subdir []byte
+			if err == nil {
+				visited[first] = true
+				subdir, err = v.readClusters(clusters)
+			}
+			if err != nil {
+				warnDamaged("directory "+memberPath, err)
+				continue
+			}
+			fsys.addDir(memberPath, modTime)
+			v.index(fsys, memberPath, subdir, visited)
+			continue
+		}
+		if err != nil {
+			warnDamaged("file "+memberPath, err)
+			continue
+		}
+		fsys.addFile(memberPath, size, modTime, v.reader(clusters, min(validSize, size)))
+	}
+}
+
+func displayDir(dir string) string {
+	if dir == "" {
+		return "/"
+	}
+	return dir
+}
+
+// exfatSetChecksum computes the checksum of a directory entry set, skipping
+// the checksum field itself.
+func exfatSetChecksum(set []byte) uint16 {
+	var sum uint16
+	for i, b := range set {
+		if i == 2 || i == 3 {
+			continue
+		}
+		sum = (sum&1)<<15 + sum>>1 + uint16(b)
+	}
+	return sum
+}
+
+// exfatTimestamp decodes an exFAT timestamp with its 10 ms increment and
+// UTC offset. Timestamps without a valid offset are local time.
+func exfatTimestamp(timestamp uint32, increment10ms, utcOffset byte) time.Time {
+	loc := time.Local
+	if utcOffset&0x80 != 0 {
+		// Seven-bit signed count of 15-minute intervals
+		quarters := int(int8(utcOffset<<1) >> 1)
+		loc = time.FixedZone("", quarters*15*60)
+	}
+	t := fatTimestamp(uint16(timestamp>>16), uint16(timestamp), loc)
+	if t.IsZero() {
+		return t
+	}
+	return t.Add(time.Duration(increment10ms) * 10 * time.Millisecond)
+}
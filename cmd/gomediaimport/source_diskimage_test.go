@@ -0,0 +1,650 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+type diskImageTestFile struct {
+	path    string
+	content string
+	time    time.Time
+}
+
+// fatImageBuilder writes a FAT12 or FAT32 image with one sector per cluster
+type fatImageBuilder struct {
+	t              *testing.T
+	file           *os.File
+	bits           int
+	offset         int64 // partition offset within the image
+	reservedSector int64
+	fatSectors     int64
+	rootEntries    int64
+	totalSectors   int64
+	nextCluster    uint32
+	fat            map[uint32]uint32
+	firstClusters  map[string]uint32
+	shortNames     int
+}
+
+func newFATImageBuilder(t *testing.T, bits int, offset int64) *fatImageBuilder {
+	t.Helper()
+	file, err := os.Create(filepath.Join(t.TempDir(), "card.img"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = file.Close() })
+	b := &fatImageBuilder{t: t, file: file, bits: bits, offset: offset, nextCluster: 2, fat: map[uint32]uint32{}, firstClusters: map[string]uint32{}}
+	if bits == 12 {
+		b.reservedSector, b.fatSectors, b.rootEntries, b.totalSectors = 1, 9, 224, 2880
+	} else {
+		// Enough clusters to be FAT32; the image is sparse
+		b.reservedSector, b.fatSectors, b.rootEntries, b.totalSectors = 32, 550, 0, 70000
+	}
+	return b
+}
+
+func (b *fatImageBuilder) writeAt(data []byte, off int64) {
+	b.t.Helper()
+	if _, err := b.file.WriteAt(data, b.offset+off); err != nil {
+		b.t.Fatal(err)
+	}
+}
+
+func (b *fatImageBuilder) rootDirOffset() int64 {
+	return (b.reservedSector + 2*b.fatSectors) * 512
+}
+
+func (b *fatImageBuilder) clusterOffset(cluster uint32) int64 {
+	rootDirSectors := b.rootEntries * 32 / 512
+	return b.rootDirOffset() + rootDirSectors*512 + int64(cluster-2)*512
+}
+
+func (b *fatImageBuilder) endOfChain() uint32 {
+	if b.bits == 12 {
+		return 0xfff
+	}
+	return 0x0fffffff
+}
+
+// allocate writes data to a new cluster chain and returns its first cluster
+func (b *fatImageBuilder) allocate(data []byte) uint32 {
+	count := max((len(data)+511)/512, 1)
+	first := b.nextCluster
+	for i := 0; i < count; i++ {
+		cluster := b.nextCluster
+		b.nextCluster++
+		b.fat[cluster] = b.endOfChain()
+		if i > 0 {
+			b.fat[cluster-1] = cluster
+		}
+	}
+	b.writeAt(data, b.clusterOffset(first))
+	return first
+}
+
+// build writes the directory tree of files and the boot sector and FATs
+func (b *fatImageBuilder) build(files []diskImageTestFile) {
+	b.t.Helper()
+	root := b.directory(files, "")
+	if b.bits == 12 {
+		b.writeAt(root, b.rootDirOffset())
+	} else {
+		b.firstClusters[""] = b.allocate(root)
+	}
+
+	boot := make([]byte, 512)
+	boot[0], boot[1], boot[2] = 0xeb, 0x3c, 0x90
+	copy(boot[3:], "MSWIN4.1")
+	binary.LittleEndian.PutUint16(boot[11:], 512)
+	boot[13] = 1
+	binary.LittleEndian.PutUint16(boot[14:], uint16(b.reservedSector))
+	boot[16] = 2
+	binary.LittleEndian.PutUint16(boot[17:], uint16(b.rootEntries))
+	boot[21] = 0xf8
+	if b.bits == 12 {
+		binary.LittleEndian.PutUint16(boot[19:], uint16(b.totalSectors))
+		binary.LittleEndian.PutUint16(boot[22:], uint16(b.fatSectors))
+	} else {
+		binary.LittleEndian.PutUint32(boot[32:], uint32(b.totalSectors))
+		binary.LittleEndian.PutUint32(boot[36:], uint32(b.fatSectors))
+		binary.LittleEndian.PutUint32(boot[44:], b.firstClusters[""])
+	}
+	boot[510], boot[511] = 0x55, 0xaa
+	b.writeAt(boot, 0)
+	b.writeFATs()
+	if err := b.file.Truncate(b.offset + b.totalSectors*512); err != nil {
+		b.t.Fatal(err)
+	}
+}
+
+func (b *fatImageBuilder) writeFATs() {
+	fat := make([]byte, b.fatSectors*512)
+	set := func(cluster, value uint32) {
+		switch b.bits {
+		case 12:
+			offset := int(cluster) + int(cluster)/2
+			current := binary.LittleEndian.Uint16(fat[offset:])
+			if cluster&1 == 1 {
+				current = current&0x000f | uint16(value)<<4
+			} else {
+				current = current&0xf000 | uint16(value)&0x0fff
+			}
+			binary.LittleEndian.PutUint16(fat[offset:], current)
+		default:
+			binary.LittleEndian.PutUint32(fat[cluster*4:], value)
+		}
+	}
+	set(0, 0xff8)
+	set(1, b.endOfChain())
+	for cluster, value := range b.fat {
+		set(cluster, value)
+	}
+	b.writeAt(fat, b.reservedSector*512)
+	b.writeAt(fat, (b.reservedSector+b.fatSectors)*512)
+}
+
+// directory returns the entries of dir, allocating its files and
+// subdirectories first.
+func (b *fatImageBuilder) directory(files []diskImageTestFile, dir string) []byte {
+	children := map[string]bool{}
+	for _, file := range files {
+		rel := file.path
+		if dir != "" {
+			if !strings.HasPrefix(rel, dir+"/") {
+				continue
+			}
+			rel = strings.TrimPrefix(rel, dir+"/")
+		}
+		name, _, _ := strings.Cut(rel, "/")
+		children[name] = true
+	}
+	var names []string
+	for name := range children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var entries []byte
+	if dir == "" {
+		label := make([]byte, 32)
+		copy(label, "CARD       ")
+		label[11] = 0x08
+		entries = append(entries, label...)
+	} else {
+		entries = append(entries, b.entry(".", 0x10, 0, 0, time.Time{})...)
+		entries = append(entries, b.entry("..", 0x10, 0, 0, time.Time{})...)
+	}
+	for _, name := range names {
+		memberPath := path.Join(dir, name)
+		var file *diskImageTestFile
+		for i := range files {
+			if files[i].path == memberPath {
+				file = &files[i]
+			}
+		}
+		if file == nil {
+			cluster := b.allocate(b.directory(files, memberPath))
+			b.firstClusters[memberPath] = cluster
+			entries = append(entries, b.entry(name, 0x10, cluster, 0, time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local))...)
+			continue
+		}
+		var cluster uint32
+		if file.content != "" {
+			cluster = b.allocate([]byte(file.content))
+		}
+		b.firstClusters[memberPath] = cluster
+		entries = append(entries, b.entry(name, 0x20, cluster, len(file.content), file.time)...)
+	}
+	// A deleted entry, which must be skipped
+	deleted := b.entry("GONE.JPG", 0x20, 0, 0, time.Time{})
+	deleted[0] = 0xe5
+	return append(entries, deleted...)
+}
+
+// entry returns the directory entries for name: an 8.3 entry, preceded by
+// long name entries if the name needs them.
+func (b *fatImageBuilder) entry(name string, attr byte, cluster uint32, size int, modTime time.Time) []byte {
+	sfn := make([]byte, 32)
+	base, ext, _ := strings.Cut(name, ".")
+	if name == "." || name == ".." {
+		base, ext = name, ""
+	}
+	longName := false
+	switch {
+	case strings.ToUpper(name) == name && len(base) <= 8 && len(ext) <= 3 && !strings.Contains(name, " "):
+	case strings.ToLower(name) == name && len(base) <= 8 && len(ext) <= 3 && !strings.Contains(name, " "):
+		sfn[12] = 0x18
+	default:
+		b.shortNames++
+		base = strings.ToUpper(strings.ReplaceAll(base, " ", ""))[:6] + "~" + string(rune('0'+b.shortNames))
+		longName = true
+	}
+	copy(sfn[:8], strings.ToUpper(base)+"        ")
+	copy(sfn[8:11], strings.ToUpper(ext)+"   ")
+	sfn[11] = attr
+	if !modTime.IsZero() {
+		binary.LittleEndian.PutUint16(sfn[22:], uint16(modTime.Hour()<<11|modTime.Minute()<<5|modTime.Second()/2))
+		binary.LittleEndian.PutUint16(sfn[24:], uint16((modTime.Year()-1980)<<9|int(modTime.Month())<<5|modTime.Day()))
+	}
+	binary.LittleEndian.PutUint16(sfn[26:], uint16(cluster))
+	binary.LittleEndian.PutUint16(sfn[20:], uint16(cluster>>16))
+	binary.LittleEndian.PutUint32(sfn[28:], uint32(size))
+	if !longName {
+		return sfn
+	}
+
+	units := append(utf16.Encode([]rune(name)), 0)
+	count := (len(units) + 12) / 13
+	for len(units) < count*13 {
+		units = append(units, 0xffff)
+	}
+	checksum := fatShortNameChecksum(sfn[:11])
+	var entries []byte
+	for ordinal := count; ordinal >= 1; ordinal-- {
+		lfn := make([]byte, 32)
+		lfn[0] = byte(ordinal)
+		if ordinal == count {
+			lfn[0] |= 0x40
+		}
+		lfn[11] = 0x0f
+		lfn[13] = checksum
+		for i, pos := range []int{1, 3, 5, 7, 9, 14, 16, 18, 20, 22, 24, 28, 30} {
+			binary.LittleEndian.PutUint16(lfn[pos:], units[13*(ordinal-1)+i])
+		}
+		entries = append(entries, lfn...)
+	}
+	return append(entries, sfn...)
+}
+
+// writeMBR adds a partition table with one partition of partType at the
+// builder's offset
+func (b *fatImageBuilder) writeMBR(partType byte) {
+	mbr := make([]byte, 512)
+	entry := mbr[446:]
+	entry[4] = partType
+	binary.LittleEndian.PutUint32(entry[8:], uint32(b.offset/512))
+	binary.LittleEndian.PutUint32(entry[12:], uint32(b.totalSectors))
+	mbr[510], mbr[511] = 0x55, 0xaa
+	if _, err := b.file.WriteAt(mbr, 0); err != nil {
+		b.t.Fatal(err)
+	}
+}
+
+func diskImageTestFiles() []diskImageTestFile {
+	june := time.Date(2024, 6, 15, 10, 30, 0, 0, time.Local)
+	july := time.Date(2024, 7, 2, 8, 0, 4, 0, time.Local)
+	return []diskImageTestFile{
+		{path: "DCIM/100CANON/MVI_0001.AVI", content: strings.Repeat("first clip ", 100), time: june},
+		{path: "DCIM/100CANON/mvi_0002.avi", content: "second clip", time: june},
+		{path: "DCIM/101CANON/Holiday clip 2024.avi", content: "holiday clip", time: july},
+		{path: "DCIM/101CANON/EMPTY.AVI", content: "", time: july},
+		{path: "MISC/README.TXT", content: "not media", time: july},
+	}
+}
+
+func readDiskImageFS(t *testing.T, name string) fs.FS {
+	t.Helper()
+	src, err := openFileSource(name)
+	if err != nil {
+		t.Fatalf("openFileSource failed: %v", err)
+	}
+	t.Cleanup(func() { _ = src.Close() })
+	return src.fsys
+}
+
+func checkDiskImageFS(t *testing.T, fsys fs.FS, files []diskImageTestFile) {
+	t.Helper()
+	for _, want := range files {
+		data, err := fs.ReadFile(fsys, want.path)
+		if err != nil {
+			t.Errorf("reading %s: %v", want.path, err)
+			continue
+		}
+		if string(data) != want.content {
+			t.Errorf("%s = %q, want %q", want.path, data, want.content)
+		}
+		info, err := fs.Stat(fsys, want.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.ModTime().Equal(want.time) {
+			t.Errorf("%s modified %v, want %v", want.path, info.ModTime(), want.time)
+		}
+	}
+	if _, err := fs.Stat(fsys, "DCIM/100CANON/GONE.JPG"); err == nil {
+		t.Error("deleted entry should not be listed")
+	}
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if strings.Join(names, ",") != "DCIM,MISC" {
+		t.Errorf("root entries = %v, want [DCIM MISC]", names)
+	}
+}
+
+func TestOpenFATImage(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		bits   int
+		offset int64
+	}{
+		{"FAT12", 12, 0},
+		{"FAT32", 32, 0},
+		{"FAT12 in MBR partition", 12, 63 * 512},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			files := diskImageTestFiles()
+			b := newFATImageBuilder(t, tt.bits, tt.offset)
+			b.build(files)
+			if tt.offset > 0 {
+				b.writeMBR(0x01)
+			}
+			checkDiskImageFS(t, readDiskImageFS(t, b.file.Name()), files)
+		})
+	}
+}
+
+func TestOpenFATImageSkipsDamagedChains(t *testing.T) {
+	files := diskImageTestFiles()
+	b := newFATImageBuilder(t, 12, 0)
+	b.build(files)
+	// Point the second cluster of the first clip outside the volume
+	b.fat[b.firstClusters["DCIM/100CANON/MVI_0001.AVI"]] = 0xff0
+	b.writeFATs()
+
+	fsys := readDiskImageFS(t, b.file.Name())
+	if _, err := fs.Stat(fsys, "DCIM/100CANON/MVI_0001.AVI"); err == nil {
+		t.Error("file with a damaged cluster chain should be skipped")
+	}
+	if data, err := fs.ReadFile(fsys, "DCIM/100CANON/mvi_0002.avi"); err != nil || string(data) != "second clip" {
+		t.Errorf("undamaged file = %q, %v", data, err)
+	}
+}
+
+func TestOpenFATImageRejectsOversizedGeometry(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		patch func(boot []byte)
+		want  string
+	}{
+		{"FAT larger than the image", func(boot []byte) {
+			binary.LittleEndian.PutUint16(boot[19:], 0)
+			binary.LittleEndian.PutUint32(boot[32:], 0xfffffff0)
+			binary.LittleEndian.PutUint16(boot[22:], 0)
+			binary.LittleEndian.PutUint32(boot[36:], 0x0fffffff)
+		}, "does not fit"},
+		{"more sectors than the image", func(boot []byte) {
+			binary.LittleEndian.PutUint16(boot[19:], 0xffff)
+		}, "does not fit"},
+		{"FAT too small for the clusters", func(boot []byte) {
+			binary.LittleEndian.PutUint16(boot[22:], 1)
+		}, "too small"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newFATImageBuilder(t, 12, 0)
+			b.build(diskImageTestFiles())
+			boot := make([]byte, 512)
+			if _, err := b.file.ReadAt(boot, 0); err != nil {
+				t.Fatal(err)
+			}
+			tt.patch(boot)
+			b.writeAt(boot, 0)
+			info, err := b.file.Stat()
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = openDiskImage(b.file, info.Size())
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("openDiskImage = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// exfatImageBuilder writes an exFAT image with 4 KiB clusters
+type exfatImageBuilder struct {
+	t           *testing.T
+	file        *os.File
+	nextCluster uint32
+}
+
+const (
+	exfatTestFATOffset    = 24 // sectors
+	exfatTestHeapOffset   = 32 // sectors
+	exfatTestClusterCount = 256
+	exfatTestClusterSize  = 4096
+)
+
+func (b *exfatImageBuilder) writeClusters(clusters []uint32, data []byte) {
+	for i, cluster := range clusters {
+		chunk := data[min(i*exfatTestClusterSize, len(data)):min((i+1)*exfatTestClusterSize, len(data))]
+		if _, err := b.file.WriteAt(chunk, exfatTestHeapOffset*512+int64(cluster-2)*exfatTestClusterSize); err != nil {
+			b.t.Fatal(err)
+		}
+	}
+}
+
+func (b *exfatImageBuilder) setFAT(cluster, value uint32) {
+	entry := make([]byte, 4)
+	binary.LittleEndian.PutUint32(entry, value)
+	if _, err := b.file.WriteAt(entry, exfatTestFATOffset*512+int64(cluster)*4); err != nil {
+		b.t.Fatal(err)
+	}
+}
+
+// store writes data contiguously, or in clusters chained in reverse order
+// when fragmented, and returns the stream extension flags and first cluster.
+func (b *exfatImageBuilder) store(data []byte, fragmented bool) (byte, uint32) {
+	count := uint32((len(data) + exfatTestClusterSize - 1) / exfatTestClusterSize)
+	if count == 0 {
+		return 0x01, 0
+	}
+	clusters := make([]uint32, count)
+	for i := range clusters {
+		clusters[i] = b.nextCluster + uint32(i)
+	}
+	b.nextCluster += count
+	if !fragmented {
+		b.writeClusters(clusters, data)
+		return 0x03, clusters[0]
+	}
+	for i, j := 0, len(clusters)-1; i < j; i, j = i+1, j-1 {
+		clusters[i], clusters[j] = clusters[j], clusters[i]
+	}
+	for i, cluster := range clusters {
+		next := uint32(0xffffffff)
+		if i+1 < len(clusters) {
+			next = clusters[i+1]
+		}
+		b.setFAT(cluster, next)
+	}
+	b.writeClusters(clusters, data)
+	return 0x01, clusters[0]
+}
+
+// exfatEntrySet returns a file directory entry set
+func exfatEntrySet(name string, attributes uint16, modTime time.Time, utcOffset byte, flags byte, cluster uint32, validSize, size int64) []byte {
+	units := utf16.Encode([]rune(name))
+	nameEntries := (len(units) + 14) / 15
+	set := make([]byte, 32*(2+nameEntries))
+	set[0] = 0x85
+	set[1] = byte(1 + nameEntries)
+	binary.LittleEndian.PutUint16(set[4:], attributes)
+	timestamp := uint32((modTime.Year()-1980)<<25|int(modTime.Month())<<21|modTime.Day()<<16) |
+		uint32(modTime.Hour()<<11|modTime.Minute()<<5|modTime.Second()/2)
+	binary.LittleEndian.PutUint32(set[12:], timestamp)
+	set[21] = byte(modTime.Second()%2*100 + modTime.Nanosecond()/10_000_000)
+	set[23] = utcOffset
+
+	stream := set[32:64]
+	stream[0] = 0xc0
+	stream[1] = flags
+	stream[3] = byte(len(units))
+	binary.LittleEndian.PutUint64(stream[8:], uint64(validSize))
+	binary.LittleEndian.PutUint32(stream[20:], cluster)
+	binary.LittleEndian.PutUint64(stream[24:], uint64(size))
+	for i, unit := range units {
+		nameEntry := set[64+32*(i/15):]
+		nameEntry[0] = 0xc1
+		binary.LittleEndian.PutUint16(nameEntry[2+2*(i%15):], unit)
+	}
+	binary.LittleEndian.PutUint16(set[2:], exfatSetChecksum(set))
+	return set
+}
+
+func TestOpenExFATImage(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), "card.img"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = file.Close() }()
+	b := &exfatImageBuilder{t: t, file: file, nextCluster: 3}
+
+	utc := time.Date(2024, 6, 15, 8, 30, 1, 500_000_000, time.UTC)
+	local := time.Date(2024, 7, 2, 8, 0, 4, 0, time.Local)
+	long := strings.Repeat("fragmented clip ", 600)
+
+	var clipDir []byte
+	flags, cluster := b.store([]byte(long), true)
+	clipDir = append(clipDir, exfatEntrySet("C0001.MP4", 0x20, local, 0, flags, cluster, int64(len(long)), int64(len(long)))...)
+	// Preallocated beyond the valid data length, which reads as zeros
+	flags, cluster = b.store(append([]byte("short clip"), make([]byte, 100)...), false)
+	clipDir = append(clipDir, exfatEntrySet("Clip with a long name.avi", 0x20, local, 0, flags, cluster, 10, 110)...)
+	damaged := exfatEntrySet("BROKEN.AVI", 0x20, local, 0, 0x03, 3, 5, 5)
+	damaged[40] ^= 0xff
+	clipDir = append(clipDir, damaged...)
+	dirFlags, dirCluster := b.store(clipDir, false)
+
+	var root []byte
+	label := make([]byte, 32)
+	label[0] = 0x83
+	root = append(root, label...)
+	deleted := exfatEntrySet("GONE.AVI", 0x20, local, 0, 0x03, 3, 5, 5)
+	deleted[0] = 0x05
+	root = append(root, deleted...)
+	root = append(root, exfatEntrySet("PRIVATE", 0x10, local, 0, dirFlags, dirCluster, int64(len(clipDir)), exfatTestClusterSize)...)
+	flags, cluster = b.store([]byte("still one"), false)
+	// UTC+2:00, as eight 15-minute intervals
+	root = append(root, exfatEntrySet("IMG_0001.avi", 0x20, utc.Add(2*time.Hour), 0x88, flags, cluster, 9, 9)...)
+	b.setFAT(2, 0xffffffff)
+	b.writeClusters([]uint32{2}, root)
+
+	boot := make([]byte, 512)
+	boot[0], boot[1], boot[2] = 0xeb, 0x76, 0x90
+	copy(boot[3:], "EXFAT   ")
+	binary.LittleEndian.PutUint32(boot[80:], exfatTestFATOffset)
+	binary.LittleEndian.PutUint32(boot[84:], 8)
+	binary.LittleEndian.PutUint32(boot[88:], exfatTestHeapOffset)
+	binary.LittleEndian.PutUint32(boot[92:], exfatTestClusterCount)
+	binary.LittleEndian.PutUint32(boot[96:], 2)
+	boot[108], boot[109], boot[110] = 9, 3, 1
+	boot[510], boot[511] = 0x55, 0xaa
+	if _, err := file.WriteAt(boot, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Truncate(exfatTestHeapOffset*512 + exfatTestClusterCount*exfatTestClusterSize); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := readDiskImageFS(t, file.Name())
+	for name, want := range map[string]string{
+		"PRIVATE/C0001.MP4":                 long,
+		"PRIVATE/Clip with a long name.avi": "short clip" + string(make([]byte, 100)),
+		"IMG_0001.avi":                      "still one",
+	} {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			t.Errorf("reading %s: %v", name, err)
+			continue
+		}
+		if string(data) != want {
+			t.Errorf("%s has unexpected content (%d bytes)", name, len(data))
+		}
+	}
+	info, err := fs.Stat(fsys, "IMG_0001.avi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(utc) {
+		t.Errorf("modification time = %v, want %v", info.ModTime(), utc)
+	}
+	info, err = fs.Stat(fsys, "PRIVATE/C0001.MP4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(local) {
+		t.Errorf("modification time = %v, want %v", info.ModTime(), local)
+	}
+	for _, name := range []string{"PRIVATE/BROKEN.AVI", "GONE.AVI"} {
+		if _, err := fs.Stat(fsys, name); err == nil {
+			t.Errorf("%s should be skipped", name)
+		}
+	}
+}
+
+func TestImportMediaFromDiskImage(t *testing.T) {
+	files := diskImageTestFiles()
+	b := newFATImageBuilder(t, 12, 63*512)
+	b.build(files)
+	b.writeMBR(0x01)
+	destDir := t.TempDir()
+
+	cfg := config{
+		SourceDir:          b.file.Name(),
+		DestDir:            destDir,
+		OrganizeByDate:     true,
+		ChecksumDuplicates: true,
+		SidecarDefault:     SidecarDelete,
+	}
+	if err := validateConfig(&cfg); err != nil {
+		t.Fatalf("validateConfig failed: %v", err)
+	}
+	if err := importMedia(cfg); err != nil {
+		t.Fatalf("importMedia failed: %v", err)
+	}
+	for rel, want := range map[string]string{
+		"2024/06/MVI_0001.AVI":          files[0].content,
+		"2024/06/mvi_0002.avi":          files[1].content,
+		"2024/07/Holiday clip 2024.avi": files[2].content,
+		"2024/07/EMPTY.AVI":             "",
+	} {
+		data, err := os.ReadFile(filepath.Join(destDir, rel))
+		if err != nil {
+			t.Errorf("expected %s: %v", rel, err)
+			continue
+		}
+		if string(data) != want {
+			t.Errorf("%s = %q, want %q", rel, data, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "2024/07/README.TXT")); err == nil {
+		t.Error("non-media file should not be imported")
+	}
+}
+
+func TestClusterReaderReadsAcrossClusters(t *testing.T) {
+	volume := strings.NewReader("..AAAABBBBCCCC")
+	r := &clusterReader{volume: volume, heapOffset: 2, clusterSize: 4, clusters: []uint32{4, 2}, validSize: 6}
+	data, err := io.ReadAll(io.NewSectionReader(r, 0, 8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "CCCCAA\x00\x00" {
+		t.Errorf("read %q", data)
+	}
+}
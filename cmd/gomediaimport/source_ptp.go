@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gphoto2Command runs gphoto2 with args and returns its standard output.
+// Closing the output waits for the command and reports its failure.
+type gphoto2Command func(args ...string) (io.ReadCloser, error)
+
+// ptpSource reads from a camera or phone connected over PTP or MTP, using
+// gphoto2 to talk to the device. Files are addressed as
+// <device folder>/<name>, for example /store_00010001/DCIM/100CANON/IMG_0001.JPG.
+type ptpSource struct {
+	scheme  string // ptp or mtp, as the source was given
+	port    string
+	gphoto2 gphoto2Command
+
+	// mu serializes gphoto2 runs: a device accepts one session at a time
+	mu      sync.Mutex
+	objects map[string]*ptpObject
+	// deleted holds the listing numbers removed from each folder, which
+	// shift the numbers of the files after them
+	deleted map[string][]int
+}
+
+// ptpObject is a file on the device as described by its object info
+type ptpObject struct {
+	Folder      string
+	Number      int // 1-based position in the folder listing
+	Name        string
+	Size        int64
+	CaptureTime time.Time
+}
+
+// openPTPSource opens the device at port, a gphoto2 port such as
+// usb:001,004, given with the ptp or mtp scheme it is named by. An empty port
+// selects the first detected device.
+func openPTPSource(scheme, port string) (*ptpSource, error) {
+	if _, err := exec.LookPath("gphoto2"); err != nil {
+		return nil, fmt.Errorf("gphoto2 is required to import from PTP/MTP devices: %w", err)
+	}
+	return newPTPSource(scheme, port, runGphoto2), nil
+}
+
+func newPTPSource(scheme, port string, command gphoto2Command) *ptpSource {
+	return &ptpSource{
+		scheme:  scheme,
+		port:    port,
+		gphoto2: command,
+		objects: make(map[string]*ptpObject),
+		deleted: make(map[string][]int),
+	}
+}
+
+func runGphoto2(args ...string) (io.ReadCloser, error) {
+	cmd := exec.Command("gphoto2", args...)
+	// Output is parsed, so it must not be translated
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &commandOutput{stdout: stdout, cmd: cmd, stderr: stderr}, nil
+}
+
+// commandOutput is the standard output of a running command
+type commandOutput struct {
+	stdout io.Reader
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+	eof    bool
+}
+
+func (o *commandOutput) Read(p []byte) (int, error) {
+	n, err := o.stdout.Read(p)
+	if err == io.EOF {
+		o.eof = true
+	}
+	return n, err
+}
+
+// Close waits for the command. A command whose output was not read to the
+// end is killed rather than left to finish a download nobody reads.
+func (o *commandOutput) Close() error {
+	if !o.eof {
+		_ = o.cmd.Process.Kill()
+		_ = o.cmd.Wait()
+		return nil
+	}
+	if err := o.cmd.Wait(); err != nil {
+		if msg := strings.TrimSpace(o.stderr.String()); msg != "" {
+			return fmt.Errorf("gphoto2 %s: %s", strings.Join(o.cmd.Args[1:], " "), msg)
+		}
+		return fmt.Errorf("gphoto2 %s: %w", strings.Join(o.cmd.Args[1:], " "), err)
+	}
+	return nil
+}
+
+// run starts gphoto2 on the source's port. Commands on the files of a folder
+// pass --no-recurse, since gphoto2 would otherwise apply them to the
+// folder's subfolders too.
+func (s *ptpSource) run(args ...string) (io.ReadCloser, error) {
+	if s.port != "" {
+		args = append([]string{"--port", s.port}, args...)
+	}
+	return s.gphoto2(args...)
+}
+
+// runOutput runs gphoto2 and returns everything it printed
+func (s *ptpSource) runOutput(args ...string) ([]byte, error) {
+	out, err := s.run(args...)
+	if err != nil {
+		return nil, err
+	}
+	data, readErr := io.ReadAll(out)
+	if err := out.Close(); err != nil {
+		return nil, err
+	}
+	return data, readErr
+}
+
+// enumerate lists every file on the device. Capture times come from the
+// object info, so files are only downloaded during enumeration when the
+// device does not report one.
+func (s *ptpSource) enumerate(cfg config) (enumerationResult, error) {
+	result, err := s.list(cfg)
+	if err != nil {
+		return enumerationResult{}, err
+	}
+
+	// Every read starts a download, so only files whose extension is
+	// missing or unknown are sniffed; media extensions are trusted. Files
+	// without a capture time are dated from their metadata. A file whose time
+	// is still unknown is left on the device rather than filed under a
+	// made-up date.
+	files := result.Files[:0]
+	for _, file := range result.Files {
+		if file.MediaCategory == "" && !sniffSourceFile(s, &file) {
+			continue
+		}
+		if file.CreationDateTime.IsZero() {
+			if metadata, err := extractMetadata(s, file); err == nil {
+				file.CreationDateTime = metadata.CreationDateTime
+				file.VideoMetadata = metadata.VideoMetadata
+				file.ImageMetadata = metadata.ImageMetadata
+			}
+		}
+		if file.CreationDateTime.IsZero() {
+			cfg.logger().Warn("capture time unknown", "source", sourcePath(file))
+			fmt.Fprintf(os.Stderr, "Warning: capture time of %s is unknown, leaving it on the device\n", sourcePath(file))
+			continue
+		}
+		files = append(files, file)
+	}
+	result.Files = files
+	return result, nil
+}
+
+// list reads the files and object info of every folder on the device
+func (s *ptpSource) list(cfg config) (enumerationResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	listing, err := s.runOutput("--list-files")
+	if err != nil {
+		return enumerationResult{}, fmt.Errorf("failed to list files on device: %w", err)
+	}
+	folders, err := parseGphoto2Folders(bytes.NewReader(listing))
+	if err != nil {
+		return enumerationResult{}, err
+	}
+
+	var result enumerationResult
+	for _, folder := range folders {
+		if folder.Count == 0 {
+			continue
+		}
+		info, err := s.runOutput("--folder", folder.Path, "--show-info", fmt.Sprintf("1-%d", folder.Count), "--no-recurse")
+		if err != nil {
+			return enumerationResult{}, fmt.Errorf("failed to read file info in %s: %w", folder.Path, err)
+		}
+		objects, err := parseGphoto2FileInfo(bytes.NewReader(info), folder.Path)
+		if err != nil {
+			return enumerationResult{}, err
+		}
+
+		for _, object := range objects {
+			s.objects[path.Join(object.Folder, object.Name)] = object
+			fileInfo := FileInfo{
+				SourceName:       object.Name,
+				SourceDir:        object.Folder,
+				Size:             object.Size,
+				CreationDateTime: object.CaptureTime,
+			}
+			// Files of unknown type that are not companions are kept for
+			// their content to be sniffed
+			if classifySourceFile(&fileInfo, cfg) || !isCompanionName(fileInfo.SourceName, cfg) {
+				result.Files = append(result.Files, fileInfo)
+			}
+		}
+	}
+	return result, nil
+}
+
+// ptpStorageRelativePath returns the path of object within its storage, such
+// as DCIM/100CANON/IMG_0001.JPG for /store_00010001/DCIM/100CANON/IMG_0001.JPG.
+func ptpStorageRelativePath(object *ptpObject) string {
+	rel := strings.TrimPrefix(path.Join(object.Folder, object.Name), "/")
+	if first, rest, ok := strings.Cut(rel, "/"); ok && strings.HasPrefix(first, "store_") {
+		rel = rest
+	}
+	return filepath.FromSlash(rel)
+}
+
+func (s *ptpSource) object(op, p string) (*ptpObject, error) {
+	object, ok := s.objects[path.Clean(filepath.ToSlash(p))]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: p, Err: fs.ErrNotExist}
+	}
+	return object, nil
+}
+
+// number returns the current listing number of object, accounting for
+// files deleted before it in the same folder.
+func (s *ptpSource) number(object *ptpObject) int {
+	number := object.Number
+	for _, deleted := range s.deleted[object.Folder] {
+		if deleted < object.Number {
+			number--
+		}
+	}
+	return number
+}
+
+func (s *ptpSource) Stat(p string) (fs.FileInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	object, err := s.object("stat", p)
+	if err != nil {
+		return nil, err
+	}
+	return remoteFileInfo{name: object.Name, size: object.Size, modTime: object.CaptureTime}, nil
+}
+
+// Open downloads the file as it is read. The device is held until the
+// returned reader is closed.
+func (s *ptpSource) Open(p string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	object, err := s.object("open", p)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	out, err := s.run("--folder", object.Folder, "--get-file", strconv.Itoa(s.number(object)), "--stdout", "--no-recurse")
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	return &ptpDownload{ReadCloser: out, unlock: s.mu.Unlock}, nil
+}
+
+// ptpDownload releases the device when closed
+type ptpDownload struct {
+	io.ReadCloser
+	unlock func()
+	once   sync.Once
+}
+
+func (d *ptpDownload) Close() error {
+	err := d.ReadCloser.Close()
+	d.once.Do(d.unlock)
+	return err
+}
+
+func (s *ptpSource) Remove(p string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	object, err := s.object("remove", p)
+	if err != nil {
+		return err
+	}
+	if _, err := s.runOutput("--folder", object.Folder, "--delete-file", strconv.Itoa(s.number(object)), "--no-recurse"); err != nil {
+		return err
+	}
+	s.deleted[object.Folder] = append(s.deleted[object.Folder], object.Number)
+	delete(s.objects, path.Join(object.Folder, object.Name))
+	return nil
+}
+
+func (s *ptpSource) Volume() string {
+	return s.scheme + ":" + s.port
+}
+
+func (s *ptpSource) Close() error {
+	return nil
+}
+
+// ptpFolder is a device folder and the number of files directly in it
+type ptpFolder struct {
+	Path  string
+	Count int
+}
+
+var gphoto2FolderLine = regexp.MustCompile(`^There (?:is|are) (no|\d+) files? in folder '(.*)'[.:]$`)
+
+// parseGphoto2Folders reads the folder headers of `gphoto2 --list-files`.
+func parseGphoto2Folders(r io.Reader) ([]ptpFolder, error) {
+	var folders []ptpFolder
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		match := gphoto2FolderLine.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if match == nil {
+			continue
+		}
+		count := 0
+		if match[1] != "no" {
+			count, _ = strconv.Atoi(match[1])
+		}
+		folders = append(folders, ptpFolder{Path: match[2], Count: count})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(folders, func(i, j int) bool { return folders[i].Path < folders[j].Path })
+	return folders, nil
+}
+
+var (
+	gphoto2InfoHeader = regexp.MustCompile(`^Information on file '(.*)' \(folder '(.*)'\):$`)
+	gphoto2SizeValue  = regexp.MustCompile(`^(\d+) byte`)
+)
+
+// gphoto2TimeLayout is the asctime format gphoto2 prints object times in
+const gphoto2TimeLayout = "Mon Jan _2 15:04:05 2006"
+
+// parseGphoto2FileInfo reads `gphoto2 --show-info` output for the files of
+// one folder, numbering them in the order they are listed.
+func parseGphoto2FileInfo(r io.Reader, folder string) ([]*ptpObject, error) {
+	var objects []*ptpObject
+	var current *ptpObject
+	section := ""
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \r")
+		if match := gphoto2InfoHeader.FindStringSubmatch(line); match != nil {
+			current = &ptpObject{Folder: folder, Number: len(objects) + 1, Name: match[1]}
+			objects = append(objects, current)
+			section = ""
+			continue
+		}
+		if current == nil || line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, " ") {
+			section = strings.TrimSuffix(line, ":")
+			continue
+		}
+		// Thumbnail and audio sections have their own sizes
+		if section != "File" {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "Size":
+			if match := gphoto2SizeValue.FindStringSubmatch(value); match != nil {
+				current.Size, _ = strconv.ParseInt(match[1], 10, 64)
+			}
+		case "Time":
+			if t, err := time.ParseInLocation(gphoto2TimeLayout, value, time.Local); err == nil {
+				current.CaptureTime = t
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeCameraFile struct {
+	name    string
+	content string
+	time    time.Time
+}
+
+// fakeCamera answers gphoto2 commands the way gphoto2 does for a connected
+// device, including renumbering a folder's files after a delete.
+type fakeCamera struct {
+	port      string
+	folders   map[string][]fakeCameraFile
+	downloads []string // Names of the files read
+}
+
+func (c *fakeCamera) run(args ...string) (io.ReadCloser, error) {
+	if len(args) < 2 || args[0] != "--port" || args[1] != c.port {
+		return nil, fmt.Errorf("expected --port %s, got %q", c.port, args)
+	}
+	args = args[2:]
+	if len(args) > 0 && args[0] == "--folder" {
+		if args[len(args)-1] != "--no-recurse" {
+			return nil, fmt.Errorf("folder command %q would recurse into subfolders", args)
+		}
+		args = args[:len(args)-1]
+	}
+
+	var out bytes.Buffer
+	switch {
+	case len(args) == 1 && args[0] == "--list-files":
+		folders := []string{"/", "/store_00010001", "/store_00010001/DCIM"}
+		for folder := range c.folders {
+			folders = append(folders, folder)
+		}
+		sort.Strings(folders)
+		for _, folder := range folders {
+			files := c.folders[folder]
+			switch len(files) {
+			case 0:
+				fmt.Fprintf(&out, "There is no file in folder '%s'.\n", folder)
+				continue
+			case 1:
+				fmt.Fprintf(&out, "There is 1 file in folder '%s':\n", folder)
+			default:
+				fmt.Fprintf(&out, "There are %d files in folder '%s':\n", len(files), folder)
+			}
+			for i, file := range files {
+				fmt.Fprintf(&out, "#%-5d %-27s rd %5d KB image/jpeg %d\n", i+1, file.name, (len(file.content)+1023)/1024, file.time.Unix())
+			}
+		}
+	case len(args) == 4 && args[0] == "--folder" && args[2] == "--show-info":
+		files := c.folders[args[1]]
+		if args[3] != fmt.Sprintf("1-%d", len(files)) {
+			return nil, fmt.Errorf("unexpected range %s", args[3])
+		}
+		for _, file := range files {
+			fmt.Fprintf(&out, "Information on file '%s' (folder '%s'):\n", file.name, args[1])
+			fmt.Fprintf(&out, "File:\n  Name:        '%s'\n  Mime type:   'image/jpeg'\n", file.name)
+			fmt.Fprintf(&out, "  Size:        %d byte(s)\n  Downloaded:  no\n  Permissions: read/delete\n", len(file.content))
+			if !file.time.IsZero() {
+				fmt.Fprintf(&out, "  Time:        %s\n", file.time.Format(gphoto2TimeLayout))
+			}
+			fmt.Fprintf(&out, "Thumbnail:\n  Mime type:   'image/jpeg'\n  Size:        9999 byte(s)\n")
+		}
+	case len(args) == 5 && args[0] == "--folder" && args[2] == "--get-file" && args[4] == "--stdout":
+		file, err := c.file(args[1], args[3])
+		if err != nil {
+			return nil, err
+		}
+		c.downloads = append(c.downloads, file.name)
+		out.WriteString(file.content)
+	case len(args) == 4 && args[0] == "--folder" && args[2] == "--delete-file":
+		if _, err := c.file(args[1], args[3]); err != nil {
+			return nil, err
+		}
+		n, _ := strconv.Atoi(args[3])
+		files := c.folders[args[1]]
+		c.folders[args[1]] = append(files[:n-1:n-1], files[n:]...)
+	default:
+		return nil, fmt.Errorf("unexpected gphoto2 arguments %q", args)
+	}
+	return io.NopCloser(&out), nil
+}
+
+func (c *fakeCamera) file(folder, number string) (fakeCameraFile, error) {
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 1 || n > len(c.folders[folder]) {
+		return fakeCameraFile{}, fmt.Errorf("*** Error: bad file number %s in %s", number, folder)
+	}
+	return c.folders[folder][n-1], nil
+}
+
+func (c *fakeCamera) names(folder string) []string {
+	var names []string
+	for _, file := range c.folders[folder] {
+		names = append(names, file.name)
+	}
+	return names
+}
+
+func TestParseGphoto2FileInfo(t *testing.T) {
+	output := `Information on file 'IMG_0001.JPG' (folder '/store_00010001/DCIM/100CANON'):
+File:
+  Name:        'IMG_0001.JPG'
+  Mime type:   'image/jpeg'
+  Size:        5129782 byte(s)
+  Width:       6000 pixel(s)
+  Height:      4000 pixel(s)
+  Downloaded:  no
+  Permissions: read/delete
+  Time:        Sat Jun  1 10:30:00 2024
+Thumbnail:
+  Mime type:  'image/jpeg'
+  Size:       11447 byte(s)
+Information on file 'MVI_0002.MP4' (folder '/store_00010001/DCIM/100CANON'):
+File:
+  Name:        'MVI_0002.MP4'
+  Size:        104857600 byte(s)
+`
+	objects, err := parseGphoto2FileInfo(strings.NewReader(output), "/store_00010001/DCIM/100CANON")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 {
+		t.Fatalf("expected 2 objects, got %d", len(objects))
+	}
+	first := objects[0]
+	want := time.Date(2024, 6, 1, 10, 30, 0, 0, time.Local)
+	if first.Name != "IMG_0001.JPG" || first.Number != 1 || first.Size != 5129782 || !first.CaptureTime.Equal(want) {
+		t.Errorf("unexpected first object: %+v", first)
+	}
+	second := objects[1]
+	if second.Name != "MVI_0002.MP4" || second.Number != 2 || second.Size != 104857600 || !second.CaptureTime.IsZero() {
+		t.Errorf("unexpected second object: %+v", second)
+	}
+}
+
+func TestParseGphoto2Folders(t *testing.T) {
+	output := `There is no file in folder '/'.
+There is no file in folder '/store_00010001'.
+There are 2 files in folder '/store_00010001/DCIM/100CANON':
+#1     IMG_0001.JPG               rd  5010 KB image/jpeg 1717237800
+#2     MVI_0002.MP4               rd 102400 KB video/mp4 1717237900
+There is 1 file in folder '/store_00010001/DCIM/101CANON':
+#1     IMG_0101.JPG               rd  4800 KB image/jpeg 1717238000
+`
+	folders, err := parseGphoto2Folders(strings.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ptpFolder{
+		{Path: "/", Count: 0},
+		{Path: "/store_00010001", Count: 0},
+		{Path: "/store_00010001/DCIM/100CANON", Count: 2},
+		{Path: "/store_00010001/DCIM/101CANON", Count: 1},
+	}
+	if fmt.Sprint(folders) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", folders, want)
+	}
+}
+
+func TestImportMediaFromPTPDevice(t *testing.T) {
+	june := time.Date(2024, 6, 15, 10, 30, 0, 0, time.Local)
+	july := time.Date(2024, 7, 2, 8, 0, 0, 0, time.Local)
+	camera := &fakeCamera{
+		port: "usb:001,004",
+		folders: map[string][]fakeCameraFile{
+			"/store_00010001/DCIM/100CANON": {
+				{name: "IMG_0001.CR2", content: "raw one", time: june},
+				{name: "IMG_0001.JPG", content: "jpeg one", time: june},
+				{name: "NOTES.TXT", content: "not media", time: june},
+				{name: "IMG_0002.JPG", content: "jpeg two", time: june},
+			},
+			"/store_00010001/DCIM/101CANON": {
+				{name: "MVI_0003.MP4", content: "video three", time: july},
+			},
+		},
+	}
+	destDir := t.TempDir()
+	cfg := config{
+		SourceDir:          "ptp:" + camera.port,
+		Source:             newPTPSource("ptp", camera.port, camera.run),
+		DestDir:            destDir,
+		OrganizeByDate:     true,
+		ChecksumDuplicates: true,
+		DeleteOriginals:    true,
+		SidecarDefault:     SidecarDelete,
+	}
+	if err := importMedia(cfg); err != nil {
+		t.Fatalf("importMedia failed: %v", err)
+	}
+
+	for rel, want := range map[string]string{
+		"2024/06/IMG_0001.CR2": "raw one",
+		"2024/06/IMG_0001.JPG": "jpeg one",
+		"2024/06/IMG_0002.JPG": "jpeg two",
+		"2024/07/MVI_0003.MP4": "video three",
+	} {
+		data, err := os.ReadFile(filepath.Join(destDir, rel))
+		if err != nil {
+			t.Errorf("expected %s: %v", rel, err)
+			continue
+		}
+		if string(data) != want {
+			t.Errorf("%s = %q, want %q", rel, data, want)
+		}
+	}
+	info, err := os.Stat(filepath.Join(destDir, "2024/07/MVI_0003.MP4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(july) {
+		t.Errorf("modification time = %v, want capture time %v", info.ModTime(), july)
+	}
+
+	// Deleting shifts the numbers of later files; only the non-media file
+	// may be left.
+	if names := camera.names("/store_00010001/DCIM/100CANON"); fmt.Sprint(names) != "[NOTES.TXT]" {
+		t.Errorf("files left in 100CANON: %v", names)
+	}
+	if names := camera.names("/store_00010001/DCIM/101CANON"); len(names) != 0 {
+		t.Errorf("files left in 101CANON: %v", names)
+	}
+}
+
+func TestPTPSourceUnknownCaptureTime(t *testing.T) {
+	exifPath := filepath.Join(t.TempDir(), "exif.jpg")
+	writeTestJPEGWithEXIF(t, exifPath, testEXIFASCII(0x9003, "2024:06:15 10:30:00"))
+	exifJPEG, err := os.ReadFile(exifPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	camera := &fakeCamera{
+		port: "usb:001,004",
+		folders: map[string][]fakeCameraFile{
+			"/store_00010001/DCIM/100CANON": {
+				{name: "IMG_0001.JPG", content: string(exifJPEG)},
+				{name: "IMG_0002.JPG", content: "no metadata"},
+			},
+		},
+	}
+	src := newPTPSource("mtp", camera.port, camera.run)
+	if got := src.Volume(); got != "mtp:usb:001,004" {
+		t.Errorf("Volume() = %q, want the mtp scheme", got)
+	}
+
+	result, err := src.enumerate(config{SidecarDefault: SidecarDelete})
+	if err != nil {
+		t.Fatalf("enumerate failed: %v", err)
+	}
+	if len(result.Files) != 1 || result.Files[0].SourceName != "IMG_0001.JPG" {
+		t.Fatalf("enumerated %v, want only the file dated by its metadata", result.Files)
+	}
+	if want := time.Date(2024, 6, 15, 10, 30, 0, 0, time.Local); !result.Files[0].CreationDateTime.Equal(want) {
+		t.Errorf("capture time = %v, want %v from EXIF", result.Files[0].CreationDateTime, want)
+	}
+}
+
+func TestPTPSourceSniffsContent(t *testing.T) {
+	june := time.Date(2024, 6, 15, 10, 30, 0, 0, time.Local)
+	camera := &fakeCamera{
+		port: "usb:001,004",
+		folders: map[string][]fakeCameraFile{
+			"/store_00010001/DCIM/100CANON": {
+				{name: "IMG_0001.JPG", content: "\x89PNG\r\n\x1a\n", time: june},
+				{name: "IMG_0002", content: "\xFF\xD8\xFF\xE0", time: june},
+				{name: "NOTES.TXT", content: "not media", time: june},
+			},
+		},
+	}
+	result, err := newPTPSource("ptp", camera.port, camera.run).enumerate(config{SidecarDefault: SidecarDelete})
+	if err != nil {
+		t.Fatalf("enumerate failed: %v", err)
+	}
+	// Media extensions are trusted without a download
+	got := make(map[string]string)
+	for _, file := range result.Files {
+		got[file.SourceName] = string(file.FileType) + " " + file.ContentExt
+	}
+	want := map[string]string{"IMG_0001.JPG": string(JPEG) + " ", "IMG_0002": string(JPEG) + " .jpg"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("enumerated %v, want %v", got, want)
+	}
+	if fmt.Sprint(camera.downloads) != "[IMG_0002 NOTES.TXT]" {
+		t.Errorf("downloaded %v, want only the files of unknown type", camera.downloads)
+	}
+}
+
+func TestPlanAndApplyFromPTPDevice(t *testing.T) {
+	june := time.Date(2024, 6, 15, 10, 30, 0, 0, time.Local)
+	camera := &fakeCamera{
+		port: "usb:001,004",
+		folders: map[string][]fakeCameraFile{
+			"/store_00010001/DCIM/100CANON": {
+				{name: "IMG_0001.JPG", content: "jpeg one", time: june},
+				{name: "IMG_0002.JPG", content: "jpeg number two", time: june},
+			},
+		},
+	}
+	destDir := t.TempDir()
+	planPath := filepath.Join(t.TempDir(), "plan.json")
+	cfg := config{
+		SourceDir:      "ptp:" + camera.port,
+		Source:         newPTPSource("ptp", camera.port, camera.run),
+		DestDir:        destDir,
+		OrganizeByDate: true,
+		SidecarDefault: SidecarDelete,
+		Quiet:          true,
+	}
+	if err := runPlanCommand(&planCmd{Out: planPath}, cfg); err != nil {
+		t.Fatalf("runPlanCommand failed: %v", err)
+	}
+
+	// apply opens the device afresh, which knows no files until listed
+	applyCfg := config{Source: newPTPSource("ptp", camera.port, camera.run), SidecarDefault: SidecarDelete, Quiet: true}
+	if err := runApplyCommand(&applyCmd{Plan: planPath}, applyCfg); err != nil {
+		t.Fatalf("runApplyCommand failed: %v", err)
+	}
+	for rel, want := range map[string]string{"2024/06/IMG_0001.JPG": "jpeg one", "2024/06/IMG_0002.JPG": "jpeg number two"} {
+		if data, err := os.ReadFile(filepath.Join(destDir, rel)); err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v, want %q", rel, data, err, want)
+		}
+	}
+}
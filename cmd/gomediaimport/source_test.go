@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// memFS is an in-memory file system whose files can be removed
+type memFS struct {
+	fstest.MapFS
+}
+
+func (m memFS) Remove(name string) error {
+	if _, ok := m.MapFS[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(m.MapFS, name)
+	return nil
+}
+
+func TestImportMediaFromMemorySource(t *testing.T) {
+	video, err := os.ReadFile(testFixturePath("minimal.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	march := time.Date(2023, 3, 4, 12, 0, 0, 0, time.Local)
+	card := memFS{fstest.MapFS{
+		"DCIM/100MEDIA/VID_0001.MP4": {Data: video, ModTime: march},
+		"DCIM/100MEDIA/VID_0002.AVI": {Data: []byte("avi two"), ModTime: march},
+		"DCIM/100MEDIA/NOTES.TXT":    {Data: []byte("not media"), ModTime: march},
+	}}
+	destDir := t.TempDir()
+	cfg := config{
+		SourceDir:          "/card",
+		Source:             newFSSource("/card", "CARD", card),
+		DestDir:            destDir,
+		OrganizeByDate:     true,
+		ChecksumDuplicates: true,
+		DeleteOriginals:    true,
+		SidecarDefault:     SidecarDelete,
+	}
+	if err := importMedia(cfg); err != nil {
+		t.Fatalf("importMedia failed: %v", err)
+	}
+
+	for rel, want := range map[string][]byte{
+		"2024/06/VID_0001.MP4": video,
+		"2023/03/VID_0002.AVI": []byte("avi two"),
+	} {
+		data, err := os.ReadFile(filepath.Join(destDir, rel))
+		if err != nil {
+			t.Errorf("expected %s: %v", rel, err)
+			continue
+		}
+		if string(data) != string(want) {
+			t.Errorf("%s has unexpected content", rel)
+		}
+	}
+
+	var left []string
+	for name := range card.MapFS {
+		left = append(left, name)
+	}
+	sort.Strings(left)
+	if len(left) != 1 || left[0] != "DCIM/100MEDIA/NOTES.TXT" {
+		t.Errorf("files left on the source: %v", left)
+	}
+}
+
+func TestEnumerateFilesFromMemorySource(t *testing.T) {
+	june := time.Date(2024, 6, 15, 10, 30, 0, 0, time.Local)
+	src := newFSSource("/volumes/CARD", "CARD", fstest.MapFS{
+		"DCIM/100MEDIA/VID_0001.AVI":         {Data: []byte("one"), ModTime: june},
+		"DCIM/100MEDIA/._VID_0001.AVI":       {Data: []byte("apple double")},
+		".Trashes/501/VID_0000.AVI":          {Data: []byte("trashed")},
+		"PRIVATE/M4ROOT/THMBNL/C0001T01.JPG": {Data: []byte("thumbnail")},
+	})
+	result, err := enumerateFiles("/volumes/CARD", config{Source: src})
+	if err != nil {
+		t.Fatalf("enumerateFiles failed: %v", err)
+	}
+	if len(result.Files) != 1 {
+		t.Fatalf("expected 1 file, got %d", len(result.Files))
+	}
+	file := result.Files[0]
+	if got := sourcePath(file); got != filepath.Join("/volumes/CARD", "DCIM", "100MEDIA", "VID_0001.AVI") {
+		t.Errorf("source path = %s", got)
+	}
+	if !file.CreationDateTime.Equal(june) || file.Size != 3 {
+		t.Errorf("unexpected file: %+v", file)
+	}
+	if len(result.CleanupTargets) != 3 {
+		t.Errorf("expected 3 cleanup targets, got %v", result.CleanupTargets)
+	}
+}
+
+func TestFSSourceWalksSubdirectory(t *testing.T) {
+	src := newFSSource("/card", "CARD", fstest.MapFS{
+		"DCIM/100MEDIA/VID_0001.AVI": {Data: []byte("one")},
+		"MISC/VID_0002.AVI":          {Data: []byte("two")},
+	})
+	result, err := enumerateFiles(filepath.Join("/card", "DCIM"), config{Source: src})
+	if err != nil {
+		t.Fatalf("enumerateFiles failed: %v", err)
+	}
+	if len(result.Files) != 1 || result.Files[0].SourceName != "VID_0001.AVI" {
+		t.Errorf("unexpected files: %+v", result.Files)
+	}
+	if src.Volume() != "CARD" {
+		t.Errorf("volume = %q, want CARD", src.Volume())
+	}
+	if err := src.Remove(filepath.Join("/card", "MISC", "VID_0002.AVI")); !errors.Is(err, errReadOnlySource) {
+		t.Errorf("expected read-only error, got %v", err)
+	}
+}
+
+func TestLocalSourceVolume(t *testing.T) {
+	if got := (localSource{root: "/media/user/CARD"}).Volume(); got != "/media/user/CARD" {
+		t.Errorf("volume = %q", got)
+	}
+	if got := (localSource{root: "/media/user/CARD", volume: "CARD"}).Volume(); got != "CARD" {
+		t.Errorf("volume = %q", got)
+	}
+}
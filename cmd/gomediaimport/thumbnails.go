@@ -0,0 +1,496 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // Register the decoders of formats copied as pictures
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+const (
+	// thumbnailMaxSize is the longest edge of a thumbnail in pixels
+	thumbnailMaxSize = 320
+	// thumbnailQuality is the JPEG quality thumbnails are written with
+	thumbnailQuality = 80
+	// maxPreviewScan is how much of a file without a known preview layout
+	// is searched for embedded JPEG previews. CR3 files keep theirs near
+	// the start.
+	maxPreviewScan = 16 << 20
+)
+
+// TIFF tags that locate embedded JPEG previews
+const (
+	tiffTagStripOffsets    = 0x0111
+	tiffTagStripByteCounts = 0x0117
+	tiffTagCompression     = 0x0103
+	tiffTagSubIFDs         = 0x014A
+	tiffTagJPEGOffset      = 0x0201
+	tiffTagJPEGLength      = 0x0202
+	tiffTagRW2JPEGFromRaw  = 0x002E
+)
+
+var errNoPreview = errors.New("no embedded preview")
+
+// defaultThumbnailDirectory returns the cache directory thumbnails are
+// written to when thumbnail_directory is not set
+func defaultThumbnailDirectory() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user cache directory: %w", err)
+	}
+	return filepath.Join(cacheDir, "gomediaimport", "thumbnails"), nil
+}
+
+// thumbnailCandidate returns true if a thumbnail is made for the file
+func thumbnailCandidate(file FileInfo) bool {
+	return file.MediaCategory == ProcessedPicture || file.MediaCategory == RawPicture
+}
+
+// thumbnailKey names a file's thumbnail in the cache by content when its
+// checksum is known, otherwise by where and when it was read
+func thumbnailKey(file FileInfo) string {
+	if file.SourceChecksum != "" {
+		return file.SourceChecksum
+	}
+	identity := fmt.Sprintf("%s\x00%s\x00%d\x00%s", file.SourceVolume, sourcePath(file), file.Size, file.CreationDateTime.UTC().Format("20060102T150405.000000000"))
+	return fmt.Sprintf("%016x", xxhash.Sum64String(identity))
+}
+
+// previewJPEGCandidates returns the sections of r that may hold an embedded
+// JPEG preview: the file itself and its embedded previews for JPEG, the
+// JPEG items and EXIF thumbnail of HEIF, the previews referenced from the
+// IFDs of TIFF-based RAW files, the preview a RAF header points to, and
+// otherwise every JPEG start marker in the first maxPreviewScan bytes.
+func previewJPEGCandidates(r io.ReaderAt, size int64) ([]*io.SectionReader, error) {
+	header := make([]byte, 16)
+	n, err := r.ReadAt(header, 0)
+	if n < 4 {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	header = header[:n]
+	switch {
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8, 0xFF}):
+		// The picture itself, then its EXIF thumbnail and MPF previews
+		return scanJPEGStarts(r, size, size)
+	case len(header) >= 12 && string(header[4:8]) == "ftyp" && isHEIFBrand(string(header[8:12])):
+		// HEVC images are not decoded, and scanning for JPEG markers would
+		// find them in HEVC data by chance
+		if sections, ok := heifPreviewSections(r, size); ok {
+			return sections, nil
+		}
+	case bytes.HasPrefix(header, []byte("II")) || bytes.HasPrefix(header, []byte("MM")):
+		if sections := tiffPreviewSections(r, size); len(sections) > 0 {
+			return sections, nil
+		}
+	case bytes.HasPrefix(header, []byte("FUJIFILMCCD-RAW")):
+		// The RAF header holds the offset and length of the JPEG preview
+		if location, err := readAtFull(r, 84, 8); err == nil {
+			offset, length := int64(binary.BigEndian.Uint32(location)), int64(binary.BigEndian.Uint32(location[4:]))
+			if length > 0 && offset+length <= size {
+				return []*io.SectionReader{io.NewSectionReader(r, offset, length)}, nil
+			}
+		}
+	}
+	return scanJPEGStarts(r, min(size, maxPreviewScan), size)
+}
+
+// tiffPreviewSections walks the IFD chain and SubIFDs of a TIFF-based RAW
+// file, including the ORF and RW2 header variants, and returns the JPEG
+// previews they reference
+func tiffPreviewSections(r io.ReaderAt, size int64) []*io.SectionReader {
+	header, err := readAtFull(r, 0, 8)
+	if err != nil {
+		return nil
+	}
+	var order tiffByteOrder
+	switch string(header[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil
+	}
+	var sections []*io.SectionReader
+	add := func(offset, length uint32) {
+		if length == 0 || int64(offset)+int64(length) > size {
+			return
+		}
+		sections = append(sections, io.NewSectionReader(r, int64(offset), int64(length)))
+	}
+	seen := make(map[uint32]bool)
+	queue := []uint32{order.Uint32(header[4:])}
+	for len(queue) > 0 && len(seen) < 64 {
+		offset := queue[0]
+		queue = queue[1:]
+		if offset == 0 || seen[offset] || int64(offset) >= size {
+			continue
+		}
+		seen[offset] = true
+		entries, next, err := readTIFFIFD(r, 0, order, offset)
+		if err != nil {
+			continue
+		}
+		queue = append(queue, next)
+		value := func(tag uint16) (uint32, bool) {
+			e, ok := findTIFFEntry(entries, tag)
+			if !ok || e.Count != 1 {
+				return 0, false
+			}
+			if e.Type == 3 { // SHORT
+				return uint32(order.Uint16(e.Value[:])), true
+			}
+			return order.Uint32(e.Value[:]), true
+		}
+		if offset, ok := value(tiffTagJPEGOffset); ok {
+			if length, ok := value(tiffTagJPEGLength); ok {
+				add(offset, length)
+			}
+		}
+		if compression, ok := value(tiffTagCompression); ok && (compression == 6 || compression == 7) {
+			if offset, ok := value(tiffTagStripOffsets); ok {
+				if length, ok := value(tiffTagStripByteCounts); ok {
+					add(offset, length)
+				}
+			}
+		}
+		if e, ok := findTIFFEntry(entries, tiffTagRW2JPEGFromRaw); ok && e.Count > 4 {
+			add(order.Uint32(e.Value[:]), e.Count)
+		}
+		if e, ok := findTIFFEntry(entries, tiffTagSubIFDs); ok && e.Count > 0 && e.Count <= 16 {
+			if e.Count == 1 {
+				queue = append(queue, order.Uint32(e.Value[:]))
+			} else if data, err := readAtFull(r, int64(order.Uint32(e.Value[:])), 4*int(e.Count)); err == nil {
+				for i := range int(e.Count) {
+					queue = append(queue, order.Uint32(data[4*i:]))
+				}
+			}
+		}
+	}
+	return sections
+}
+
+// scanJPEGStarts returns a section for every JPEG start of image marker
+// followed by another marker in the first limit bytes of r
+func scanJPEGStarts(r io.ReaderAt, limit, size int64) ([]*io.SectionReader, error) {
+	const chunk = 1 << 20
+	var sections []*io.SectionReader
+	buf := make([]byte, chunk+3)
+	for base := int64(0); base < limit; base += chunk {
+		n, err := r.ReadAt(buf[:min(int64(len(buf)), size-base)], base)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		data := buf[:n]
+		for i := 0; i+3 < len(data) && int64(i) < chunk; i++ {
+			if data[i] == 0xFF && data[i+1] == 0xD8 && data[i+2] == 0xFF && data[i+3] >= 0xC0 && data[i+3] != 0xFF {
+				sections = append(sections, io.NewSectionReader(r, base+int64(i), size-base-int64(i)))
+			}
+		}
+	}
+	return sections, nil
+}
+
+// decodePreview decodes the smallest baseline or progressive JPEG among the
+// candidates that still fills a thumbnail, so full-size pictures are only
+// decoded when they have no smaller preview. Without one it falls back to
+// the largest. The lossless JPEG that some RAW formats store their sensor
+// data in cannot be decoded and is skipped.
+func decodePreview(candidates []*io.SectionReader) (image.Image, error) {
+	type preview struct {
+		section *io.SectionReader
+		edge    int // Longest edge in pixels
+		pixels  int
+	}
+	var previews []preview
+	for _, section := range candidates {
+		cfg, err := jpeg.DecodeConfig(io.NewSectionReader(section, 0, section.Size()))
+		if err != nil || cfg.Width == 0 || cfg.Height == 0 {
+			continue
+		}
+		previews = append(previews, preview{section, max(cfg.Width, cfg.Height), cfg.Width * cfg.Height})
+	}
+	sort.SliceStable(previews, func(i, j int) bool {
+		bigI, bigJ := previews[i].edge >= thumbnailMaxSize, previews[j].edge >= thumbnailMaxSize
+		switch {
+		case bigI != bigJ:
+			return bigI
+		case bigI:
+			return previews[i].pixels < previews[j].pixels
+		default:
+			return previews[i].pixels > previews[j].pixels
+		}
+	})
+	for _, p := range previews {
+		if img, err := jpeg.Decode(io.NewSectionReader(p.section, 0, p.section.Size())); err == nil {
+			return img, nil
+		}
+	}
+	return nil, errNoPreview
+}
+
+// decodeThumbnailSource returns the image a thumbnail is made from: the
+// picture itself for PNG and GIF, otherwise the JPEG preview decodePreview
+// picks
+func decodeThumbnailSource(r io.ReaderAt, size int64, file FileInfo) (image.Image, error) {
+	switch file.FileType {
+	case PNG, GIF:
+		img, _, err := image.Decode(io.NewSectionReader(r, 0, size))
+		return img, err
+	}
+	candidates, err := previewJPEGCandidates(r, size)
+	if err != nil {
+		return nil, err
+	}
+	return decodePreview(candidates)
+}
+
+// scaleImage shrinks img to fit within maxSize pixels, averaging the source
+// pixels that make up each thumbnail pixel
+func scaleImage(img image.Image, maxSize int) *image.RGBA {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	tw, th := w, h
+	if w > maxSize || h > maxSize {
+		if w >= h {
+			tw, th = maxSize, max(1, h*maxSize/w)
+		} else {
+			tw, th = max(1, w*maxSize/h), maxSize
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := range th {
+		y0, y1 := bounds.Min.Y+y*h/th, bounds.Min.Y+max((y+1)*h/th, y*h/th+1)
+		for x := range tw {
+			x0, x1 := bounds.Min.X+x*w/tw, bounds.Min.X+max((x+1)*w/tw, x*w/tw+1)
+			var r, g, b, a, n uint64
+			// Sample at most 4x4 source pixels per thumbnail pixel, which
+			// is enough to avoid aliasing at thumbnail size
+			stepY, stepX := max(1, (y1-y0)/4), max(1, (x1-x0)/4)
+			for sy := y0; sy < y1; sy += stepY {
+				for sx := x0; sx < x1; sx += stepX {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, b, a, n = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n >> 8), uint8(g / n >> 8), uint8(b / n >> 8), uint8(a / n >> 8)})
+		}
+	}
+	return dst
+}
+
+// orientImage applies an EXIF orientation so the thumbnail is upright
+func orientImage(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := range h {
+		for x := range w {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored horizontally
+				dx, dy = w-1-x, y
+			case 3: // rotated 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // mirrored and rotated 90° counterclockwise
+				dx, dy = y, x
+			case 6: // rotated 90° clockwise
+				dx, dy = h-1-y, x
+			case 7: // mirrored and rotated 90° clockwise
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90° counterclockwise
+				dx, dy = y, w-1-x
+			}
+			dst.SetRGBA(dx, dy, img.RGBAAt(x, y))
+		}
+	}
+	return dst
+}
+
+// thumbnail is the cached thumbnail of a file
+type thumbnail struct {
+	Name   string       // File name in the thumbnail directory
+	Poster posterSource // Where a video's poster came from, "" for pictures
+}
+
+// thumbnailInput returns where a file's thumbnail is read from: its copy
+// when the import wrote it or found it already in a local destination, so a
+// camera connected over PTP is not read twice, otherwise the source. The
+// returned file names the copy, with its size.
+func thumbnailInput(src mediaSource, file FileInfo, cfg config) (mediaSource, FileInfo) {
+	if cfg.DryRun || (file.Status != StatusCopied && file.Status != StatusPreExisting) {
+		return src, file
+	}
+	if _, ok := destinationFor(cfg).(localDestination); !ok {
+		return src, file
+	}
+	path := file.LibraryPath
+	if path == "" {
+		if file.DestName == "" {
+			return src, file
+		}
+		path = filepath.Join(file.DestDir, file.DestName)
+	}
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return src, file
+	}
+	file.SourceDir, file.SourceName, file.Size = filepath.Dir(path), filepath.Base(path), info.Size()
+	return localSource{root: file.SourceDir}, file
+}
+
+// writeThumbnail makes the thumbnail of one file at path, unless the cache
+// already has it
+func writeThumbnail(src mediaSource, file FileInfo, cfg config, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	src, file = thumbnailInput(src, file, cfg)
+	r, err := openSeekable(src, sourcePath(file))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = r.Close() }()
+	img, err := decodeThumbnailSource(asReaderAt(r), file.Size, file)
+	if err != nil {
+		return err
+	}
+	orientation := 0
+	if file.ImageMetadata != nil {
+		orientation = file.ImageMetadata.Orientation
+	}
+	return saveThumbnail(img, orientation, path)
+}
+
+// writeVideoPoster makes the poster thumbnail of a video in dir, unless the
+// cache already has one. Posters are cached under the suffix of their
+// source so the source is known on later imports.
+func writeVideoPoster(src mediaSource, file FileInfo, cfg config, dir string) (thumbnail, error) {
+	key := thumbnailKey(file)
+	for _, p := range posterSources {
+		if p.Source == posterSonyThumbnail && !cfg.SonyPosters {
+			continue
+		}
+		name := key + "_" + p.Suffix + ".jpg"
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return thumbnail{Name: name, Poster: p.Source}, nil
+		}
+	}
+	img, source, err := readVideoPoster(src, file, cfg)
+	if err != nil {
+		return thumbnail{}, err
+	}
+	for _, p := range posterSources {
+		if p.Source == source {
+			name := key + "_" + p.Suffix + ".jpg"
+			return thumbnail{Name: name, Poster: source}, saveThumbnail(img, 0, filepath.Join(dir, name))
+		}
+	}
+	return thumbnail{}, fmt.Errorf("unknown poster source %q", source)
+}
+
+// readVideoPoster decodes the poster of a video: its Sony thumbnail with
+// sony_thumbnail_posters, which stays on the source, otherwise the poster
+// the video itself yields
+func readVideoPoster(src mediaSource, file FileInfo, cfg config) (image.Image, posterSource, error) {
+	if cfg.SonyPosters {
+		if img, err := decodeSonyThumbnail(src, file); err == nil {
+			return img, posterSonyThumbnail, nil
+		}
+	}
+	src, file = thumbnailInput(src, file, cfg)
+	r, err := openSeekable(src, sourcePath(file))
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() { _ = r.Close() }()
+	return decodeVideoPoster(src, asReaderAt(r), file, cfg)
+}
+
+// saveThumbnail scales img down to a thumbnail, turns it upright, and
+// writes it to path
+func saveThumbnail(img image.Image, orientation int, path string) error {
+	thumb := orientImage(scaleImage(img, thumbnailMaxSize), orientation)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return err
+	}
+	// Written under a temporary name so an interrupted import leaves no
+	// truncated thumbnail in the cache
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// writeThumbnails makes a thumbnail of every picture of the import, and
+// with video_posters a poster of every video, in the thumbnail directory
+// and returns them by file index. Files without a decodable preview get
+// none, which is not an error.
+func writeThumbnails(files []FileInfo, cfg config, dir string) (map[int]thumbnail, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create thumbnail directory: %w", err)
+	}
+	src := sourceFor(cfg)
+	jobs := make(chan int)
+	var mu sync.Mutex
+	thumbnails := make(map[int]thumbnail)
+	var wg sync.WaitGroup
+	for range effectiveWorkers(cfg.Workers) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				var thumb thumbnail
+				var err error
+				if posterCandidate(files[i], cfg) {
+					thumb, err = writeVideoPoster(src, files[i], cfg, dir)
+				} else {
+					thumb.Name = thumbnailKey(files[i]) + ".jpg"
+					err = writeThumbnail(src, files[i], cfg, filepath.Join(dir, thumb.Name))
+				}
+				if err != nil {
+					if cfg.Verbose && !errors.Is(err, errNoPreview) {
+						fmt.Fprintf(os.Stderr, "Warning: no thumbnail for %s: %v\n", sourcePath(files[i]), err)
+					}
+					continue
+				}
+				mu.Lock()
+				thumbnails[i] = thumb
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range files {
+		if thumbnailCandidate(files[i]) || posterCandidate(files[i], cfg) {
+			jobs <- i
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return thumbnails, nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// HEIF files store every image as an item of the meta box: iinf names the
+// type of each item and iloc where its data is. The primary image and its
+// thumbnails are usually HEVC, which cannot be decoded here; previews come
+// from JPEG items and the thumbnail of the Exif item.
+
+// maxHEIFMetaSize bounds the meta box read into memory. It holds item
+// descriptions only, so it is small even in files with many items.
+const maxHEIFMetaSize = 4 << 20
+
+// heifExtent is where the data of an item is, in the file or, for items
+// constructed from the meta box itself, in its idat box
+type heifExtent struct {
+	inIdat bool
+	offset uint64
+	length uint64
+}
+
+// heifPreviewSections returns the JPEG items of a HEIF file and the JPEG
+// thumbnail of its Exif item. ok is false if the file has no meta box.
+func heifPreviewSections(r io.ReaderAt, size int64) (sections []*io.SectionReader, ok bool) {
+	meta, ok := readHEIFMetaBox(r, size)
+	if !ok || len(meta) < 4 {
+		return nil, false
+	}
+	// meta is a full box: version and flags come before its children
+	children := isoBoxPayloads(meta[4:])
+	types := parseHEIFItemTypes(children["iinf"])
+	locations := parseHEIFItemLocations(children["iloc"])
+	idat := children["idat"]
+
+	for id, itemType := range types {
+		if itemType != "jpeg" && itemType != "Exif" {
+			continue
+		}
+		extents := locations[id]
+		// Items split into several extents are not contiguous
+		if len(extents) != 1 {
+			continue
+		}
+		var item *io.SectionReader
+		if extent := extents[0]; extent.inIdat {
+			if extent.offset+extent.length > uint64(len(idat)) {
+				continue
+			}
+			item = io.NewSectionReader(bytes.NewReader(idat), int64(extent.offset), int64(extent.length))
+		} else {
+			length := extent.length
+			if length == 0 {
+				// A length of zero means the rest of the file
+				length = uint64(size) - min(extent.offset, uint64(size))
+			}
+			if extent.offset+length > uint64(size) {
+				continue
+			}
+			item = io.NewSectionReader(r, int64(extent.offset), int64(length))
+		}
+
+		if itemType == "jpeg" {
+			sections = append(sections, item)
+			continue
+		}
+		// The Exif item starts with the offset of the TIFF header, which
+		// follows an "Exif\0\0" marker
+		header, err := readAtFull(item, 0, 4)
+		if err != nil {
+			continue
+		}
+		tiffStart := 4 + int64(binary.BigEndian.Uint32(header))
+		if tiffStart >= item.Size() {
+			continue
+		}
+		tiff := io.NewSectionReader(item, tiffStart, item.Size()-tiffStart)
+		sections = append(sections, tiffPreviewSections(tiff, tiff.Size())...)
+	}
+	return sections, true
+}
+
+// readHEIFMetaBox returns the payload of the top-level meta box
+func readHEIFMetaBox(r io.ReaderAt, size int64) ([]byte, bool) {
+	for offset := int64(0); offset+8 <= size; {
+		header, err := readAtFull(r, offset, 16)
+		if err != nil {
+			if header, err = readAtFull(r, offset, 8); err != nil {
+				return nil, false
+			}
+		}
+		boxSize, headerSize := int64(binary.BigEndian.Uint32(header)), int64(8)
+		switch boxSize {
+		case 0:
+			boxSize = size - offset
+		case 1:
+			if len(header) < 16 {
+				return nil, false
+			}
+			boxSize, headerSize = int64(binary.BigEndian.Uint64(header[8:])), 16
+		}
+		if boxSize < headerSize || boxSize > size-offset {
+			return nil, false
+		}
+		if string(header[4:8]) == "meta" {
+			if boxSize-headerSize > maxHEIFMetaSize {
+				return nil, false
+			}
+			payload, err := readAtFull(r, offset+headerSize, int(boxSize-headerSize))
+			return payload, err == nil
+		}
+		offset += boxSize
+	}
+	return nil, false
+}
+
+// isoBoxPayloads returns the payload of the first box of each type in data
+func isoBoxPayloads(data []byte) map[string][]byte {
+	boxes := make(map[string][]byte)
+	for len(data) >= 8 {
+		boxSize := uint64(binary.BigEndian.Uint32(data))
+		headerSize := uint64(8)
+		switch boxSize {
+		case 0:
+			boxSize = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return boxes
+			}
+			boxSize, headerSize = binary.BigEndian.Uint64(data[8:]), 16
+		}
+		if boxSize < headerSize || boxSize > uint64(len(data)) {
+			return boxes
+		}
+		typ := string(data[4:8])
+		if _, seen := boxes[typ]; !seen {
+			boxes[typ] = data[headerSize:boxSize]
+		}
+		data = data[boxSize:]
+	}
+	return boxes
+}
+
+// heifReader reads the big-endian fields of a box. A read past the end
+// sets failed and returns zero, so parsers check once at the end.
+type heifReader struct {
+	data   []byte
+	failed bool
+}
+
+func (h *heifReader) uint(n int) uint64 {
+	if n == 0 {
+		return 0
+	}
+	if len(h.data) < n {
+		h.failed = true
+		h.data = nil
+		return 0
+	}
+	var v uint64
+	for _, b := range h.data[:n] {
+		v = v<<8 | uint64(b)
+	}
+	h.data = h.data[n:]
+	return v
+}
+
+// parseHEIFItemTypes returns the item type of every item entry (infe
+// version 2 or 3) in an iinf box
+func parseHEIFItemTypes(iinf []byte) map[uint32]string {
+	types := make(map[uint32]string)
+	h := &heifReader{data: iinf}
+	version := h.uint(1)
+	h.uint(3)
+	if version == 0 {
+		h.uint(2)
+	} else {
+		h.uint(4)
+	}
+	if h.failed {
+		return types
+	}
+	for typ, infe := range isoBoxSequence(h.data) {
+		if typ != "infe" {
+			continue
+		}
+		e := &heifReader{data: infe}
+		version := e.uint(1)
+		e.uint(3)
+		if version < 2 {
+			continue
+		}
+		var id uint64
+		if version == 2 {
+			id = e.uint(2)
+		} else {
+			id = e.uint(4)
+		}
+		e.uint(2) // protection index
+		if e.failed || len(e.data) < 4 {
+			continue
+		}
+		types[uint32(id)] = string(e.data[:4])
+	}
+	return types
+}
+
+// parseHEIFItemLocations returns the extents of every item in an iloc box.
+// Items in other files (a data reference other than 0) or built from other
+// items are left out.
+func parseHEIFItemLocations(iloc []byte) map[uint32][]heifExtent {
+	locations := make(map[uint32][]heifExtent)
+	h := &heifReader{data: iloc}
+	version := h.uint(1)
+	h.uint(3)
+	sizes := h.uint(2)
+	offsetSize, lengthSize := int(sizes>>12&0xF), int(sizes>>8&0xF)
+	baseOffsetSize, indexSize := int(sizes>>4&0xF), 0
+	if version == 1 || version == 2 {
+		indexSize = int(sizes & 0xF)
+	}
+	var count uint64
+	if version < 2 {
+		count = h.uint(2)
+	} else {
+		count = h.uint(4)
+	}
+	for range count {
+		var id uint64
+		if version < 2 {
+			id = h.uint(2)
+		} else {
+			id = h.uint(4)
+		}
+		method := uint64(0)
+		if version == 1 || version == 2 {
+			method = h.uint(2) & 0xF
+		}
+		dataReference := h.uint(2)
+		baseOffset := h.uint(baseOffsetSize)
+		extentCount := h.uint(2)
+		var extents []heifExtent
+		for range extentCount {
+			h.uint(indexSize)
+			offset := h.uint(offsetSize)
+			length := h.uint(lengthSize)
+			extents = append(extents, heifExtent{inIdat: method == 1, offset: baseOffset + offset, length: length})
+		}
+		if h.failed {
+			break
+		}
+		if dataReference == 0 && method <= 1 {
+			locations[uint32(id)] = extents
+		}
+	}
+	return locations
+}
+
+// isoBoxSequence yields the type and payload of each box in data
+func isoBoxSequence(data []byte) func(yield func(string, []byte) bool) {
+	return func(yield func(string, []byte) bool) {
+		for len(data) >= 8 {
+			boxSize := uint64(binary.BigEndian.Uint32(data))
+			if boxSize < 8 || boxSize > uint64(len(data)) {
+				return
+			}
+			if !yield(string(data[4:8]), data[8:boxSize]) {
+				return
+			}
+			data = data[boxSize:]
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"testing"
+)
+
+// testHEIFItem is an item of a test HEIF file
+type testHEIFItem struct {
+	Type string
+	Data []byte
+}
+
+// testHEIF builds a HEIF file whose meta box describes the items, numbered
+// from 1, and whose mdat holds their data
+func testHEIF(t *testing.T, items []testHEIFItem) []byte {
+	t.Helper()
+	be := binary.BigEndian
+	box := func(typ string, payload ...[]byte) []byte {
+		body := bytes.Join(payload, nil)
+		b := be.AppendUint32(nil, uint32(8+len(body)))
+		return append(append(b, typ...), body...)
+	}
+
+	ftyp := box("ftyp", []byte("heic\x00\x00\x00\x00mif1heic"))
+	iinf := be.AppendUint16([]byte{0, 0, 0, 0}, uint16(len(items)))
+	for i, item := range items {
+		infe := be.AppendUint16([]byte{2, 0, 0, 0}, uint16(i+1))
+		infe = append(be.AppendUint16(infe, 0), item.Type+"\x00"...)
+		iinf = append(iinf, box("infe", infe)...)
+	}
+	// iloc version 1 with 4-byte offsets and lengths, no base offsets
+	meta := func(mdatStart int) []byte {
+		iloc := be.AppendUint16([]byte{1, 0, 0, 0, 0x44, 0x00}, uint16(len(items)))
+		offset := mdatStart
+		for i, item := range items {
+			iloc = be.AppendUint16(iloc, uint16(i+1))
+			iloc = be.AppendUint16(iloc, 0) // construction method
+			iloc = be.AppendUint16(iloc, 0) // data reference
+			iloc = be.AppendUint16(iloc, 1) // extent count
+			iloc = be.AppendUint32(iloc, uint32(offset))
+			iloc = be.AppendUint32(iloc, uint32(len(item.Data)))
+			offset += len(item.Data)
+		}
+		return box("meta", []byte{0, 0, 0, 0}, box("hdlr", make([]byte, 21)), box("iinf", iinf), box("iloc", iloc))
+	}
+	mdatStart := len(ftyp) + len(meta(0)) + 8
+	var mdat []byte
+	for _, item := range items {
+		mdat = append(mdat, item.Data...)
+	}
+	return bytes.Join([][]byte{ftyp, meta(mdatStart), box("mdat", mdat)}, nil)
+}
+
+// testExifItem wraps a TIFF structure as the data of a HEIF Exif item
+func testExifItem(tiff []byte) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, 6), append([]byte("Exif\x00\x00"), tiff...)...)
+}
+
+func TestHEIFPreview(t *testing.T) {
+	exif := testExifItem(testTIFFWithPreview(t, testJPEG(t, 160, 120, color.White), testJPEG(t, 80, 60, color.White)))
+	// HEVC data that happens to contain a JPEG start marker is not scanned
+	hevc := testJPEG(t, 360, 270, color.Black)
+
+	tests := []struct {
+		name  string
+		items []testHEIFItem
+		width int
+	}{
+		{"jpeg item", []testHEIFItem{{"hvc1", hevc}, {"jpeg", testJPEG(t, 400, 300, color.White)}, {"Exif", exif}}, 400},
+		{"exif thumbnail", []testHEIFItem{{"hvc1", hevc}, {"Exif", exif}}, 160},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := testHEIF(t, tt.items)
+			img, err := decodeThumbnailSource(bytes.NewReader(data), int64(len(data)), FileInfo{FileType: HEIF})
+			if err != nil {
+				t.Fatalf("decodeThumbnailSource failed: %v", err)
+			}
+			if got := img.Bounds().Dx(); got != tt.width {
+				t.Errorf("preview width = %d, want %d", got, tt.width)
+			}
+		})
+	}
+
+	data := testHEIF(t, []testHEIFItem{{"hvc1", hevc}})
+	if _, err := decodeThumbnailSource(bytes.NewReader(data), int64(len(data)), FileInfo{FileType: HEIF}); err != errNoPreview {
+		t.Errorf("decodeThumbnailSource of HEVC only = %v, want errNoPreview", err)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testJPEG encodes a w x h JPEG filled with c
+func testJPEG(t *testing.T, w, h int, c color.Color) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testTIFFWithPreview builds a TIFF-based RAW file whose IFD0 points to a
+// small JPEG thumbnail and whose SubIFD holds a larger JPEG preview as a
+// single strip
+func testTIFFWithPreview(t *testing.T, thumbnail, preview []byte) []byte {
+	t.Helper()
+	le := binary.LittleEndian
+	const ifd0 = 8
+	const subIFD = ifd0 + 2 + 3*12 + 4
+	dataStart := subIFD + 2 + 3*12 + 4
+	entry := func(b []byte, tag, typ uint16, value uint32) []byte {
+		b = le.AppendUint16(b, tag)
+		b = le.AppendUint16(b, typ)
+		b = le.AppendUint32(b, 1)
+		return le.AppendUint32(b, value)
+	}
+	data := []byte{'I', 'I', 42, 0}
+	data = le.AppendUint32(data, ifd0)
+	data = le.AppendUint16(data, 3)
+	data = entry(data, tiffTagSubIFDs, tiffTypeLong, subIFD)
+	data = entry(data, tiffTagJPEGOffset, tiffTypeLong, uint32(dataStart))
+	data = entry(data, tiffTagJPEGLength, tiffTypeLong, uint32(len(thumbnail)))
+	data = le.AppendUint32(data, 0)
+	data = le.AppendUint16(data, 3)
+	data = entry(data, tiffTagCompression, 3, 6)
+	data = entry(data, tiffTagStripOffsets, tiffTypeLong, uint32(dataStart+len(thumbnail)))
+	data = entry(data, tiffTagStripByteCounts, tiffTypeLong, uint32(len(preview)))
+	data = le.AppendUint32(data, 0)
+	data = append(data, thumbnail...)
+	return append(data, preview...)
+}
+
+func TestDecodeThumbnailSource(t *testing.T) {
+	small := testJPEG(t, 16, 12, color.RGBA{255, 0, 0, 255})
+	large := testJPEG(t, 64, 48, color.RGBA{0, 0, 255, 255})
+	// A container with its previews somewhere inside, like CR3 or HEIF
+	container := append(append(append([]byte("\x00\x00\x00\x18ftypcrx "), small...), 0xFF, 0xD8, 0xFF, 0xC3, 0, 0), large...)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		fileType FileType
+		width    int
+	}{
+		{"jpeg", large, JPEG, 64},
+		{"tiff raw", testTIFFWithPreview(t, small, large), RAW, 64},
+		{"container", container, RAW, 64},
+		// The smallest preview that fills a thumbnail is decoded, not the
+		// full picture
+		{"raw preview", testTIFFWithPreview(t, testJPEG(t, 400, 300, color.Black), testJPEG(t, 1200, 900, color.White)), RAW, 400},
+		{"jpeg with mpf preview", append(testJPEG(t, 1200, 900, color.White), testJPEG(t, 480, 360, color.Black)...), JPEG, 480},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := decodeThumbnailSource(bytes.NewReader(tt.data), int64(len(tt.data)), FileInfo{FileType: tt.fileType})
+			if err != nil {
+				t.Fatalf("decodeThumbnailSource failed: %v", err)
+			}
+			if got := img.Bounds().Dx(); got != tt.width {
+				t.Errorf("preview width = %d, want %d", got, tt.width)
+			}
+		})
+	}
+
+	noPreview := []byte("\x00\x00\x00\x18ftypheic and no JPEG at all")
+	if _, err := decodeThumbnailSource(bytes.NewReader(noPreview), int64(len(noPreview)), FileInfo{FileType: HEIF}); err != errNoPreview {
+		t.Errorf("decodeThumbnailSource without a preview = %v, want errNoPreview", err)
+	}
+}
+
+func TestThumbnailInput(t *testing.T) {
+	sourceDir, destDir := t.TempDir(), t.TempDir()
+	writeLibraryTestFile(t, destDir, "2024/IMG_0001.JPG", "copied picture")
+	src := localSource{root: sourceDir}
+	file := FileInfo{SourceDir: sourceDir, SourceName: "IMG_0001.JPG", Size: 3, DestDir: filepath.Join(destDir, "2024"), DestName: "IMG_0001.JPG", Status: StatusCopied}
+
+	in, inFile := thumbnailInput(src, file, config{DestDir: destDir})
+	if _, ok := in.(localSource); !ok || sourcePath(inFile) != filepath.Join(destDir, "2024", "IMG_0001.JPG") || inFile.Size != 14 {
+		t.Errorf("thumbnail input = %s (%d bytes), want the copy", sourcePath(inFile), inFile.Size)
+	}
+
+	for name, tt := range map[string]struct {
+		file FileInfo
+		cfg  config
+	}{
+		"dry run":     {file, config{DestDir: destDir, DryRun: true}},
+		"not copied":  {FileInfo{SourceDir: sourceDir, SourceName: "IMG_0001.JPG", DestDir: file.DestDir, DestName: file.DestName, Status: StatusFailed}, config{DestDir: destDir}},
+		"copy absent": {FileInfo{SourceDir: sourceDir, SourceName: "IMG_0001.JPG", DestDir: file.DestDir, DestName: "IMG_0002.JPG", Status: StatusCopied}, config{DestDir: destDir}},
+	} {
+		if _, inFile := thumbnailInput(src, tt.file, tt.cfg); sourcePath(inFile) != sourcePath(tt.file) {
+			t.Errorf("%s: thumbnail input = %s, want the source", name, sourcePath(inFile))
+		}
+	}
+}
+
+func TestScaleAndOrientImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 1000, 500))
+	img.SetRGBA(0, 0, color.RGBA{255, 255, 255, 255})
+	thumb := scaleImage(img, thumbnailMaxSize)
+	if got := thumb.Bounds().Size(); got != image.Pt(320, 160) {
+		t.Errorf("thumbnail size = %v, want (320,160)", got)
+	}
+	if got := scaleImage(image.NewRGBA(image.Rect(0, 0, 100, 80)), thumbnailMaxSize).Bounds().Size(); got != image.Pt(100, 80) {
+		t.Errorf("small image scaled to %v, want it unchanged", got)
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	marker := color.RGBA{255, 0, 0, 255}
+	src.SetRGBA(0, 0, marker)
+	for orientation, want := range map[int]image.Point{1: {0, 0}, 3: {2, 1}, 6: {1, 0}, 8: {0, 2}} {
+		got := orientImage(src, orientation)
+		if got.RGBAAt(want.X, want.Y) != marker {
+			t.Errorf("orientation %d: top-left pixel not at %v", orientation, want)
+		}
+	}
+}
+
+func TestImportMediaContactSheet(t *testing.T) {
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+	thumbDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(sourceDir, "IMG_0001.JPG"), testJPEG(t, 640, 480, color.RGBA{0, 128, 0, 255}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	copyFixtureToTempDir(t, sourceDir, "minimal.mp4", "clip.mp4")
+
+	cfg := config{
+		SourceDir:          sourceDir,
+		DestDir:            destDir,
+		SidecarDefault:     SidecarDelete,
+		ContactSheet:       true,
+		ThumbnailDirectory: thumbDir,
+		Quiet:              true,
+	}
+	if err := importMedia(cfg); err != nil {
+		t.Fatalf("importMedia failed: %v", err)
+	}
+
+	thumbs, _ := filepath.Glob(filepath.Join(thumbDir, "*.jpg"))
+	if len(thumbs) != 1 {
+		t.Fatalf("thumbnails = %v, want one for the picture", thumbs)
+	}
+	f, err := os.Open(thumbs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	thumb, err := jpeg.DecodeConfig(f)
+	_ = f.Close()
+	if err != nil || thumb.Width != 320 || thumb.Height != 240 {
+		t.Errorf("thumbnail = %+v, %v, want 320x240", thumb, err)
+	}
+
+	sheets, _ := filepath.Glob(filepath.Join(thumbDir, contactSheetsSubfolder, "*.html"))
+	if len(sheets) != 1 {
+		t.Fatalf("contact sheets = %v, want one", sheets)
+	}
+	html, err := os.ReadFile(sheets[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`src="../` + filepath.Base(thumbs[0]) + `"`, "IMG_0001.JPG", "clip.mp4", "2 copied", ">video<"} {
+		if !strings.Contains(string(html), want) {
+			t.Errorf("contact sheet does not contain %q", want)
+		}
+	}
+}
+
+func TestFileCamera(t *testing.T) {
+	tests := []struct {
+		file FileInfo
+		want string
+	}{
+		{FileInfo{ImageMetadata: &ImageMetadata{Make: "Canon", Model: "Canon EOS R5"}}, "Canon EOS R5"},
+		{FileInfo{ImageMetadata: &ImageMetadata{Make: "SONY", Model: "ILCE-7M4"}}, "SONY ILCE-7M4"},
+		{FileInfo{VideoMetadata: &VideoMetadata{Model: "HERO12 Black"}}, "HERO12 Black"},
+		{FileInfo{CameraFamily: cameraFamilyDCF}, "DCF"},
+	}
+	for _, tt := range tests {
+		if got := fileCamera(tt.file); got != tt.want {
+			t.Errorf("fileCamera = %q, want %q", got, tt.want)
+		}
+	}
+}
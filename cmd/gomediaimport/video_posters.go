@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// posterSource is where the poster image of a video came from
+type posterSource string
+
+const (
+	posterSonyThumbnail     posterSource = "Sony thumbnail"
+	posterCoverArt          posterSource = "cover art"
+	posterEmbeddedThumbnail posterSource = "embedded thumbnail"
+	posterFirstFrame        posterSource = "first frame"
+	posterKeyframe          posterSource = "first keyframe"
+)
+
+// posterSources are the poster sources in the order they are tried, with
+// the suffix of their posters in the thumbnail cache
+var posterSources = []struct {
+	Source posterSource
+	Suffix string
+}{
+	{posterSonyThumbnail, "sony"},
+	{posterCoverArt, "cover"},
+	{posterEmbeddedThumbnail, "thumb"},
+	{posterFirstFrame, "frame"},
+	{posterKeyframe, "keyframe"},
+}
+
+// quickTimeThumbnailAtoms are user data atoms cameras keep a JPEG preview
+// of the clip in
+var quickTimeThumbnailAtoms = map[string]bool{
+	"thmb": true, "THMB": true, "CNTH": true, "PRVW": true,
+}
+
+// mjpegFormats are the sample formats of Motion JPEG tracks, whose frames
+// decode as JPEG
+var mjpegFormats = map[string]bool{
+	"jpeg": true, "mjpa": true, "mjpb": true, "AVDJ": true, "dmb1": true,
+}
+
+// posterKeyframeCommand returns the first keyframe of a local video as a
+// JPEG. It is a variable so tests can do without ffmpeg.
+var posterKeyframeCommand = ffmpegKeyframe
+
+// posterCandidate returns true if a poster is made for the file
+func posterCandidate(file FileInfo, cfg config) bool {
+	return cfg.VideoPosters && file.MediaCategory == Video
+}
+
+// sonyThumbnailPath returns the THMBNL JPEG a Sony XAVC camera wrote for a
+// clip, like M4ROOT/THMBNL/C0001T01.JPG for M4ROOT/CLIP/C0001.MP4, or "" if
+// the clip is not in a CLIP folder
+func sonyThumbnailPath(file FileInfo) string {
+	clipDir := file.SourceDir
+	if !strings.EqualFold(filepath.Base(clipDir), "CLIP") || !strings.EqualFold(filepath.Base(filepath.Dir(clipDir)), "M4ROOT") {
+		return ""
+	}
+	name := strings.TrimSuffix(file.SourceName, filepath.Ext(file.SourceName))
+	return filepath.Join(filepath.Dir(clipDir), "THMBNL", name+"T01.JPG")
+}
+
+// decodeSonyThumbnail decodes the THMBNL JPEG of a clip. Cards copied to a
+// case-sensitive filesystem may have lowercased its name.
+func decodeSonyThumbnail(src mediaSource, file FileInfo) (image.Image, error) {
+	path := sonyThumbnailPath(file)
+	if path == "" {
+		return nil, errNoPreview
+	}
+	for _, candidate := range []string{path, filepath.Join(filepath.Dir(path), strings.ToLower(filepath.Base(path)))} {
+		r, err := src.Open(candidate)
+		if err != nil {
+			continue
+		}
+		img, _, err := image.Decode(r)
+		_ = r.Close()
+		return img, err
+	}
+	return nil, errNoPreview
+}
+
+// keepSonyThumbnails drops the Sony thumbnail directories from the source
+// artifacts to clean up, since sony_thumbnail_posters uses them as posters
+func keepSonyThumbnails(targets []sourceCleanupTarget) []sourceCleanupTarget {
+	var kept []sourceCleanupTarget
+	for _, target := range targets {
+		if target.Kind != sourceArtifactSonyThumbnail {
+			kept = append(kept, target)
+		}
+	}
+	return kept
+}
+
+// quickTimePoster returns the poster a MP4 or MOV file carries: its cover
+// art, a camera's embedded JPEG thumbnail, or the first frame of a Motion
+// JPEG track
+func quickTimePoster(r io.ReaderAt, size int64) (image.Image, posterSource, error) {
+	moov, _, _, err := readQuickTimeMovie(r, size)
+	if err != nil {
+		return nil, "", err
+	}
+	// iTunes-style files keep their metadata in user data, QuickTime
+	// files directly in the movie atom
+	for _, ilst := range []*qtBox{moov.childPath("udta", "meta", "ilst"), moov.childPath("meta", "ilst")} {
+		covr := ilst.childPath("covr")
+		if covr == nil {
+			continue
+		}
+		if value, _, ok := quickTimeItemValue(covr); ok {
+			if img, _, err := image.Decode(bytes.NewReader(value)); err == nil {
+				return img, posterCoverArt, nil
+			}
+		}
+	}
+
+	var embedded image.Image
+	moov.walk(func(box *qtBox) {
+		if embedded != nil || !quickTimeThumbnailAtoms[box.Type] {
+			return
+		}
+		if start := bytes.Index(box.Data, []byte{0xFF, 0xD8, 0xFF}); start >= 0 {
+			if img, _, err := image.Decode(bytes.NewReader(box.Data[start:])); err == nil {
+				embedded = img
+			}
+		}
+	})
+	if embedded != nil {
+		return embedded, posterEmbeddedThumbnail, nil
+	}
+
+	for _, trak := range moov.Children {
+		if trak.Type != "trak" {
+			continue
+		}
+		offset, length, ok := firstMJPEGSample(trak.childPath("mdia", "minf", "stbl"))
+		if !ok || offset+length > size || length > maxPreviewScan {
+			continue
+		}
+		if img, err := jpeg.Decode(io.NewSectionReader(r, offset, length)); err == nil {
+			return img, posterFirstFrame, nil
+		}
+	}
+	return nil, "", errNoPreview
+}
+
+// childPath follows a path of child atoms, returning nil if one is missing
+func (b *qtBox) childPath(types ...string) *qtBox {
+	for _, typ := range types {
+		if b == nil {
+			return nil
+		}
+		b = b.child(typ)
+	}
+	return b
+}
+
+// firstMJPEGSample locates the first sample of a Motion JPEG track from its
+// sample table
+func firstMJPEGSample(stbl *qtBox) (offset, length int64, ok bool) {
+	if stbl == nil {
+		return 0, 0, false
+	}
+	stsd, stsz := stbl.child("stsd"), stbl.child("stsz")
+	if stsd == nil || len(stsd.Data) < 16 || !mjpegFormats[string(stsd.Data[12:16])] || stsz == nil || len(stsz.Data) < 12 {
+		return 0, 0, false
+	}
+	length = int64(binary.BigEndian.Uint32(stsz.Data[4:]))
+	if length == 0 {
+		if len(stsz.Data) < 16 || binary.BigEndian.Uint32(stsz.Data[8:]) == 0 {
+			return 0, 0, false
+		}
+		length = int64(binary.BigEndian.Uint32(stsz.Data[12:]))
+	}
+	switch {
+	case stbl.child("stco") != nil:
+		stco := stbl.child("stco").Data
+		if len(stco) < 12 || binary.BigEndian.Uint32(stco[4:]) == 0 {
+			return 0, 0, false
+		}
+		offset = int64(binary.BigEndian.Uint32(stco[8:]))
+	case stbl.child("co64") != nil:
+		co64 := stbl.child("co64").Data
+		if len(co64) < 16 || binary.BigEndian.Uint32(co64[4:]) == 0 {
+			return 0, 0, false
+		}
+		offset = int64(binary.BigEndian.Uint64(co64[8:]))
+	default:
+		return 0, 0, false
+	}
+	return offset, length, length > 0
+}
+
+// ffmpegKeyframeTimeout bounds how long ffmpeg may take for one video, so a
+// damaged file cannot stall the import. It is a variable so tests can
+// shorten it.
+var ffmpegKeyframeTimeout = 30 * time.Second
+
+// ffmpegKeyframe decodes the first keyframe of a local video with ffmpeg,
+// if it is installed
+func ffmpegKeyframe(path string) ([]byte, error) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return nil, errNoPreview
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ffmpegKeyframeTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-v", "error", "-skip_frame", "nokey", "-i", path,
+		"-frames:v", "1", "-f", "image2pipe", "-c:v", "mjpeg", "-")
+	// Do not wait for children of a killed ffmpeg that keep its output open
+	cmd.WaitDelay = time.Second
+	output, err := cmd.Output()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("ffmpeg did not finish within %s", ffmpegKeyframeTimeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg failed: %w", err)
+	}
+	return output, nil
+}
+
+// decodeVideoPoster returns the poster a video carries, or its first
+// keyframe for videos on the local filesystem. Sony thumbnails are separate
+// files on the card and are tried before.
+func decodeVideoPoster(src mediaSource, r io.ReaderAt, file FileInfo, cfg config) (image.Image, posterSource, error) {
+	if isoBaseMediaFileTypes[file.FileType] {
+		if img, source, err := quickTimePoster(r, file.Size); err == nil {
+			return img, source, nil
+		}
+	}
+	if _, ok := src.(localSource); ok {
+		if data, err := posterKeyframeCommand(sourcePath(file)); err == nil {
+			img, _, err := image.Decode(bytes.NewReader(data))
+			if err != nil {
+				return nil, "", fmt.Errorf("failed to decode keyframe: %w", err)
+			}
+			return img, posterKeyframe, nil
+		} else if err != errNoPreview {
+			return nil, "", err
+		}
+	}
+	return nil, "", errNoPreview
+}
+
+// printVideoPosters reports how many videos got a poster from each source
+func printVideoPosters(files []FileInfo, thumbnails map[int]thumbnail) {
+	counts := make(map[posterSource]int)
+	var videos, total int
+	for i, file := range files {
+		if file.MediaCategory != Video {
+			continue
+		}
+		videos++
+		if thumb, ok := thumbnails[i]; ok {
+			counts[thumb.Poster]++
+			total++
+		}
+	}
+	fmt.Printf("Video posters: %d of %d videos\n", total, videos)
+	for _, p := range posterSources {
+		if count := counts[p.Source]; count > 0 {
+			fmt.Printf("  %s: %d\n", p.Source, count)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image/color"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testMovie builds a movie whose movie atom holds children, followed by a
+// media data atom holding mdat
+func testMovie(children []*qtBox, mdat []byte) []byte {
+	ftyp := (&qtBox{Type: "ftyp", Data: []byte("isom\x00\x00\x02\x00isom")}).encode()
+	moov := (&qtBox{Type: "moov", container: true, Children: children}).encode()
+	data := append(ftyp, moov...)
+	return append(data, (&qtBox{Type: "mdat", Data: mdat}).encode()...)
+}
+
+func TestQuickTimePoster(t *testing.T) {
+	cover := testJPEG(t, 64, 48, color.RGBA{255, 0, 0, 255})
+	coverItem := &qtBox{Type: "covr"}
+	setQuickTimeItemValue(coverItem, cover, []byte{0, 0, 0, 13, 0, 0, 0, 0})
+	coverMovie := testMovie([]*qtBox{{Type: "udta", container: true, Children: []*qtBox{
+		{Type: "meta", container: true, Prefix: []byte{0, 0, 0, 0}, Children: []*qtBox{
+			{Type: "ilst", container: true, Children: []*qtBox{coverItem}},
+		}},
+	}}}, nil)
+
+	thumb := testJPEG(t, 32, 24, color.RGBA{0, 0, 255, 255})
+	thumbMovie := testMovie([]*qtBox{{Type: "udta", container: true, Children: []*qtBox{
+		{Type: "thmb", Data: append([]byte{0, 0, 0, 0}, thumb...)},
+	}}}, nil)
+
+	// A Motion JPEG track whose only chunk starts the media data
+	frame := testJPEG(t, 80, 60, color.RGBA{0, 255, 0, 255})
+	be := binary.BigEndian
+	stsd := be.AppendUint32(make([]byte, 4), 1)
+	stsd = append(be.AppendUint32(stsd, 16), "jpeg\x00\x00\x00\x00"...)
+	stsz := be.AppendUint32(make([]byte, 4), uint32(len(frame)))
+	stsz = be.AppendUint32(stsz, 1)
+	stco := be.AppendUint32(make([]byte, 4), 1)
+	stbl := &qtBox{Type: "stbl", container: true, Children: []*qtBox{
+		{Type: "stsd", Data: stsd}, {Type: "stsz", Data: stsz}, {Type: "stco", Data: be.AppendUint32(stco, 0)},
+	}}
+	trak := &qtBox{Type: "trak", container: true, Children: []*qtBox{{Type: "mdia", container: true, Children: []*qtBox{
+		{Type: "minf", container: true, Children: []*qtBox{stbl}},
+	}}}}
+	frameMovie := testMovie([]*qtBox{trak}, frame)
+	// Point the chunk offset at the media data now its position is known
+	mdatStart := len(frameMovie) - len(frame)
+	be.PutUint32(stbl.Children[2].Data[8:], uint32(mdatStart))
+	frameMovie = testMovie([]*qtBox{trak}, frame)
+
+	tests := []struct {
+		name       string
+		data       []byte
+		wantSource posterSource
+		wantWidth  int
+	}{
+		{"cover art", coverMovie, posterCoverArt, 64},
+		{"embedded thumbnail", thumbMovie, posterEmbeddedThumbnail, 32},
+		{"Motion JPEG frame", frameMovie, posterFirstFrame, 80},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, source, err := quickTimePoster(bytes.NewReader(tt.data), int64(len(tt.data)))
+			if err != nil {
+				t.Fatalf("quickTimePoster failed: %v", err)
+			}
+			if source != tt.wantSource || img.Bounds().Dx() != tt.wantWidth {
+				t.Errorf("poster = %s %dpx wide, want %s %dpx", source, img.Bounds().Dx(), tt.wantSource, tt.wantWidth)
+			}
+		})
+	}
+
+	empty := testMovie([]*qtBox{{Type: "udta", container: true}}, nil)
+	if _, _, err := quickTimePoster(bytes.NewReader(empty), int64(len(empty))); !errors.Is(err, errNoPreview) {
+		t.Errorf("quickTimePoster without a poster = %v, want errNoPreview", err)
+	}
+}
+
+func TestSonyThumbnailPath(t *testing.T) {
+	tests := []struct {
+		dir, name, want string
+	}{
+		{"/card/PRIVATE/M4ROOT/CLIP", "C0001.MP4", "/card/PRIVATE/M4ROOT/THMBNL/C0001T01.JPG"},
+		{"/card/private/m4root/clip", "C0002.mp4", "/card/private/m4root/THMBNL/C0002T01.JPG"},
+		{"/card/DCIM/100MSDCF", "C0001.MP4", ""},
+		{"/card/CLIP", "C0001.MP4", ""},
+	}
+	for _, tt := range tests {
+		file := FileInfo{SourceDir: filepath.FromSlash(tt.dir), SourceName: tt.name}
+		if got := sonyThumbnailPath(file); got != filepath.FromSlash(tt.want) {
+			t.Errorf("sonyThumbnailPath(%s/%s) = %q, want %q", tt.dir, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeVideoPosterKeyframe(t *testing.T) {
+	frame := testJPEG(t, 48, 32, color.RGBA{0, 0, 0, 255})
+	var called string
+	defer func(command func(string) ([]byte, error)) { posterKeyframeCommand = command }(posterKeyframeCommand)
+	posterKeyframeCommand = func(path string) ([]byte, error) {
+		called = path
+		return frame, nil
+	}
+
+	dir := t.TempDir()
+	file := FileInfo{SourceDir: dir, SourceName: "clip.mkv", FileType: MKV, MediaCategory: Video}
+	img, source, err := decodeVideoPoster(localSource{root: dir}, bytes.NewReader(nil), file, config{VideoPosters: true})
+	if err != nil {
+		t.Fatalf("decodeVideoPoster failed: %v", err)
+	}
+	if source != posterKeyframe || img.Bounds().Dx() != 48 || called != filepath.Join(dir, "clip.mkv") {
+		t.Errorf("poster = %s %dpx wide from %q, want the keyframe of the clip", source, img.Bounds().Dx(), called)
+	}
+}
+
+func TestFFmpegKeyframeTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script in place of ffmpeg")
+	}
+	binDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(binDir, "ffmpeg"), []byte("#!/bin/sh\nexec sleep 10\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	defer func(timeout time.Duration) { ffmpegKeyframeTimeout = timeout }(ffmpegKeyframeTimeout)
+	ffmpegKeyframeTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	_, err := ffmpegKeyframe(filepath.Join(binDir, "clip.mp4"))
+	if err == nil || !strings.Contains(err.Error(), "did not finish") {
+		t.Errorf("ffmpegKeyframe = %v, want a timeout error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("ffmpegKeyframe took %s despite the timeout", elapsed)
+	}
+}
+
+func TestImportMediaSonyThumbnailPosters(t *testing.T) {
+	defer func(command func(string) ([]byte, error)) { posterKeyframeCommand = command }(posterKeyframeCommand)
+	posterKeyframeCommand = func(string) ([]byte, error) { return nil, errNoPreview }
+
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+	thumbDir := t.TempDir()
+	clipDir := filepath.Join(sourceDir, "PRIVATE", "M4ROOT", "CLIP")
+	thumbnailDir := filepath.Join(sourceDir, "PRIVATE", "M4ROOT", "THMBNL")
+	for _, dir := range []string{clipDir, thumbnailDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	copyFixtureToTempDir(t, clipDir, "minimal.mp4", "C0001.MP4")
+	sonyThumbnail := filepath.Join(thumbnailDir, "C0001T01.JPG")
+	if err := os.WriteFile(sonyThumbnail, testJPEG(t, 160, 90, color.RGBA{0, 0, 255, 255}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config{
+		SourceDir:          sourceDir,
+		DestDir:            destDir,
+		SidecarDefault:     SidecarDelete,
+		ContactSheet:       true,
+		ThumbnailDirectory: thumbDir,
+		VideoPosters:       true,
+		SonyPosters:        true,
+		DeleteOriginals:    true,
+		Quiet:              true,
+	}
+	if err := importMedia(cfg); err != nil {
+		t.Fatalf("importMedia failed: %v", err)
+	}
+
+	posters, _ := filepath.Glob(filepath.Join(thumbDir, "*_sony.jpg"))
+	if len(posters) != 1 {
+		t.Fatalf("posters = %v, want one from the Sony thumbnail", posters)
+	}
+	sheets, _ := filepath.Glob(filepath.Join(thumbDir, contactSheetsSubfolder, "*.html"))
+	if len(sheets) != 1 {
+		t.Fatalf("contact sheets = %v, want one", sheets)
+	}
+	html, err := os.ReadFile(sheets[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`src="../` + filepath.Base(posters[0]) + `"`, "poster from Sony thumbnail"} {
+		if !strings.Contains(string(html), want) {
+			t.Errorf("contact sheet does not contain %q", want)
+		}
+	}
+	if _, err := os.Stat(sonyThumbnail); err != nil {
+		t.Errorf("Sony thumbnail was not kept on the source: %v", err)
+	}
+}
+
+func TestValidateConfigVideoPosters(t *testing.T) {
+	tmpDir := t.TempDir()
+	base := config{SourceDir: tmpDir, DestDir: filepath.Join(tmpDir, "dest"), SidecarDefault: SidecarDelete, ContactSheet: true, VideoPosters: true}
+	tests := []struct {
+		name    string
+		modify  func(*config)
+		wantErr string
+	}{
+		{"defaults", func(*config) {}, ""},
+		{"Sony thumbnails", func(c *config) { c.SonyPosters = true }, ""},
+		{"without contact sheet", func(c *config) { c.ContactSheet = false }, "video_posters requires contact_sheet"},
+		{"Sony thumbnails without posters", func(c *config) { c.VideoPosters = false; c.SonyPosters = true }, "sony_thumbnail_posters requires video_posters"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := base
+			tt.modify(&cfg)
+			err := validateCommonConfig(&cfg)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateCommonConfig returned error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateCommonConfig = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// XMP namespaces written to provenance sidecars. Lightroom and darktable read
+// the capture time from xmp:CreateDate and photoshop:DateCreated and keep the
+// other properties as custom metadata.
+const (
+	rdfNamespace           = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	xmpNamespace           = "http://ns.adobe.com/xap/1.0/"
+	photoshopNamespace     = "http://ns.adobe.com/photoshop/1.0/"
+	xmpMMNamespace         = "http://ns.adobe.com/xap/1.0/mm/"
+	exifNamespace          = "http://ns.adobe.com/exif/1.0/"
+	gomediaimportNamespace = "https://github.com/tonimelisma/gomediaimport/xmp/1.0/"
+)
+
+// xmpSkeleton is the packet new sidecars are created from
+const xmpSkeleton = "<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n" +
+	"<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">\n" +
+	" <rdf:RDF xmlns:rdf=\"" + rdfNamespace + "\">\n" +
+	"  </rdf:RDF>\n" +
+	"</x:xmpmeta>\n" +
+	"<?xpacket end=\"w\"?>\n"
+
+// xmpOwnedProperties are the properties a provenance sidecar sets. Existing
+// values are replaced when merging; every property in the gomediaimport
+// namespace is owned.
+var xmpOwnedProperties = map[xml.Name]bool{
+	{Space: xmpNamespace, Local: "CreateDate"}:          true,
+	{Space: photoshopNamespace, Local: "DateCreated"}:   true,
+	{Space: xmpMMNamespace, Local: "PreservedFileName"}: true,
+}
+
+// xmpGPSProperties are the properties of a GPX position. They are owned only
+// when the provenance has one, so positions in copied sidecars are kept.
+var xmpGPSProperties = map[xml.Name]bool{
+	{Space: exifNamespace, Local: "GPSLatitude"}:    true,
+	{Space: exifNamespace, Local: "GPSLongitude"}:   true,
+	{Space: exifNamespace, Local: "GPSAltitude"}:    true,
+	{Space: exifNamespace, Local: "GPSAltitudeRef"}: true,
+}
+
+// xmpProvenance is what a sidecar records about an imported file
+type xmpProvenance struct {
+	CaptureTime  time.Time
+	TimeFallback bool
+	OriginalName string
+	SourceVolume string
+	SourcePath   string
+	Session      string
+	Checksum     string
+	GPS          *gpsLocation // Position matched from GPX tracks, nil if N/A
+}
+
+// newImportSessionID returns a random UUID identifying one import run
+func newImportSessionID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// xmpDateTime formats a capture time in local time without a zone, as EXIF
+// stores it
+func xmpDateTime(t time.Time) string {
+	if t.Nanosecond() != 0 {
+		return t.Format("2006-01-02T15:04:05.000")
+	}
+	return t.Format("2006-01-02T15:04:05")
+}
+
+// xmpGPSCoordinate formats a coordinate as XMP's "DDD,MM.mmmmmmK"
+func xmpGPSCoordinate(value float64, positive, negative byte) string {
+	ref := positive
+	if value < 0 {
+		ref, value = negative, -value
+	}
+	degrees := math.Floor(value)
+	return fmt.Sprintf("%d,%.6f%c", int(degrees), (value-degrees)*60, ref)
+}
+
+// description renders the provenance as an rdf:Description element followed
+// by the indentation of the closing rdf:RDF tag. It declares every prefix it
+// uses, so it can be placed in any packet.
+func (p xmpProvenance) description() string {
+	timeSource := "metadata"
+	if p.TimeFallback {
+		timeSource = "fallback"
+	}
+	properties := []struct{ name, value string }{
+		{"xmp:CreateDate", xmpDateTime(p.CaptureTime)},
+		{"photoshop:DateCreated", xmpDateTime(p.CaptureTime)},
+		{"xmpMM:PreservedFileName", p.OriginalName},
+		{"gomediaimport:CaptureTimeSource", timeSource},
+		{"gomediaimport:SourceVolume", p.SourceVolume},
+		{"gomediaimport:SourcePath", filepath.ToSlash(p.SourcePath)},
+		{"gomediaimport:ImportSession", p.Session},
+		{"gomediaimport:Checksum", "xxh64:" + p.Checksum},
+	}
+	if p.GPS != nil {
+		properties = append(properties,
+			struct{ name, value string }{"exif:GPSLatitude", xmpGPSCoordinate(p.GPS.Latitude, 'N', 'S')},
+			struct{ name, value string }{"exif:GPSLongitude", xmpGPSCoordinate(p.GPS.Longitude, 'E', 'W')},
+		)
+		if p.GPS.Altitude != nil {
+			altitude, ref := *p.GPS.Altitude, "0"
+			if altitude < 0 {
+				altitude, ref = -altitude, "1"
+			}
+			properties = append(properties,
+				struct{ name, value string }{"exif:GPSAltitude", fmt.Sprintf("%d/100", int64(math.Round(altitude*100)))},
+				struct{ name, value string }{"exif:GPSAltitudeRef", ref},
+			)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("<rdf:Description rdf:about=\"\"")
+	for _, ns := range [][2]string{
+		{"rdf", rdfNamespace},
+		{"xmp", xmpNamespace},
+		{"photoshop", photoshopNamespace},
+		{"xmpMM", xmpMMNamespace},
+		{"exif", exifNamespace},
+		{"gomediaimport", gomediaimportNamespace},
+	} {
+		fmt.Fprintf(&b, "\n    xmlns:%s=\"%s\"", ns[0], ns[1])
+	}
+	for _, property := range properties {
+		if property.value == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "\n    %s=\"%s\"", property.name, escapeXMLAttr(property.value))
+	}
+	b.WriteString("/>\n ")
+	return b.String()
+}
+
+var (
+	xmlTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	xmlAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", "\"", "&quot;", "\n", "&#xA;", "\r", "&#xD;", "\t", "&#x9;")
+)
+
+func escapeXMLAttr(s string) string {
+	return xmlAttrEscaper.Replace(s)
+}
+
+// mergeXMPPacket returns packet with the provenance properties replaced: any
+// owned property of an existing rdf:Description, as an attribute or an
+// element, is dropped, and the provenance is added as a description of its
+// own at the end of rdf:RDF. Everything else is written back unchanged apart
+// from entity encoding and empty-element syntax.
+func mergeXMPPacket(packet []byte, provenance xmpProvenance) ([]byte, error) {
+	dec := xml.NewDecoder(bytes.NewReader(packet))
+	var out bytes.Buffer
+
+	// Prefix bindings are tracked by hand because RawToken leaves them
+	// unresolved, and the document is written back with its own prefixes
+	scopes := []map[string]string{{"xml": "http://www.w3.org/XML/1998/namespace"}}
+	resolve := func(name xml.Name) xml.Name {
+		if name.Space == "" {
+			return name
+		}
+		for i := len(scopes) - 1; i >= 0; i-- {
+			if uri, ok := scopes[i][name.Space]; ok {
+				return xml.Name{Space: uri, Local: name.Local}
+			}
+		}
+		return name
+	}
+	owned := func(name xml.Name) bool {
+		return xmpOwnedProperties[name] || name.Space == gomediaimportNamespace || (provenance.GPS != nil && xmpGPSProperties[name])
+	}
+	description := xml.Name{Space: rdfNamespace, Local: "Description"}
+	rdfRoot := xml.Name{Space: rdfNamespace, Local: "RDF"}
+
+	var stack []xml.Name
+	var rawNames []string
+	skipDepth := 0
+	inserted := false
+	// A description that holds nothing but owned properties, such as one
+	// written by an earlier import, is removed as a whole. candidateStart is
+	// where its output began, or -1.
+	candidateStart, candidateDepth := -1, 0
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid XMP: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			scope := make(map[string]string)
+			for _, attr := range t.Attr {
+				if attr.Name.Space == "xmlns" {
+					scope[attr.Name.Local] = attr.Value
+				}
+			}
+			scopes = append(scopes, scope)
+			name := resolve(t.Name)
+			parentIsDescription := len(stack) > 0 && stack[len(stack)-1] == description
+			stack = append(stack, name)
+			rawNames = append(rawNames, rawXMLName(t.Name))
+			if skipDepth > 0 || (parentIsDescription && owned(name)) {
+				skipDepth++
+				continue
+			}
+			candidateStart = -1
+			if name == description && len(stack) >= 2 && stack[len(stack)-2] == rdfRoot && onlyOwnedAttributes(t.Attr, resolve, owned) {
+				candidateStart = len(bytes.TrimRight(out.Bytes(), " \t\r\n"))
+				candidateDepth = len(stack)
+			}
+			out.WriteString("<" + rawXMLName(t.Name))
+			for _, attr := range t.Attr {
+				if name == description && attr.Name.Space != "xmlns" && owned(resolve(attr.Name)) {
+					continue
+				}
+				fmt.Fprintf(&out, " %s=\"%s\"", rawXMLName(attr.Name), escapeXMLAttr(attr.Value))
+			}
+			out.WriteString(">")
+		case xml.EndElement:
+			// RawToken does not check that elements are balanced
+			if len(stack) == 0 || rawNames[len(rawNames)-1] != rawXMLName(t.Name) {
+				return nil, fmt.Errorf("invalid XMP: unexpected end element %s", rawXMLName(t.Name))
+			}
+			name := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			rawNames = rawNames[:len(rawNames)-1]
+			scopes = scopes[:len(scopes)-1]
+			if skipDepth > 0 {
+				skipDepth--
+				continue
+			}
+			if candidateStart >= 0 && len(stack) == candidateDepth-1 {
+				out.Truncate(candidateStart)
+				candidateStart = -1
+				continue
+			}
+			if name == rdfRoot && !inserted {
+				out.WriteString(provenance.description())
+				inserted = true
+			}
+			out.WriteString("</" + rawXMLName(t.Name) + ">")
+		case xml.CharData:
+			if skipDepth == 0 {
+				if len(bytes.TrimSpace(t)) > 0 {
+					candidateStart = -1
+				}
+				out.WriteString(xmlTextEscaper.Replace(string(t)))
+			}
+		case xml.Comment:
+			if skipDepth == 0 {
+				candidateStart = -1
+				out.WriteString("<!--" + string(t) + "-->")
+			}
+		case xml.ProcInst:
+			out.WriteString("<?" + t.Target)
+			if len(t.Inst) > 0 {
+				out.WriteString(" " + string(t.Inst))
+			}
+			out.WriteString("?>")
+		case xml.Directive:
+			out.WriteString("<!" + string(t) + ">")
+		}
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("invalid XMP: unclosed element %s", rawNames[len(rawNames)-1])
+	}
+	if !inserted {
+		return nil, fmt.Errorf("invalid XMP: no rdf:RDF element")
+	}
+	return out.Bytes(), nil
+}
+
+// onlyOwnedAttributes returns true if an rdf:Description sets owned
+// properties and no others
+func onlyOwnedAttributes(attrs []xml.Attr, resolve func(xml.Name) xml.Name, owned func(xml.Name) bool) bool {
+	var found bool
+	for _, attr := range attrs {
+		name := resolve(attr.Name)
+		switch {
+		case attr.Name.Space == "xmlns" || attr.Name.Local == "xmlns" && attr.Name.Space == "":
+		case name == xml.Name{Space: rdfNamespace, Local: "about"}:
+		case owned(name):
+			found = true
+		default:
+			return false
+		}
+	}
+	return found
+}
+
+func rawXMLName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+// isXMPSidecarFile returns true if file is a copied .xmp sidecar
+func isXMPSidecarFile(file FileInfo) bool {
+	return file.MediaCategory == Sidecar && file.Status == StatusCopied && strings.EqualFold(filepath.Ext(file.SourceName), ".xmp")
+}
+
+// wantsXMPSidecar returns true if a provenance sidecar is written for file
+func wantsXMPSidecar(file FileInfo) bool {
+	return file.Status == StatusCopied && file.MediaCategory != Sidecar && file.MediaCategory != Proxy
+}
+
+// planXMPSidecarNames sets XMPSidecarName on every copied media file. A file
+// whose .xmp sidecar was copied from the source gets that sidecar, which
+// belongs to the RAW picture of a RAW+JPEG pair. The others get their
+// destination base name with .xmp, the name Lightroom reads, unless another
+// file in the import has it or it already exists at the destination, where it
+// belongs to another file; RAW pictures claim it first, and the others get
+// their full name with .xmp, the name darktable reads. Names are compared
+// without case, as on macOS and Windows.
+func planXMPSidecarNames(files []FileInfo, exists func(path string) bool) {
+	claimed := make(map[string]bool)
+	claim := func(dir, name string) bool {
+		key := strings.ToLower(filepath.Join(dir, name))
+		if claimed[key] {
+			return false
+		}
+		claimed[key] = true
+		return true
+	}
+	for i := range files {
+		files[i].XMPSidecarName = ""
+	}
+	for _, file := range files {
+		if file.Status == StatusCopied && !wantsXMPSidecar(file) {
+			// Never overwrite a sidecar copied from the source
+			claim(file.DestDir, file.DestName)
+		}
+	}
+	for _, sidecar := range files {
+		if !isXMPSidecarFile(sidecar) || sidecar.ParentIndex < 0 {
+			continue
+		}
+		owner := sidecar.ParentIndex
+		if pair := files[owner].PairIndex; pair >= 0 && files[pair].MediaCategory == RawPicture && wantsXMPSidecar(files[pair]) {
+			owner = pair
+		}
+		if !wantsXMPSidecar(files[owner]) || files[owner].XMPSidecarName != "" || files[owner].DestDir != sidecar.DestDir {
+			continue
+		}
+		files[owner].XMPSidecarName = sidecar.DestName
+	}
+
+	var order []int
+	for i, file := range files {
+		if wantsXMPSidecar(file) && file.XMPSidecarName == "" {
+			order = append(order, i)
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return files[order[a]].MediaCategory == RawPicture && files[order[b]].MediaCategory != RawPicture
+	})
+	for _, i := range order {
+		name := strings.TrimSuffix(files[i].DestName, filepath.Ext(files[i].DestName)) + ".xmp"
+		if exists(filepath.Join(files[i].DestDir, name)) || !claim(files[i].DestDir, name) {
+			name = files[i].DestName + ".xmp"
+			claim(files[i].DestDir, name)
+		}
+		files[i].XMPSidecarName = name
+	}
+}
+
+// writeXMPSidecars writes an XMP sidecar with the capture time and import
+// provenance next to every copied media file. Sidecars copied from the source,
+// and sidecars named after the file that already exist at a local
+// destination, are merged into instead of replaced. Files whose
+// sidecar could not be written have XMPSidecarName cleared.
+func writeXMPSidecars(files []FileInfo, cfg config, session string) error {
+	src := sourceFor(cfg)
+	dest := destinationFor(cfg)
+	planXMPSidecarNames(files, func(path string) bool {
+		_, err := dest.Stat(path)
+		return err == nil
+	})
+
+	copiedFrom := make(map[string]string)
+	for _, file := range files {
+		if isXMPSidecarFile(file) {
+			copiedFrom[filepath.Join(file.DestDir, file.DestName)] = sourcePath(file)
+		}
+	}
+
+	var xmpErrors []error
+	for i := range files {
+		if files[i].XMPSidecarName == "" {
+			continue
+		}
+		file := &files[i]
+		destPath := filepath.Join(file.DestDir, file.XMPSidecarName)
+		checksum := file.SourceChecksum
+		if checksum == "" && file.MetadataEdited {
+			// The copy no longer matches the original
+			var err error
+			if checksum, err = sourceChecksum(src, file); err != nil {
+				file.XMPSidecarName = ""
+				xmpErrors = append(xmpErrors, fmt.Errorf("failed to calculate checksum for %s: %w", sourcePath(*file), err))
+				continue
+			}
+		}
+		if checksum == "" {
+			var err error
+			if checksum, err = dest.Hash(filepath.Join(file.DestDir, file.DestName)); err != nil {
+				file.XMPSidecarName = ""
+				xmpErrors = append(xmpErrors, fmt.Errorf("failed to calculate checksum for %s: %w", file.DestName, err))
+				continue
+			}
+		}
+		provenance := xmpProvenance{
+			CaptureTime:  file.CreationDateTime,
+			TimeFallback: file.TimeIsFallback,
+			OriginalName: file.SourceName,
+			SourceVolume: file.SourceVolume,
+			SourcePath:   sourcePath(*file),
+			Session:      session,
+			Checksum:     checksum,
+			GPS:          file.GPXLocation,
+		}
+
+		existing, err := readExistingXMP(src, dest, destPath, copiedFrom[destPath])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Not writing XMP sidecar %s: %v\n", destPath, err)
+			file.XMPSidecarName = ""
+			continue
+		}
+		if existing == nil {
+			existing = []byte(xmpSkeleton)
+		}
+		packet, err := mergeXMPPacket(existing, provenance)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Not merging into XMP sidecar %s: %v\n", destPath, err)
+			file.XMPSidecarName = ""
+			continue
+		}
+		if err := writeDestinationFile(dest, destPath, packet); err != nil {
+			file.XMPSidecarName = ""
+			xmpErrors = append(xmpErrors, fmt.Errorf("failed to write XMP sidecar %s: %w", destPath, err))
+		}
+	}
+	return errors.Join(xmpErrors...)
+}
+
+// readExistingXMP returns the packet a sidecar should be merged into: the
+// source of a sidecar copied in this import, or a sidecar already at a local
+// destination. It returns nil if there is none. Existing sidecars at remote
+// destinations cannot be read back and are left alone.
+func readExistingXMP(src mediaSource, dest destination, destPath, copiedFromPath string) ([]byte, error) {
+	if copiedFromPath != "" {
+		r, err := src.Open(copiedFromPath)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = r.Close() }()
+		return io.ReadAll(r)
+	}
+	if _, err := dest.Stat(destPath); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if _, ok := dest.(localDestination); !ok {
+		return nil, fmt.Errorf("an existing sidecar cannot be merged at a remote destination")
+	}
+	return os.ReadFile(destPath)
+}
+
+// writeDestinationFile stores data at path on dest
+func writeDestinationFile(dest destination, path string, data []byte) error {
+	w, err := dest.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lightroomXMP is a sidecar as Lightroom writes it, with the capture time both
+// as an attribute and as an element
+const lightroomXMP = `<?xpacket begin="` + "\ufeff" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/" x:xmptk="Adobe XMP Core 7.0">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:ps="http://ns.adobe.com/photoshop/1.0/"
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmp:Rating="3"
+    xmp:CreateDate="2019-01-01T00:00:00">
+   <ps:DateCreated>2019-01-01T00:00:00</ps:DateCreated>
+   <!-- keyword & title -->
+   <dc:title>
+    <rdf:Alt>
+     <rdf:li xml:lang="x-default">Sunset &amp; sea</rdf:li>
+    </rdf:Alt>
+   </dc:title>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+
+func testProvenance(session string) xmpProvenance {
+	return xmpProvenance{
+		CaptureTime:  time.Date(2024, 6, 15, 10, 30, 0, 0, time.Local),
+		OriginalName: "IMG_0001.CR2",
+		SourceVolume: "EOS_DIGITAL",
+		SourcePath:   "/media/EOS_DIGITAL/DCIM/100CANON/IMG_0001.CR2",
+		Session:      session,
+		Checksum:     "0123456789abcdef",
+	}
+}
+
+// xmpProperties decodes a packet and returns its property values, as
+// namespace URI and local name, whether written as attributes or elements
+func xmpProperties(t *testing.T, packet []byte) map[string][]string {
+	t.Helper()
+	properties := make(map[string][]string)
+	dec := xml.NewDecoder(bytes.NewReader(packet))
+	var stack []xml.Name
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("packet is not well-formed: %v\n%s", err, packet)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			if tok.Name.Local == "Description" {
+				for _, attr := range tok.Attr {
+					if attr.Name.Space != "xmlns" && attr.Name.Local != "about" {
+						key := attr.Name.Space + attr.Name.Local
+						properties[key] = append(properties[key], attr.Value)
+					}
+				}
+			}
+			stack = append(stack, tok.Name)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) >= 2 && stack[len(stack)-2].Local == "Description" && len(bytes.TrimSpace(tok)) > 0 {
+				key := stack[len(stack)-1].Space + stack[len(stack)-1].Local
+				properties[key] = append(properties[key], string(tok))
+			}
+		}
+	}
+	return properties
+}
+
+func TestMergeXMPPacket(t *testing.T) {
+	merged, err := mergeXMPPacket([]byte(lightroomXMP), testProvenance("first"))
+	if err != nil {
+		t.Fatalf("mergeXMPPacket failed: %v", err)
+	}
+	properties := xmpProperties(t, merged)
+	for key, want := range map[string][]string{
+		xmpNamespace + "CreateDate":                  {"2024-06-15T10:30:00"},
+		photoshopNamespace + "DateCreated":           {"2024-06-15T10:30:00"},
+		xmpNamespace + "Rating":                      {"3"},
+		xmpMMNamespace + "PreservedFileName":         {"IMG_0001.CR2"},
+		gomediaimportNamespace + "ImportSession":     {"first"},
+		gomediaimportNamespace + "CaptureTimeSource": {"metadata"},
+		gomediaimportNamespace + "SourceVolume":      {"EOS_DIGITAL"},
+		gomediaimportNamespace + "Checksum":          {"xxh64:0123456789abcdef"},
+		"http://purl.org/dc/elements/1.1/" + "title": nil,
+	} {
+		if got := properties[key]; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	for _, kept := range []string{"<!-- keyword & title -->", "Sunset &amp; sea", `xml:lang="x-default"`, `x:xmptk="Adobe XMP Core 7.0"`, `<?xpacket end="w"?>`} {
+		if !bytes.Contains(merged, []byte(kept)) {
+			t.Errorf("merged packet lost %q:\n%s", kept, merged)
+		}
+	}
+
+	// A second import replaces the description the first one added
+	remerged, err := mergeXMPPacket(merged, testProvenance("second"))
+	if err != nil {
+		t.Fatalf("mergeXMPPacket failed: %v", err)
+	}
+	properties = xmpProperties(t, remerged)
+	if got := properties[gomediaimportNamespace+"ImportSession"]; !reflect.DeepEqual(got, []string{"second"}) {
+		t.Errorf("ImportSession after second merge = %q, want [second]", got)
+	}
+	if got := strings.Count(string(remerged), "<rdf:Description"); got != 2 {
+		t.Errorf("second merge left %d descriptions, want 2:\n%s", got, remerged)
+	}
+	if again, _ := mergeXMPPacket(remerged, testProvenance("second")); !bytes.Equal(again, remerged) {
+		t.Errorf("merging the same provenance again changed the packet:\n%s\n---\n%s", remerged, again)
+	}
+}
+
+func TestMergeXMPPacketErrors(t *testing.T) {
+	for name, packet := range map[string]string{
+		"not XML":    "Rating: 3",
+		"no RDF":     `<x:xmpmeta xmlns:x="adobe:ns:meta/"></x:xmpmeta>`,
+		"unbalanced": `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"></x:xmpmeta>`,
+	} {
+		if _, err := mergeXMPPacket([]byte(packet), testProvenance("s")); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestNewXMPPacket(t *testing.T) {
+	provenance := testProvenance("session")
+	provenance.TimeFallback = true
+	provenance.CaptureTime = time.Date(2024, 6, 15, 10, 30, 0, 250*int(time.Millisecond), time.Local)
+	packet, err := mergeXMPPacket([]byte(xmpSkeleton), provenance)
+	if err != nil {
+		t.Fatalf("mergeXMPPacket failed: %v", err)
+	}
+	properties := xmpProperties(t, packet)
+	if got := properties[xmpNamespace+"CreateDate"]; !reflect.DeepEqual(got, []string{"2024-06-15T10:30:00.250"}) {
+		t.Errorf("CreateDate = %q", got)
+	}
+	if got := properties[gomediaimportNamespace+"CaptureTimeSource"]; !reflect.DeepEqual(got, []string{"fallback"}) {
+		t.Errorf("CaptureTimeSource = %q", got)
+	}
+	if !bytes.HasPrefix(packet, []byte("<?xpacket begin=\"\ufeff\"")) {
+		t.Errorf("packet does not start with an xpacket header:\n%s", packet)
+	}
+}
+
+func TestPlanXMPSidecarNames(t *testing.T) {
+	media := func(name string, category MediaCategory, status FileStatus) FileInfo {
+		return FileInfo{SourceName: name, DestName: name, DestDir: "/lib", MediaCategory: category, Status: status, ParentIndex: -1, PairIndex: -1}
+	}
+	files := []FileInfo{
+		media("A.JPG", ProcessedPicture, StatusCopied),
+		media("A.CR2", RawPicture, StatusCopied),
+		media("B.JPG", ProcessedPicture, StatusCopied),
+		media("C.MOV", Video, StatusCopied),
+		media("C.XMP", Sidecar, StatusCopied),
+		media("D.JPG", ProcessedPicture, StatusPreExisting),
+		media("E.LRV", Proxy, StatusCopied),
+	}
+	files[0].PairIndex, files[1].PairIndex = 1, 0
+	files[4].ParentIndex = 3
+	exists := func(path string) bool { return path == filepath.Join("/lib", "B.xmp") }
+
+	planXMPSidecarNames(files, exists)
+	want := map[string]string{
+		// The RAW of a pair claims the base name first
+		"A.CR2": "A.xmp",
+		"A.JPG": "A.JPG.xmp",
+		// B.xmp belongs to a file imported earlier
+		"B.JPG": "B.JPG.xmp",
+		"C.MOV": "C.XMP",
+		"C.XMP": "",
+		"D.JPG": "",
+		"E.LRV": "",
+	}
+	for _, file := range files {
+		if file.XMPSidecarName != want[file.SourceName] {
+			t.Errorf("%s: XMP sidecar = %q, want %q", file.SourceName, file.XMPSidecarName, want[file.SourceName])
+		}
+	}
+}
+
+func TestNewImportSessionID(t *testing.T) {
+	a, b := newImportSessionID(), newImportSessionID()
+	if len(a) != 36 || a[14] != '4' || a == b {
+		t.Errorf("session IDs %q and %q are not distinct version 4 UUIDs", a, b)
+	}
+}
+
+func TestImportMediaWritesXMPSidecars(t *testing.T) {
+	video, err := os.ReadFile(testFixturePath("minimal.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+	march := time.Date(2024, 3, 1, 9, 0, 0, 0, time.Local)
+	for name, content := range map[string][]byte{
+		"clip.mp4":     video,
+		"IMG_0001.JPG": []byte("jpeg without exif"),
+		"IMG_0002.CR2": []byte("raw data"),
+		"IMG_0002.JPG": []byte("jpeg data"),
+		"IMG_0002.XMP": []byte(lightroomXMP),
+	} {
+		path := filepath.Join(sourceDir, name)
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, march, march); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := config{
+		SourceDir:         sourceDir,
+		DestDir:           destDir,
+		SidecarDefault:    SidecarDelete,
+		XMPSidecars:       true,
+		ChecksumManifests: true,
+	}
+	if err := importMedia(cfg); err != nil {
+		t.Fatalf("importMedia failed: %v", err)
+	}
+	want := []string{
+		"IMG_0001.JPG",
+		"IMG_0001.xmp",
+		"IMG_0002.CR2",
+		// The JPEG of a RAW+JPEG pair uses the darktable name
+		"IMG_0002.JPG",
+		"IMG_0002.JPG.xmp",
+		"IMG_0002.XMP",
+		"checksums.xxhash",
+		"clip.mp4",
+		"clip.xmp",
+	}
+	if got := listTree(t, destDir); !reflect.DeepEqual(got, want) {
+		t.Fatalf("destination = %v, want %v", got, want)
+	}
+
+	read := func(name string) map[string][]string {
+		data, err := os.ReadFile(filepath.Join(destDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return xmpProperties(t, data)
+	}
+	clip := read("clip.xmp")
+	if got := clip[xmpNamespace+"CreateDate"]; !reflect.DeepEqual(got, []string{"2024-06-15T10:30:00"}) {
+		t.Errorf("clip CreateDate = %q, want the embedded creation time", got)
+	}
+	if got := clip[gomediaimportNamespace+"SourcePath"]; !reflect.DeepEqual(got, []string{filepath.ToSlash(filepath.Join(sourceDir, "clip.mp4"))}) {
+		t.Errorf("clip SourcePath = %q", got)
+	}
+	fallback := read("IMG_0001.xmp")
+	if got := fallback[gomediaimportNamespace+"CaptureTimeSource"]; !reflect.DeepEqual(got, []string{"fallback"}) {
+		t.Errorf("IMG_0001 CaptureTimeSource = %q, want fallback", got)
+	}
+	if got := fallback[xmpNamespace+"CreateDate"]; !reflect.DeepEqual(got, []string{"2024-03-01T09:00:00"}) {
+		t.Errorf("IMG_0001 CreateDate = %q, want the file time", got)
+	}
+
+	// The sidecar from the card is merged into, keeping its rating
+	raw := read("IMG_0002.XMP")
+	if got := raw[xmpNamespace+"Rating"]; !reflect.DeepEqual(got, []string{"3"}) {
+		t.Errorf("merged Rating = %q, want [3]", got)
+	}
+	if got := raw[xmpMMNamespace+"PreservedFileName"]; !reflect.DeepEqual(got, []string{"IMG_0002.CR2"}) {
+		t.Errorf("merged PreservedFileName = %q", got)
+	}
+	if got, other := raw[gomediaimportNamespace+"ImportSession"], read("clip.xmp")[gomediaimportNamespace+"ImportSession"]; len(got) != 1 || !reflect.DeepEqual(got, other) {
+		t.Errorf("import sessions %q and %q differ", got, other)
+	}
+	if source, _ := os.ReadFile(filepath.Join(sourceDir, "IMG_0002.XMP")); string(source) != lightroomXMP {
+		t.Error("the source sidecar was modified")
+	}
+
+	// Written sidecars are listed in the checksum manifest
+	result, err := verifyLibrary(destDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Changed) > 0 || len(result.Unexpected) > 0 || len(result.Missing) > 0 {
+		t.Errorf("verify found problems: %+v", result)
+	}
+}
@@ -7,12 +7,17 @@ require (
 	github.com/bep/imagemeta v0.17.0
 	github.com/cespare/xxhash/v2 v2.3.0
 	github.com/mattn/go-isatty v0.0.20
+	github.com/pkg/sftp v1.13.10
 	github.com/tonimelisma/videometa v0.2.0
+	golang.org/x/crypto v0.48.0
+	golang.org/x/net v0.50.0
+	golang.org/x/sys v0.41.0
+	golang.org/x/term v0.40.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/alexflint/go-scalar v1.2.0 // indirect
-	golang.org/x/sys v0.41.0 // indirect
+	github.com/kr/fs v0.1.0 // indirect
 	golang.org/x/text v0.34.0 // indirect
 )